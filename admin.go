@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"gorm.io/gorm"
+)
+
+// tokenCallTimeout bounds the calls reading the symbol and decimals of a token contract
+const tokenCallTimeout = 10 * time.Second
+
+// AdminAPI serves the asset registry management endpoints on a listener separate from the public
+// WebSocket endpoint. Every request must carry the configured token as a bearer token.
+//
+//	GET    /assets                      lists all assets, including disabled ones
+//	POST   /assets                      adds an asset, reading its symbol and decimals from the token contract
+//	PATCH  /assets/{chain_id}/{token}   renames, disables or enables an asset and refreshes its decimals
+//	DELETE /assets/{chain_id}/{token}   removes an asset without ledger balances
+//	GET    /asset-groups                lists the asset groups
+//	POST   /asset-groups                creates an asset group
+//	PATCH  /asset-groups/{symbol}       raises the decimals of an asset group
+//	GET    /treasury                    returns the liquidity alerts and rebalancing plan of the last treasury check
+//	POST   /treasury/rebalance          checks the broker liquidity and sends the rebalancing transactions
+//
+// Assets join the group of their symbol, which is created with the decimals of the first token.
+// The treasury endpoints are only served when the treasury is enabled.
+type AdminAPI struct {
+	db    *gorm.DB
+	token string
+	// callers returns the client used to call token contracts on a chain, or nil if the chain is not running
+	callers  func(chainID uint32) bind.ContractCaller
+	treasury *Treasury
+}
+
+// NewAdminAPI creates the admin API authenticated with token
+func NewAdminAPI(db *gorm.DB, token string, callers func(chainID uint32) bind.ContractCaller, treasury *Treasury) *AdminAPI {
+	return &AdminAPI{db: db, token: token, callers: callers, treasury: treasury}
+}
+
+// AdminAssetRequest is the body of the requests adding or updating an asset
+type AdminAssetRequest struct {
+	Token    string `json:"token"`
+	ChainID  uint32 `json:"chain_id"`
+	Symbol   string `json:"symbol"`   // Defaults to the symbol of the token contract when adding an asset
+	Disabled *bool  `json:"disabled"` // Only used by updates
+}
+
+// AdminAssetResponse represents an asset in the admin API
+type AdminAssetResponse struct {
+	Token    string `json:"token"`
+	ChainID  uint32 `json:"chain_id"`
+	Symbol   string `json:"symbol"`
+	Decimals uint8  `json:"decimals"`
+	Disabled bool   `json:"disabled"`
+}
+
+// adminError is an error returned to the admin API client with its HTTP status
+type adminError struct {
+	status  int
+	message string
+}
+
+func (e *adminError) Error() string {
+	return e.message
+}
+
+func newAdminError(status int, format string, args ...any) error {
+	return &adminError{status: status, message: fmt.Sprintf(format, args...)}
+}
+
+// Handler returns the HTTP handler of the admin API
+func (a *AdminAPI) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /assets", a.handle(a.listAssets))
+	mux.HandleFunc("POST /assets", a.handle(a.addAsset))
+	mux.HandleFunc("PATCH /assets/{chain_id}/{token}", a.handle(a.updateAsset))
+	mux.HandleFunc("DELETE /assets/{chain_id}/{token}", a.handle(a.removeAsset))
+	mux.HandleFunc("GET /asset-groups", a.handle(a.listAssetGroups))
+	mux.HandleFunc("POST /asset-groups", a.handle(a.addAssetGroup))
+	mux.HandleFunc("PATCH /asset-groups/{symbol}", a.handle(a.updateAssetGroup))
+	if a.treasury != nil {
+		mux.HandleFunc("GET /treasury", a.handle(a.treasuryReport))
+		mux.HandleFunc("POST /treasury/rebalance", a.handle(a.rebalanceTreasury))
+	}
+	return mux
+}
+
+// handle authenticates a request and writes the result of the handler as JSON
+func (a *AdminAPI) handle(handler func(r *http.Request) (any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			writeAdminJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+
+		result, err := handler(r)
+		if err != nil {
+			var adminErr *adminError
+			if errors.As(err, &adminErr) {
+				writeAdminJSON(w, adminErr.status, map[string]string{"error": adminErr.message})
+				return
+			}
+			log.Printf("[Admin] Error handling %s %s: %v", r.Method, r.URL.Path, err)
+			writeAdminJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+
+		status := http.StatusOK
+		if r.Method == http.MethodPost {
+			status = http.StatusCreated
+		}
+		writeAdminJSON(w, status, result)
+	}
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("[Admin] Error writing response: %v", err)
+	}
+}
+
+func (a *AdminAPI) listAssets(r *http.Request) (any, error) {
+	var assets []Asset
+	if err := a.db.Order("chain_id, symbol").Find(&assets).Error; err != nil {
+		return nil, err
+	}
+
+	response := make([]AdminAssetResponse, 0, len(assets))
+	for _, asset := range assets {
+		response = append(response, adminAssetResponse(asset))
+	}
+	return response, nil
+}
+
+func (a *AdminAPI) addAsset(r *http.Request) (any, error) {
+	var req AdminAssetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, newAdminError(http.StatusBadRequest, "invalid request body: %v", err)
+	}
+	if !common.IsHexAddress(req.Token) {
+		return nil, newAdminError(http.StatusBadRequest, "invalid token address %q", req.Token)
+	}
+	token := common.HexToAddress(req.Token)
+
+	metadata, err := a.readToken(r.Context(), req.ChainID, token)
+	if err != nil {
+		return nil, err
+	}
+
+	symbol := strings.ToLower(req.Symbol)
+	if symbol == "" {
+		symbol = strings.ToLower(metadata.Symbol)
+	}
+	if symbol == "" {
+		return nil, newAdminError(http.StatusBadRequest, "token %s has no symbol", token.Hex())
+	}
+
+	asset := Asset{Token: token.Hex(), ChainID: req.ChainID, Symbol: symbol, Decimals: metadata.Decimals}
+	err = a.db.Transaction(func(tx *gorm.DB) error {
+		existing, err := GetAssetByToken(tx, asset.Token, asset.ChainID)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return newAdminError(http.StatusConflict, "asset %s already exists on chain %d", asset.Token, asset.ChainID)
+		}
+		if err := checkSymbolAvailable(tx, asset); err != nil {
+			return err
+		}
+		if _, err := JoinAssetGroup(tx, asset); err != nil {
+			return newAdminError(http.StatusConflict, "%v", err)
+		}
+		return tx.Create(&asset).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[Admin] Added asset %s (%s, %d decimals) on chain %d", asset.Token, asset.Symbol, asset.Decimals, asset.ChainID)
+	return adminAssetResponse(asset), nil
+}
+
+func (a *AdminAPI) updateAsset(r *http.Request) (any, error) {
+	var req AdminAssetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, newAdminError(http.StatusBadRequest, "invalid request body: %v", err)
+	}
+
+	asset, err := a.findAsset(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decimals are refreshed from the token contract, so a wrong value can be corrected,
+	// unless the request only disables or enables the asset
+	decimals := asset.Decimals
+	if req.Symbol != "" || req.Disabled == nil {
+		metadata, err := a.readToken(r.Context(), asset.ChainID, common.HexToAddress(asset.Token))
+		if err != nil {
+			return nil, err
+		}
+		decimals = metadata.Decimals
+	}
+
+	err = a.db.Transaction(func(tx *gorm.DB) error {
+		if symbol := strings.ToLower(req.Symbol); symbol != "" && symbol != asset.Symbol {
+			// Ledger balances are kept by symbol and would no longer match the asset
+			if err := checkNoLedgerBalances(tx, asset); err != nil {
+				return err
+			}
+			asset.Symbol = symbol
+			if err := checkSymbolAvailable(tx, *asset); err != nil {
+				return err
+			}
+		}
+		if req.Disabled != nil {
+			asset.Disabled = *req.Disabled
+		}
+		if asset.Decimals != decimals {
+			// Recorded balances and the states of open channels were converted with the old decimals
+			if err := checkNoLedgerBalances(tx, asset); err != nil {
+				return err
+			}
+			if err := checkNoOpenChannels(tx, asset); err != nil {
+				return err
+			}
+			log.Printf("[Admin] Decimals of asset %s on chain %d corrected from %d to %d", asset.Token, asset.ChainID, asset.Decimals, decimals)
+			asset.Decimals = decimals
+		}
+		if _, err := JoinAssetGroup(tx, *asset); err != nil {
+			return newAdminError(http.StatusConflict, "%v", err)
+		}
+
+		return tx.Model(&Asset{}).
+			Where("token = ? AND chain_id = ?", asset.Token, asset.ChainID).
+			Updates(map[string]any{"symbol": asset.Symbol, "decimals": asset.Decimals, "disabled": asset.Disabled}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[Admin] Updated asset %s on chain %d: symbol %s, disabled %t", asset.Token, asset.ChainID, asset.Symbol, asset.Disabled)
+	return adminAssetResponse(*asset), nil
+}
+
+func (a *AdminAPI) removeAsset(r *http.Request) (any, error) {
+	asset, err := a.findAsset(r)
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkNoLedgerBalances(tx, asset); err != nil {
+			return err
+		}
+		return tx.Where("token = ? AND chain_id = ?", asset.Token, asset.ChainID).Delete(&Asset{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[Admin] Removed asset %s (%s) on chain %d", asset.Token, asset.Symbol, asset.ChainID)
+	return adminAssetResponse(*asset), nil
+}
+
+// AdminAssetGroupRequest is the body of the requests creating or updating an asset group
+type AdminAssetGroupRequest struct {
+	Symbol   string `json:"symbol"`
+	Decimals uint8  `json:"decimals"`
+}
+
+// AdminAssetGroupResponse represents an asset group in the admin API
+type AdminAssetGroupResponse struct {
+	Symbol   string `json:"symbol"`
+	Decimals uint8  `json:"decimals"`
+}
+
+func (a *AdminAPI) listAssetGroups(r *http.Request) (any, error) {
+	var groups []AssetGroup
+	if err := a.db.Order("symbol").Find(&groups).Error; err != nil {
+		return nil, err
+	}
+
+	response := make([]AdminAssetGroupResponse, 0, len(groups))
+	for _, group := range groups {
+		response = append(response, AdminAssetGroupResponse{Symbol: group.Symbol, Decimals: group.Decimals})
+	}
+	return response, nil
+}
+
+func (a *AdminAPI) addAssetGroup(r *http.Request) (any, error) {
+	var req AdminAssetGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, newAdminError(http.StatusBadRequest, "invalid request body: %v", err)
+	}
+	group := AssetGroup{Symbol: strings.ToLower(req.Symbol), Decimals: req.Decimals}
+	if group.Symbol == "" {
+		return nil, newAdminError(http.StatusBadRequest, "symbol is required")
+	}
+	if group.Decimals > maxLedgerDecimals {
+		return nil, newAdminError(http.StatusBadRequest, "asset groups have at most %d decimals", maxLedgerDecimals)
+	}
+
+	err := a.db.Transaction(func(tx *gorm.DB) error {
+		existing, err := GetAssetGroup(tx, group.Symbol)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return newAdminError(http.StatusConflict, "asset group %s already exists", group.Symbol)
+		}
+		return tx.Create(&group).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[Admin] Added asset group %s with %d decimals", group.Symbol, group.Decimals)
+	return AdminAssetGroupResponse{Symbol: group.Symbol, Decimals: group.Decimals}, nil
+}
+
+// updateAssetGroup raises the decimals of a group, so that a token with more decimals can join it.
+// Lowering them could leave unified balances finer than the group.
+func (a *AdminAPI) updateAssetGroup(r *http.Request) (any, error) {
+	var req AdminAssetGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, newAdminError(http.StatusBadRequest, "invalid request body: %v", err)
+	}
+
+	symbol := strings.ToLower(r.PathValue("symbol"))
+	group, err := GetAssetGroup(a.db, symbol)
+	if err != nil {
+		return nil, err
+	}
+	if group == nil {
+		return nil, newAdminError(http.StatusNotFound, "asset group %s not found", symbol)
+	}
+	if req.Decimals < group.Decimals {
+		return nil, newAdminError(http.StatusBadRequest, "decimals of asset group %s cannot be lowered from %d", symbol, group.Decimals)
+	}
+	if req.Decimals > maxLedgerDecimals {
+		return nil, newAdminError(http.StatusBadRequest, "asset groups have at most %d decimals", maxLedgerDecimals)
+	}
+
+	if err := a.db.Model(&AssetGroup{}).Where("symbol = ?", symbol).Update("decimals", req.Decimals).Error; err != nil {
+		return nil, err
+	}
+
+	log.Printf("[Admin] Raised decimals of asset group %s from %d to %d", symbol, group.Decimals, req.Decimals)
+	return AdminAssetGroupResponse{Symbol: symbol, Decimals: req.Decimals}, nil
+}
+
+// findAsset loads the asset addressed by the chain_id and token path values
+func (a *AdminAPI) findAsset(r *http.Request) (*Asset, error) {
+	chainID, err := strconv.ParseUint(r.PathValue("chain_id"), 10, 32)
+	if err != nil {
+		return nil, newAdminError(http.StatusBadRequest, "invalid chain ID %q", r.PathValue("chain_id"))
+	}
+	if !common.IsHexAddress(r.PathValue("token")) {
+		return nil, newAdminError(http.StatusBadRequest, "invalid token address %q", r.PathValue("token"))
+	}
+	token := common.HexToAddress(r.PathValue("token")).Hex()
+
+	asset, err := GetAssetByToken(a.db, token, uint32(chainID))
+	if err != nil {
+		return nil, err
+	}
+	if asset == nil {
+		return nil, newAdminError(http.StatusNotFound, "asset %s not found on chain %d", token, chainID)
+	}
+	return asset, nil
+}
+
+// readToken reads the symbol and decimals of a token through the client of its chain
+func (a *AdminAPI) readToken(ctx context.Context, chainID uint32, token common.Address) (TokenMetadata, error) {
+	caller := a.callers(chainID)
+	if caller == nil {
+		return TokenMetadata{}, newAdminError(http.StatusBadRequest, "no network is running for chain %d", chainID)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, tokenCallTimeout)
+	defer cancel()
+	metadata, err := ReadTokenMetadata(ctx, caller, token)
+	if err != nil {
+		return metadata, newAdminError(http.StatusBadGateway, "%v", err)
+	}
+	return metadata, nil
+}
+
+// checkSymbolAvailable rejects a symbol already used by another token of the same chain,
+// since channels and ledger balances of a chain are matched to assets by symbol
+func checkSymbolAvailable(tx *gorm.DB, asset Asset) error {
+	existing, err := GetAssetBySymbol(tx, asset.Symbol, asset.ChainID)
+	if err != nil {
+		return err
+	}
+	if existing != nil && existing.Token != asset.Token {
+		return newAdminError(http.StatusConflict, "symbol %s is already used by token %s on chain %d", asset.Symbol, existing.Token, asset.ChainID)
+	}
+	return nil
+}
+
+// checkNoLedgerBalances rejects changes orphaning the ledger balances of an asset
+func checkNoLedgerBalances(tx *gorm.DB, asset *Asset) error {
+	hasBalances, err := HasLedgerBalances(tx, asset.Symbol)
+	if err != nil {
+		return err
+	}
+	if hasBalances {
+		return newAdminError(http.StatusConflict, "asset %s has non-zero ledger balances", asset.Symbol)
+	}
+	return nil
+}
+
+// checkNoOpenChannels rejects changes to an asset that channels of its token have not been closed with
+func checkNoOpenChannels(tx *gorm.DB, asset *Asset) error {
+	var count int64
+	if err := tx.Model(&Channel{}).
+		Where("chain_id = ? AND token = ? AND status <> ?", asset.ChainID, asset.Token, ChannelStatusClosed).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return newAdminError(http.StatusConflict, "asset %s has %d open channels on chain %d", asset.Symbol, count, asset.ChainID)
+	}
+	return nil
+}
+
+func adminAssetResponse(asset Asset) AdminAssetResponse {
+	return AdminAssetResponse{
+		Token:    asset.Token,
+		ChainID:  asset.ChainID,
+		Symbol:   asset.Symbol,
+		Decimals: asset.Decimals,
+		Disabled: asset.Disabled,
+	}
+}
+
+func (a *AdminAPI) treasuryReport(r *http.Request) (any, error) {
+	return a.treasury.Report(), nil
+}
+
+func (a *AdminAPI) rebalanceTreasury(r *http.Request) (any, error) {
+	return a.treasury.Check(r.Context(), true), nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTokenCaller answers the symbol and decimals calls of any ERC-20 token
+type fakeTokenCaller struct {
+	symbol   string
+	decimals uint8
+}
+
+func (c *fakeTokenCaller) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	return []byte{0x1}, nil
+}
+
+func (c *fakeTokenCaller) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	method, err := erc20Abi.MethodById(call.Data)
+	if err != nil {
+		return nil, err
+	}
+	if method.Name == "symbol" {
+		return method.Outputs.Pack(c.symbol)
+	}
+	return method.Outputs.Pack(c.decimals)
+}
+
+func TestAdminAPIAssets(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	caller := &fakeTokenCaller{symbol: "USDC", decimals: 6}
+	handler := NewAdminAPI(db, "secret", func(chainID uint32) bind.ContractCaller {
+		if chainID == 137 {
+			return caller
+		}
+		return nil
+	}, nil).Handler()
+
+	request := func(method, path, token string, body any) (int, map[string]any) {
+		var reqBody bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&reqBody).Encode(body))
+		}
+		req := httptest.NewRequest(method, path, &reqBody)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		var response map[string]any
+		if method != http.MethodGet {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		}
+		return rec.Code, response
+	}
+
+	token := "0x2222222222222222222222222222222222222222"
+	tokenPath := "/assets/137/" + token
+
+	// Requests without the admin token are rejected
+	status, _ := request(http.MethodPost, "/assets", "wrong", map[string]any{"token": token, "chain_id": 137})
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	// Symbol and decimals are read from the token contract
+	status, response := request(http.MethodPost, "/assets", "secret", map[string]any{"token": token, "chain_id": 137})
+	require.Equal(t, http.StatusCreated, status, response)
+	assert.Equal(t, "usdc", response["symbol"])
+	assert.Equal(t, float64(6), response["decimals"])
+
+	asset, err := GetAssetByToken(db, common.HexToAddress(token).Hex(), 137)
+	require.NoError(t, err)
+	require.NotNil(t, asset)
+	assert.Equal(t, uint8(6), asset.Decimals)
+
+	status, response = request(http.MethodPost, "/assets", "secret", map[string]any{"token": token, "chain_id": 137})
+	assert.Equal(t, http.StatusConflict, status, response)
+
+	// A symbol maps to a single token per chain
+	status, response = request(http.MethodPost, "/assets", "secret", map[string]any{"token": "0x3333333333333333333333333333333333333333", "chain_id": 137})
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Contains(t, response["error"], "symbol usdc is already used")
+
+	status, _ = request(http.MethodPost, "/assets", "secret", map[string]any{"token": token, "chain_id": 1})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	// A token cannot have more decimals than its asset group, whose decimals can only be raised
+	caller.decimals = 18
+	status, response = request(http.MethodPatch, tokenPath, "secret", map[string]any{})
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, "token 0x2222222222222222222222222222222222222222 has 18 decimals, more than the 6 of asset group usdc", response["error"])
+
+	status, _ = request(http.MethodPatch, "/asset-groups/usdc", "secret", map[string]any{"decimals": 18})
+	require.Equal(t, http.StatusOK, status)
+	status, _ = request(http.MethodPatch, "/asset-groups/usdc", "secret", map[string]any{"decimals": 6})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, response = request(http.MethodPatch, tokenPath, "secret", map[string]any{})
+	require.Equal(t, http.StatusOK, status, response)
+	assert.Equal(t, float64(18), response["decimals"])
+
+	// Disabled assets are hidden from get_assets, disabling does not read the token contract
+	caller.decimals = 8
+	status, response = request(http.MethodPatch, tokenPath, "secret", map[string]any{"disabled": true})
+	require.Equal(t, http.StatusOK, status, response)
+	assert.Equal(t, true, response["disabled"])
+	assert.Equal(t, float64(18), response["decimals"])
+
+	assets, err := GetAllAssets(db, nil)
+	require.NoError(t, err)
+	assert.Empty(t, assets)
+
+	// Assets with ledger balances cannot be removed or renamed
+	participant := "0x1111111111111111111111111111111111111111"
+	ledger := GetParticipantLedger(db, participant)
+	require.NoError(t, ledger.Record(participant, "usdc", decimal.NewFromInt(10)))
+
+	status, response = request(http.MethodDelete, tokenPath, "secret", nil)
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, "asset usdc has non-zero ledger balances", response["error"])
+	status, _ = request(http.MethodPatch, tokenPath, "secret", map[string]any{"symbol": "usdc.e"})
+	assert.Equal(t, http.StatusConflict, status)
+
+	// Nor can their decimals change, which the balances were converted with
+	status, response = request(http.MethodPatch, tokenPath, "secret", map[string]any{})
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, "asset usdc has non-zero ledger balances", response["error"])
+
+	require.NoError(t, ledger.Record(participant, "usdc", decimal.NewFromInt(-10)))
+
+	// The same holds while channels of the token are open
+	require.NoError(t, db.Create(&Channel{ChannelID: "0xChannel", ChainID: 137, Token: common.HexToAddress(token).Hex(), Participant: participant, Status: ChannelStatusOpen}).Error)
+	status, response = request(http.MethodPatch, tokenPath, "secret", map[string]any{})
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, "asset usdc has 1 open channels on chain 137", response["error"])
+	require.NoError(t, db.Model(&Channel{}).Where("channel_id = ?", "0xChannel").Update("status", ChannelStatusClosed).Error)
+
+	status, response = request(http.MethodPatch, tokenPath, "secret", map[string]any{})
+	require.Equal(t, http.StatusOK, status, response)
+	assert.Equal(t, float64(8), response["decimals"])
+	status, response = request(http.MethodDelete, tokenPath, "secret", nil)
+	require.Equal(t, http.StatusOK, status, response)
+
+	asset, err = GetAssetByToken(db, common.HexToAddress(token).Hex(), 137)
+	require.NoError(t, err)
+	assert.Nil(t, asset)
+
+	status, _ = request(http.MethodDelete, tokenPath, "secret", nil)
+	assert.Equal(t, http.StatusNotFound, status)
+}
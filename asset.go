@@ -1,20 +1,155 @@
 package main
 
 import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// Asset maps a token of a chain to the asset group, named by Symbol, its balances are kept in
 type Asset struct {
 	Token    string `gorm:"column:token;primaryKey"`    // part of primaryKey
 	ChainID  uint32 `gorm:"column:chain_id;primaryKey"` // part of primaryKey
 	Symbol   string `gorm:"column:symbol;index"`        // e.g. "usdc"
 	Decimals uint8  `gorm:"column:decimals;not null"`
+	Disabled bool   `gorm:"column:disabled;not null;default:false"` // Disabled assets are not offered for new channels
 }
 
 func (Asset) TableName() string {
 	return "assets"
 }
 
+// maxLedgerDecimals is the scale of the amount columns of the ledger
+const maxLedgerDecimals = 18
+
+// AssetGroup is a canonical ledger asset. The tokens of every chain mapped to a group share one unified
+// balance, kept with the decimals of the group. A token may only join a group with at least as many
+// decimals, so deposits are recorded exactly, while withdrawals to a token with fewer decimals are
+// rounded down and leave the remainder in the unified balance.
+type AssetGroup struct {
+	Symbol    string `gorm:"column:symbol;primaryKey"`
+	Decimals  uint8  `gorm:"column:decimals;not null"`
+	CreatedAt time.Time
+}
+
+func (AssetGroup) TableName() string {
+	return "asset_groups"
+}
+
+// LedgerAsset is an asset with the group its amounts are converted against
+type LedgerAsset struct {
+	Asset
+	Group AssetGroup
+}
+
+// ToLedger converts a raw token amount to an amount of the unified balance
+func (a *LedgerAsset) ToLedger(raw *big.Int) decimal.Decimal {
+	return decimal.NewFromBigInt(raw, -int32(a.Decimals))
+}
+
+// ToRaw converts an amount of the unified balance to raw token units, rounding down the part
+// finer than the decimals of the token
+func (a *LedgerAsset) ToRaw(amount decimal.Decimal) *big.Int {
+	return amount.Shift(int32(a.Decimals)).BigInt()
+}
+
+// GetLedgerAsset returns the asset of a token with its group, or nil if the token is not supported.
+// An asset without a group, such as one inserted directly, is its own group with its decimals.
+func GetLedgerAsset(db *gorm.DB, tokenAddress string, chainID uint32) (*LedgerAsset, error) {
+	asset, err := GetAssetByToken(db, tokenAddress, chainID)
+	if err != nil || asset == nil {
+		return nil, err
+	}
+
+	group, err := GetAssetGroup(db, asset.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	if group == nil {
+		group = &AssetGroup{Symbol: asset.Symbol, Decimals: asset.Decimals}
+	}
+	if asset.Decimals > group.Decimals {
+		return nil, fmt.Errorf("token %s has %d decimals, more than the %d of asset group %s", asset.Token, asset.Decimals, group.Decimals, group.Symbol)
+	}
+	return &LedgerAsset{Asset: *asset, Group: *group}, nil
+}
+
+// GroupDecimals returns the decimals the unified balance of a symbol is kept with. A symbol without a group
+// uses the most decimals of its assets, and an unknown symbol the scale of the ledger.
+func GroupDecimals(db *gorm.DB, symbol string) (uint8, error) {
+	group, err := GetAssetGroup(db, symbol)
+	if err != nil {
+		return 0, err
+	}
+	if group != nil {
+		return group.Decimals, nil
+	}
+
+	var decimals sql.NullInt64
+	if err := db.Model(&Asset{}).Where("symbol = ?", symbol).Select("MAX(decimals)").Row().Scan(&decimals); err != nil {
+		return 0, err
+	}
+	if !decimals.Valid {
+		return maxLedgerDecimals, nil
+	}
+	return uint8(decimals.Int64), nil
+}
+
+// CheckAmountPrecision rejects an off-chain amount finer than the decimals of its asset group,
+// which the ledger could not keep exactly and no token of the group could pay out
+func CheckAmountPrecision(db *gorm.DB, symbol string, amount decimal.Decimal) error {
+	decimals, err := GroupDecimals(db, symbol)
+	if err != nil {
+		return fmt.Errorf("failed to get decimals of asset %s: %w", symbol, err)
+	}
+	if !amount.Truncate(int32(decimals)).Equal(amount) {
+		return fmt.Errorf("amount %s of %s has more than %d decimals", amount, symbol, decimals)
+	}
+	return nil
+}
+
+// GetAssetGroup returns the asset group of a symbol, or nil if there is none
+func GetAssetGroup(db *gorm.DB, symbol string) (*AssetGroup, error) {
+	var group AssetGroup
+	err := db.Where("symbol = ?", symbol).First(&group).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	return &group, err
+}
+
+// JoinAssetGroup checks that an asset fits the group of its symbol, creating the group with the
+// decimals of the asset if it does not exist yet
+func JoinAssetGroup(tx *gorm.DB, asset Asset) (*AssetGroup, error) {
+	group, err := GetAssetGroup(tx, asset.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	if group == nil {
+		if asset.Decimals > maxLedgerDecimals {
+			return nil, fmt.Errorf("token %s has %d decimals, more than the %d kept by the ledger", asset.Token, asset.Decimals, maxLedgerDecimals)
+		}
+		group = &AssetGroup{Symbol: asset.Symbol, Decimals: asset.Decimals}
+		if err := tx.Create(group).Error; err != nil {
+			return nil, err
+		}
+		return group, nil
+	}
+
+	if asset.Decimals > group.Decimals {
+		return nil, fmt.Errorf("token %s has %d decimals, more than the %d of asset group %s", asset.Token, asset.Decimals, group.Decimals, group.Symbol)
+	}
+	return group, nil
+}
+
 func GetAssetByToken(db *gorm.DB, tokenAddress string, chainID uint32) (*Asset, error) {
 	var asset Asset
 	err := db.Where("token = ? AND chain_id = ?", tokenAddress, chainID).First(&asset).Error
@@ -33,10 +168,10 @@ func GetAssetBySymbol(db *gorm.DB, symbol string, chainID uint32) (*Asset, error
 	return &asset, err
 }
 
-// GetAllAssets returns all supported assets. If chainID is provided, it filters assets by that chain ID
+// GetAllAssets returns all supported assets, without disabled ones. If chainID is provided, it filters assets by that chain ID
 func GetAllAssets(db *gorm.DB, chainID *uint32) ([]Asset, error) {
 	var assets []Asset
-	query := db.Model(&Asset{})
+	query := db.Model(&Asset{}).Where("disabled = ?", false)
 
 	if chainID != nil {
 		query = query.Where("chain_id = ?", *chainID)
@@ -45,3 +180,68 @@ func GetAllAssets(db *gorm.DB, chainID *uint32) ([]Asset, error) {
 	err := query.Order("chain_id, symbol").Find(&assets).Error
 	return assets, err
 }
+
+// UpsertAssets creates the configured assets of a chain that do not exist yet. Every new asset must fit the group
+// of its symbol. Existing assets are kept as they are, since ledger balances and open channels depend on their
+// symbol and decimals; they are changed through the admin API, which checks that the change is safe.
+func UpsertAssets(db *gorm.DB, chainID uint32, configs []AssetConfig) error {
+	if len(configs) == 0 {
+		return nil
+	}
+
+	assets := make([]Asset, 0, len(configs))
+	for _, config := range configs {
+		assets = append(assets, Asset{
+			Token:    common.HexToAddress(config.Token).Hex(),
+			ChainID:  chainID,
+			Symbol:   strings.ToLower(config.Symbol),
+			Decimals: config.Decimals,
+		})
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, asset := range assets {
+			existing, err := GetAssetByToken(tx, asset.Token, asset.ChainID)
+			if err != nil {
+				return err
+			}
+			if existing != nil {
+				if existing.Symbol != asset.Symbol || existing.Decimals != asset.Decimals {
+					log.Printf("Asset %s on chain %d is configured as %s with %d decimals, keeping %s with %d decimals",
+						asset.Token, asset.ChainID, asset.Symbol, asset.Decimals, existing.Symbol, existing.Decimals)
+				}
+				continue
+			}
+
+			if _, err := JoinAssetGroup(tx, asset); err != nil {
+				return err
+			}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&asset).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// HasLedgerBalances reports whether any account holds a non-zero balance of the asset symbol
+func HasLedgerBalances(db *gorm.DB, assetSymbol string) (bool, error) {
+	type result struct {
+		Balance decimal.Decimal `gorm:"column:balance"`
+	}
+	var balances []result
+	if err := db.Model(&Entry{}).
+		Where("asset_symbol = ?", assetSymbol).
+		Select("COALESCE(SUM(credit),0) - COALESCE(SUM(debit),0) AS balance").
+		Group("account_id, participant").
+		Scan(&balances).Error; err != nil {
+		return false, err
+	}
+
+	for _, balance := range balances {
+		if !balance.Balance.IsZero() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
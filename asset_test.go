@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssetGroups(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// The first token of a symbol creates its group, tokens with more decimals cannot join it
+	require.NoError(t, UpsertAssets(db, 137, []AssetConfig{{Token: "0x2222222222222222222222222222222222222222", Symbol: "usdc", Decimals: 6}}))
+	err := UpsertAssets(db, 56, []AssetConfig{{Token: "0x3333333333333333333333333333333333333333", Symbol: "usdc", Decimals: 18}})
+	assert.EqualError(t, err, "token 0x3333333333333333333333333333333333333333 has 18 decimals, more than the 6 of asset group usdc")
+
+	require.NoError(t, db.Model(&AssetGroup{}).Where("symbol = ?", "usdc").Update("decimals", 18).Error)
+	require.NoError(t, UpsertAssets(db, 56, []AssetConfig{{Token: "0x3333333333333333333333333333333333333333", Symbol: "usdc", Decimals: 18}}))
+
+	// Reloading the configuration only adds missing assets and keeps existing ones as they are
+	require.NoError(t, UpsertAssets(db, 137, []AssetConfig{
+		{Token: "0x2222222222222222222222222222222222222222", Symbol: "usdt", Decimals: 18},
+		{Token: "0x6666666666666666666666666666666666666666", Symbol: "dai", Decimals: 18},
+	}))
+	existing, err := GetAssetByToken(db, "0x2222222222222222222222222222222222222222", 137)
+	require.NoError(t, err)
+	assert.Equal(t, "usdc", existing.Symbol)
+	assert.Equal(t, uint8(6), existing.Decimals)
+	added, err := GetAssetByToken(db, "0x6666666666666666666666666666666666666666", 137)
+	require.NoError(t, err)
+	require.NotNil(t, added)
+
+	polygon, err := GetLedgerAsset(db, "0x2222222222222222222222222222222222222222", 137)
+	require.NoError(t, err)
+	require.NotNil(t, polygon)
+	assert.Equal(t, uint8(18), polygon.Group.Decimals)
+
+	// Deposits are recorded exactly and withdrawals rounded down to the token decimals
+	bsc, err := GetLedgerAsset(db, "0x3333333333333333333333333333333333333333", 56)
+	require.NoError(t, err)
+	deposit := bsc.ToLedger(big.NewInt(1500000000000000001))
+	assert.Equal(t, "1.500000000000000001", deposit.String())
+	assert.Equal(t, big.NewInt(1500000), polygon.ToRaw(deposit))
+	assert.Equal(t, big.NewInt(1500000000000000001), bsc.ToRaw(deposit))
+
+	// An asset without a group is its own group
+	require.NoError(t, db.Create(&Asset{Token: "0x4444444444444444444444444444444444444444", ChainID: 137, Symbol: "weth", Decimals: 18}).Error)
+	weth, err := GetLedgerAsset(db, "0x4444444444444444444444444444444444444444", 137)
+	require.NoError(t, err)
+	assert.Equal(t, AssetGroup{Symbol: "weth", Decimals: 18}, weth.Group)
+
+	missing, err := GetLedgerAsset(db, "0x5555555555555555555555555555555555555555", 137)
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+}
+
+func TestCheckAmountPrecision(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Amounts are limited to the decimals of the group, trailing zeros do not count
+	require.NoError(t, db.Create(&AssetGroup{Symbol: "usdc", Decimals: 6}).Error)
+	assert.NoError(t, CheckAmountPrecision(db, "usdc", decimal.RequireFromString("1.5000000")))
+	assert.EqualError(t, CheckAmountPrecision(db, "usdc", decimal.RequireFromString("1.0000001")), "amount 1.0000001 of usdc has more than 6 decimals")
+
+	// A symbol without a group uses the most decimals of its assets
+	require.NoError(t, db.Create(&Asset{Token: "0x2222222222222222222222222222222222222222", ChainID: 137, Symbol: "weth", Decimals: 8}).Error)
+	require.NoError(t, db.Create(&Asset{Token: "0x3333333333333333333333333333333333333333", ChainID: 56, Symbol: "weth", Decimals: 18}).Error)
+	assert.NoError(t, CheckAmountPrecision(db, "weth", decimal.New(1, -18)))
+	assert.Error(t, CheckAmountPrecision(db, "weth", decimal.New(1, -19)))
+
+	// An unknown symbol is limited to the scale of the ledger
+	assert.NoError(t, CheckAmountPrecision(db, "dai", decimal.New(1, -18)))
+	assert.Error(t, CheckAmountPrecision(db, "dai", decimal.New(1, -19)))
+}
+
+func TestHandleCloseChannelCrossChainBalance(t *testing.T) {
+	rawKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	signer := Signer{privateKey: rawKey}
+	participant := signer.GetAddress().Hex()
+
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	token := "0x2222222222222222222222222222222222222222"
+	require.NoError(t, db.Create(&AssetGroup{Symbol: "usdc", Decimals: 18}).Error)
+	require.NoError(t, db.Create(&Asset{Token: token, ChainID: 137, Symbol: "usdc", Decimals: 6}).Error)
+	channel := Channel{
+		ChannelID:   "0xChannelCrossChain",
+		Participant: participant,
+		Status:      ChannelStatusOpen,
+		Token:       token,
+		ChainID:     137,
+		Amount:      2000000,
+		Adjudicator: "0xAdj",
+	}
+	require.NoError(t, db.Create(&channel).Error)
+
+	// Part of the unified balance was deposited with an 18 decimals token of another chain
+	ledger := GetParticipantLedger(db, participant)
+	require.NoError(t, ledger.Record(participant, "usdc", decimal.RequireFromString("1.5000001")))
+
+	rpcRequest := &RPCMessage{
+		Req: &RPCData{
+			RequestID: 1,
+			Method:    "close_channel",
+			Params:    []any{CloseChannelParams{ChannelID: channel.ChannelID, FundsDestination: participant}},
+			Timestamp: uint64(time.Now().Unix()),
+		},
+	}
+	reqBytes, err := json.Marshal(rpcRequest.Req)
+	require.NoError(t, err)
+	signed, err := signer.Sign(reqBytes)
+	require.NoError(t, err)
+	rpcRequest.Sig = []string{hexutil.Encode(signed)}
+
+	response, err := HandleCloseChannel(rpcRequest, db, NewBrokerKeys(&signer, nil), time.Hour, SigningContext{Mode: SignatureModeRaw})
+	require.NoError(t, err)
+	closeResponse, ok := response.Res.Params[0].(CloseChannelResponse)
+	require.True(t, ok)
+	assert.Equal(t, "1500000", closeResponse.FinalAllocations[0].Amount.String())
+	assert.Equal(t, "500000", closeResponse.FinalAllocations[1].Amount.String())
+
+	// Only the amount payable in the channel token is locked, the remainder stays in the unified balance
+	locked, err := GetLockedSettlement(db, channel, "usdc")
+	require.NoError(t, err)
+	assert.Equal(t, "1.5", locked.String())
+
+	balance, err := ledger.Balance(participant, "usdc")
+	require.NoError(t, err)
+	assert.InDelta(t, 0.0000001, balance.InexactFloat64(), 1e-12)
+}
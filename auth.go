@@ -129,8 +129,12 @@ func (am *AuthManager) registerAuthSession(address string) {
 	am.authSessions[address] = time.Now()
 }
 
-// ValidateSession checks if a session is valid
-func (am *AuthManager) ValidateSession(address string) bool {
+// ValidateSession checks if a session is valid and the session token of the connection, expiring at tokenExpiry, is not expired
+func (am *AuthManager) ValidateSession(address string, tokenExpiry time.Time) bool {
+	if time.Now().After(tokenExpiry) {
+		return false
+	}
+
 	am.authSessionsMu.RLock()
 	defer am.authSessionsMu.RUnlock()
 
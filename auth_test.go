@@ -39,9 +39,10 @@ func TestAuthManagerSessionManagement(t *testing.T) {
 	// Add a test session
 	testAddr := "0x1234567890123456789012345678901234567890"
 	am.registerAuthSession(testAddr)
+	tokenExpiry := time.Now().Add(time.Hour)
 
 	// Verify session is valid
-	valid := am.ValidateSession(testAddr)
+	valid := am.ValidateSession(testAddr, tokenExpiry)
 	assert.True(t, valid)
 
 	// Update session
@@ -50,11 +51,16 @@ func TestAuthManagerSessionManagement(t *testing.T) {
 	assert.True(t, updated)
 
 	// Verify still valid
-	valid = am.ValidateSession(testAddr)
+	valid = am.ValidateSession(testAddr, tokenExpiry)
 	assert.True(t, valid)
 
 	// Wait for session to expire
 	time.Sleep(500 * time.Millisecond)
-	valid = am.ValidateSession(testAddr)
+	valid = am.ValidateSession(testAddr, tokenExpiry)
 	assert.False(t, valid)
+
+	// An active session is not valid past the expiry of its session token
+	am.registerAuthSession(testAddr)
+	assert.True(t, am.ValidateSession(testAddr, tokenExpiry))
+	assert.False(t, am.ValidateSession(testAddr, time.Now().Add(-time.Second)))
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// BrokerKeys holds the current broker key and, during a key rotation, the previous one.
+// New channels are opened with the current key, while channels opened before the rotation
+// keep being signed with the key recorded on them until they are closed.
+type BrokerKeys struct {
+	current  BrokerSigner
+	previous BrokerSigner
+}
+
+// NewBrokerKeys creates the broker key set, previous is nil outside a rotation
+func NewBrokerKeys(current, previous BrokerSigner) *BrokerKeys {
+	return &BrokerKeys{current: current, previous: previous}
+}
+
+// LoadBrokerKeys creates the current broker key and, if configured, the previous one
+func LoadBrokerKeys(conf SignerConfig) (*BrokerKeys, error) {
+	current, err := NewBrokerSigner(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	prevConf, ok := conf.previous()
+	if !ok {
+		return NewBrokerKeys(current, nil), nil
+	}
+	previous, err := NewBrokerSigner(prevConf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise previous broker signer: %w", err)
+	}
+	if previous.GetAddress() == current.GetAddress() {
+		return nil, errors.New("previous broker key must differ from the current one")
+	}
+	return NewBrokerKeys(current, previous), nil
+}
+
+// Current returns the key used for new channels, RPC messages and session tokens
+func (k *BrokerKeys) Current() BrokerSigner {
+	return k.current
+}
+
+// All returns the current key followed by the previous one, if any
+func (k *BrokerKeys) All() []BrokerSigner {
+	if k.previous == nil {
+		return []BrokerSigner{k.current}
+	}
+	return []BrokerSigner{k.current, k.previous}
+}
+
+// Get returns the broker key with the given address, or nil if it is not one of the broker keys
+func (k *BrokerKeys) Get(keyID string) BrokerSigner {
+	for _, signer := range k.All() {
+		if strings.EqualFold(signer.GetAddress().Hex(), keyID) {
+			return signer
+		}
+	}
+	return nil
+}
+
+// legacy returns the key of channels opened before broker keys were recorded on them
+func (k *BrokerKeys) legacy() BrokerSigner {
+	if k.previous != nil {
+		return k.previous
+	}
+	return k.current
+}
+
+// ForChannel returns the broker key the channel was opened with
+func (k *BrokerKeys) ForChannel(channel Channel) (BrokerSigner, error) {
+	if channel.BrokerKeyID == "" {
+		return k.legacy(), nil
+	}
+	signer := k.Get(channel.BrokerKeyID)
+	if signer == nil {
+		return nil, fmt.Errorf("broker key %s of channel %s is not configured", channel.BrokerKeyID, channel.ChannelID)
+	}
+	return signer, nil
+}
+
+// VerifySessionToken verifies a session token issued with any of the broker keys,
+// so that sessions survive a rotation until their tokens expire
+func (k *BrokerKeys) VerifySessionToken(token string) (*SessionClaims, error) {
+	var firstErr error
+	for _, signer := range k.All() {
+		claims, err := VerifySessionToken(signer.GetAddress(), token)
+		if err == nil {
+			return claims, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+// AssignLegacyChannelKeys records the legacy broker key on channels that were opened before
+// broker keys were recorded, so that they keep their key through later rotations
+func AssignLegacyChannelKeys(db *gorm.DB, keys *BrokerKeys) error {
+	err := db.Model(&Channel{}).
+		Where("broker_key_id = ?", "").
+		Update("broker_key_id", keys.legacy().GetAddress().Hex()).Error
+	if err != nil {
+		return fmt.Errorf("failed to assign broker keys to channels: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSigner(t *testing.T) *Signer {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	return &Signer{privateKey: key}
+}
+
+func TestBrokerKeys(t *testing.T) {
+	current := newTestSigner(t)
+	previous := newTestSigner(t)
+	keys := NewBrokerKeys(current, previous)
+
+	assert.Equal(t, current.GetAddress(), keys.Current().GetAddress())
+	assert.Len(t, keys.All(), 2)
+	assert.Equal(t, previous.GetAddress(), keys.Get(previous.GetAddress().Hex()).GetAddress())
+	assert.Nil(t, keys.Get("0x1111111111111111111111111111111111111111"))
+
+	// Channels opened before keys were recorded belong to the previous key
+	signer, err := keys.ForChannel(Channel{ChannelID: "0xLegacy"})
+	require.NoError(t, err)
+	assert.Equal(t, previous.GetAddress(), signer.GetAddress())
+
+	signer, err = keys.ForChannel(Channel{ChannelID: "0xNew", BrokerKeyID: current.GetAddress().Hex()})
+	require.NoError(t, err)
+	assert.Equal(t, current.GetAddress(), signer.GetAddress())
+
+	_, err = keys.ForChannel(Channel{ChannelID: "0xRetired", BrokerKeyID: "0x1111111111111111111111111111111111111111"})
+	assert.EqualError(t, err, "broker key 0x1111111111111111111111111111111111111111 of channel 0xRetired is not configured")
+
+	// Without a rotation, legacy channels belong to the current key
+	signer, err = NewBrokerKeys(current, nil).ForChannel(Channel{ChannelID: "0xLegacy"})
+	require.NoError(t, err)
+	assert.Equal(t, current.GetAddress(), signer.GetAddress())
+
+	// Session tokens issued before the rotation stay valid
+	token, _, err := IssueSessionToken(previous, SessionClaims{Address: "0x1111111111111111111111111111111111111111"}, time.Minute)
+	require.NoError(t, err)
+	claims, err := keys.VerifySessionToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, "0x1111111111111111111111111111111111111111", claims.Address)
+
+	_, err = NewBrokerKeys(current, nil).VerifySessionToken(token)
+	assert.Error(t, err)
+}
+
+func TestAssignLegacyChannelKeys(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	current := newTestSigner(t)
+	previous := newTestSigner(t)
+	keys := NewBrokerKeys(current, previous)
+
+	require.NoError(t, db.Create(&Channel{ChannelID: "0xLegacy", Participant: "0xA", Status: ChannelStatusOpen}).Error)
+	require.NoError(t, db.Create(&Channel{ChannelID: "0xNew", Participant: "0xA", Status: ChannelStatusOpen, BrokerKeyID: current.GetAddress().Hex()}).Error)
+
+	require.NoError(t, AssignLegacyChannelKeys(db, keys))
+
+	var legacy, opened Channel
+	require.NoError(t, db.Where("channel_id = ?", "0xLegacy").First(&legacy).Error)
+	require.NoError(t, db.Where("channel_id = ?", "0xNew").First(&opened).Error)
+	assert.Equal(t, previous.GetAddress().Hex(), legacy.BrokerKeyID)
+	assert.Equal(t, current.GetAddress().Hex(), opened.BrokerKeyID)
+}
+
+func TestHandleCloseChannelPreviousBrokerKey(t *testing.T) {
+	participantSigner := newTestSigner(t)
+	participant := participantSigner.GetAddress().Hex()
+	current := newTestSigner(t)
+	previous := newTestSigner(t)
+	keys := NewBrokerKeys(current, previous)
+
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	token := "0x2222222222222222222222222222222222222222"
+	require.NoError(t, db.Create(&Asset{Token: token, ChainID: 137, Symbol: "usdc", Decimals: 6}).Error)
+	channel := Channel{
+		ChannelID:   "0xChannelRotated",
+		Participant: participant,
+		Status:      ChannelStatusOpen,
+		Token:       token,
+		ChainID:     137,
+		Amount:      1000000,
+		Adjudicator: "0xAdj",
+		BrokerKeyID: previous.GetAddress().Hex(),
+	}
+	require.NoError(t, db.Create(&channel).Error)
+	require.NoError(t, GetParticipantLedger(db, participant).Record(participant, "usdc", decimal.NewFromInt(1)))
+
+	rpcRequest := &RPCMessage{
+		Req: &RPCData{
+			RequestID: 1,
+			Method:    "close_channel",
+			Params:    []any{CloseChannelParams{ChannelID: channel.ChannelID, FundsDestination: participant}},
+			Timestamp: uint64(time.Now().Unix()),
+		},
+	}
+	reqBytes, err := json.Marshal(rpcRequest.Req)
+	require.NoError(t, err)
+	signed, err := participantSigner.Sign(reqBytes)
+	require.NoError(t, err)
+	rpcRequest.Sig = []string{hexutil.Encode(signed)}
+
+	response, err := HandleCloseChannel(rpcRequest, db, keys, time.Hour, SigningContext{Mode: SignatureModeRaw})
+	require.NoError(t, err)
+	closeResponse, ok := response.Res.Params[0].(CloseChannelResponse)
+	require.True(t, ok)
+
+	// The final state is signed by, and settles the broker share to, the key the channel was opened with
+	require.Len(t, closeResponse.FinalAllocations, 2)
+	assert.Equal(t, previous.GetAddress().Hex(), closeResponse.FinalAllocations[1].Participant)
+	stateHash := common.HexToHash(closeResponse.StateHash)
+	sig := make([]byte, 65)
+	copy(sig[:32], hexutil.MustDecode(closeResponse.Signature.R))
+	copy(sig[32:64], hexutil.MustDecode(closeResponse.Signature.S))
+	sig[64] = closeResponse.Signature.V - 27
+	pubKey, err := crypto.SigToPub(stateHash.Bytes(), sig)
+	require.NoError(t, err)
+	assert.Equal(t, previous.GetAddress(), crypto.PubkeyToAddress(*pubKey))
+
+	// A channel whose broker key was retired can no longer be signed for
+	_, err = HandleCloseChannel(rpcRequest, db, NewBrokerKeys(current, nil), time.Hour, SigningContext{Mode: SignatureModeRaw})
+	assert.ErrorContains(t, err, "is not configured")
+}
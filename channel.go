@@ -13,9 +13,11 @@ import (
 type ChannelStatus string
 
 var (
-	ChannelStatusJoining ChannelStatus = "joining"
-	ChannelStatusOpen    ChannelStatus = "open"
-	ChannelStatusClosed  ChannelStatus = "closed"
+	ChannelStatusJoining    ChannelStatus = "joining"
+	ChannelStatusJoinFailed ChannelStatus = "join_failed"
+	ChannelStatusOpen       ChannelStatus = "open"
+	ChannelStatusChallenged ChannelStatus = "challenged"
+	ChannelStatusClosed     ChannelStatus = "closed"
 )
 
 // Channel represents a state channel between participants
@@ -30,6 +32,7 @@ type Channel struct {
 	Nonce       uint64        `gorm:"column:nonce;default:0"`
 	Version     uint64        `gorm:"column:version;default:0"`
 	Adjudicator string        `gorm:"column:adjudicator;not null"`
+	BrokerKeyID string        `gorm:"column:broker_key_id;not null;default:''"` // Address of the broker key the channel was opened with
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
@@ -40,14 +43,16 @@ func (Channel) TableName() string {
 }
 
 // CreateChannel creates a new channel in the database
-// For real channels, participantB is always the broker application
-func CreateChannel(tx *gorm.DB, channelID, participantA string, nonce uint64, adjudicator string, chainID uint32, tokenAddress string, amount uint64) (Channel, error) {
+// For real channels, participantB is always the broker application, identified by the address of its key
+func CreateChannel(tx *gorm.DB, channelID, participantA, brokerKeyID string, nonce uint64, adjudicator string, challenge uint64, chainID uint32, tokenAddress string, amount uint64) (Channel, error) {
 	channel := Channel{
 		ChannelID:   channelID,
 		Participant: participantA,
+		BrokerKeyID: brokerKeyID,
 		ChainID:     chainID, // Set the network ID for channels
 		Status:      ChannelStatusJoining,
 		Nonce:       nonce,
+		Challenge:   challenge,
 		Adjudicator: adjudicator,
 		Token:       tokenAddress,
 		Amount:      amount,
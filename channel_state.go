@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/erc7824/go-nitrolite"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"gorm.io/gorm"
+)
+
+// ChannelState represents a channel state signed by the broker, kept to defend the channel in disputes
+type ChannelState struct {
+	ID              uint   `gorm:"primaryKey"`
+	ChannelID       string `gorm:"column:channel_id;not null;index:idx_channel_states_channel_version"`
+	Version         uint64 `gorm:"column:version;not null;index:idx_channel_states_channel_version"`
+	Intent          uint8  `gorm:"column:intent;not null"`
+	StateData       string `gorm:"column:state_data;not null"`
+	Allocations     []byte `gorm:"column:allocations;type:text;not null"`
+	BrokerSignature string `gorm:"column:broker_signature;not null"`
+	UserSignature   string `gorm:"column:user_signature;not null;default:''"`
+	CreatedAt       time.Time
+}
+
+// TableName specifies the table name for the ChannelState model
+func (ChannelState) TableName() string {
+	return "channel_states"
+}
+
+// SaveChannelState stores a state signed by the broker. userSig may be nil if the counterparty signature is not known.
+func SaveChannelState(tx *gorm.DB, channelID string, intent nitrolite.Intent, version uint64, stateData []byte, allocations []nitrolite.Allocation, brokerSig nitrolite.Signature, userSig *nitrolite.Signature) (*ChannelState, error) {
+	allocationsJSON, err := json.Marshal(allocations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize allocations: %w", err)
+	}
+
+	state := ChannelState{
+		ChannelID:       channelID,
+		Version:         version,
+		Intent:          uint8(intent),
+		StateData:       hexutil.Encode(stateData),
+		Allocations:     allocationsJSON,
+		BrokerSignature: encodeNitroSignature(brokerSig),
+		CreatedAt:       time.Now(),
+	}
+	if userSig != nil {
+		state.UserSignature = encodeNitroSignature(*userSig)
+	}
+
+	if err := tx.Create(&state).Error; err != nil {
+		return nil, fmt.Errorf("failed to save channel state: %w", err)
+	}
+	return &state, nil
+}
+
+// GetLatestSignedChannelState returns the highest version state of a channel signed by both the user and the broker,
+// or nil if there is none
+func GetLatestSignedChannelState(tx *gorm.DB, channelID string) (*ChannelState, error) {
+	var state ChannelState
+	err := tx.Where("channel_id = ? AND user_signature <> ''", channelID).
+		Order("version DESC").
+		First(&state).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &state, nil
+}
+
+// NitroState converts the stored state into the custody contract representation.
+// Signatures are ordered by participant index: the user first, then the broker.
+func (s ChannelState) NitroState() (nitrolite.State, error) {
+	data, err := hexutil.Decode(s.StateData)
+	if err != nil {
+		return nitrolite.State{}, fmt.Errorf("invalid state data: %w", err)
+	}
+
+	var allocations []nitrolite.Allocation
+	if err := json.Unmarshal(s.Allocations, &allocations); err != nil {
+		return nitrolite.State{}, fmt.Errorf("invalid allocations: %w", err)
+	}
+
+	state := nitrolite.State{
+		Intent:      s.Intent,
+		Version:     new(big.Int).SetUint64(s.Version),
+		Data:        data,
+		Allocations: allocations,
+	}
+
+	for _, encoded := range []string{s.UserSignature, s.BrokerSignature} {
+		if encoded == "" {
+			continue
+		}
+		sig, err := decodeNitroSignature(encoded)
+		if err != nil {
+			return nitrolite.State{}, err
+		}
+		state.Sigs = append(state.Sigs, sig)
+	}
+
+	return state, nil
+}
+
+// encodeNitroSignature serializes a signature as 0x-prefixed r || s || v
+func encodeNitroSignature(sig nitrolite.Signature) string {
+	raw := make([]byte, 65)
+	copy(raw[0:32], sig.R[:])
+	copy(raw[32:64], sig.S[:])
+	raw[64] = sig.V
+	return hexutil.Encode(raw)
+}
+
+// decodeNitroSignature parses a signature serialized by encodeNitroSignature
+func decodeNitroSignature(encoded string) (nitrolite.Signature, error) {
+	raw, err := hexutil.Decode(encoded)
+	if err != nil {
+		return nitrolite.Signature{}, fmt.Errorf("invalid signature: %w", err)
+	}
+	if len(raw) != 65 {
+		return nitrolite.Signature{}, fmt.Errorf("invalid signature length: got %d, want 65", len(raw))
+	}
+
+	var sig nitrolite.Signature
+	copy(sig.R[:], raw[0:32])
+	copy(sig.S[:], raw[32:64])
+	sig.V = raw[64]
+	return sig, nil
+}
+
+// SaveCounterSignedState records a state signed by both the user and the broker, as submitted to the custody contract.
+// The user signature is attached to the stored state with the same broker signature, or the state is stored if it is not known.
+func SaveCounterSignedState(tx *gorm.DB, channelID string, state nitrolite.State) error {
+	if len(state.Sigs) < 2 {
+		return errors.New("state is not signed by both participants")
+	}
+	userSig := state.Sigs[0]
+	brokerSig := state.Sigs[1]
+
+	result := tx.Model(&ChannelState{}).
+		Where("channel_id = ? AND version = ? AND broker_signature = ?", channelID, state.Version.Uint64(), encodeNitroSignature(brokerSig)).
+		Update("user_signature", encodeNitroSignature(userSig))
+	if result.Error != nil {
+		return fmt.Errorf("failed to update channel state: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+
+	_, err := SaveChannelState(tx, channelID, nitrolite.Intent(state.Intent), state.Version.Uint64(), state.Data, state.Allocations, brokerSig, &userSig)
+	return err
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/erc7824/go-nitrolite"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChannelState(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	brokerKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	userKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	channelID := common.HexToHash("0xc0ffee")
+	allocations := []nitrolite.Allocation{
+		{
+			Destination: crypto.PubkeyToAddress(userKey.PublicKey),
+			Token:       common.HexToAddress("0x2222222222222222222222222222222222222222"),
+			Amount:      big.NewInt(1000000),
+		},
+		{
+			Destination: crypto.PubkeyToAddress(brokerKey.PublicKey),
+			Token:       common.HexToAddress("0x2222222222222222222222222222222222222222"),
+			Amount:      big.NewInt(0),
+		},
+	}
+
+	sign := func(version uint64) (nitrolite.Signature, nitrolite.Signature) {
+		encoded, err := nitrolite.EncodeState(channelID, nitrolite.IntentRESIZE, new(big.Int).SetUint64(version), []byte{}, allocations)
+		require.NoError(t, err)
+		brokerSig, err := nitrolite.Sign(encoded, brokerKey)
+		require.NoError(t, err)
+		userSig, err := nitrolite.Sign(encoded, userKey)
+		require.NoError(t, err)
+		return brokerSig, userSig
+	}
+
+	// Without a counterparty signature there is no co-signed state
+	brokerSig, userSig := sign(1)
+	_, err = SaveChannelState(db, channelID.Hex(), nitrolite.IntentRESIZE, 1, []byte{}, allocations, brokerSig, nil)
+	require.NoError(t, err)
+
+	latest, err := GetLatestSignedChannelState(db, channelID.Hex())
+	require.NoError(t, err)
+	assert.Nil(t, latest)
+
+	_, err = SaveChannelState(db, channelID.Hex(), nitrolite.IntentRESIZE, 1, []byte{}, allocations, brokerSig, &userSig)
+	require.NoError(t, err)
+	brokerSig2, _ := sign(2)
+	_, err = SaveChannelState(db, channelID.Hex(), nitrolite.IntentRESIZE, 2, []byte{}, allocations, brokerSig2, nil)
+	require.NoError(t, err)
+
+	latest, err = GetLatestSignedChannelState(db, channelID.Hex())
+	require.NoError(t, err)
+	require.NotNil(t, latest)
+	assert.Equal(t, uint64(1), latest.Version)
+
+	state, err := latest.NitroState()
+	require.NoError(t, err)
+	require.Len(t, state.Sigs, 2)
+	assert.Equal(t, allocations[0].Amount.String(), state.Allocations[0].Amount.String())
+
+	encoded, err := nitrolite.EncodeState(channelID, nitrolite.Intent(state.Intent), state.Version, state.Data, state.Allocations)
+	require.NoError(t, err)
+
+	valid, err := nitrolite.Verify(encoded, state.Sigs[0], crypto.PubkeyToAddress(userKey.PublicKey))
+	require.NoError(t, err)
+	assert.True(t, valid)
+
+	valid, err = nitrolite.Verify(encoded, state.Sigs[1], crypto.PubkeyToAddress(brokerKey.PublicKey))
+	require.NoError(t, err)
+	assert.True(t, valid)
+}
@@ -71,6 +71,10 @@ Returns common environment variables
   value: {{ .Values.config.logLevel }}
 - name: DATABASE_DRIVER
   value: {{ .Values.config.database.driver }}
+{{- if .Values.config.file }}
+- name: CLEARNODE_CONFIG_FILE
+  value: /etc/clearnode/clearnode.yaml
+{{- end }}
 {{- range $key, $value := .Values.config.extraEnvs }}
 - name: {{ $key | upper }}
   value: {{ $value | print | quote }}
@@ -1,19 +1,32 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ilyakaznacheev/cleanenv"
 	"github.com/joho/godotenv"
+	"github.com/shopspring/decimal"
 )
 
 // knownNetworks maps network name prefixes to their respective chain IDs.
-// Each prefix is used to find corresponding environment variables:
-// - {PREFIX}_INFURA_URL: The Infura endpoint URL for the network
+// They are enabled from environment variables alone, while other networks are added in the config file.
+// Each prefix, or the upper-cased name of a network from the config file, is used to find the
+// corresponding environment variables, which override the config file:
+// - {PREFIX}_INFURA_URL or {PREFIX}_RPC_URL: The RPC endpoint URL for the network
 // - {PREFIX}_CUSTODY_CONTRACT_ADDRESS: The custody contract address
+// - {PREFIX}_ADJUDICATORS: Optional comma separated adjudicator addresses channels may be opened with
+// - {PREFIX}_CONFIRMATIONS: Optional number of blocks a log must be buried under before it is processed
+// - {PREFIX}_GAS_MODE: Optional transaction type, "eip1559" (default) or "legacy"
+// - {PREFIX}_MAX_FEE_GWEI: Optional cap of the max fee per gas, or of the gas price of legacy transactions
+// - {PREFIX}_TIP_MULTIPLIER: Optional multiplier of the suggested priority fee, or of the suggested legacy gas price
+// - {PREFIX}_GAS_LIMIT_MARGIN: Optional percentage added to the estimated gas of broker transactions
 var knownNetworks = map[string]uint32{
 	"POLYGON":     137,
 	"ETH_SEPOLIA": 11155111,
@@ -28,17 +41,147 @@ type NetworkConfig struct {
 	ChainID        uint32
 	InfuraURL      string
 	CustodyAddress string
+	Adjudicators   []string // Adjudicators channels may be opened with, any adjudicator if empty
+	Confirmations  uint64
+	GasPolicy      GasPolicy
+	Assets         []AssetConfig // Assets added to the database when the network is loaded
+}
+
+// AssetConfig is a token of a network
+type AssetConfig struct {
+	Token    string
+	Symbol   string
+	Decimals uint8
+	// Optional targets of the broker's available custody balance in token units, kept by the treasury
+	MinLiquidity string
+	MaxLiquidity string
+}
+
+// LiquidityTargets returns the minimum and maximum broker liquidity of the asset, nil when not set
+func (a AssetConfig) LiquidityTargets() (*decimal.Decimal, *decimal.Decimal, error) {
+	var targets [2]*decimal.Decimal
+	for i, value := range []string{a.MinLiquidity, a.MaxLiquidity} {
+		if value == "" {
+			continue
+		}
+		target, err := decimal.NewFromString(value)
+		if err != nil || target.IsNegative() {
+			return nil, nil, fmt.Errorf("invalid liquidity target %q of asset %s", value, a.Token)
+		}
+		targets[i] = &target
+	}
+	if targets[0] != nil && targets[1] != nil && targets[0].GreaterThan(*targets[1]) {
+		return nil, nil, fmt.Errorf("min_liquidity of asset %s is above its max_liquidity", a.Token)
+	}
+	return targets[0], targets[1], nil
 }
 
 // Config represents the overall application configuration
 type Config struct {
 	networks      map[string]*NetworkConfig
-	privateKeyHex string
+	signerConf    SignerConfig
 	dbConf        DatabaseConfig
-	msgExpiryTime int // Time in seconds for message timestamp validation
+	listenAddr    string // Address of the WebSocket server
+	metricsAddr   string // Address of the Prometheus metrics server
+	adminAddr     string // Address of the admin API server
+	adminToken    string // Bearer token of the admin API, which is disabled without one
+	msgExpiryTime int    // Time in seconds for message timestamp validation
+	// Time after which funds locked for a signed resize or close state return to the unified balance
+	settlementLockTTL time.Duration
+	// Maximum number of simultaneous WebSocket connections per address, 0 means unlimited
+	maxConnectionsPerAddress int
+	// Number of messages buffered per WebSocket connection before senders are blocked
+	wsOutboundQueueSize int
+	// Interval between WebSocket pings, 0 disables keepalive and idle connection reaping
+	wsPingInterval time.Duration
+	// Lifetime of the session tokens returned by auth_verify
+	sessionTokenTTL time.Duration
+	// How long EIP-1271 contract wallet signature checks are cached
+	contractSignatureCacheTTL time.Duration
+	// Replacement policy of stuck broker transactions
+	txConf TxManagerConfig
+	// Interval between checks of the config file for changed networks, 0 only reloads on SIGHUP
+	configReloadInterval time.Duration
+	// Interval between checks of the broker liquidity against its targets, 0 disables the treasury
+	treasuryInterval time.Duration
+	// Whether the treasury sends the rebalancing transactions of its plan
+	treasuryAutoExecute bool
 }
 
-// LoadConfig builds configuration from environment variables
+// FileConfig is the layout of the YAML or TOML config file set with CLEARNODE_CONFIG_FILE.
+// Durations are in seconds like their environment variables, which take precedence over the file.
+// The database, the broker signer and the admin API token are only configured with environment variables.
+// Networks and their assets are reloaded when the file changes, other settings need a restart.
+type FileConfig struct {
+	ListenAddr                string              `yaml:"listen_addr" toml:"listen_addr"`
+	MetricsAddr               string              `yaml:"metrics_addr" toml:"metrics_addr"`
+	AdminAddr                 string              `yaml:"admin_addr" toml:"admin_addr"`
+	MsgExpiryTime             int                 `yaml:"msg_expiry_time" toml:"msg_expiry_time"`
+	SettlementLockTTL         int                 `yaml:"settlement_lock_ttl" toml:"settlement_lock_ttl"`
+	MaxConnectionsPerAddress  int                 `yaml:"max_connections_per_address" toml:"max_connections_per_address"`
+	WSOutboundQueueSize       int                 `yaml:"ws_outbound_queue_size" toml:"ws_outbound_queue_size"`
+	WSPingInterval            int                 `yaml:"ws_ping_interval" toml:"ws_ping_interval"`
+	SessionTokenTTL           int                 `yaml:"session_token_ttl" toml:"session_token_ttl"`
+	ContractSignatureCacheTTL int                 `yaml:"contract_signature_cache_ttl" toml:"contract_signature_cache_ttl"`
+	TxResubmitTimeout         int                 `yaml:"tx_resubmit_timeout" toml:"tx_resubmit_timeout"`
+	TxMaxResubmits            int                 `yaml:"tx_max_resubmits" toml:"tx_max_resubmits"`
+	ConfigReloadInterval      int                 `yaml:"config_reload_interval" toml:"config_reload_interval"`
+	TreasuryInterval          int                 `yaml:"treasury_interval" toml:"treasury_interval"`
+	TreasuryAutoExecute       bool                `yaml:"treasury_auto_execute" toml:"treasury_auto_execute"`
+	Networks                  []NetworkFileConfig `yaml:"networks" toml:"networks"`
+}
+
+// NetworkFileConfig is a network in the config file
+type NetworkFileConfig struct {
+	Name           string            `yaml:"name" toml:"name"`
+	ChainID        uint32            `yaml:"chain_id" toml:"chain_id"`
+	RPCURL         string            `yaml:"rpc_url" toml:"rpc_url"`
+	CustodyAddress string            `yaml:"custody_address" toml:"custody_address"`
+	Adjudicators   []string          `yaml:"adjudicators" toml:"adjudicators"`
+	Confirmations  uint64            `yaml:"confirmations" toml:"confirmations"`
+	Gas            GasFileConfig     `yaml:"gas" toml:"gas"`
+	Assets         []AssetFileConfig `yaml:"assets" toml:"assets"`
+}
+
+// AssetFileConfig is a token of a network in the config file. Decimals are required,
+// as a missing value would add the token and its asset group with 0 decimals.
+type AssetFileConfig struct {
+	Token        string `yaml:"token" toml:"token"`
+	Symbol       string `yaml:"symbol" toml:"symbol"`
+	Decimals     *uint8 `yaml:"decimals" toml:"decimals"`
+	MinLiquidity string `yaml:"min_liquidity" toml:"min_liquidity"`
+	MaxLiquidity string `yaml:"max_liquidity" toml:"max_liquidity"`
+}
+
+// GasFileConfig is the gas policy of a network in the config file, unset fields keep their default
+type GasFileConfig struct {
+	Mode           string  `yaml:"mode" toml:"mode"`
+	MaxFeeGwei     float64 `yaml:"max_fee_gwei" toml:"max_fee_gwei"`
+	TipMultiplier  float64 `yaml:"tip_multiplier" toml:"tip_multiplier"`
+	GasLimitMargin *uint64 `yaml:"gas_limit_margin" toml:"gas_limit_margin"`
+}
+
+// defaultFileConfig returns the settings used when they are neither in the config file nor in the environment
+func defaultFileConfig() FileConfig {
+	return FileConfig{
+		ListenAddr:                ":8000",
+		MetricsAddr:               ":4242",
+		AdminAddr:                 ":4243",
+		MsgExpiryTime:             60,
+		SettlementLockTTL:         3600,
+		MaxConnectionsPerAddress:  10,
+		WSOutboundQueueSize:       256,
+		WSPingInterval:            30,
+		SessionTokenTTL:           86400,
+		ContractSignatureCacheTTL: 300,
+		TxResubmitTimeout:         120,
+		TxMaxResubmits:            5,
+		ConfigReloadInterval:      30,
+		TreasuryInterval:          60,
+	}
+}
+
+// LoadConfig builds configuration from the optional config file and environment variables
 func LoadConfig() (*Config, error) {
 	var err error
 	// Load environment variables
@@ -46,6 +189,11 @@ func LoadConfig() (*Config, error) {
 		log.Println("Warning: .env file not found")
 	}
 
+	fileConf, err := readConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
 	// Get database URL from environment variables
 	var dbConf DatabaseConfig
 	dbURL := os.Getenv("CLEARNODE_DATABASE_URL")
@@ -66,13 +214,27 @@ func LoadConfig() (*Config, error) {
 		}
 	}
 
-	// Retrieve the private key.
-	privateKeyHex := os.Getenv("BROKER_PRIVATE_KEY")
-	if privateKeyHex == "" {
-		log.Println("BROKER_PRIVATE_KEY environment variable is required")
+	// Read the broker signer backend, the private key is only required for the local backend
+	var signerConf SignerConfig
+	if err := cleanenv.ReadEnv(&signerConf); err != nil {
+		logger.Errorw("failed to read signer env", "err", err)
+		return nil, err
 	}
 
-	messageTimestampExpiry := 60
+	listenAddr := fileConf.ListenAddr
+	if addr := os.Getenv("LISTEN_ADDR"); addr != "" {
+		listenAddr = addr
+	}
+	metricsAddr := fileConf.MetricsAddr
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		metricsAddr = addr
+	}
+	adminAddr := fileConf.AdminAddr
+	if addr := os.Getenv("ADMIN_ADDR"); addr != "" {
+		adminAddr = addr
+	}
+
+	messageTimestampExpiry := fileConf.MsgExpiryTime
 	if messageExpiry := os.Getenv("MSG_EXPIRY_TIME"); messageExpiry != "" {
 		if parsed, err := strconv.Atoi(messageExpiry); err == nil && parsed > 0 {
 			messageTimestampExpiry = parsed
@@ -82,47 +244,399 @@ func LoadConfig() (*Config, error) {
 	}
 	log.Printf("Using %d seconds message expiry time", messageTimestampExpiry)
 
-	config := Config{
-		networks:      make(map[string]*NetworkConfig),
-		privateKeyHex: privateKeyHex,
-		dbConf:        dbConf,
-		msgExpiryTime: messageTimestampExpiry,
+	settlementLockTTL := time.Duration(fileConf.SettlementLockTTL) * time.Second
+	if lockTTL := os.Getenv("SETTLEMENT_LOCK_TTL"); lockTTL != "" {
+		if parsed, err := strconv.Atoi(lockTTL); err == nil && parsed > 0 {
+			settlementLockTTL = time.Duration(parsed) * time.Second
+		} else {
+			log.Println("Invalid SETTLEMENT_LOCK_TTL, using default value")
+		}
 	}
+	log.Printf("Using %s settlement lock TTL", settlementLockTTL)
 
-	// Process each network
-	envs := os.Environ()
-	for network, chainID := range knownNetworks {
-		infuraURL := ""
-		custodyAddress := ""
+	maxConnectionsPerAddress := fileConf.MaxConnectionsPerAddress
+	if maxConns := os.Getenv("MAX_CONNECTIONS_PER_ADDRESS"); maxConns != "" {
+		if parsed, err := strconv.Atoi(maxConns); err == nil && parsed >= 0 {
+			maxConnectionsPerAddress = parsed
+		} else {
+			log.Println("Invalid MAX_CONNECTIONS_PER_ADDRESS, using default value")
+		}
+	}
 
-		// Look for matching environment variables
-		for _, env := range envs {
-			parts := strings.SplitN(env, "=", 2)
-			if len(parts) != 2 {
-				continue
-			}
+	wsOutboundQueueSize := fileConf.WSOutboundQueueSize
+	if queueSize := os.Getenv("WS_OUTBOUND_QUEUE_SIZE"); queueSize != "" {
+		if parsed, err := strconv.Atoi(queueSize); err == nil && parsed > 0 {
+			wsOutboundQueueSize = parsed
+		} else {
+			log.Println("Invalid WS_OUTBOUND_QUEUE_SIZE, using default value")
+		}
+	}
 
-			key := parts[0]
-			value := parts[1]
+	wsPingInterval := time.Duration(fileConf.WSPingInterval) * time.Second
+	if pingInterval := os.Getenv("WS_PING_INTERVAL"); pingInterval != "" {
+		if parsed, err := strconv.Atoi(pingInterval); err == nil && parsed >= 0 {
+			wsPingInterval = time.Duration(parsed) * time.Second
+		} else {
+			log.Println("Invalid WS_PING_INTERVAL, using default value")
+		}
+	}
 
-			if strings.HasPrefix(key, network+"_INFURA_URL") {
-				infuraURL = value
-			} else if strings.HasPrefix(key, network+"_CUSTODY_CONTRACT_ADDRESS") {
-				custodyAddress = value
-			}
+	sessionTokenTTL := time.Duration(fileConf.SessionTokenTTL) * time.Second
+	if tokenTTL := os.Getenv("SESSION_TOKEN_TTL"); tokenTTL != "" {
+		if parsed, err := strconv.Atoi(tokenTTL); err == nil && parsed > 0 {
+			sessionTokenTTL = time.Duration(parsed) * time.Second
+		} else {
+			log.Println("Invalid SESSION_TOKEN_TTL, using default value")
 		}
+	}
 
-		// Only add network if both required variables are present
-		if infuraURL != "" && custodyAddress != "" {
-			networkLower := strings.ToLower(network)
-			config.networks[networkLower] = &NetworkConfig{
-				Name:           networkLower,
-				ChainID:        chainID,
-				InfuraURL:      infuraURL,
-				CustodyAddress: custodyAddress,
-			}
+	contractSignatureCacheTTL := time.Duration(fileConf.ContractSignatureCacheTTL) * time.Second
+	if cacheTTL := os.Getenv("CONTRACT_SIGNATURE_CACHE_TTL"); cacheTTL != "" {
+		if parsed, err := strconv.Atoi(cacheTTL); err == nil && parsed >= 0 {
+			contractSignatureCacheTTL = time.Duration(parsed) * time.Second
+		} else {
+			log.Println("Invalid CONTRACT_SIGNATURE_CACHE_TTL, using default value")
 		}
 	}
 
+	txConf := TxManagerConfig{
+		ResubmitTimeout: time.Duration(fileConf.TxResubmitTimeout) * time.Second,
+		MaxResubmits:    fileConf.TxMaxResubmits,
+	}
+	if resubmitTimeout := os.Getenv("TX_RESUBMIT_TIMEOUT"); resubmitTimeout != "" {
+		if parsed, err := strconv.Atoi(resubmitTimeout); err == nil && parsed > 0 {
+			txConf.ResubmitTimeout = time.Duration(parsed) * time.Second
+		} else {
+			log.Println("Invalid TX_RESUBMIT_TIMEOUT, using default value")
+		}
+	}
+	if maxResubmits := os.Getenv("TX_MAX_RESUBMITS"); maxResubmits != "" {
+		if parsed, err := strconv.Atoi(maxResubmits); err == nil && parsed >= 0 {
+			txConf.MaxResubmits = parsed
+		} else {
+			log.Println("Invalid TX_MAX_RESUBMITS, using default value")
+		}
+	}
+
+	configReloadInterval := time.Duration(fileConf.ConfigReloadInterval) * time.Second
+	if reloadInterval := os.Getenv("CONFIG_RELOAD_INTERVAL"); reloadInterval != "" {
+		if parsed, err := strconv.Atoi(reloadInterval); err == nil && parsed >= 0 {
+			configReloadInterval = time.Duration(parsed) * time.Second
+		} else {
+			log.Println("Invalid CONFIG_RELOAD_INTERVAL, using default value")
+		}
+	}
+
+	treasuryInterval := time.Duration(fileConf.TreasuryInterval) * time.Second
+	if interval := os.Getenv("TREASURY_INTERVAL"); interval != "" {
+		if parsed, err := strconv.Atoi(interval); err == nil && parsed >= 0 {
+			treasuryInterval = time.Duration(parsed) * time.Second
+		} else {
+			log.Println("Invalid TREASURY_INTERVAL, using default value")
+		}
+	}
+	treasuryAutoExecute := fileConf.TreasuryAutoExecute
+	if autoExecute := os.Getenv("TREASURY_AUTO_EXECUTE"); autoExecute != "" {
+		if parsed, err := strconv.ParseBool(autoExecute); err == nil {
+			treasuryAutoExecute = parsed
+		} else {
+			log.Println("Invalid TREASURY_AUTO_EXECUTE, using default value")
+		}
+	}
+
+	networks, err := loadNetworks(fileConf.Networks)
+	if err != nil {
+		return nil, err
+	}
+
+	config := Config{
+		networks:          networks,
+		signerConf:        signerConf,
+		dbConf:            dbConf,
+		listenAddr:        listenAddr,
+		metricsAddr:       metricsAddr,
+		adminAddr:         adminAddr,
+		adminToken:        os.Getenv("ADMIN_API_TOKEN"),
+		msgExpiryTime:     messageTimestampExpiry,
+		settlementLockTTL: settlementLockTTL,
+
+		maxConnectionsPerAddress: maxConnectionsPerAddress,
+		wsOutboundQueueSize:      wsOutboundQueueSize,
+		wsPingInterval:           wsPingInterval,
+		sessionTokenTTL:          sessionTokenTTL,
+
+		contractSignatureCacheTTL: contractSignatureCacheTTL,
+		txConf:                    txConf,
+		configReloadInterval:      configReloadInterval,
+		treasuryInterval:          treasuryInterval,
+		treasuryAutoExecute:       treasuryAutoExecute,
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
 	return &config, nil
 }
+
+// LoadNetworks reads the networks again from the config file and the environment, so that
+// network changes are applied without a restart
+func LoadNetworks() (map[string]*NetworkConfig, error) {
+	fileConf, err := readConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	networks, err := loadNetworks(fileConf.Networks)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateNetworks(networks); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return networks, nil
+}
+
+// readConfigFile reads the file set with CLEARNODE_CONFIG_FILE over the default settings
+func readConfigFile() (FileConfig, error) {
+	fileConf := defaultFileConfig()
+	if configFile := os.Getenv("CLEARNODE_CONFIG_FILE"); configFile != "" {
+		if err := cleanenv.ReadConfig(configFile, &fileConf); err != nil {
+			return fileConf, fmt.Errorf("failed to read config file %s: %w", configFile, err)
+		}
+		log.Printf("Loaded config file %s", configFile)
+	}
+	return fileConf, nil
+}
+
+// loadNetworks merges the networks of the config file with the known networks and applies their
+// environment overrides. Known networks are only enabled once their RPC URL and custody address are set.
+func loadNetworks(fileNetworks []NetworkFileConfig) (map[string]*NetworkConfig, error) {
+	networks := make(map[string]*NetworkConfig)
+	fromFile := make(map[string]bool)
+
+	for _, fileNetwork := range fileNetworks {
+		network, err := fileNetwork.networkConfig()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := networks[network.Name]; ok {
+			return nil, fmt.Errorf("network %s is configured more than once", network.Name)
+		}
+		networks[network.Name] = network
+		fromFile[network.Name] = true
+	}
+
+	for prefix, chainID := range knownNetworks {
+		name := strings.ToLower(prefix)
+		if _, ok := networks[name]; !ok {
+			networks[name] = &NetworkConfig{Name: name, ChainID: chainID, GasPolicy: DefaultGasPolicy()}
+		}
+	}
+
+	for name, network := range networks {
+		prefix := strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		applyNetworkEnv(prefix, network)
+
+		if !fromFile[name] && (network.InfuraURL == "" || network.CustodyAddress == "") {
+			delete(networks, name)
+		}
+	}
+
+	return networks, nil
+}
+
+// networkConfig converts a network of the config file, filling unset gas settings with their defaults.
+// Assets must set their decimals.
+func (n NetworkFileConfig) networkConfig() (*NetworkConfig, error) {
+	if n.Name == "" {
+		return nil, errors.New("network name is required in the config file")
+	}
+
+	gasPolicy := DefaultGasPolicy()
+	if n.Gas.Mode != "" {
+		gasPolicy.Mode = strings.ToLower(n.Gas.Mode)
+	}
+	if n.Gas.MaxFeeGwei != 0 {
+		gasPolicy.MaxFeePerGas = decimal.NewFromFloat(n.Gas.MaxFeeGwei).Shift(9).BigInt()
+	}
+	if n.Gas.TipMultiplier != 0 {
+		gasPolicy.TipMultiplier = n.Gas.TipMultiplier
+	}
+	if n.Gas.GasLimitMargin != nil {
+		gasPolicy.GasLimitMargin = *n.Gas.GasLimitMargin
+	}
+
+	assets := make([]AssetConfig, 0, len(n.Assets))
+	for _, asset := range n.Assets {
+		if asset.Decimals == nil {
+			return nil, fmt.Errorf("network %s: asset %s has no decimals", n.Name, asset.Token)
+		}
+		assets = append(assets, AssetConfig{
+			Token:        asset.Token,
+			Symbol:       asset.Symbol,
+			Decimals:     *asset.Decimals,
+			MinLiquidity: asset.MinLiquidity,
+			MaxLiquidity: asset.MaxLiquidity,
+		})
+	}
+
+	return &NetworkConfig{
+		Name:           strings.ToLower(n.Name),
+		ChainID:        n.ChainID,
+		InfuraURL:      n.RPCURL,
+		CustodyAddress: n.CustodyAddress,
+		Adjudicators:   n.Adjudicators,
+		Confirmations:  n.Confirmations,
+		GasPolicy:      gasPolicy,
+		Assets:         assets,
+	}, nil
+}
+
+// applyNetworkEnv overrides the settings of a network with the environment variables of its prefix
+func applyNetworkEnv(prefix string, network *NetworkConfig) {
+	if value := os.Getenv(prefix + "_RPC_URL"); value != "" {
+		network.InfuraURL = value
+	}
+	if value := os.Getenv(prefix + "_INFURA_URL"); value != "" {
+		network.InfuraURL = value
+	}
+	if value := os.Getenv(prefix + "_CUSTODY_CONTRACT_ADDRESS"); value != "" {
+		network.CustodyAddress = value
+	}
+	if value := os.Getenv(prefix + "_ADJUDICATORS"); value != "" {
+		network.Adjudicators = nil
+		for _, adjudicator := range strings.Split(value, ",") {
+			network.Adjudicators = append(network.Adjudicators, strings.TrimSpace(adjudicator))
+		}
+	}
+	if value := os.Getenv(prefix + "_CONFIRMATIONS"); value != "" {
+		if parsed, err := strconv.ParseUint(value, 10, 64); err == nil {
+			network.Confirmations = parsed
+		} else {
+			log.Printf("Invalid %s_CONFIRMATIONS, using default value", prefix)
+		}
+	}
+	if value := os.Getenv(prefix + "_GAS_MODE"); value != "" {
+		network.GasPolicy.Mode = strings.ToLower(value)
+	}
+	if value := os.Getenv(prefix + "_MAX_FEE_GWEI"); value != "" {
+		if parsed, err := decimal.NewFromString(value); err == nil && parsed.IsPositive() {
+			network.GasPolicy.MaxFeePerGas = parsed.Shift(9).BigInt()
+		} else {
+			log.Printf("Invalid %s_MAX_FEE_GWEI, using default value", prefix)
+		}
+	}
+	if value := os.Getenv(prefix + "_TIP_MULTIPLIER"); value != "" {
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil && parsed > 0 {
+			network.GasPolicy.TipMultiplier = parsed
+		} else {
+			log.Printf("Invalid %s_TIP_MULTIPLIER, using default value", prefix)
+		}
+	}
+	if value := os.Getenv(prefix + "_GAS_LIMIT_MARGIN"); value != "" {
+		if parsed, err := strconv.ParseUint(value, 10, 64); err == nil {
+			network.GasPolicy.GasLimitMargin = parsed
+		} else {
+			log.Printf("Invalid %s_GAS_LIMIT_MARGIN, using default value", prefix)
+		}
+	}
+}
+
+// Validate checks the configuration at startup, so that a mistake in the config file is reported
+// before any connection is made
+func (c *Config) Validate() error {
+	if c.listenAddr == "" {
+		return errors.New("listen_addr is required")
+	}
+	if c.metricsAddr == "" {
+		return errors.New("metrics_addr is required")
+	}
+	if c.adminToken != "" && c.adminAddr == "" {
+		return errors.New("admin_addr is required")
+	}
+	if c.msgExpiryTime <= 0 {
+		return errors.New("msg_expiry_time must be positive")
+	}
+	if c.settlementLockTTL <= 0 {
+		return errors.New("settlement_lock_ttl must be positive")
+	}
+	if c.sessionTokenTTL <= 0 {
+		return errors.New("session_token_ttl must be positive")
+	}
+	if c.maxConnectionsPerAddress < 0 {
+		return errors.New("max_connections_per_address must not be negative")
+	}
+	if c.wsOutboundQueueSize <= 0 {
+		return errors.New("ws_outbound_queue_size must be positive")
+	}
+	if c.wsPingInterval < 0 {
+		return errors.New("ws_ping_interval must not be negative")
+	}
+	if c.contractSignatureCacheTTL < 0 {
+		return errors.New("contract_signature_cache_ttl must not be negative")
+	}
+	if c.txConf.ResubmitTimeout <= 0 {
+		return errors.New("tx_resubmit_timeout must be positive")
+	}
+	if c.txConf.MaxResubmits < 0 {
+		return errors.New("tx_max_resubmits must not be negative")
+	}
+	if c.configReloadInterval < 0 {
+		return errors.New("config_reload_interval must not be negative")
+	}
+	if c.treasuryInterval < 0 {
+		return errors.New("treasury_interval must not be negative")
+	}
+
+	return validateNetworks(c.networks)
+}
+
+// validateNetworks checks every network and that no two networks share a chain
+func validateNetworks(networks map[string]*NetworkConfig) error {
+	chains := make(map[uint32]string)
+	for name, network := range networks {
+		if err := network.Validate(); err != nil {
+			return fmt.Errorf("network %s: %w", name, err)
+		}
+		if other, ok := chains[network.ChainID]; ok {
+			return fmt.Errorf("networks %s and %s have the same chain ID %d", other, name, network.ChainID)
+		}
+		chains[network.ChainID] = name
+	}
+	return nil
+}
+
+// Validate checks that the network can be connected to
+func (n *NetworkConfig) Validate() error {
+	if n.ChainID == 0 {
+		return errors.New("chain_id is required")
+	}
+	if n.InfuraURL == "" {
+		return errors.New("rpc_url is required")
+	}
+	if !common.IsHexAddress(n.CustodyAddress) {
+		return fmt.Errorf("invalid custody_address %q", n.CustodyAddress)
+	}
+	for _, adjudicator := range n.Adjudicators {
+		if !common.IsHexAddress(adjudicator) {
+			return fmt.Errorf("invalid adjudicator %q", adjudicator)
+		}
+	}
+	if err := n.GasPolicy.Validate(); err != nil {
+		return fmt.Errorf("invalid gas policy: %w", err)
+	}
+	for _, asset := range n.Assets {
+		if !common.IsHexAddress(asset.Token) {
+			return fmt.Errorf("invalid asset token %q", asset.Token)
+		}
+		if asset.Symbol == "" {
+			return fmt.Errorf("asset %s has no symbol", asset.Token)
+		}
+		if _, _, err := asset.LiquidityTargets(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
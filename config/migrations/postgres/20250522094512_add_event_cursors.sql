@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE event_cursors (
+    chain_id BIGINT NOT NULL,
+    contract_address VARCHAR NOT NULL,
+    block_number BIGINT NOT NULL,
+    log_index BIGINT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (chain_id, contract_address)
+);
+
+-- +goose Down
+DROP TABLE event_cursors;
@@ -0,0 +1,17 @@
+-- +goose Up
+CREATE TABLE contract_events (
+    id SERIAL PRIMARY KEY,
+    chain_id BIGINT NOT NULL,
+    tx_hash VARCHAR NOT NULL,
+    log_index BIGINT NOT NULL,
+    block_number BIGINT NOT NULL,
+    block_hash VARCHAR NOT NULL,
+    contract_address VARCHAR NOT NULL,
+    event_id VARCHAR NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_contract_events_chain_tx_log ON contract_events(chain_id, tx_hash, log_index);
+
+-- +goose Down
+DROP TABLE contract_events;
@@ -0,0 +1,15 @@
+-- +goose Up
+ALTER TABLE contract_events ADD COLUMN channel_id VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE contract_events ADD COLUMN prev_status VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE contract_events ADD COLUMN prev_amount BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE contract_events ADD COLUMN prev_version BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE contract_events ADD COLUMN asset_symbol VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE contract_events ADD COLUMN ledger_amount DECIMAL(38,18) NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE contract_events DROP COLUMN ledger_amount;
+ALTER TABLE contract_events DROP COLUMN asset_symbol;
+ALTER TABLE contract_events DROP COLUMN prev_version;
+ALTER TABLE contract_events DROP COLUMN prev_amount;
+ALTER TABLE contract_events DROP COLUMN prev_status;
+ALTER TABLE contract_events DROP COLUMN channel_id;
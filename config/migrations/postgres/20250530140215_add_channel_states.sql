@@ -0,0 +1,17 @@
+-- +goose Up
+CREATE TABLE channel_states (
+    id SERIAL PRIMARY KEY,
+    channel_id VARCHAR NOT NULL,
+    version BIGINT NOT NULL,
+    intent SMALLINT NOT NULL,
+    state_data VARCHAR NOT NULL,
+    allocations TEXT NOT NULL,
+    broker_signature VARCHAR NOT NULL,
+    user_signature VARCHAR NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_channel_states_channel_version ON channel_states(channel_id, version);
+
+-- +goose Down
+DROP TABLE channel_states;
@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE pending_settlements (
+    channel_id VARCHAR PRIMARY KEY,
+    participant VARCHAR NOT NULL,
+    asset_symbol VARCHAR NOT NULL,
+    version BIGINT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_pending_settlements_expires_at ON pending_settlements(expires_at);
+
+-- +goose Down
+DROP TABLE pending_settlements;
@@ -0,0 +1,24 @@
+-- +goose Up
+CREATE TABLE session_keys (
+    address VARCHAR PRIMARY KEY,
+    owner VARCHAR NOT NULL,
+    methods TEXT[] NOT NULL,
+    app_protocols TEXT[],
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_session_keys_owner ON session_keys(owner);
+
+CREATE TABLE session_key_allowances (
+    session_key VARCHAR NOT NULL REFERENCES session_keys(address) ON DELETE CASCADE,
+    asset_symbol VARCHAR NOT NULL,
+    allowance DECIMAL(38,18) NOT NULL,
+    spent DECIMAL(38,18) NOT NULL DEFAULT 0,
+    PRIMARY KEY (session_key, asset_symbol)
+);
+
+-- +goose Down
+DROP TABLE session_key_allowances;
+DROP TABLE session_keys;
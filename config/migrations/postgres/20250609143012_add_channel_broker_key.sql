@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE channels ADD COLUMN broker_key_id VARCHAR NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE channels DROP COLUMN broker_key_id;
@@ -0,0 +1,29 @@
+-- +goose Up
+CREATE TABLE broker_transactions (
+    id SERIAL PRIMARY KEY,
+    chain_id BIGINT NOT NULL,
+    kind VARCHAR NOT NULL,
+    channel_id VARCHAR NOT NULL DEFAULT '',
+    from_address VARCHAR NOT NULL,
+    to_address VARCHAR NOT NULL,
+    data BYTEA,
+    nonce BIGINT NOT NULL,
+    gas_limit BIGINT NOT NULL,
+    gas_price VARCHAR NOT NULL,
+    tx_hash VARCHAR NOT NULL,
+    replaced_hashes VARCHAR NOT NULL DEFAULT '',
+    resubmits INTEGER NOT NULL DEFAULT 0,
+    status VARCHAR NOT NULL,
+    error VARCHAR NOT NULL DEFAULT '',
+    block_number BIGINT NOT NULL DEFAULT 0,
+    sent_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_broker_transactions_status ON broker_transactions(chain_id, status);
+CREATE INDEX idx_broker_transactions_channel_id ON broker_transactions(channel_id);
+CREATE INDEX idx_broker_transactions_tx_hash ON broker_transactions(tx_hash);
+
+-- +goose Down
+DROP TABLE broker_transactions;
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE broker_transactions ADD COLUMN gas_tip_cap VARCHAR NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE broker_transactions DROP COLUMN gas_tip_cap;
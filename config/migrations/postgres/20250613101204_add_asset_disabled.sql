@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE assets ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT false;
+
+-- +goose Down
+ALTER TABLE assets DROP COLUMN disabled;
@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE asset_groups (
+    symbol VARCHAR PRIMARY KEY,
+    decimals SMALLINT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Existing symbols become groups with the most decimals of their tokens
+INSERT INTO asset_groups (symbol, decimals)
+SELECT symbol, MAX(decimals) FROM assets GROUP BY symbol;
+
+-- +goose Down
+DROP TABLE asset_groups;
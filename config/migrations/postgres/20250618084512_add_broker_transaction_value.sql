@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE broker_transactions ADD COLUMN value VARCHAR NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE broker_transactions DROP COLUMN value;
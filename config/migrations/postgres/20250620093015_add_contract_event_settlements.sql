@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE contract_events ADD COLUMN settlement_asset VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE contract_events ADD COLUMN settlement_amount DECIMAL(38,18) NOT NULL DEFAULT 0;
+ALTER TABLE contract_events ADD COLUMN settlement_version BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE contract_events ADD COLUMN settlement_expires_at TIMESTAMPTZ;
+
+-- +goose Down
+ALTER TABLE contract_events DROP COLUMN settlement_expires_at;
+ALTER TABLE contract_events DROP COLUMN settlement_version;
+ALTER TABLE contract_events DROP COLUMN settlement_amount;
+ALTER TABLE contract_events DROP COLUMN settlement_asset;
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE pending_settlements ADD COLUMN allocated DECIMAL(38,18) NOT NULL DEFAULT 0;
+ALTER TABLE contract_events ADD COLUMN settlement_allocated DECIMAL(38,18) NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE contract_events DROP COLUMN settlement_allocated;
+ALTER TABLE pending_settlements DROP COLUMN allocated;
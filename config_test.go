@@ -0,0 +1,214 @@
+package main
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestConfigFile writes a config file and points CLEARNODE_CONFIG_FILE to it
+func writeTestConfigFile(t *testing.T, name, content string) {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	t.Setenv("CLEARNODE_CONFIG_FILE", path)
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	writeTestConfigFile(t, "clearnode.yaml", `
+listen_addr: ":9000"
+session_token_ttl: 3600
+msg_expiry_time: 30
+networks:
+  - name: arbitrum
+    chain_id: 42161
+    rpc_url: https://arbitrum.example
+    custody_address: "0x1111111111111111111111111111111111111111"
+    adjudicators: ["0x2222222222222222222222222222222222222222"]
+    confirmations: 3
+    gas:
+      max_fee_gwei: 1.5
+      tip_multiplier: 2
+  - name: anvil
+    chain_id: 31337
+    rpc_url: http://localhost:8545
+    custody_address: "0x3333333333333333333333333333333333333333"
+    gas:
+      mode: legacy
+      gas_limit_margin: 0
+`)
+	// Environment variables override the file and still enable known networks
+	t.Setenv("SESSION_TOKEN_TTL", "600")
+	t.Setenv("ARBITRUM_CONFIRMATIONS", "12")
+	t.Setenv("POLYGON_INFURA_URL", "https://polygon.example")
+	t.Setenv("POLYGON_CUSTODY_CONTRACT_ADDRESS", "0x4444444444444444444444444444444444444444")
+
+	config, err := LoadConfig()
+	require.NoError(t, err)
+
+	assert.Equal(t, ":9000", config.listenAddr)
+	assert.Equal(t, ":4242", config.metricsAddr)
+	assert.Equal(t, 30, config.msgExpiryTime)
+	assert.Equal(t, 10*time.Minute, config.sessionTokenTTL)
+	assert.Equal(t, time.Hour, config.settlementLockTTL)
+
+	require.Len(t, config.networks, 3)
+	arbitrum := config.networks["arbitrum"]
+	assert.Equal(t, uint32(42161), arbitrum.ChainID)
+	assert.Equal(t, "https://arbitrum.example", arbitrum.InfuraURL)
+	assert.Equal(t, []string{"0x2222222222222222222222222222222222222222"}, arbitrum.Adjudicators)
+	assert.Equal(t, uint64(12), arbitrum.Confirmations)
+	assert.Equal(t, GasModeEIP1559, arbitrum.GasPolicy.Mode)
+	assert.Equal(t, big.NewInt(1500000000), arbitrum.GasPolicy.MaxFeePerGas)
+	assert.Equal(t, 2.0, arbitrum.GasPolicy.TipMultiplier)
+	assert.Equal(t, uint64(20), arbitrum.GasPolicy.GasLimitMargin)
+
+	anvil := config.networks["anvil"]
+	assert.Equal(t, GasModeLegacy, anvil.GasPolicy.Mode)
+	assert.Equal(t, uint64(0), anvil.GasPolicy.GasLimitMargin)
+	assert.Nil(t, anvil.GasPolicy.MaxFeePerGas)
+
+	polygon := config.networks["polygon"]
+	assert.Equal(t, uint32(137), polygon.ChainID)
+	assert.Equal(t, "https://polygon.example", polygon.InfuraURL)
+}
+
+func TestLoadConfigFileTOML(t *testing.T) {
+	writeTestConfigFile(t, "clearnode.toml", `
+metrics_addr = ":9100"
+
+[[networks]]
+name = "anvil"
+chain_id = 31337
+rpc_url = "http://localhost:8545"
+custody_address = "0x3333333333333333333333333333333333333333"
+
+[networks.gas]
+mode = "legacy"
+`)
+
+	config, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, ":9100", config.metricsAddr)
+	require.Contains(t, config.networks, "anvil")
+	assert.Equal(t, GasModeLegacy, config.networks["anvil"].GasPolicy.Mode)
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{
+			name: "invalid custody address",
+			content: `
+networks:
+  - name: anvil
+    chain_id: 31337
+    rpc_url: http://localhost:8545
+    custody_address: "0x33"
+`,
+			err: `invalid configuration: network anvil: invalid custody_address "0x33"`,
+		},
+		{
+			name: "missing rpc url",
+			content: `
+networks:
+  - name: anvil
+    chain_id: 31337
+    custody_address: "0x3333333333333333333333333333333333333333"
+`,
+			err: "invalid configuration: network anvil: rpc_url is required",
+		},
+		{
+			name: "unknown gas mode",
+			content: `
+networks:
+  - name: anvil
+    chain_id: 31337
+    rpc_url: http://localhost:8545
+    custody_address: "0x3333333333333333333333333333333333333333"
+    gas:
+      mode: fast
+`,
+			err: "invalid configuration: network anvil: invalid gas policy: unknown gas mode: fast",
+		},
+		{
+			name: "invalid asset token",
+			content: `
+networks:
+  - name: anvil
+    chain_id: 31337
+    rpc_url: http://localhost:8545
+    custody_address: "0x3333333333333333333333333333333333333333"
+    assets:
+      - token: usdc
+        symbol: usdc
+        decimals: 6
+`,
+			err: `invalid configuration: network anvil: invalid asset token "usdc"`,
+		},
+		{
+			name: "liquidity targets out of order",
+			content: `
+networks:
+  - name: anvil
+    chain_id: 31337
+    rpc_url: http://localhost:8545
+    custody_address: "0x3333333333333333333333333333333333333333"
+    assets:
+      - token: "0x2222222222222222222222222222222222222222"
+        symbol: usdc
+        decimals: 6
+        min_liquidity: 5000
+        max_liquidity: 1000.5
+`,
+			err: "invalid configuration: network anvil: min_liquidity of asset 0x2222222222222222222222222222222222222222 is above its max_liquidity",
+		},
+		{
+			name: "asset without decimals",
+			content: `
+networks:
+  - name: anvil
+    chain_id: 31337
+    rpc_url: http://localhost:8545
+    custody_address: "0x3333333333333333333333333333333333333333"
+    assets:
+      - token: "0x2222222222222222222222222222222222222222"
+        symbol: usdc
+`,
+			err: "network anvil: asset 0x2222222222222222222222222222222222222222 has no decimals",
+		},
+		{
+			name: "duplicate network",
+			content: `
+networks:
+  - name: anvil
+    chain_id: 31337
+  - name: Anvil
+    chain_id: 31338
+`,
+			err: "network anvil is configured more than once",
+		},
+		{
+			name: "non-positive ttl",
+			content: `
+session_token_ttl: 0
+`,
+			err: "invalid configuration: session_token_ttl must be positive",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			writeTestConfigFile(t, "clearnode.yaml", test.content)
+			_, err := LoadConfig()
+			assert.EqualError(t, err, test.err)
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrEventAlreadyProcessed is returned when a contract log has already been recorded
+var ErrEventAlreadyProcessed = errors.New("event already processed")
+
+// ContractEvent represents a processed custody contract log.
+// Besides deduplication, it keeps the channel state preceding the event, the ledger amount the event
+// recorded and the settlement lock it released, so that its effect can be compensated if the log is
+// removed by a chain reorg.
+type ContractEvent struct {
+	ID              uint            `gorm:"primaryKey"`
+	ChainID         uint32          `gorm:"column:chain_id;not null;uniqueIndex:idx_contract_events_chain_tx_log"`
+	TxHash          string          `gorm:"column:tx_hash;not null;uniqueIndex:idx_contract_events_chain_tx_log"`
+	LogIndex        uint            `gorm:"column:log_index;not null;uniqueIndex:idx_contract_events_chain_tx_log"`
+	BlockNumber     uint64          `gorm:"column:block_number;not null"`
+	BlockHash       string          `gorm:"column:block_hash;not null"`
+	ContractAddress string          `gorm:"column:contract_address;not null"`
+	EventID         string          `gorm:"column:event_id;not null"`
+	ChannelID       string          `gorm:"column:channel_id;not null;default:''"`
+	PrevStatus      ChannelStatus   `gorm:"column:prev_status;not null;default:''"`
+	PrevAmount      uint64          `gorm:"column:prev_amount;not null;default:0"`
+	PrevVersion     uint64          `gorm:"column:prev_version;not null;default:0"`
+	AssetSymbol     string          `gorm:"column:asset_symbol;not null;default:''"`
+	LedgerAmount    decimal.Decimal `gorm:"column:ledger_amount;type:decimal(38,18);not null;default:0"`
+
+	// Settlement lock released by the event, restored if the event is reverted
+	SettlementAsset     string          `gorm:"column:settlement_asset;not null;default:''"`
+	SettlementAmount    decimal.Decimal `gorm:"column:settlement_amount;type:decimal(38,18);not null;default:0"`
+	SettlementVersion   uint64          `gorm:"column:settlement_version;not null;default:0"`
+	SettlementAllocated decimal.Decimal `gorm:"column:settlement_allocated;type:decimal(38,18);not null;default:0"`
+	SettlementExpiresAt *time.Time      `gorm:"column:settlement_expires_at"`
+
+	CreatedAt time.Time
+}
+
+// TableName specifies the table name for the ContractEvent model
+func (ContractEvent) TableName() string {
+	return "contract_events"
+}
+
+// RecordContractEvent stores a log as processed within the given transaction.
+// It returns ErrEventAlreadyProcessed if the same (chain, tx hash, log index) was recorded before.
+func RecordContractEvent(tx *gorm.DB, chainID uint32, l types.Log) (*ContractEvent, error) {
+	event := ContractEvent{
+		ChainID:             chainID,
+		TxHash:              l.TxHash.Hex(),
+		LogIndex:            l.Index,
+		BlockNumber:         l.BlockNumber,
+		BlockHash:           l.BlockHash.Hex(),
+		ContractAddress:     l.Address.Hex(),
+		EventID:             l.Topics[0].Hex(),
+		LedgerAmount:        decimal.Zero,
+		SettlementAmount:    decimal.Zero,
+		SettlementAllocated: decimal.Zero,
+		CreatedAt:           time.Now(),
+	}
+
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&event)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrEventAlreadyProcessed
+	}
+	return &event, nil
+}
+
+// SaveChannelEffect stores the channel state preceding the event and the amount it recorded
+// on the participant ledger. A prev channel with an empty status means the event created the channel.
+func (e *ContractEvent) SaveChannelEffect(tx *gorm.DB, prev Channel, assetSymbol string, ledgerAmount decimal.Decimal) error {
+	e.ChannelID = prev.ChannelID
+	e.PrevStatus = prev.Status
+	e.PrevAmount = prev.Amount
+	e.PrevVersion = prev.Version
+	e.AssetSymbol = assetSymbol
+	e.LedgerAmount = ledgerAmount
+	return tx.Save(e).Error
+}
+
+// KeepReleasedSettlement stores the settlement lock released by the event and the amount it held with the
+// channel effect, so that the lock can be restored if the event is reverted
+func (e *ContractEvent) KeepReleasedSettlement(settlement *PendingSettlement, amount decimal.Decimal) {
+	if settlement == nil {
+		return
+	}
+	e.SettlementAsset = settlement.AssetSymbol
+	e.SettlementAmount = amount
+	e.SettlementVersion = settlement.Version
+	e.SettlementAllocated = settlement.Allocated
+	e.SettlementExpiresAt = &settlement.ExpiresAt
+}
+
+// GetContractEvent returns the recorded event for a log, or nil if the log was not processed
+func GetContractEvent(tx *gorm.DB, chainID uint32, l types.Log) (*ContractEvent, error) {
+	var event ContractEvent
+	err := tx.Where("chain_id = ? AND tx_hash = ? AND log_index = ? AND block_hash = ?",
+		chainID, l.TxHash.Hex(), l.Index, l.BlockHash.Hex()).First(&event).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &event, nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	erc1271ABI = `[{"type":"function","name":"isValidSignature","stateMutability":"view",` +
+		`"inputs":[{"name":"hash","type":"bytes32"},{"name":"signature","type":"bytes"}],` +
+		`"outputs":[{"name":"magicValue","type":"bytes4"}]}]`
+
+	// contractCallTimeout bounds a single isValidSignature call
+	contractCallTimeout = 5 * time.Second
+	// contractSignatureCacheSize is the number of verification results kept before the cache is pruned
+	contractSignatureCacheSize = 10000
+)
+
+// erc1271MagicValue is returned by isValidSignature for a valid signature
+var erc1271MagicValue = [4]byte{0x16, 0x26, 0xba, 0x7e}
+
+var erc1271Abi = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(erc1271ABI))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+// ContractWallets verifies signatures of smart contract wallets, such as Safe accounts, with an
+// EIP-1271 isValidSignature call on the wallet contract. Results are cached, keyed by chain,
+// wallet, digest and signature.
+type ContractWallets struct {
+	mu      sync.RWMutex
+	callers map[uint32]bind.ContractCaller
+
+	cacheTTL time.Duration
+	cacheMu  sync.Mutex
+	cache    map[string]contractSignatureResult
+}
+
+type contractSignatureResult struct {
+	valid     bool
+	expiresAt time.Time
+}
+
+// NewContractWallets creates a verifier without chains, caching results for cacheTTL
+func NewContractWallets(cacheTTL time.Duration) *ContractWallets {
+	return &ContractWallets{
+		callers:  make(map[uint32]bind.ContractCaller),
+		cacheTTL: cacheTTL,
+		cache:    make(map[string]contractSignatureResult),
+	}
+}
+
+// AddChain registers the client used to call wallet contracts on a chain
+func (w *ContractWallets) AddChain(chainID uint32, caller bind.ContractCaller) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.callers[chainID] = caller
+}
+
+// RemoveChain unregisters the client of a chain, for a network that was stopped
+func (w *ContractWallets) RemoveChain(chainID uint32) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.callers, chainID)
+}
+
+// IsValidSignature reports whether the contract wallet on the given chain accepts sigHex as its signature of digest.
+// A raw signature does not commit to a chain, so without a chain ID no contract wallet signature is accepted:
+// the same wallet address can belong to different owners on different chains.
+func (w *ContractWallets) IsValidSignature(chainID uint32, wallet string, digest []byte, sigHex string) bool {
+	if w == nil || chainID == 0 || !common.IsHexAddress(wallet) || len(digest) != common.HashLength {
+		return false
+	}
+	sig, err := hexutil.Decode(sigHex)
+	if err != nil {
+		return false
+	}
+
+	w.mu.RLock()
+	_, ok := w.callers[chainID]
+	w.mu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	return w.isValidOnChain(chainID, common.HexToAddress(wallet), common.BytesToHash(digest), sig)
+}
+
+func (w *ContractWallets) isValidOnChain(chainID uint32, wallet common.Address, digest common.Hash, sig []byte) bool {
+	key := fmt.Sprintf("%d:%s:%s:%s", chainID, wallet.Hex(), digest.Hex(), hexutil.Encode(sig))
+	if valid, ok := w.cached(key); ok {
+		return valid
+	}
+
+	w.mu.RLock()
+	caller := w.callers[chainID]
+	w.mu.RUnlock()
+
+	valid, err := callIsValidSignature(caller, wallet, digest, sig)
+	if err != nil {
+		// Do not cache node failures, only results the contract itself returned
+		log.Printf("Error verifying contract signature of %s on chain %d: %v", wallet.Hex(), chainID, err)
+		return false
+	}
+
+	w.store(key, valid)
+	return valid
+}
+
+// callIsValidSignature calls isValidSignature on wallet. Accounts without code and calls that
+// revert are invalid signatures; other errors are returned.
+func callIsValidSignature(caller bind.ContractCaller, wallet common.Address, digest common.Hash, sig []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), contractCallTimeout)
+	defer cancel()
+
+	code, err := caller.CodeAt(ctx, wallet, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to get code: %w", err)
+	}
+	if len(code) == 0 {
+		return false, nil
+	}
+
+	data, err := erc1271Abi.Pack("isValidSignature", digest, sig)
+	if err != nil {
+		return false, err
+	}
+	result, err := caller.CallContract(ctx, ethereum.CallMsg{To: &wallet, Data: data}, nil)
+	if err != nil {
+		var dataErr rpc.DataError
+		if errors.As(err, &dataErr) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to call isValidSignature: %w", err)
+	}
+
+	return len(result) >= 4 && bytes.Equal(result[:4], erc1271MagicValue[:]), nil
+}
+
+func (w *ContractWallets) cached(key string) (bool, bool) {
+	w.cacheMu.Lock()
+	defer w.cacheMu.Unlock()
+
+	result, ok := w.cache[key]
+	if !ok || time.Now().After(result.expiresAt) {
+		return false, false
+	}
+	return result.valid, true
+}
+
+func (w *ContractWallets) store(key string, valid bool) {
+	w.cacheMu.Lock()
+	defer w.cacheMu.Unlock()
+
+	now := time.Now()
+	if len(w.cache) >= contractSignatureCacheSize {
+		for k, result := range w.cache {
+			if now.After(result.expiresAt) {
+				delete(w.cache, k)
+			}
+		}
+		if len(w.cache) >= contractSignatureCacheSize {
+			w.cache = make(map[string]contractSignatureResult)
+		}
+	}
+	w.cache[key] = contractSignatureResult{valid: valid, expiresAt: now.Add(w.cacheTTL)}
+}
+
+// VerifySignature reports whether sigHex is a signature of digest by expected, either by the key of an
+// externally owned account or, failing that, by a contract wallet on the chain of the context.
+func (c SigningContext) VerifySignature(digest []byte, sigHex, expected string) bool {
+	recovered, err := RecoverAddressFromHash(digest, sigHex)
+	if err == nil && strings.EqualFold(recovered, expected) {
+		return true
+	}
+	return c.Wallets.IsValidSignature(c.ChainID, expected, digest, sigHex)
+}
+
+// contractSigner returns the first of the candidate contract wallets that accepts sigHex as its signature of digest
+func (c SigningContext) contractSigner(digest []byte, sigHex string, candidates []string) (string, bool) {
+	for _, candidate := range candidates {
+		if c.Wallets.IsValidSignature(c.ChainID, candidate, digest, sigHex) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testWalletChainID = 1337
+
+// erc1271TestCode returns the runtime code of a wallet whose isValidSignature accepts any signature of hash
+func erc1271TestCode(hash common.Hash) []byte {
+	code := []byte{0x60, 0x04, 0x35, 0x7f} // PUSH1 4 CALLDATALOAD PUSH32
+	code = append(code, hash.Bytes()...)
+	code = append(code,
+		0x14, 0x60, 0x2d, 0x57, // EQ PUSH1 45 JUMPI
+		0x60, 0x20, 0x60, 0x00, 0xf3, // return 32 zero bytes
+		0x5b,                         // JUMPDEST
+		0x63, 0x16, 0x26, 0xba, 0x7e, // PUSH4 magic value
+		0x60, 0xe0, 0x1b, 0x60, 0x00, 0x52, // left align and store at 0
+		0x60, 0x20, 0x60, 0x00, 0xf3, // return 32 bytes
+	)
+	return code
+}
+
+// countingCaller counts the isValidSignature calls made through it
+type countingCaller struct {
+	bind.ContractCaller
+	calls atomic.Int32
+}
+
+func (c *countingCaller) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	c.calls.Add(1)
+	return c.ContractCaller.CallContract(ctx, call, blockNumber)
+}
+
+// setupTestContractWallets deploys wallets accepting signatures of the given hashes on a simulated chain
+func setupTestContractWallets(t *testing.T, wallets map[common.Address]common.Hash) (*ContractWallets, *countingCaller) {
+	alloc := types.GenesisAlloc{}
+	for addr, hash := range wallets {
+		alloc[addr] = types.Account{Code: erc1271TestCode(hash), Balance: big.NewInt(0)}
+	}
+	backend := simulated.NewBackend(alloc)
+	t.Cleanup(func() { backend.Close() })
+
+	caller := &countingCaller{ContractCaller: backend.Client()}
+	contractWallets := NewContractWallets(time.Minute)
+	contractWallets.AddChain(testWalletChainID, caller)
+	return contractWallets, caller
+}
+
+func TestContractWallets(t *testing.T) {
+	wallet := common.HexToAddress("0x5afe000000000000000000000000000000000001")
+	digest := crypto.Keccak256Hash([]byte("message"))
+	contractWallets, caller := setupTestContractWallets(t, map[common.Address]common.Hash{wallet: digest})
+
+	sig := hexutil.Encode(make([]byte, 96))
+
+	assert.True(t, contractWallets.IsValidSignature(testWalletChainID, wallet.Hex(), digest.Bytes(), sig))
+	// Without a chain no contract wallet signature is accepted
+	assert.False(t, contractWallets.IsValidSignature(0, wallet.Hex(), digest.Bytes(), sig))
+	assert.False(t, contractWallets.IsValidSignature(1, wallet.Hex(), digest.Bytes(), sig))
+	assert.False(t, contractWallets.IsValidSignature(testWalletChainID, wallet.Hex(), crypto.Keccak256([]byte("other")), sig))
+
+	// Accounts without code are not contract wallets
+	calls := caller.calls.Load()
+	assert.False(t, contractWallets.IsValidSignature(testWalletChainID, "0x1111111111111111111111111111111111111111", digest.Bytes(), sig))
+	assert.Equal(t, calls, caller.calls.Load())
+
+	// Results are cached
+	calls = caller.calls.Load()
+	for i := 0; i < 3; i++ {
+		assert.True(t, contractWallets.IsValidSignature(testWalletChainID, wallet.Hex(), digest.Bytes(), sig))
+		assert.False(t, contractWallets.IsValidSignature(testWalletChainID, wallet.Hex(), crypto.Keccak256([]byte("other")), sig))
+	}
+	assert.Equal(t, calls, caller.calls.Load())
+
+	// Without a verifier only EOA signatures are accepted
+	var none *ContractWallets
+	assert.False(t, none.IsValidSignature(testWalletChainID, wallet.Hex(), digest.Bytes(), sig))
+}
+
+func TestHandleCreateApplicationContractWallet(t *testing.T) {
+	rawB, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	signerB := Signer{privateKey: rawB}
+	addrA := common.HexToAddress("0x5afe000000000000000000000000000000000001").Hex()
+	addrB := signerB.GetAddress().Hex()
+
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	require.NoError(t, GetParticipantLedger(db, addrA).Record(addrA, "usdc", decimal.NewFromInt(100)))
+	require.NoError(t, GetParticipantLedger(db, addrB).Record(addrB, "usdc", decimal.NewFromInt(200)))
+
+	ts := uint64(time.Now().Unix())
+	params := CreateAppSessionParams{
+		Definition: AppDefinition{
+			Protocol:     "test-proto",
+			Participants: []string{addrA, addrB},
+			Weights:      []uint64{1, 1},
+			Quorum:       2,
+			Challenge:    60,
+			Nonce:        ts,
+		},
+		Allocations: []AppAllocation{
+			{Participant: addrA, AssetSymbol: "usdc", Amount: decimal.NewFromInt(10)},
+			{Participant: addrB, AssetSymbol: "usdc", Amount: decimal.NewFromInt(20)},
+		},
+	}
+	rpcReq := &RPCMessage{
+		Req: &RPCData{RequestID: 1, Method: "create_app_session", Params: []any{params}, Timestamp: ts},
+	}
+	signBytes, err := CreateAppSignData{
+		RequestID: rpcReq.Req.RequestID,
+		Method:    rpcReq.Req.Method,
+		Params:    []CreateAppSessionParams{params},
+		Timestamp: rpcReq.Req.Timestamp,
+	}.MarshalJSON()
+	require.NoError(t, err)
+	sigB, err := signerB.Sign(signBytes)
+	require.NoError(t, err)
+
+	// A multisig wallet signature is not a single 65 byte ECDSA signature
+	rpcReq.Sig = []string{hexutil.Encode(make([]byte, 130)), hexutil.Encode(sigB)}
+
+	contractWallets, _ := setupTestContractWallets(t, map[common.Address]common.Hash{
+		common.HexToAddress(addrA): crypto.Keccak256Hash(signBytes),
+	})
+
+	// Without contract wallet verification the wallet signature is rejected
+	_, err = HandleCreateApplication(rpcReq, db, SigningContext{Mode: SignatureModeRaw})
+	assert.EqualError(t, err, "invalid signature")
+
+	// Nor without the chain of the wallet
+	_, err = HandleCreateApplication(rpcReq, db, SigningContext{Mode: SignatureModeRaw, Wallets: contractWallets})
+	assert.EqualError(t, err, "invalid signature")
+
+	_, err = HandleCreateApplication(rpcReq, db, SigningContext{Mode: SignatureModeRaw, ChainID: testWalletChainID, Wallets: contractWallets})
+	require.NoError(t, err)
+
+	balanceA, err := GetParticipantLedger(db, addrA).Balance(addrA, "usdc")
+	require.NoError(t, err)
+	assert.Equal(t, decimal.NewFromInt(90).String(), balanceA.String())
+}
+
+func TestVerifyChallengeResponseContractWallet(t *testing.T) {
+	wallet := common.HexToAddress("0x5afe000000000000000000000000000000000001").Hex()
+
+	am := NewAuthManager()
+	challenge, err := am.GenerateChallenge(wallet)
+	require.NoError(t, err)
+
+	params := AuthVerifyParams{Address: wallet, Challenge: challenge}
+	rpc := &RPCMessage{
+		Req: &RPCData{RequestID: 1, Method: "auth_verify", Params: []any{params}, Timestamp: uint64(time.Now().UnixMilli())},
+		Sig: []string{hexutil.Encode(make([]byte, 65))},
+	}
+	reqBytes, err := json.Marshal(rpc.Req)
+	require.NoError(t, err)
+
+	contractWallets, _ := setupTestContractWallets(t, map[common.Address]common.Hash{
+		common.HexToAddress(wallet): crypto.Keccak256Hash(reqBytes),
+	})
+
+	_, err = verifyChallengeResponse(rpc, params, am, SigningContext{Mode: SignatureModeRaw})
+	assert.EqualError(t, err, "invalid signature")
+
+	addr, err := verifyChallengeResponse(rpc, params, am, SigningContext{Mode: SignatureModeRaw, ChainID: testWalletChainID, Wallets: contractWallets})
+	require.NoError(t, err)
+	assert.Equal(t, wallet, addr)
+}
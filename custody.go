@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"math/big"
+	"sync"
 	"time"
 
 	"github.com/erc7824/go-nitrolite"
@@ -23,95 +24,374 @@ var (
 	custodyAbi *abi.ABI
 )
 
+const (
+	// checkpointRetryInterval is the delay between checkpoint attempts while a channel is challenged
+	checkpointRetryInterval = 30 * time.Second
+	// custodyConnectTimeout bounds connecting to the RPC endpoint of a network and reading its chain ID
+	custodyConnectTimeout = 15 * time.Second
+)
+
 // Custody implements the BlockchainClient interface using the Custody contract
 type Custody struct {
 	client            *ethclient.Client
 	custody           *nitrolite.Custody
 	db                *gorm.DB
 	custodyAddr       common.Address
-	transactOpts      *bind.TransactOpts
+	txManager         *TxManager
 	chainID           uint32
-	signer            *Signer
+	keys              *BrokerKeys
+	adjudicators      map[common.Address]bool // Adjudicators channels may be opened with, any if empty
+	confirmations     uint64
+	metrics           *Metrics
 	sendBalanceUpdate func(string)
 	sendChannelUpdate func(Channel)
+
+	defenseMu sync.Mutex
+	defenses  map[string]challengeDefense // Challenges of the defended channels, by channel ID
+}
+
+// challengeDefense is a challenge the broker defends until it expires, kept to defend it again if a checkpoint fails
+type challengeDefense struct {
+	ctx        context.Context
+	expiration time.Time
 }
 
-// NewCustody initializes the Ethereum client and custody contract wrapper.
-func NewCustody(signer *Signer, db *gorm.DB, sendBalanceUpdate func(string), sendChannelUpdate func(Channel), infuraURL, custodyAddressStr string, chain uint32) (*Custody, error) {
-	custodyAddress := common.HexToAddress(custodyAddressStr)
-	client, err := ethclient.Dial(infuraURL)
+// NewCustody initializes the Ethereum client and custody contract wrapper of a network.
+func NewCustody(ctx context.Context, keys *BrokerKeys, db *gorm.DB, metrics *Metrics, sendBalanceUpdate func(string), sendChannelUpdate func(Channel), network *NetworkConfig, txConf TxManagerConfig) (*Custody, error) {
+	// An unreachable endpoint must not hold up the caller for longer than the connect timeout
+	connectCtx, cancel := context.WithTimeout(ctx, custodyConnectTimeout)
+	defer cancel()
+
+	custodyAddress := common.HexToAddress(network.CustodyAddress)
+	client, err := ethclient.DialContext(connectCtx, network.InfuraURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to Ethereum node: %w", err)
 	}
 
-	chainID, err := client.ChainID(context.Background())
+	chainID, err := client.ChainID(connectCtx)
 	if err != nil {
+		client.Close()
 		return nil, fmt.Errorf("failed to get chain ID: %w", err)
 	}
-
-	// Create auth options for transactions.
-	auth, err := bind.NewKeyedTransactorWithChainID(signer.GetPrivateKey(), chainID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create transaction signer: %w", err)
+	if chainID.Uint64() != uint64(network.ChainID) {
+		client.Close()
+		return nil, fmt.Errorf("RPC endpoint serves chain %s instead of %d", chainID, network.ChainID)
 	}
-	auth.GasPrice = big.NewInt(30000000000) // 20 gwei.
-	auth.GasLimit = uint64(3000000)
 
 	custody, err := nitrolite.NewCustody(custodyAddress, client)
 	if err != nil {
+		client.Close()
 		return nil, fmt.Errorf("failed to bind custody contract: %w", err)
 	}
 
-	return &Custody{
+	c := &Custody{
 		client:            client,
 		custody:           custody,
 		db:                db,
 		custodyAddr:       custodyAddress,
-		transactOpts:      auth,
 		chainID:           uint32(chainID.Int64()),
-		signer:            signer,
+		keys:              keys,
+		adjudicators:      adjudicatorSet(network.Adjudicators),
+		confirmations:     network.Confirmations,
+		metrics:           metrics,
 		sendBalanceUpdate: sendBalanceUpdate,
 		sendChannelUpdate: sendChannelUpdate,
-	}, nil
+		defenses:          make(map[string]challengeDefense),
+	}
+
+	// Transactions of every broker key are sent through the transaction manager of the chain
+	c.txManager, err = NewTxManager(client, db, chainID, keys, txConf, network.GasPolicy, metrics, c.handleTransactionResult)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return c, nil
 }
 
-// ListenEvents initializes event listening for the custody contract
+// ListenEvents initializes event listening for the custody contract.
+// Listening resumes from the block of the last processed event stored in the database.
 func (c *Custody) ListenEvents(ctx context.Context) {
-	// TODO: store processed events in a database
-	listenEvents(ctx, c.client, c.custodyAddr, c.chainID, 0, c.handleBlockChainEvent)
+	var lastBlock uint64
+
+	cursor, err := GetEventCursor(c.db, c.chainID, c.custodyAddr.Hex())
+	if err != nil {
+		log.Printf("Error loading event cursor for chain %d: %v", c.chainID, err)
+	} else if cursor != nil {
+		lastBlock = cursor.BlockNumber
+	}
+
+	c.resumeDefenses(ctx)
+
+	handle := func(l types.Log) error {
+		return c.handleBlockChainEvent(ctx, l)
+	}
+	listenEvents(ctx, c.client, c.custodyAddr, c.chainID, lastBlock, c.confirmations, handle, c.processRemovedLog)
+}
+
+// resumeDefenses defends the channels of the chain that are still challenged, as the defense of a challenge
+// does not survive a restart. The challenge expiry is estimated from the time the channel was marked challenged.
+func (c *Custody) resumeDefenses(ctx context.Context) {
+	var channels []Channel
+	if err := c.db.Where("chain_id = ? AND status = ?", c.chainID, ChannelStatusChallenged).Find(&channels).Error; err != nil {
+		log.Printf("[Challenged] Error loading challenged channels for chain %d: %v", c.chainID, err)
+		return
+	}
+
+	for _, channel := range channels {
+		expiration := channel.UpdatedAt.Add(time.Duration(channel.Challenge) * time.Second)
+		log.Printf("[Challenged] Resuming defense of channel %s, challenge expires at %s", channel.ChannelID, expiration)
+		go c.defendChallenge(ctx, channel.ChannelID, expiration)
+	}
+}
+
+// recordEvent stores a log as processed and advances the event cursor past it within the transaction applying the log
+func (c *Custody) recordEvent(tx *gorm.DB, l types.Log) (*ContractEvent, error) {
+	event, err := RecordContractEvent(tx, c.chainID, l)
+	if err != nil {
+		return nil, err
+	}
+	if err := SaveEventCursor(tx, c.chainID, c.custodyAddr.Hex(), l.BlockNumber, l.Index); err != nil {
+		return nil, fmt.Errorf("failed to save event cursor: %w", err)
+	}
+	return event, nil
+}
+
+// skipEvent advances the event cursor past a log that does not change any channel of the broker
+func (c *Custody) skipEvent(l types.Log) error {
+	if err := SaveEventCursor(c.db, c.chainID, c.custodyAddr.Hex(), l.BlockNumber, l.Index); err != nil {
+		return fmt.Errorf("failed to save event cursor: %w", err)
+	}
+	return nil
+}
+
+// eventError handles the failed transaction of a log. Logs that were already processed or were rejected
+// are skipped, any other error is returned so that the log is handled again.
+func (c *Custody) eventError(l types.Log, err error) error {
+	var rejected rejectedEventError
+	if errors.Is(err, ErrEventAlreadyProcessed) || errors.As(err, &rejected) {
+		return c.skipEvent(l)
+	}
+	return err
+}
+
+// rejectedEventError is returned from the transaction of a log that does not apply to the broker,
+// such as an event of an unknown channel. Retrying such a log cannot succeed, so it is skipped.
+type rejectedEventError struct {
+	error
+}
+
+// rejectEvent formats the error of a rejected log
+func rejectEvent(format string, args ...any) error {
+	return rejectedEventError{fmt.Errorf(format, args...)}
+}
+
+// processRemovedLog handles a custody log removed by a chain reorg.
+// Logs that were already applied are reverted and the event cursor is moved back to their block.
+func (c *Custody) processRemovedLog(l types.Log, handled bool) {
+	stage := "pending"
+	if handled {
+		stage = "processed"
+	}
+	if c.metrics != nil {
+		c.metrics.ReorgedEvents.With(prometheus.Labels{
+			"network": fmt.Sprintf("%d", c.chainID),
+			"stage":   stage,
+		}).Inc()
+	}
+
+	if !handled {
+		return
+	}
+
+	c.revertBlockChainEvent(l)
+
+	if err := RewindEventCursor(c.db, c.chainID, c.custodyAddr.Hex(), l.BlockNumber); err != nil {
+		log.Printf("Error rewinding event cursor for chain %d: %v", c.chainID, err)
+	}
+}
+
+// adjudicatorSet builds the allow-list of adjudicator addresses
+func adjudicatorSet(adjudicators []string) map[common.Address]bool {
+	set := make(map[common.Address]bool, len(adjudicators))
+	for _, adjudicator := range adjudicators {
+		set[common.HexToAddress(adjudicator)] = true
+	}
+	return set
 }
 
-// Join calls the join method on the custody contract
-func (c *Custody) Join(channelID string, lastStateData []byte) error {
+// adjudicatorAllowed reports whether the broker joins channels with the given adjudicator
+func (c *Custody) adjudicatorAllowed(adjudicator common.Address) bool {
+	return len(c.adjudicators) == 0 || c.adjudicators[adjudicator]
+}
+
+// Join calls the join method on the custody contract with the broker signature of the initial state.
+// The transaction is sent from the broker key the channel was opened with.
+func (c *Custody) Join(channel Channel, sig nitrolite.Signature) error {
 	// Convert string channelID to bytes32
-	channelIDBytes := common.HexToHash(channelID)
+	channelIDBytes := common.HexToHash(channel.ChannelID)
+
+	signer, err := c.keys.ForChannel(channel)
+	if err != nil {
+		return err
+	}
 
 	// The broker will always join as participant with index 1 (second participant)
 	index := big.NewInt(1)
 
-	sig, err := c.signer.NitroSign(lastStateData)
+	data, err := custodyAbi.Pack("join", channelIDBytes, index, sig)
 	if err != nil {
-		return fmt.Errorf("failed to sign data: %w", err)
+		return fmt.Errorf("failed to pack join call: %w", err)
 	}
+	if _, err := c.txManager.Send(context.Background(), TxKindJoin, channel.ChannelID, signer.GetAddress(), c.custodyAddr, data); err != nil {
+		return fmt.Errorf("failed to join channel: %w", err)
+	}
+
+	return nil
+}
 
-	gasPrice, err := c.client.SuggestGasPrice(context.Background())
+// Checkpoint submits the latest state co-signed by the user and the broker to the custody contract
+func (c *Custody) Checkpoint(channelID string) error {
+	state, err := GetLatestSignedChannelState(c.db, channelID)
 	if err != nil {
-		return fmt.Errorf("failed to suggest gas price: %w", err)
+		return fmt.Errorf("failed to get latest signed state: %w", err)
+	}
+	if state == nil {
+		return fmt.Errorf("no co-signed state stored for channel %s", channelID)
 	}
 
-	c.transactOpts.GasPrice = gasPrice.Add(gasPrice, gasPrice)
-	// Call the join method on the custody contract
-	tx, err := c.custody.Join(c.transactOpts, channelIDBytes, index, sig)
+	candidate, err := state.NitroState()
 	if err != nil {
-		return fmt.Errorf("failed to join channel: %w", err)
+		return fmt.Errorf("failed to build candidate state: %w", err)
+	}
+
+	data, err := custodyAbi.Pack("checkpoint", common.HexToHash(channelID), candidate, []nitrolite.State{})
+	if err != nil {
+		return fmt.Errorf("failed to pack checkpoint call: %w", err)
 	}
-	log.Println("TxHash:", tx.Hash().Hex())
+
+	// Any participant may checkpoint a co-signed state, it is sent from the current broker key
+	tx, err := c.txManager.Send(context.Background(), TxKindCheckpoint, channelID, c.keys.Current().GetAddress(), c.custodyAddr, data)
+	if err != nil {
+		return fmt.Errorf("failed to checkpoint channel: %w", err)
+	}
+	log.Printf("[Checkpoint] Submitted state version %d for channel %s, TxHash: %s", state.Version, channelID, tx.TxHash)
 
 	return nil
 }
 
-// handleBlockChainEvent processes different event types received from the blockchain
-func (c *Custody) handleBlockChainEvent(l types.Log) {
+// handleTransactionResult is called by the transaction manager once a broker transaction is mined or failed
+func (c *Custody) handleTransactionResult(tx BrokerTransaction) {
+	if tx.Kind == TxKindCheckpoint && tx.Status == TxStatusConfirmed {
+		c.endDefense(tx.ChannelID)
+	}
+	if tx.Status != TxStatusFailed {
+		return
+	}
+
+	switch tx.Kind {
+	case TxKindJoin:
+		c.failJoin(tx.ChannelID, tx.Error)
+	case TxKindCheckpoint:
+		log.Printf("[Checkpoint] Checkpoint transaction %s for channel %s failed: %s", tx.TxHash, tx.ChannelID, tx.Error)
+		c.defendAgain(tx.ChannelID)
+	case TxKindApprove, TxKindDeposit, TxKindWithdraw:
+		// The treasury plans the rebalancing again at its next check
+		log.Printf("[Treasury] %s transaction %s on chain %d failed: %s", tx.Kind, tx.TxHash, c.chainID, tx.Error)
+	}
+}
+
+// failJoin marks a channel that is still joining as failed to join, so the participant can see
+// that the broker did not join it and withdraw the deposit
+func (c *Custody) failJoin(channelID string, reason string) {
+	var channel Channel
+	var failed bool
+	err := c.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("channel_id = ?", channelID).First(&channel)
+		if result.Error != nil {
+			return fmt.Errorf("error finding channel: %w", result.Error)
+		}
+		if channel.Status != ChannelStatusJoining {
+			return nil
+		}
+
+		channel.Status = ChannelStatusJoinFailed
+		channel.UpdatedAt = time.Now()
+		if err := tx.Save(&channel).Error; err != nil {
+			return fmt.Errorf("failed to mark channel as join failed: %w", err)
+		}
+		failed = true
+		return nil
+	})
+	if err != nil {
+		log.Printf("[JoinFailed] Error updating channel %s: %v", channelID, err)
+		return
+	}
+	if !failed {
+		return
+	}
+
+	log.Printf("[JoinFailed] Broker failed to join channel %s: %s", channelID, reason)
+	c.sendChannelUpdate(channel)
+}
+
+// defendChallenge keeps submitting the latest co-signed state until the checkpoint is sent,
+// the channel leaves the challenged status, the challenge period expires or ctx is cancelled.
+// The challenge is kept until the checkpoint is confirmed, a failed checkpoint is defended again by defendAgain.
+func (c *Custody) defendChallenge(ctx context.Context, channelID string, expiration time.Time) {
+	c.defenseMu.Lock()
+	c.defenses[channelID] = challengeDefense{ctx: ctx, expiration: expiration}
+	c.defenseMu.Unlock()
+
+	for time.Now().Before(expiration) {
+		channel, err := GetChannelByID(c.db, channelID)
+		if err != nil {
+			log.Printf("[Challenged] Error loading channel %s: %v", channelID, err)
+		} else if channel == nil || channel.Status != ChannelStatusChallenged {
+			c.endDefense(channelID)
+			return
+		} else if err := c.Checkpoint(channelID); err != nil {
+			log.Printf("[Challenged] Error submitting checkpoint for channel %s: %v", channelID, err)
+		} else {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			c.endDefense(channelID)
+			return
+		case <-time.After(min(checkpointRetryInterval, time.Until(expiration))):
+		}
+	}
+	c.endDefense(channelID)
+	log.Printf("[Challenged] Challenge period for channel %s expired without a checkpoint", channelID)
+}
+
+// defendAgain restarts the defense of a channel whose checkpoint transaction reverted or was dropped,
+// as long as its challenge has not expired
+func (c *Custody) defendAgain(channelID string) {
+	c.defenseMu.Lock()
+	defense, ok := c.defenses[channelID]
+	c.defenseMu.Unlock()
+	if !ok || defense.ctx.Err() != nil || !time.Now().Before(defense.expiration) {
+		return
+	}
+
+	log.Printf("[Challenged] Defending channel %s again, challenge expires at %s", channelID, defense.expiration)
+	go c.defendChallenge(defense.ctx, channelID, defense.expiration)
+}
+
+// endDefense forgets the challenge of a channel that no longer needs to be defended
+func (c *Custody) endDefense(channelID string) {
+	c.defenseMu.Lock()
+	defer c.defenseMu.Unlock()
+	delete(c.defenses, channelID)
+}
+
+// handleBlockChainEvent processes different event types received from the blockchain.
+// The event cursor is advanced past the log in the transaction applying it, or on its own if the log is skipped.
+// An error is returned if the log could not be applied, it is then handled again without advancing the cursor.
+func (c *Custody) handleBlockChainEvent(ctx context.Context, l types.Log) error {
 	log.Printf("Received event: %+v\n", l)
 
 	eventID := l.Topics[0]
@@ -121,12 +401,12 @@ func (c *Custody) handleBlockChainEvent(l types.Log) {
 		log.Printf("[Created] Event data: %+v\n", ev)
 		if err != nil {
 			log.Println("error parsing Created event:", err)
-			return
+			return c.skipEvent(l)
 		}
 
 		if len(ev.Channel.Participants) < 2 {
 			log.Println("[Created] Error: not enough participants in the channel")
-			return
+			return c.skipEvent(l)
 		}
 
 		participantA := ev.Channel.Participants[0].Hex()
@@ -135,49 +415,95 @@ func (c *Custody) handleBlockChainEvent(l types.Log) {
 		tokenAddress := ev.Initial.Allocations[0].Token.Hex()
 		tokenAmount := ev.Initial.Allocations[0].Amount.Int64()
 
-		// Check if channel was created with the broker.
-		if participantB != c.signer.GetAddress() {
-			log.Printf("participantB %s is not Broker %s\n", participantB, c.signer.GetAddress().Hex())
-			return
+		// Check if channel was created with one of the broker keys.
+		signer := c.keys.Get(participantB.Hex())
+		if signer == nil {
+			log.Printf("participantB %s is not Broker %s\n", participantB, c.keys.Current().GetAddress().Hex())
+			return c.skipEvent(l)
+		}
+
+		if !c.adjudicatorAllowed(ev.Channel.Adjudicator) {
+			log.Printf("[Created] Adjudicator %s of channel %s is not allowed\n", ev.Channel.Adjudicator.Hex(), common.Hash(ev.ChannelId).Hex())
+			return c.skipEvent(l)
 		}
 
-		// Check if there is already existing open channel with the broker
-		existingOpenChannel, err := CheckExistingChannels(c.db, participantA, tokenAddress, c.chainID)
+		// Channels are not joined for disabled assets, existing channels keep working
+		asset, err := GetAssetByToken(c.db, tokenAddress, c.chainID)
 		if err != nil {
-			log.Printf("[Created] Error checking channels in database: %v", err)
-			return
+			log.Printf("[Created] Error fetching asset %s: %v\n", tokenAddress, err)
+			return err
+		}
+		if asset != nil && asset.Disabled {
+			log.Printf("[Created] Asset %s of channel %s is disabled\n", tokenAddress, common.Hash(ev.ChannelId).Hex())
+			return c.skipEvent(l)
 		}
 
-		if existingOpenChannel != nil {
-			log.Printf("[Created] An open channel with broker already exists: %s", existingOpenChannel.ChannelID)
-			return
+		encodedState, err := nitrolite.EncodeState(ev.ChannelId, nitrolite.IntentINITIALIZE, big.NewInt(0), ev.Initial.Data, ev.Initial.Allocations)
+		if err != nil {
+			log.Printf("[ChannelCreated] Error encoding state hash: %v", err)
+			return c.skipEvent(l)
 		}
 
-		channelID := common.BytesToHash(ev.ChannelId[:]).Hex()
-		ch, err := CreateChannel(
-			c.db,
-			channelID,
-			participantA,
-			nonce,
-			ev.Channel.Adjudicator.Hex(),
-			c.chainID,
-			tokenAddress,
-			uint64(tokenAmount),
-		)
+		sig, err := signer.NitroSign(encodedState)
 		if err != nil {
-			log.Printf("[ChannelCreated] Error creating/updating channel in database: %v", err)
-			return
+			log.Printf("[ChannelCreated] Error signing initial state: %v", err)
+			return err
 		}
 
-		encodedState, err := nitrolite.EncodeState(ev.ChannelId, nitrolite.IntentINITIALIZE, big.NewInt(0), ev.Initial.Data, ev.Initial.Allocations)
+		var userSig *nitrolite.Signature
+		if len(ev.Initial.Sigs) > 0 {
+			userSig = &ev.Initial.Sigs[0]
+		}
+
+		var ch Channel
+		channelID := common.BytesToHash(ev.ChannelId[:]).Hex()
+		err = c.db.Transaction(func(tx *gorm.DB) error {
+			event, err := c.recordEvent(tx, l)
+			if err != nil {
+				return err
+			}
+
+			// Check if there is already existing open channel with the broker
+			existingOpenChannel, err := CheckExistingChannels(tx, participantA, tokenAddress, c.chainID)
+			if err != nil {
+				return fmt.Errorf("error checking channels in database: %w", err)
+			}
+
+			if existingOpenChannel != nil {
+				return rejectEvent("an open channel with broker already exists: %s", existingOpenChannel.ChannelID)
+			}
+
+			ch, err = CreateChannel(
+				tx,
+				channelID,
+				participantA,
+				signer.GetAddress().Hex(),
+				nonce,
+				ev.Channel.Adjudicator.Hex(),
+				ev.Channel.Challenge,
+				c.chainID,
+				tokenAddress,
+				uint64(tokenAmount),
+			)
+			if err != nil {
+				return err
+			}
+
+			if _, err := SaveChannelState(tx, channelID, nitrolite.IntentINITIALIZE, 0, ev.Initial.Data, ev.Initial.Allocations, sig, userSig); err != nil {
+				return err
+			}
+
+			return event.SaveChannelEffect(tx, Channel{ChannelID: channelID}, "", decimal.Zero)
+		})
 		if err != nil {
-			log.Printf("[ChannelCreated] Error encoding state hash: %v", err)
-			return
+			log.Printf("[ChannelCreated] Error creating/updating channel in database: %v", err)
+			return c.eventError(l, err)
 		}
 
-		if err := c.Join(channelID, encodedState); err != nil {
+		if err := c.Join(ch, sig); err != nil {
 			log.Printf("[ChannelCreated] Error joining channel: %v", err)
-			return
+			c.failJoin(channelID, err.Error())
+			return nil
 		}
 
 		c.sendChannelUpdate(ch)
@@ -188,20 +514,26 @@ func (c *Custody) handleBlockChainEvent(l types.Log) {
 		ev, err := c.custody.ParseJoined(l)
 		if err != nil {
 			log.Println("error parsing ChannelJoined event:", err)
-			return
+			return c.skipEvent(l)
 		}
 		log.Printf("Joined event data: %+v\n", ev)
 
 		var channel Channel
 		channelID := common.BytesToHash(ev.ChannelId[:]).Hex()
 		err = c.db.Transaction(func(tx *gorm.DB) error {
+			event, err := c.recordEvent(tx, l)
+			if err != nil {
+				return err
+			}
+
 			result := tx.Where("channel_id = ?", channelID).First(&channel)
 			if result.Error != nil {
 				if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-					return fmt.Errorf("channel with ID %s not found", channelID)
+					return rejectEvent("channel with ID %s not found", channelID)
 				}
 				return fmt.Errorf("error finding channel: %w", result.Error)
 			}
+			prev := channel
 
 			// Update the channel status to "open"
 			channel.Status = ChannelStatusOpen
@@ -211,16 +543,16 @@ func (c *Custody) handleBlockChainEvent(l types.Log) {
 			}
 			log.Printf("Joined channel with ID: %s", channelID)
 
-			asset, err := GetAssetByToken(tx, channel.Token, c.chainID)
+			asset, err := GetLedgerAsset(tx, channel.Token, c.chainID)
 			if err != nil {
 				return fmt.Errorf("DB error fetching asset: %w", err)
 			}
 
 			if asset == nil {
-				return fmt.Errorf("Asset not found in database for token: %s", channel.Token)
+				return rejectEvent("Asset not found in database for token: %s", channel.Token)
 			}
 
-			tokenAmount := decimal.NewFromBigInt(big.NewInt(int64(channel.Amount)), -int32(asset.Decimals))
+			tokenAmount := asset.ToLedger(new(big.Int).SetUint64(channel.Amount))
 
 			ledger := GetParticipantLedger(tx, channel.Participant)
 			if err := ledger.Record(channel.Participant, asset.Symbol, tokenAmount); err != nil {
@@ -228,11 +560,11 @@ func (c *Custody) handleBlockChainEvent(l types.Log) {
 				return err
 			}
 
-			return nil
+			return event.SaveChannelEffect(tx, prev, asset.Symbol, tokenAmount)
 		})
 		if err != nil {
 			log.Printf("[Joined] Error closing channel in database: %v", err)
-			return
+			return c.eventError(l, err)
 		}
 		c.sendBalanceUpdate(channel.Participant)
 		c.sendChannelUpdate(channel)
@@ -241,31 +573,44 @@ func (c *Custody) handleBlockChainEvent(l types.Log) {
 		ev, err := c.custody.ParseClosed(l)
 		if err != nil {
 			log.Println("error parsing ChannelClosed event:", err)
-			return
+			return c.skipEvent(l)
 		}
 		log.Printf("Closed event data: %+v\n", ev)
 
 		var channel Channel
 		channelID := common.BytesToHash(ev.ChannelId[:]).Hex()
 		err = c.db.Transaction(func(tx *gorm.DB) error {
+			event, err := c.recordEvent(tx, l)
+			if err != nil {
+				return err
+			}
+
 			result := tx.Where("channel_id = ?", channelID).First(&channel)
 			if result.Error != nil {
 				if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-					return fmt.Errorf("channel with ID %s not found", channelID)
+					return rejectEvent("channel with ID %s not found", channelID)
 				}
 				return fmt.Errorf("error finding channel: %w", result.Error)
 			}
+			prev := channel
 
-			asset, err := GetAssetByToken(tx, channel.Token, c.chainID)
+			// The final state landed, so the funds locked when it was signed are settled below
+			settlement, locked, err := ReleaseSettlement(tx, channelID)
+			if err != nil {
+				return fmt.Errorf("failed to release pending settlement: %w", err)
+			}
+			event.KeepReleasedSettlement(settlement, locked)
+
+			asset, err := GetLedgerAsset(tx, channel.Token, c.chainID)
 			if err != nil {
 				return fmt.Errorf("DB error fetching asset: %w", err)
 			}
 
 			if asset == nil {
-				return fmt.Errorf("Asset not found in database for token: %s", channel.Token)
+				return rejectEvent("Asset not found in database for token: %s", channel.Token)
 			}
 
-			tokenAmount := decimal.NewFromBigInt(big.NewInt(int64(channel.Amount)), -int32(asset.Decimals))
+			tokenAmount := asset.ToLedger(new(big.Int).SetUint64(channel.Amount))
 
 			ledger := GetParticipantLedger(tx, channel.Participant)
 			if err := ledger.Record(channel.Participant, asset.Symbol, tokenAmount.Neg()); err != nil {
@@ -284,12 +629,13 @@ func (c *Custody) handleBlockChainEvent(l types.Log) {
 
 			log.Printf("Closed channel with ID: %s", channelID)
 
-			return nil
+			return event.SaveChannelEffect(tx, prev, asset.Symbol, tokenAmount.Neg())
 		})
 		if err != nil {
 			log.Printf("[Closed] Error closing channel: %v", err)
-			return
+			return c.eventError(l, err)
 		}
+		c.recordSubmittedState(l, channelID)
 		c.sendBalanceUpdate(channel.Participant)
 		c.sendChannelUpdate(channel)
 
@@ -297,17 +643,33 @@ func (c *Custody) handleBlockChainEvent(l types.Log) {
 		ev, err := c.custody.ParseResized(l)
 		if err != nil {
 			log.Println("error parsing Resized event:", err)
-			return
+			return c.skipEvent(l)
 		}
 		log.Printf("Resized event data: %+v\n", ev)
 
 		var channel Channel
+		channelID := common.BytesToHash(ev.ChannelId[:]).Hex()
 		err = c.db.Transaction(func(tx *gorm.DB) error {
-			channelID := common.BytesToHash(ev.ChannelId[:]).Hex()
-			result := c.db.Where("channel_id = ?", channelID).First(&channel)
+			event, err := c.recordEvent(tx, l)
+			if err != nil {
+				return err
+			}
+
+			result := tx.Where("channel_id = ?", channelID).First(&channel)
 			if result.Error != nil {
+				if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+					return rejectEvent("channel with ID %s not found", channelID)
+				}
 				return fmt.Errorf("error finding channel: %w", result.Error)
 			}
+			prev := channel
+
+			// The resize state landed, so the funds locked when it was signed are settled below
+			settlement, locked, err := ReleaseSettlement(tx, channelID)
+			if err != nil {
+				return fmt.Errorf("failed to release pending settlement: %w", err)
+			}
+			event.KeepReleasedSettlement(settlement, locked)
 
 			newAmount := int64(channel.Amount)
 			for _, change := range ev.DeltaAllocations {
@@ -317,42 +679,240 @@ func (c *Custody) handleBlockChainEvent(l types.Log) {
 			channel.Amount = uint64(newAmount)
 			channel.UpdatedAt = time.Now()
 			channel.Version++
-			if err := c.db.Save(&channel).Error; err != nil {
+			if err := tx.Save(&channel).Error; err != nil {
 				return fmt.Errorf("[Resized] Error saving channel in database: %w", err)
 			}
 
 			resizeAmount := ev.DeltaAllocations[0] // Participant deposits or withdraws.
 			if resizeAmount.Cmp(big.NewInt(0)) != 0 {
-				asset, err := GetAssetByToken(tx, channel.Token, c.chainID)
+				asset, err := GetLedgerAsset(tx, channel.Token, c.chainID)
 				if err != nil {
 					return fmt.Errorf("DB error fetching asset: %w", err)
 				}
 
 				if asset == nil {
-					return fmt.Errorf("Asset not found in database for token: %s", channel.Token)
+					return rejectEvent("Asset not found in database for token: %s", channel.Token)
 				}
 
-				amount := decimal.NewFromBigInt(resizeAmount, -int32(asset.Decimals))
+				amount := asset.ToLedger(resizeAmount)
 				ledger := GetParticipantLedger(tx, channel.Participant)
 				if err := ledger.Record(channel.Participant, asset.Symbol, amount); err != nil {
 					log.Printf("[Resized] Error recording balance update for participant: %v", err)
 					return err
 				}
+
+				return event.SaveChannelEffect(tx, prev, asset.Symbol, amount)
 			}
 
-			return nil
+			return event.SaveChannelEffect(tx, prev, "", decimal.Zero)
 		})
 
 		if err != nil {
 			log.Printf("[Resized] Error resizing channel: %v", err)
-			return
+			return c.eventError(l, err)
 		}
 
+		c.recordSubmittedState(l, channelID)
 		c.sendBalanceUpdate(channel.Participant)
 		c.sendChannelUpdate(channel)
+
+	case custodyAbi.Events["Challenged"].ID:
+		ev, err := c.custody.ParseChallenged(l)
+		if err != nil {
+			log.Println("error parsing Challenged event:", err)
+			return c.skipEvent(l)
+		}
+		log.Printf("Challenged event data: %+v\n", ev)
+
+		var channel Channel
+		channelID := common.BytesToHash(ev.ChannelId[:]).Hex()
+		err = c.db.Transaction(func(tx *gorm.DB) error {
+			event, err := c.recordEvent(tx, l)
+			if err != nil {
+				return err
+			}
+
+			result := tx.Where("channel_id = ?", channelID).First(&channel)
+			if result.Error != nil {
+				if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+					return rejectEvent("channel with ID %s not found", channelID)
+				}
+				return fmt.Errorf("error finding channel: %w", result.Error)
+			}
+			prev := channel
+
+			channel.Status = ChannelStatusChallenged
+			channel.UpdatedAt = time.Now()
+			if err := tx.Save(&channel).Error; err != nil {
+				return fmt.Errorf("failed to mark channel as challenged: %w", err)
+			}
+
+			return event.SaveChannelEffect(tx, prev, "", decimal.Zero)
+		})
+		if err != nil {
+			log.Printf("[Challenged] Error updating channel: %v", err)
+			return c.eventError(l, err)
+		}
+		c.sendChannelUpdate(channel)
+
+		expiration := time.Unix(ev.Expiration.Int64(), 0)
+		if ev.Expiration.Sign() == 0 {
+			expiration = time.Now().Add(time.Duration(channel.Challenge) * time.Second)
+		}
+		log.Printf("Channel %s challenged, challenge expires at %s", channelID, expiration)
+
+		go c.defendChallenge(ctx, channelID, expiration)
+
+	case custodyAbi.Events["Checkpointed"].ID:
+		ev, err := c.custody.ParseCheckpointed(l)
+		if err != nil {
+			log.Println("error parsing Checkpointed event:", err)
+			return c.skipEvent(l)
+		}
+		log.Printf("Checkpointed event data: %+v\n", ev)
+
+		var channel Channel
+		channelID := common.BytesToHash(ev.ChannelId[:]).Hex()
+		err = c.db.Transaction(func(tx *gorm.DB) error {
+			event, err := c.recordEvent(tx, l)
+			if err != nil {
+				return err
+			}
+
+			result := tx.Where("channel_id = ?", channelID).First(&channel)
+			if result.Error != nil {
+				if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+					return rejectEvent("channel with ID %s not found", channelID)
+				}
+				return fmt.Errorf("error finding channel: %w", result.Error)
+			}
+			prev := channel
+
+			// A checkpoint during a challenge resolves the dispute and reopens the channel
+			if channel.Status == ChannelStatusChallenged {
+				channel.Status = ChannelStatusOpen
+				channel.UpdatedAt = time.Now()
+				if err := tx.Save(&channel).Error; err != nil {
+					return fmt.Errorf("failed to reopen channel: %w", err)
+				}
+			}
+
+			return event.SaveChannelEffect(tx, prev, "", decimal.Zero)
+		})
+		if err != nil {
+			log.Printf("[Checkpointed] Error updating channel: %v", err)
+			return c.eventError(l, err)
+		}
+		c.recordSubmittedState(l, channelID)
+		c.sendChannelUpdate(channel)
+
+	case custodyAbi.Events["Opened"].ID:
+		ev, err := c.custody.ParseOpened(l)
+		if err != nil {
+			log.Println("error parsing Opened event:", err)
+			return c.skipEvent(l)
+		}
+		// The channel is marked open when the Joined event is processed
+		log.Printf("Opened event data: %+v\n", ev)
+
 	default:
 		log.Println("Unknown event ID:", eventID.Hex())
+		return c.skipEvent(l)
 	}
+
+	return nil
+}
+
+// revertBlockChainEvent compensates a processed log that was removed by a chain reorg.
+// The ledger amount recorded by the event is reversed and the channel is rolled back to the state
+// preceding the event; a channel created by the event is deleted. The event record is removed so
+// the log is processed again if it is included in the new canonical chain.
+func (c *Custody) revertBlockChainEvent(l types.Log) {
+	var channel Channel
+	var reverted, deleted bool
+	err := c.db.Transaction(func(tx *gorm.DB) error {
+		event, err := GetContractEvent(tx, c.chainID, l)
+		if err != nil {
+			return fmt.Errorf("error finding contract event: %w", err)
+		}
+		if event == nil {
+			return nil
+		}
+
+		if err := tx.Delete(event).Error; err != nil {
+			return fmt.Errorf("failed to delete contract event: %w", err)
+		}
+
+		if event.ChannelID == "" {
+			return nil
+		}
+
+		result := tx.Where("channel_id = ?", event.ChannelID).First(&channel)
+		if result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				log.Printf("[Reorg] Channel %s of removed event not found", event.ChannelID)
+				return nil
+			}
+			return fmt.Errorf("error finding channel: %w", result.Error)
+		}
+
+		if !event.LedgerAmount.IsZero() {
+			ledger := GetParticipantLedger(tx, channel.Participant)
+			if err := ledger.Record(channel.Participant, event.AssetSymbol, event.LedgerAmount.Neg()); err != nil {
+				return fmt.Errorf("error recording compensating entry: %w", err)
+			}
+		}
+		reverted = true
+
+		if event.PrevStatus == "" {
+			deleted = true
+			if err := tx.Where("channel_id = ?", channel.ChannelID).Delete(&ChannelState{}).Error; err != nil {
+				return fmt.Errorf("failed to delete channel states: %w", err)
+			}
+			return tx.Delete(&channel).Error
+		}
+
+		channel.Status = event.PrevStatus
+		channel.Amount = event.PrevAmount
+		channel.Version = event.PrevVersion
+		channel.UpdatedAt = time.Now()
+		if err := tx.Save(&channel).Error; err != nil {
+			return fmt.Errorf("failed to roll back channel: %w", err)
+		}
+
+		// The signed state the event settled can land again, so its funds are locked again
+		if event.SettlementVersion > 0 {
+			if err := LockSettlement(tx, channel, event.SettlementAsset, event.SettlementAmount, event.SettlementVersion, *event.SettlementExpiresAt); err != nil {
+				return fmt.Errorf("failed to restore pending settlement: %w", err)
+			}
+			if err := ReserveAllocation(tx, channel.ChannelID, event.SettlementAllocated); err != nil {
+				return fmt.Errorf("failed to restore settlement allocation: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("[Reorg] Error reverting event %s:%d on chain %d: %v", l.TxHash.Hex(), l.Index, c.chainID, err)
+		return
+	}
+	if !reverted {
+		return
+	}
+
+	log.Printf("[Reorg] Reverted event %s:%d for channel %s on chain %d", l.TxHash.Hex(), l.Index, channel.ChannelID, c.chainID)
+	c.sendBalanceUpdate(channel.Participant)
+	if !deleted {
+		c.sendChannelUpdate(channel)
+	}
+}
+
+// AvailableBalance returns the custody balance of a broker address for a token that is not locked in channels
+func (c *Custody) AvailableBalance(ctx context.Context, broker, token common.Address) (*big.Int, error) {
+	info, err := c.custody.GetAccountInfo(&bind.CallOpts{Context: ctx}, broker, token)
+	if err != nil {
+		return nil, err
+	}
+	return info.Available, nil
 }
 
 // UpdateBalanceMetrics fetches the broker's account information from the smart contract and updates metrics
@@ -362,31 +922,99 @@ func (c *Custody) UpdateBalanceMetrics(ctx context.Context, tokens []common.Addr
 		return
 	}
 
-	brokerAddr := c.signer.GetAddress()
 	for _, token := range tokens {
 		// Create a call opts with the provided context
 		callOpts := &bind.CallOpts{
 			Context: ctx,
 		}
 
-		logger.Infow("Fetching account info", "network", c.chainID, "token", token.Hex(), "broker", brokerAddr.Hex())
-		// Call getAccountInfo on the custody contract
-		info, err := c.custody.GetAccountInfo(callOpts, brokerAddr, token)
-		if err != nil {
-			logger.Errorw("Failed to get account info", "network", c.chainID, "token", token.Hex(), "error", err)
+		// During a key rotation funds and channels are split between the broker keys
+		available, channelCount := new(big.Int), new(big.Int)
+		var failed bool
+		for _, signer := range c.keys.All() {
+			brokerAddr := signer.GetAddress()
+			logger.Infow("Fetching account info", "network", c.chainID, "token", token.Hex(), "broker", brokerAddr.Hex())
+			// Call getAccountInfo on the custody contract
+			info, err := c.custody.GetAccountInfo(callOpts, brokerAddr, token)
+			if err != nil {
+				logger.Errorw("Failed to get account info", "network", c.chainID, "token", token.Hex(), "error", err)
+				failed = true
+				break
+			}
+			available.Add(available, info.Available)
+			channelCount.Add(channelCount, info.ChannelCount)
+		}
+		if failed {
 			continue
 		}
 
 		metrics.BrokerBalanceAvailable.With(prometheus.Labels{
 			"network": fmt.Sprintf("%d", c.chainID),
 			"token":   token.Hex(),
-		}).Set(float64(info.Available.Int64()))
+		}).Set(float64(available.Int64()))
 
 		metrics.BrokerChannelCount.With(prometheus.Labels{
 			"network": fmt.Sprintf("%d", c.chainID),
 			"token":   token.Hex(),
-		}).Set(float64(info.ChannelCount.Int64()))
+		}).Set(float64(channelCount.Int64()))
+
+		logger.Infow("Updated contract balance metrics", "network", c.chainID, "token", token.Hex(), "available", available.String(), "channels", channelCount.String())
+	}
+}
+
+// recordSubmittedState stores the user signature of a state submitted to the custody contract.
+// The state is decoded from the calldata of the transaction that emitted the log.
+func (c *Custody) recordSubmittedState(l types.Log, channelID string) {
+	if c.client == nil {
+		return
+	}
+
+	tx, _, err := c.client.TransactionByHash(context.Background(), l.TxHash)
+	if err != nil {
+		log.Printf("Error fetching transaction %s: %v", l.TxHash.Hex(), err)
+		return
+	}
+
+	state, err := decodeCandidateState(tx.Data())
+	if err != nil {
+		log.Printf("Error decoding candidate state of transaction %s: %v", l.TxHash.Hex(), err)
+		return
+	}
+	if state == nil || len(state.Sigs) < 2 {
+		return
+	}
+
+	if err := SaveCounterSignedState(c.db, channelID, *state); err != nil {
+		log.Printf("Error storing submitted state for channel %s: %v", channelID, err)
+	}
+}
+
+// decodeCandidateState extracts the candidate state from the calldata of a custody contract call.
+// It returns nil if the call does not carry a candidate state, e.g. when made through another contract.
+func decodeCandidateState(data []byte) (*nitrolite.State, error) {
+	if len(data) < 4 {
+		return nil, nil
+	}
+
+	method, err := custodyAbi.MethodById(data[:4])
+	if err != nil {
+		return nil, nil
+	}
+
+	switch method.Name {
+	case "resize", "close", "checkpoint", "challenge":
+	default:
+		return nil, nil
+	}
+
+	args, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpack %s arguments: %w", method.Name, err)
+	}
 
-		logger.Infow("Updated contract balance metrics", "network", c.chainID, "token", token.Hex(), "available", info.Available.String(), "channels", info.ChannelCount.String())
+	state, ok := abi.ConvertType(args[1], new(nitrolite.State)).(*nitrolite.State)
+	if !ok {
+		return nil, fmt.Errorf("unexpected candidate type in %s call", method.Name)
 	}
+	return state, nil
 }
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/erc7824/go-nitrolite"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// newTestCustody creates a Custody client without a blockchain connection, suitable for feeding logs directly
+func newTestCustody(t *testing.T, db *gorm.DB, chainID uint32) *Custody {
+	t.Helper()
+
+	custodyAddr := common.HexToAddress("0x00000000000000000000000000000000000c0de1")
+	custody, err := nitrolite.NewCustody(custodyAddr, nil)
+	require.NoError(t, err)
+
+	return &Custody{
+		custody:           custody,
+		db:                db,
+		custodyAddr:       custodyAddr,
+		chainID:           chainID,
+		sendBalanceUpdate: func(string) {},
+		sendChannelUpdate: func(Channel) {},
+		defenses:          make(map[string]challengeDefense),
+	}
+}
+
+// newTestLog builds a custody contract log for the given event
+func newTestLog(t *testing.T, eventName string, channelID common.Hash, txHash common.Hash, index uint, args ...interface{}) types.Log {
+	t.Helper()
+
+	event := custodyAbi.Events[eventName]
+	data, err := event.Inputs.NonIndexed().Pack(args...)
+	require.NoError(t, err)
+
+	return types.Log{
+		Address:     common.HexToAddress("0x00000000000000000000000000000000000c0de1"),
+		Topics:      []common.Hash{event.ID, channelID},
+		Data:        data,
+		BlockNumber: 100,
+		TxHash:      txHash,
+		Index:       index,
+	}
+}
+
+func TestHandleBlockChainEventIdempotent(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	participant := "0x1111111111111111111111111111111111111111"
+	token := "0x2222222222222222222222222222222222222222"
+	channelID := common.HexToHash("0xabc1")
+
+	require.NoError(t, db.Create(&Asset{Token: token, ChainID: 137, Symbol: "usdc", Decimals: 6}).Error)
+	require.NoError(t, db.Create(&Channel{
+		ChannelID:   channelID.Hex(),
+		ChainID:     137,
+		Participant: participant,
+		Token:       token,
+		Amount:      1000000,
+		Status:      ChannelStatusJoining,
+	}).Error)
+
+	c := newTestCustody(t, db, 137)
+	ledger := GetParticipantLedger(db, participant)
+
+	// Joined delivered twice credits the deposit only once
+	joined := newTestLog(t, "Joined", channelID, common.HexToHash("0x01"), 0, big.NewInt(1))
+	require.NoError(t, c.handleBlockChainEvent(context.Background(), joined))
+	require.NoError(t, c.handleBlockChainEvent(context.Background(), joined))
+
+	balance, err := ledger.Balance(participant, "usdc")
+	require.NoError(t, err)
+	assert.Equal(t, decimal.NewFromInt(1).String(), balance.String())
+
+	// Resized delivered twice applies the delta only once
+	resized := newTestLog(t, "Resized", channelID, common.HexToHash("0x02"), 3, []*big.Int{big.NewInt(500000), big.NewInt(0)})
+	require.NoError(t, c.handleBlockChainEvent(context.Background(), resized))
+	require.NoError(t, c.handleBlockChainEvent(context.Background(), resized))
+
+	balance, err = ledger.Balance(participant, "usdc")
+	require.NoError(t, err)
+	assert.Equal(t, decimal.NewFromFloat(1.5).String(), balance.String())
+
+	channel, err := GetChannelByID(db, channelID.Hex())
+	require.NoError(t, err)
+	require.NotNil(t, channel)
+	assert.Equal(t, ChannelStatusOpen, channel.Status)
+	assert.Equal(t, uint64(1500000), channel.Amount)
+	assert.Equal(t, uint64(1), channel.Version)
+
+	var count int64
+	require.NoError(t, db.Model(&ContractEvent{}).Count(&count).Error)
+	assert.Equal(t, int64(2), count)
+
+	// The same log index in a different transaction is a distinct event
+	resizedAgain := newTestLog(t, "Resized", channelID, common.HexToHash("0x03"), 3, []*big.Int{big.NewInt(-500000), big.NewInt(0)})
+	require.NoError(t, c.handleBlockChainEvent(context.Background(), resizedAgain))
+
+	balance, err = ledger.Balance(participant, "usdc")
+	require.NoError(t, err)
+	assert.Equal(t, decimal.NewFromInt(1).String(), balance.String())
+}
+
+func TestHandleBlockChainEventCursor(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	participant := "0x1111111111111111111111111111111111111111"
+	token := "0x2222222222222222222222222222222222222222"
+	channelID := common.HexToHash("0xabc7")
+
+	require.NoError(t, db.Create(&Asset{Token: token, ChainID: 137, Symbol: "usdc", Decimals: 6}).Error)
+	require.NoError(t, db.Create(&Channel{
+		ChannelID:   channelID.Hex(),
+		ChainID:     137,
+		Participant: participant,
+		Token:       token,
+		Amount:      1000000,
+		Status:      ChannelStatusJoining,
+	}).Error)
+
+	c := newTestCustody(t, db, 137)
+	cursorIndex := func() uint {
+		cursor, err := GetEventCursor(db, 137, c.custodyAddr.Hex())
+		require.NoError(t, err)
+		require.NotNil(t, cursor)
+		return cursor.LogIndex
+	}
+
+	// Logs of unknown channels are skipped and only move the cursor
+	unknown := newTestLog(t, "Joined", common.HexToHash("0xdead"), common.HexToHash("0x01"), 1, big.NewInt(1))
+	require.NoError(t, c.handleBlockChainEvent(context.Background(), unknown))
+	assert.Equal(t, uint(1), cursorIndex())
+
+	// A log that fails to apply leaves the cursor in place so it is handled again
+	require.NoError(t, db.Migrator().DropTable(&Entry{}))
+	joined := newTestLog(t, "Joined", channelID, common.HexToHash("0x02"), 2, big.NewInt(1))
+	require.Error(t, c.handleBlockChainEvent(context.Background(), joined))
+	assert.Equal(t, uint(1), cursorIndex())
+
+	channel, err := GetChannelByID(db, channelID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, ChannelStatusJoining, channel.Status)
+
+	require.NoError(t, db.AutoMigrate(&Entry{}))
+	require.NoError(t, c.handleBlockChainEvent(context.Background(), joined))
+	assert.Equal(t, uint(2), cursorIndex())
+
+	channel, err = GetChannelByID(db, channelID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, ChannelStatusOpen, channel.Status)
+}
+
+func TestRevertBlockChainEvent(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	participant := "0x1111111111111111111111111111111111111111"
+	token := "0x2222222222222222222222222222222222222222"
+	channelID := common.HexToHash("0xabc2")
+
+	require.NoError(t, db.Create(&Asset{Token: token, ChainID: 137, Symbol: "usdc", Decimals: 6}).Error)
+	require.NoError(t, db.Create(&Channel{
+		ChannelID:   channelID.Hex(),
+		ChainID:     137,
+		Participant: participant,
+		Token:       token,
+		Amount:      1000000,
+		Status:      ChannelStatusJoining,
+	}).Error)
+
+	c := newTestCustody(t, db, 137)
+	ledger := GetParticipantLedger(db, participant)
+
+	joined := newTestLog(t, "Joined", channelID, common.HexToHash("0x01"), 0, big.NewInt(1))
+	resized := newTestLog(t, "Resized", channelID, common.HexToHash("0x02"), 1, []*big.Int{big.NewInt(500000), big.NewInt(0)})
+	require.NoError(t, c.handleBlockChainEvent(context.Background(), joined))
+	require.NoError(t, c.handleBlockChainEvent(context.Background(), resized))
+
+	balance, err := ledger.Balance(participant, "usdc")
+	require.NoError(t, err)
+	assert.Equal(t, decimal.NewFromFloat(1.5).String(), balance.String())
+
+	// Reverting the resize restores the previous amount and version
+	c.revertBlockChainEvent(resized)
+
+	balance, err = ledger.Balance(participant, "usdc")
+	require.NoError(t, err)
+	assert.Equal(t, decimal.NewFromInt(1).String(), balance.String())
+
+	channel, err := GetChannelByID(db, channelID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, ChannelStatusOpen, channel.Status)
+	assert.Equal(t, uint64(1000000), channel.Amount)
+	assert.Equal(t, uint64(0), channel.Version)
+
+	// Reverting the join rolls the channel back to joining and debits the deposit
+	c.revertBlockChainEvent(joined)
+
+	balance, err = ledger.Balance(participant, "usdc")
+	require.NoError(t, err)
+	assert.True(t, balance.IsZero())
+
+	channel, err = GetChannelByID(db, channelID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, ChannelStatusJoining, channel.Status)
+
+	// A second removal of the same log has no effect
+	c.revertBlockChainEvent(joined)
+
+	balance, err = ledger.Balance(participant, "usdc")
+	require.NoError(t, err)
+	assert.True(t, balance.IsZero())
+
+	var count int64
+	require.NoError(t, db.Model(&ContractEvent{}).Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+
+	// The log is applied again once it is included in the new canonical chain
+	joined.BlockHash = common.HexToHash("0xb10c")
+	require.NoError(t, c.handleBlockChainEvent(context.Background(), joined))
+
+	balance, err = ledger.Balance(participant, "usdc")
+	require.NoError(t, err)
+	assert.Equal(t, decimal.NewFromInt(1).String(), balance.String())
+}
+
+func TestHandleChallengeEvents(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	channelID := common.HexToHash("0xabc3")
+	require.NoError(t, db.Create(&Channel{
+		ChannelID:   channelID.Hex(),
+		ChainID:     137,
+		Participant: "0x1111111111111111111111111111111111111111",
+		Token:       "0x2222222222222222222222222222222222222222",
+		Amount:      1000000,
+		Status:      ChannelStatusOpen,
+		Challenge:   3600,
+	}).Error)
+
+	c := newTestCustody(t, db, 137)
+
+	// An already expired challenge leaves nothing to defend
+	challenged := newTestLog(t, "Challenged", channelID, common.HexToHash("0x01"), 0, big.NewInt(1))
+	require.NoError(t, c.handleBlockChainEvent(context.Background(), challenged))
+
+	channel, err := GetChannelByID(db, channelID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, ChannelStatusChallenged, channel.Status)
+
+	checkpointed := newTestLog(t, "Checkpointed", channelID, common.HexToHash("0x02"), 0)
+	require.NoError(t, c.handleBlockChainEvent(context.Background(), checkpointed))
+
+	channel, err = GetChannelByID(db, channelID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, ChannelStatusOpen, channel.Status)
+
+	// Reverting the checkpoint puts the channel back under challenge
+	c.revertBlockChainEvent(checkpointed)
+
+	channel, err = GetChannelByID(db, channelID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, ChannelStatusChallenged, channel.Status)
+}
+
+func TestDecodeCandidateState(t *testing.T) {
+	candidate := nitrolite.State{
+		Intent:  uint8(nitrolite.IntentRESIZE),
+		Version: big.NewInt(4),
+		Data:    []byte{0x01},
+		Allocations: []nitrolite.Allocation{
+			{Destination: common.HexToAddress("0x01"), Token: common.HexToAddress("0x02"), Amount: big.NewInt(10)},
+		},
+		Sigs: []nitrolite.Signature{{V: 27, R: [32]byte{1}, S: [32]byte{2}}, {V: 28, R: [32]byte{3}, S: [32]byte{4}}},
+	}
+
+	data, err := custodyAbi.Pack("resize", common.HexToHash("0xabc4"), candidate, []nitrolite.State{})
+	require.NoError(t, err)
+
+	state, err := decodeCandidateState(data)
+	require.NoError(t, err)
+	require.NotNil(t, state)
+	assert.Equal(t, candidate.Version.String(), state.Version.String())
+	assert.Equal(t, candidate.Data, state.Data)
+	assert.Equal(t, candidate.Sigs, state.Sigs)
+	assert.Equal(t, candidate.Allocations[0].Amount.String(), state.Allocations[0].Amount.String())
+
+	// Calls without a candidate state are ignored
+	data, err = custodyAbi.Pack("deposit", common.HexToAddress("0x02"), big.NewInt(1))
+	require.NoError(t, err)
+	state, err = decodeCandidateState(data)
+	require.NoError(t, err)
+	assert.Nil(t, state)
+}
+
+func TestCreatedAdjudicatorAllowList(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	signer := newTestSigner(t)
+	c := newTestCustody(t, db, 137)
+	c.keys = NewBrokerKeys(signer, nil)
+	c.adjudicators = adjudicatorSet([]string{"0x00000000000000000000000000000000000000a1"})
+
+	channelID := common.HexToHash("0xabc5")
+	created := newTestLog(t, "Created", channelID, common.HexToHash("0x01"), 0,
+		nitrolite.Channel{
+			Participants: []common.Address{common.HexToAddress("0x1111111111111111111111111111111111111111"), signer.GetAddress()},
+			Adjudicator:  common.HexToAddress("0x00000000000000000000000000000000000000b2"),
+			Challenge:    3600,
+			Nonce:        1,
+		},
+		nitrolite.State{
+			Version: big.NewInt(0),
+			Data:    []byte{},
+			Allocations: []nitrolite.Allocation{
+				{Destination: common.HexToAddress("0x1111111111111111111111111111111111111111"), Token: common.HexToAddress("0x2222222222222222222222222222222222222222"), Amount: big.NewInt(1000000)},
+				{Destination: signer.GetAddress(), Token: common.HexToAddress("0x2222222222222222222222222222222222222222"), Amount: big.NewInt(0)},
+			},
+			Sigs: []nitrolite.Signature{},
+		},
+	)
+
+	// The broker does not join channels with an adjudicator outside the allow-list
+	require.NoError(t, c.handleBlockChainEvent(context.Background(), created))
+
+	channel, err := GetChannelByID(db, channelID.Hex())
+	require.NoError(t, err)
+	assert.Nil(t, channel)
+
+	assert.True(t, c.adjudicatorAllowed(common.HexToAddress("0x00000000000000000000000000000000000000a1")))
+}
+
+func TestCreatedDisabledAsset(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	signer := newTestSigner(t)
+	c := newTestCustody(t, db, 137)
+	c.keys = NewBrokerKeys(signer, nil)
+
+	token := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	require.NoError(t, db.Create(&Asset{Token: token.Hex(), ChainID: 137, Symbol: "usdc", Decimals: 6, Disabled: true}).Error)
+
+	channelID := common.HexToHash("0xabc6")
+	created := newTestLog(t, "Created", channelID, common.HexToHash("0x01"), 0,
+		nitrolite.Channel{
+			Participants: []common.Address{common.HexToAddress("0x1111111111111111111111111111111111111111"), signer.GetAddress()},
+			Adjudicator:  common.HexToAddress("0x00000000000000000000000000000000000000a1"),
+			Challenge:    3600,
+			Nonce:        1,
+		},
+		nitrolite.State{
+			Version: big.NewInt(0),
+			Data:    []byte{},
+			Allocations: []nitrolite.Allocation{
+				{Destination: common.HexToAddress("0x1111111111111111111111111111111111111111"), Token: token, Amount: big.NewInt(1000000)},
+				{Destination: signer.GetAddress(), Token: token, Amount: big.NewInt(0)},
+			},
+			Sigs: []nitrolite.Signature{},
+		},
+	)
+
+	// The broker does not join new channels of a disabled asset
+	require.NoError(t, c.handleBlockChainEvent(context.Background(), created))
+
+	channel, err := GetChannelByID(db, channelID.Hex())
+	require.NoError(t, err)
+	assert.Nil(t, channel)
+}
+
+func TestRevertRestoresSettlement(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	participant := "0x1111111111111111111111111111111111111111"
+	token := "0x2222222222222222222222222222222222222222"
+	channelID := common.HexToHash("0xabc8")
+
+	require.NoError(t, db.Create(&Asset{Token: token, ChainID: 137, Symbol: "usdc", Decimals: 6}).Error)
+	channel := Channel{
+		ChannelID:   channelID.Hex(),
+		ChainID:     137,
+		Participant: participant,
+		Token:       token,
+		Amount:      5000000,
+		Status:      ChannelStatusOpen,
+	}
+	require.NoError(t, db.Create(&channel).Error)
+
+	ledger := GetParticipantLedger(db, participant)
+	require.NoError(t, ledger.Record(participant, "usdc", decimal.NewFromInt(10)))
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	require.NoError(t, LockSettlement(db, channel, "usdc", decimal.NewFromInt(4), 1, expiresAt))
+
+	assertBalances := func(unified, locked int64) {
+		t.Helper()
+		balance, err := ledger.Balance(participant, "usdc")
+		require.NoError(t, err)
+		assert.Equal(t, decimal.NewFromInt(unified).String(), balance.String())
+
+		lockedAmount, err := GetLockedSettlement(db, channel, "usdc")
+		require.NoError(t, err)
+		assert.Equal(t, decimal.NewFromInt(locked).String(), lockedAmount.String())
+	}
+	assertBalances(6, 4)
+
+	// The landed resize settles the lock
+	c := newTestCustody(t, db, 137)
+	resized := newTestLog(t, "Resized", channelID, common.HexToHash("0x01"), 0, []*big.Int{big.NewInt(-4000000), big.NewInt(0)})
+	require.NoError(t, c.handleBlockChainEvent(context.Background(), resized))
+	assertBalances(6, 0)
+
+	// Reverting it locks the funds of the signed state again, as the state can still land
+	c.revertBlockChainEvent(resized)
+	assertBalances(6, 4)
+
+	var settlement PendingSettlement
+	require.NoError(t, db.Where("channel_id = ?", channelID.Hex()).First(&settlement).Error)
+	assert.Equal(t, uint64(1), settlement.Version)
+	assert.True(t, expiresAt.Equal(settlement.ExpiresAt))
+}
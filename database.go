@@ -246,7 +246,7 @@ func migratePostgres(cnf DatabaseConfig) error {
 }
 
 func migrateSqlite(db *gorm.DB) error {
-	if err := db.AutoMigrate(&Entry{}, &Channel{}, &Asset{}, &AppSession{}, &RPCRecord{}); err != nil {
+	if err := db.AutoMigrate(&Entry{}, &Channel{}, &Asset{}, &AppSession{}, &RPCRecord{}, &EventCursor{}, &ContractEvent{}, &ChannelState{}, &PendingSettlement{}, &SessionKey{}, &SessionKeyAllowance{}, &BrokerTransaction{}, &AssetGroup{}); err != nil {
 		return err
 	}
 	return nil
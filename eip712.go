@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// SignatureMode selects what client signatures are made over
+type SignatureMode string
+
+const (
+	// SignatureModeRaw signs the Keccak256 hash of the JSON encoded request
+	SignatureModeRaw SignatureMode = "raw"
+	// SignatureModeEIP712 signs EIP-712 typed data for the payloads that support it
+	SignatureModeEIP712 SignatureMode = "eip712"
+
+	eip712DomainName    = "Clearnode"
+	eip712DomainVersion = "1"
+)
+
+// ParseSignatureMode parses a signature mode, defaulting to raw
+func ParseSignatureMode(mode string) (SignatureMode, error) {
+	switch SignatureMode(mode) {
+	case "", SignatureModeRaw:
+		return SignatureModeRaw, nil
+	case SignatureModeEIP712:
+		return SignatureModeEIP712, nil
+	default:
+		return "", fmt.Errorf("unsupported signature mode: %s", mode)
+	}
+}
+
+// SigningContext is the signature mode of a session together with the EIP-712 domain it signs in
+type SigningContext struct {
+	Mode    SignatureMode
+	ChainID uint32
+	Broker  common.Address
+	// Wallets verifies signatures of contract wallets, nil if only EOA signatures are accepted
+	Wallets *ContractWallets
+}
+
+// typedPayload is a request payload that also has an EIP-712 representation
+type typedPayload interface {
+	json.Marshaler
+	TypedData(domain apitypes.TypedDataDomain) apitypes.TypedData
+}
+
+// Domain returns the EIP-712 domain, separated by chain and broker address
+func (c SigningContext) Domain() apitypes.TypedDataDomain {
+	return apitypes.TypedDataDomain{
+		Name:              eip712DomainName,
+		Version:           eip712DomainVersion,
+		ChainId:           math.NewHexOrDecimal256(int64(c.ChainID)),
+		VerifyingContract: c.Broker.Hex(),
+	}
+}
+
+// WithChain returns a copy of the context signing in the domain of another chain
+func (c SigningContext) WithChain(chainID uint32) SigningContext {
+	c.ChainID = chainID
+	return c
+}
+
+// Digest returns the hash the signatures of payload are made over
+func (c SigningContext) Digest(payload typedPayload) ([]byte, error) {
+	if c.Mode == SignatureModeEIP712 {
+		hash, _, err := apitypes.TypedDataAndHash(payload.TypedData(c.Domain()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash typed data: %w", err)
+		}
+		return hash, nil
+	}
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize message: %w", err)
+	}
+	return crypto.Keccak256(raw), nil
+}
+
+var eip712DomainType = []apitypes.Type{
+	{Name: "name", Type: "string"},
+	{Name: "version", Type: "string"},
+	{Name: "chainId", Type: "uint256"},
+	{Name: "verifyingContract", Type: "address"},
+}
+
+var appAllocationType = []apitypes.Type{
+	{Name: "participant", Type: "address"},
+	{Name: "asset", Type: "string"},
+	{Name: "amount", Type: "string"},
+}
+
+// TypedData returns the auth_verify challenge response as EIP-712 typed data.
+// The session key grant is zero valued if the request does not carry one.
+func (p AuthVerifyParams) TypedData(domain apitypes.TypedDataDomain) apitypes.TypedData {
+	grant := SessionKeyGrant{Address: common.Address{}.Hex()}
+	if p.SessionKey != nil {
+		grant = *p.SessionKey
+	}
+
+	allowances := make([]interface{}, 0, len(grant.Allowances))
+	for _, a := range grant.Allowances {
+		allowances = append(allowances, map[string]interface{}{
+			"asset":  a.AssetSymbol,
+			"amount": a.Amount.String(),
+		})
+	}
+
+	return apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": eip712DomainType,
+			"AuthVerify": {
+				{Name: "wallet", Type: "address"},
+				{Name: "challenge", Type: "string"},
+				{Name: "scope", Type: "string"},
+				{Name: "sessionKey", Type: "SessionKeyGrant"},
+			},
+			"SessionKeyGrant": {
+				{Name: "key", Type: "address"},
+				{Name: "expiresAt", Type: "uint64"},
+				{Name: "methods", Type: "string[]"},
+				{Name: "appProtocols", Type: "string[]"},
+				{Name: "allowances", Type: "Allowance[]"},
+			},
+			"Allowance": {
+				{Name: "asset", Type: "string"},
+				{Name: "amount", Type: "string"},
+			},
+		},
+		PrimaryType: "AuthVerify",
+		Domain:      domain,
+		Message: apitypes.TypedDataMessage{
+			"wallet":    p.Address,
+			"challenge": p.Challenge.String(),
+			"scope":     p.Scope,
+			"sessionKey": map[string]interface{}{
+				"key":          grant.Address,
+				"expiresAt":    new(big.Int).SetUint64(grant.ExpiresAt),
+				"methods":      stringsToInterfaces(grant.Methods),
+				"appProtocols": stringsToInterfaces(grant.AppProtocols),
+				"allowances":   allowances,
+			},
+		},
+	}
+}
+
+// TypedData returns the create_app_session request as EIP-712 typed data
+func (r CreateAppSignData) TypedData(domain apitypes.TypedDataDomain) apitypes.TypedData {
+	var params CreateAppSessionParams
+	if len(r.Params) > 0 {
+		params = r.Params[0]
+	}
+
+	weights := make([]interface{}, 0, len(params.Definition.Weights))
+	for _, w := range params.Definition.Weights {
+		weights = append(weights, new(big.Int).SetUint64(w))
+	}
+
+	return apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": eip712DomainType,
+			"CreateAppSession": {
+				{Name: "requestId", Type: "uint64"},
+				{Name: "timestamp", Type: "uint64"},
+				{Name: "definition", Type: "AppDefinition"},
+				{Name: "allocations", Type: "AppAllocation[]"},
+			},
+			"AppDefinition": {
+				{Name: "protocol", Type: "string"},
+				{Name: "participants", Type: "address[]"},
+				{Name: "weights", Type: "uint64[]"},
+				{Name: "quorum", Type: "uint64"},
+				{Name: "challenge", Type: "uint64"},
+				{Name: "nonce", Type: "uint64"},
+			},
+			"AppAllocation": appAllocationType,
+		},
+		PrimaryType: "CreateAppSession",
+		Domain:      domain,
+		Message: apitypes.TypedDataMessage{
+			"requestId": new(big.Int).SetUint64(r.RequestID),
+			"timestamp": new(big.Int).SetUint64(r.Timestamp),
+			"definition": map[string]interface{}{
+				"protocol":     params.Definition.Protocol,
+				"participants": stringsToInterfaces(params.Definition.Participants),
+				"weights":      weights,
+				"quorum":       new(big.Int).SetUint64(params.Definition.Quorum),
+				"challenge":    new(big.Int).SetUint64(params.Definition.Challenge),
+				"nonce":        new(big.Int).SetUint64(params.Definition.Nonce),
+			},
+			"allocations": appAllocationsToInterfaces(params.Allocations),
+		},
+	}
+}
+
+// TypedData returns the close_app_session request as EIP-712 typed data
+func (r CloseAppSignData) TypedData(domain apitypes.TypedDataDomain) apitypes.TypedData {
+	var params CloseAppSessionParams
+	if len(r.Params) > 0 {
+		params = r.Params[0]
+	}
+
+	return apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": eip712DomainType,
+			"CloseAppSession": {
+				{Name: "requestId", Type: "uint64"},
+				{Name: "timestamp", Type: "uint64"},
+				{Name: "appSessionId", Type: "bytes32"},
+				{Name: "allocations", Type: "AppAllocation[]"},
+			},
+			"AppAllocation": appAllocationType,
+		},
+		PrimaryType: "CloseAppSession",
+		Domain:      domain,
+		Message: apitypes.TypedDataMessage{
+			"requestId":    new(big.Int).SetUint64(r.RequestID),
+			"timestamp":    new(big.Int).SetUint64(r.Timestamp),
+			"appSessionId": common.HexToHash(params.AppSessionID).Hex(),
+			"allocations":  appAllocationsToInterfaces(params.Allocations),
+		},
+	}
+}
+
+// TypedData returns the submit_app_state request as EIP-712 typed data
+func (r SubmitAppStateSignData) TypedData(domain apitypes.TypedDataDomain) apitypes.TypedData {
+	var params SubmitAppStateParams
+	if len(r.Params) > 0 {
+		params = r.Params[0]
+	}
+
+	return apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": eip712DomainType,
+			"SubmitAppState": {
+				{Name: "requestId", Type: "uint64"},
+				{Name: "timestamp", Type: "uint64"},
+				{Name: "appSessionId", Type: "bytes32"},
+				{Name: "version", Type: "uint64"},
+				{Name: "allocations", Type: "AppAllocation[]"},
+			},
+			"AppAllocation": appAllocationType,
+		},
+		PrimaryType: "SubmitAppState",
+		Domain:      domain,
+		Message: apitypes.TypedDataMessage{
+			"requestId":    new(big.Int).SetUint64(r.RequestID),
+			"timestamp":    new(big.Int).SetUint64(r.Timestamp),
+			"appSessionId": common.HexToHash(params.AppSessionID).Hex(),
+			"version":      new(big.Int).SetUint64(params.Version),
+			"allocations":  appAllocationsToInterfaces(params.Allocations),
+		},
+	}
+}
+
+// TypedData returns the resize_channel request as EIP-712 typed data
+func (r ResizeChannelSignData) TypedData(domain apitypes.TypedDataDomain) apitypes.TypedData {
+	var params ResizeChannelParams
+	if len(r.Params) > 0 {
+		params = r.Params[0]
+	}
+
+	return apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": eip712DomainType,
+			"ResizeChannel": {
+				{Name: "requestId", Type: "uint64"},
+				{Name: "timestamp", Type: "uint64"},
+				{Name: "channelId", Type: "bytes32"},
+				{Name: "resizeAmount", Type: "int256"},
+				{Name: "allocateAmount", Type: "int256"},
+				{Name: "fundsDestination", Type: "address"},
+			},
+		},
+		PrimaryType: "ResizeChannel",
+		Domain:      domain,
+		Message: apitypes.TypedDataMessage{
+			"requestId":        new(big.Int).SetUint64(r.RequestID),
+			"timestamp":        new(big.Int).SetUint64(r.Timestamp),
+			"channelId":        common.HexToHash(params.ChannelID).Hex(),
+			"resizeAmount":     bigIntOrZero(params.ResizeAmount),
+			"allocateAmount":   bigIntOrZero(params.AllocateAmount),
+			"fundsDestination": params.FundsDestination,
+		},
+	}
+}
+
+// TypedData returns the transfer request as EIP-712 typed data
+func (r TransferSignData) TypedData(domain apitypes.TypedDataDomain) apitypes.TypedData {
+	var params TransferParams
+	if len(r.Params) > 0 {
+		params = r.Params[0]
+	}
+
+	allocations := make([]interface{}, 0, len(params.Allocations))
+	for _, a := range params.Allocations {
+		allocations = append(allocations, map[string]interface{}{
+			"destination": a.Destination,
+			"asset":       a.AssetSymbol,
+			"amount":      a.Amount.String(),
+		})
+	}
+
+	return apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": eip712DomainType,
+			"Transfer": {
+				{Name: "requestId", Type: "uint64"},
+				{Name: "timestamp", Type: "uint64"},
+				{Name: "allocations", Type: "TransferAllocation[]"},
+			},
+			"TransferAllocation": {
+				{Name: "destination", Type: "address"},
+				{Name: "asset", Type: "string"},
+				{Name: "amount", Type: "string"},
+			},
+		},
+		PrimaryType: "Transfer",
+		Domain:      domain,
+		Message: apitypes.TypedDataMessage{
+			"requestId":   new(big.Int).SetUint64(r.RequestID),
+			"timestamp":   new(big.Int).SetUint64(r.Timestamp),
+			"allocations": allocations,
+		},
+	}
+}
+
+// TypedData returns the close_channel request as EIP-712 typed data
+func (r CloseChannelSignData) TypedData(domain apitypes.TypedDataDomain) apitypes.TypedData {
+	var params CloseChannelParams
+	if len(r.Params) > 0 {
+		params = r.Params[0]
+	}
+
+	return apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": eip712DomainType,
+			"CloseChannel": {
+				{Name: "requestId", Type: "uint64"},
+				{Name: "timestamp", Type: "uint64"},
+				{Name: "channelId", Type: "bytes32"},
+				{Name: "fundsDestination", Type: "address"},
+			},
+		},
+		PrimaryType: "CloseChannel",
+		Domain:      domain,
+		Message: apitypes.TypedDataMessage{
+			"requestId":        new(big.Int).SetUint64(r.RequestID),
+			"timestamp":        new(big.Int).SetUint64(r.Timestamp),
+			"channelId":        common.HexToHash(params.ChannelID).Hex(),
+			"fundsDestination": params.FundsDestination,
+		},
+	}
+}
+
+func appAllocationsToInterfaces(allocations []AppAllocation) []interface{} {
+	result := make([]interface{}, 0, len(allocations))
+	for _, a := range allocations {
+		result = append(result, map[string]interface{}{
+			"participant": a.Participant,
+			"asset":       a.AssetSymbol,
+			"amount":      a.Amount.String(),
+		})
+	}
+	return result
+}
+
+func stringsToInterfaces(values []string) []interface{} {
+	result := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		result = append(result, v)
+	}
+	return result
+}
+
+func bigIntOrZero(v *big.Int) *big.Int {
+	if v == nil {
+		return big.NewInt(0)
+	}
+	return v
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signTypedData signs the EIP-712 hash of typed data as a wallet supporting only typed data would
+func signTypedData(s *Signer, typedData apitypes.TypedData) ([]byte, error) {
+	hash, _, err := apitypes.TypedDataAndHash(typedData)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.Sign(hash, s.privateKey)
+}
+
+func TestHandleCreateApplicationEIP712(t *testing.T) {
+	rawA, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	rawB, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	brokerKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	signerA := Signer{privateKey: rawA}
+	signerB := Signer{privateKey: rawB}
+	addrA := signerA.GetAddress().Hex()
+	addrB := signerB.GetAddress().Hex()
+	broker := Signer{privateKey: brokerKey}
+
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	require.NoError(t, GetParticipantLedger(db, addrA).Record(addrA, "usdc", decimal.NewFromInt(100)))
+	require.NoError(t, GetParticipantLedger(db, addrB).Record(addrB, "usdc", decimal.NewFromInt(200)))
+
+	sigCtx := SigningContext{Mode: SignatureModeEIP712, ChainID: 137, Broker: broker.GetAddress()}
+
+	newRequest := func(requestID uint64, sign func(data CreateAppSignData) []string) *RPCMessage {
+		ts := uint64(time.Now().Unix())
+		params := CreateAppSessionParams{
+			Definition: AppDefinition{
+				Protocol:     "test-proto",
+				Participants: []string{addrA, addrB},
+				Weights:      []uint64{1, 1},
+				Quorum:       2,
+				Challenge:    60,
+				Nonce:        ts + requestID,
+			},
+			Allocations: []AppAllocation{
+				{Participant: addrA, AssetSymbol: "usdc", Amount: decimal.NewFromInt(10)},
+				{Participant: addrB, AssetSymbol: "usdc", Amount: decimal.NewFromInt(20)},
+			},
+		}
+		rpcReq := &RPCMessage{
+			Req: &RPCData{
+				RequestID: requestID,
+				Method:    "create_app_session",
+				Params:    []any{params},
+				Timestamp: ts,
+			},
+		}
+		rpcReq.Sig = sign(CreateAppSignData{
+			RequestID: rpcReq.Req.RequestID,
+			Method:    rpcReq.Req.Method,
+			Params:    []CreateAppSessionParams{params},
+			Timestamp: rpcReq.Req.Timestamp,
+		})
+		return rpcReq
+	}
+
+	signTyped := func(ctx SigningContext) func(data CreateAppSignData) []string {
+		return func(data CreateAppSignData) []string {
+			var sigs []string
+			for _, s := range []Signer{signerA, signerB} {
+				sig, err := signTypedData(&s, data.TypedData(ctx.Domain()))
+				require.NoError(t, err)
+				sigs = append(sigs, hexutil.Encode(sig))
+			}
+			return sigs
+		}
+	}
+
+	// Typed data signatures in the session's domain are accepted
+	_, err = HandleCreateApplication(newRequest(1, signTyped(sigCtx)), db, sigCtx)
+	require.NoError(t, err)
+
+	balanceA, err := GetParticipantLedger(db, addrA).Balance(addrA, "usdc")
+	require.NoError(t, err)
+	assert.Equal(t, decimal.NewFromInt(90).String(), balanceA.String())
+
+	// Signatures made in the domain of another chain do not recover the participants
+	_, err = HandleCreateApplication(newRequest(2, signTyped(sigCtx.WithChain(1))), db, sigCtx)
+	assert.ErrorContains(t, err, "missing signature for participant")
+
+	// Raw signatures are not accepted in eip712 mode, but still work in raw mode
+	signRaw := func(data CreateAppSignData) []string {
+		signBytes, err := data.MarshalJSON()
+		require.NoError(t, err)
+		var sigs []string
+		for _, s := range []Signer{signerA, signerB} {
+			sig, err := s.Sign(signBytes)
+			require.NoError(t, err)
+			sigs = append(sigs, hexutil.Encode(sig))
+		}
+		return sigs
+	}
+	_, err = HandleCreateApplication(newRequest(3, signRaw), db, sigCtx)
+	assert.ErrorContains(t, err, "missing signature for participant")
+
+	_, err = HandleCreateApplication(newRequest(4, signRaw), db, SigningContext{Mode: SignatureModeRaw})
+	require.NoError(t, err)
+}
+
+func TestHandleSubmitAppStateEIP712(t *testing.T) {
+	signerA := newTestSigner(t)
+	signerB := newTestSigner(t)
+	addrA := signerA.GetAddress().Hex()
+	addrB := signerB.GetAddress().Hex()
+
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	vAppID := "0x00000000000000000000000000000000000000000000000000000000000000a1"
+	require.NoError(t, db.Create(&AppSession{
+		SessionID:    vAppID,
+		Participants: []string{addrA, addrB},
+		Status:       ChannelStatusOpen,
+		Challenge:    60,
+		Weights:      []int64{1, 1},
+		Quorum:       2,
+		Version:      1,
+	}).Error)
+	require.NoError(t, GetParticipantLedger(db, addrA).Record(vAppID, "usdc", decimal.NewFromInt(100)))
+	require.NoError(t, GetParticipantLedger(db, addrB).Record(vAppID, "usdc", decimal.NewFromInt(200)))
+
+	sigCtx := SigningContext{Mode: SignatureModeEIP712, ChainID: 137, Broker: newTestSigner(t).GetAddress()}
+
+	newRequest := func(requestID uint64, ctx SigningContext) *RPCMessage {
+		params := SubmitAppStateParams{
+			AppSessionID: vAppID,
+			Version:      2,
+			Allocations: []AppAllocation{
+				{Participant: addrA, AssetSymbol: "usdc", Amount: decimal.NewFromInt(250)},
+				{Participant: addrB, AssetSymbol: "usdc", Amount: decimal.NewFromInt(50)},
+			},
+		}
+		rpcReq := &RPCMessage{
+			Req: &RPCData{RequestID: requestID, Method: "submit_app_state", Params: []any{params}, Timestamp: uint64(time.Now().Unix())},
+		}
+		data := SubmitAppStateSignData{
+			RequestID: rpcReq.Req.RequestID,
+			Method:    rpcReq.Req.Method,
+			Params:    []SubmitAppStateParams{params},
+			Timestamp: rpcReq.Req.Timestamp,
+		}
+		for _, s := range []*Signer{signerA, signerB} {
+			sig, err := signTypedData(s, data.TypedData(ctx.Domain()))
+			require.NoError(t, err)
+			rpcReq.Sig = append(rpcReq.Sig, hexutil.Encode(sig))
+		}
+		return rpcReq
+	}
+
+	// Signatures made in the domain of another chain do not recover the participants
+	_, err := HandleSubmitAppState(newRequest(1, sigCtx.WithChain(1)), db, sigCtx)
+	assert.ErrorContains(t, err, "signature from unknown participant")
+
+	// Typed data signatures in the session's domain are accepted
+	resp, err := HandleSubmitAppState(newRequest(2, sigCtx), db, sigCtx)
+	require.NoError(t, err)
+	appResp, ok := resp.Res.Params[0].(*AppSessionResponse)
+	require.True(t, ok)
+	assert.Equal(t, uint64(2), appResp.Version)
+	assert.Equal(t, []string{addrA, addrB}, appResp.Participants)
+
+	balanceA, err := GetParticipantLedger(db, addrA).Balance(vAppID, "usdc")
+	require.NoError(t, err)
+	assert.Equal(t, decimal.NewFromInt(250).String(), balanceA.String())
+}
+
+func TestVerifyChallengeResponseEIP712(t *testing.T) {
+	walletKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	wallet := Signer{privateKey: walletKey}
+	sessionKey := newTestSigner(t)
+	brokerKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	sigCtx := SigningContext{Mode: SignatureModeEIP712, ChainID: 1, Broker: crypto.PubkeyToAddress(brokerKey.PublicKey)}
+
+	newAuthVerify := func(am *AuthManager, ctx SigningContext) (*RPCMessage, AuthVerifyParams) {
+		challenge, err := am.GenerateChallenge(wallet.GetAddress().Hex())
+		require.NoError(t, err)
+
+		params := AuthVerifyParams{
+			Address:       wallet.GetAddress().Hex(),
+			Challenge:     challenge,
+			Scope:         ScopeAll,
+			SignatureMode: string(SignatureModeEIP712),
+			ChainID:       1,
+			SessionKey: &SessionKeyGrant{
+				Address:    sessionKey.GetAddress().Hex(),
+				ExpiresAt:  uint64(time.Now().Add(time.Hour).UnixMilli()),
+				Methods:    []string{"transfer"},
+				Allowances: []AllowanceParams{{AssetSymbol: "usdc", Amount: decimal.NewFromInt(5)}},
+			},
+		}
+		sig, err := signTypedData(&wallet, params.TypedData(ctx.Domain()))
+		require.NoError(t, err)
+		keySig, err := signTypedData(sessionKey, params.TypedData(ctx.Domain()))
+		require.NoError(t, err)
+
+		return &RPCMessage{
+			Req: &RPCData{RequestID: 1, Method: "auth_verify", Params: []any{params}, Timestamp: uint64(time.Now().UnixMilli())},
+			Sig: []string{hexutil.Encode(sig), hexutil.Encode(keySig)},
+		}, params
+	}
+
+	am := NewAuthManager()
+	rpc, params := newAuthVerify(am, sigCtx)
+	addr, err := verifyChallengeResponse(rpc, params, am, sigCtx)
+	require.NoError(t, err)
+	assert.Equal(t, wallet.GetAddress().Hex(), addr)
+
+	// The session key has to sign the request as well
+	rpc, params = newAuthVerify(am, sigCtx)
+	rpc.Sig = rpc.Sig[:1]
+	_, err = verifyChallengeResponse(rpc, params, am, sigCtx)
+	assert.EqualError(t, err, "missing session key signature")
+
+	rpc, params = newAuthVerify(am, sigCtx)
+	rpc.Sig[1] = rpc.Sig[0]
+	_, err = verifyChallengeResponse(rpc, params, am, sigCtx)
+	assert.EqualError(t, err, "invalid session key signature")
+
+	// A changed grant invalidates the signature
+	rpc, params = newAuthVerify(am, sigCtx)
+	params.SessionKey.Allowances[0].Amount = decimal.NewFromInt(5000)
+	_, err = verifyChallengeResponse(rpc, params, am, sigCtx)
+	assert.EqualError(t, err, "invalid signature")
+
+	// A signature for another broker is rejected
+	otherBroker := sigCtx
+	otherBroker.Broker = wallet.GetAddress()
+	rpc, params = newAuthVerify(am, otherBroker)
+	_, err = verifyChallengeResponse(rpc, params, am, sigCtx)
+	assert.EqualError(t, err, "invalid signature")
+}
+
+func TestParseSignatureMode(t *testing.T) {
+	for input, expected := range map[string]SignatureMode{"": SignatureModeRaw, "raw": SignatureModeRaw, "eip712": SignatureModeEIP712} {
+		mode, err := ParseSignatureMode(input)
+		require.NoError(t, err, fmt.Sprintf("mode %q", input))
+		assert.Equal(t, expected, mode)
+	}
+
+	_, err := ParseSignatureMode("personal_sign")
+	assert.EqualError(t, err, "unsupported signature mode: personal_sign")
+}
+
+func TestHandleTransferEIP712(t *testing.T) {
+	signer := newTestSigner(t)
+	sender := signer.GetAddress().Hex()
+	recipient := "0x1111111111111111111111111111111111111111"
+
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	require.NoError(t, GetParticipantLedger(db, sender).Record(sender, "usdc", decimal.NewFromInt(100)))
+
+	sigCtx := SigningContext{Mode: SignatureModeEIP712, ChainID: 137, Broker: newTestSigner(t).GetAddress()}
+
+	newRequest := func(requestID uint64, ctx SigningContext) *RPCMessage {
+		params := TransferParams{Allocations: []TransferAllocation{
+			{Destination: recipient, AssetSymbol: "usdc", Amount: decimal.NewFromInt(30)},
+		}}
+		rpcReq := &RPCMessage{
+			Req: &RPCData{RequestID: requestID, Method: "transfer", Params: []any{params}, Timestamp: uint64(time.Now().Unix())},
+		}
+		data := TransferSignData{
+			RequestID: rpcReq.Req.RequestID,
+			Method:    rpcReq.Req.Method,
+			Params:    []TransferParams{params},
+			Timestamp: rpcReq.Req.Timestamp,
+		}
+		sig, err := signTypedData(signer, data.TypedData(ctx.Domain()))
+		require.NoError(t, err)
+		rpcReq.Sig = []string{hexutil.Encode(sig)}
+		return rpcReq
+	}
+
+	// Signatures made in the domain of another chain do not recover the sender
+	_, err := HandleTransfer(newRequest(1, sigCtx.WithChain(1)), sender, db, sigCtx)
+	assert.Error(t, err)
+
+	_, err = HandleTransfer(newRequest(2, sigCtx), sender, db, sigCtx)
+	require.NoError(t, err)
+
+	balance, err := GetParticipantLedger(db, recipient).Balance(recipient, "usdc")
+	require.NoError(t, err)
+	assert.Equal(t, decimal.NewFromInt(30).String(), balance.String())
+}
+
+func TestHandleCloseChannelEIP712(t *testing.T) {
+	signer := newTestSigner(t)
+	participant := signer.GetAddress().Hex()
+
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	token := "0x2222222222222222222222222222222222222222"
+	require.NoError(t, db.Create(&Asset{Token: token, ChainID: 137, Symbol: "usdc", Decimals: 6}).Error)
+	channel := Channel{
+		ChannelID:   "0x00000000000000000000000000000000000000000000000000000000000000c1",
+		Participant: participant,
+		Status:      ChannelStatusOpen,
+		Token:       token,
+		ChainID:     137,
+		Amount:      2000000,
+		Adjudicator: "0xAdj",
+	}
+	require.NoError(t, db.Create(&channel).Error)
+	require.NoError(t, GetParticipantLedger(db, participant).Record(participant, "usdc", decimal.NewFromInt(2)))
+
+	// The session domain is on another chain, close_channel is signed in the domain of the channel's chain
+	sigCtx := SigningContext{Mode: SignatureModeEIP712, ChainID: 1, Broker: newTestSigner(t).GetAddress()}
+
+	newRequest := func(requestID uint64, ctx SigningContext) *RPCMessage {
+		params := CloseChannelParams{ChannelID: channel.ChannelID, FundsDestination: participant}
+		rpcReq := &RPCMessage{
+			Req: &RPCData{RequestID: requestID, Method: "close_channel", Params: []any{params}, Timestamp: uint64(time.Now().Unix())},
+		}
+		data := CloseChannelSignData{
+			RequestID: rpcReq.Req.RequestID,
+			Method:    rpcReq.Req.Method,
+			Params:    []CloseChannelParams{params},
+			Timestamp: rpcReq.Req.Timestamp,
+		}
+		sig, err := signTypedData(signer, data.TypedData(ctx.Domain()))
+		require.NoError(t, err)
+		rpcReq.Sig = []string{hexutil.Encode(sig)}
+		return rpcReq
+	}
+
+	_, err := HandleCloseChannel(newRequest(1, sigCtx), db, NewBrokerKeys(signer, nil), time.Hour, sigCtx)
+	assert.Error(t, err)
+
+	response, err := HandleCloseChannel(newRequest(2, sigCtx.WithChain(channel.ChainID)), db, NewBrokerKeys(signer, nil), time.Hour, sigCtx)
+	require.NoError(t, err)
+	closeResponse, ok := response.Res.Params[0].(CloseChannelResponse)
+	require.True(t, ok)
+	assert.Equal(t, "2000000", closeResponse.FinalAllocations[0].Amount.String())
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const erc20ABI = `[` +
+	`{"type":"function","name":"symbol","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"string"}]},` +
+	`{"type":"function","name":"decimals","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"uint8"}]},` +
+	`{"type":"function","name":"balanceOf","stateMutability":"view","inputs":[{"name":"account","type":"address"}],"outputs":[{"name":"","type":"uint256"}]},` +
+	`{"type":"function","name":"allowance","stateMutability":"view","inputs":[{"name":"owner","type":"address"},{"name":"spender","type":"address"}],"outputs":[{"name":"","type":"uint256"}]},` +
+	`{"type":"function","name":"approve","stateMutability":"nonpayable","inputs":[{"name":"spender","type":"address"},{"name":"amount","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]}` +
+	`]`
+
+var erc20Abi = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(erc20ABI))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+// TokenMetadata is the symbol and decimals reported by an ERC-20 contract
+type TokenMetadata struct {
+	Symbol   string
+	Decimals uint8
+}
+
+// ReadTokenMetadata calls the symbol and decimals methods of an ERC-20 token contract
+func ReadTokenMetadata(ctx context.Context, caller bind.ContractCaller, token common.Address) (TokenMetadata, error) {
+	contract := bind.NewBoundContract(token, erc20Abi, caller, nil, nil)
+	opts := &bind.CallOpts{Context: ctx}
+
+	var metadata TokenMetadata
+	var out []interface{}
+	if err := contract.Call(opts, &out, "decimals"); err != nil {
+		return metadata, fmt.Errorf("failed to read decimals of token %s: %w", token.Hex(), err)
+	}
+	metadata.Decimals = *abi.ConvertType(out[0], new(uint8)).(*uint8)
+
+	out = nil
+	if err := contract.Call(opts, &out, "symbol"); err != nil {
+		return metadata, fmt.Errorf("failed to read symbol of token %s: %w", token.Hex(), err)
+	}
+	metadata.Symbol = *abi.ConvertType(out[0], new(string)).(*string)
+
+	return metadata, nil
+}
+
+// ReadTokenBalance calls the balanceOf method of an ERC-20 token contract
+func ReadTokenBalance(ctx context.Context, caller bind.ContractCaller, token, account common.Address) (*big.Int, error) {
+	contract := bind.NewBoundContract(token, erc20Abi, caller, nil, nil)
+
+	var out []interface{}
+	if err := contract.Call(&bind.CallOpts{Context: ctx}, &out, "balanceOf", account); err != nil {
+		return nil, fmt.Errorf("failed to read balance of token %s: %w", token.Hex(), err)
+	}
+	return *abi.ConvertType(out[0], new(*big.Int)).(**big.Int), nil
+}
+
+// ReadTokenAllowance calls the allowance method of an ERC-20 token contract
+func ReadTokenAllowance(ctx context.Context, caller bind.ContractCaller, token, owner, spender common.Address) (*big.Int, error) {
+	contract := bind.NewBoundContract(token, erc20Abi, caller, nil, nil)
+
+	var out []interface{}
+	if err := contract.Call(&bind.CallOpts{Context: ctx}, &out, "allowance", owner, spender); err != nil {
+		return nil, fmt.Errorf("failed to read allowance of token %s: %w", token.Hex(), err)
+	}
+	return *abi.ConvertType(out[0], new(*big.Int)).(**big.Int), nil
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"math/big"
+	"sort"
 	"sync/atomic"
 	"time"
 
@@ -18,6 +21,10 @@ var logger = log.Logger("base-event-listener")
 
 const (
 	maxBackOffCount = 5
+	// maxBackfillBlockRange limits the number of blocks requested in a single FilterLogs call
+	maxBackfillBlockRange = 2000
+	// confirmationPollInterval is how often the chain head is polled while logs await confirmations
+	confirmationPollInterval = 5 * time.Second
 )
 
 func init() {
@@ -31,23 +38,90 @@ func init() {
 	}
 }
 
-type LogHandler func(l types.Log)
+// LogHandler is called for every confirmed log. A log whose handler returns an error is handled again
+// before any later log, the next time new logs arrive or the chain head is polled.
+type LogHandler func(l types.Log) error
 
-// listenEvents listens for blockchain events and processes them with the provided handler
+// RemovedLogHandler is called for a log removed by a chain reorg.
+// handled is false if the log was still awaiting confirmations and never reached the LogHandler.
+type RemovedLogHandler func(l types.Log, handled bool)
+
+// listenEvents listens for blockchain events and processes them with the provided handler.
+// Logs are held back until they are at least confirmations blocks deep. Logs removed by a reorg
+// are dropped if still pending, otherwise passed to removedHandler so their effect can be reverted.
+// If lastBlock is not zero, logs emitted since lastBlock are replayed with FilterLogs every time
+// a subscription is established, so no event is missed while the node was down or resubscribing.
+// Listening stops when ctx is cancelled.
 func listenEvents(
 	ctx context.Context,
 	client bind.ContractBackend,
 	contractAddress common.Address,
 	chainID uint32,
 	lastBlock uint64,
+	confirmations uint64,
 	handler LogHandler,
+	removedHandler RemovedLogHandler,
 ) {
 	var backOffCount atomic.Uint64
 	var currentCh chan types.Log
 	var eventSubscription event.Subscription
 
-	logger.Infow("starting listening events", "chainID", chainID, "contractAddress", contractAddress.String())
+	var pending []types.Log
+	var headBlock uint64
+
+	// enqueue adds a log to the pending queue, keeping it ordered by position
+	enqueue := func(l types.Log) {
+		i := sort.Search(len(pending), func(i int) bool {
+			p := pending[i]
+			return p.BlockNumber > l.BlockNumber || (p.BlockNumber == l.BlockNumber && p.Index >= l.Index)
+		})
+		if i < len(pending) && sameLog(pending[i], l) {
+			return
+		}
+		pending = append(pending, types.Log{})
+		copy(pending[i+1:], pending[i:])
+		pending[i] = l
+		headBlock = max(headBlock, l.BlockNumber)
+	}
+
+	// release handles every pending log that has reached the confirmation depth
+	release := func() {
+		for len(pending) > 0 && pending[0].BlockNumber+confirmations <= headBlock {
+			l := pending[0]
+			if err := handler(l); err != nil {
+				logger.Errorw("failed to handle log, retrying", "error", err, "chainID", chainID, "blockNumber", l.BlockNumber, "txHash", l.TxHash.Hex(), "logIndex", l.Index)
+				return
+			}
+			pending = pending[1:]
+			lastBlock = max(lastBlock, l.BlockNumber)
+		}
+	}
+
+	remove := func(l types.Log) {
+		logger.Warnw("log removed by chain reorg", "chainID", chainID, "blockNumber", l.BlockNumber, "blockHash", l.BlockHash.Hex(), "txHash", l.TxHash.Hex(), "logIndex", l.Index)
+		for i, p := range pending {
+			if sameLog(p, l) {
+				pending = append(pending[:i], pending[i+1:]...)
+				removedHandler(l, false)
+				return
+			}
+		}
+		removedHandler(l, true)
+		if lastBlock != 0 {
+			lastBlock = min(lastBlock, l.BlockNumber)
+		}
+	}
+
+	confirmationTicker := time.NewTicker(confirmationPollInterval)
+	defer confirmationTicker.Stop()
+
+	logger.Infow("starting listening events", "chainID", chainID, "contractAddress", contractAddress.String(), "lastBlock", lastBlock, "confirmations", confirmations)
 	for {
+		if ctx.Err() != nil {
+			logger.Infow("stopped listening events", "chainID", chainID, "contractAddress", contractAddress.String())
+			return
+		}
+
 		if eventSubscription == nil {
 			waitForBackOffTimeout(int(backOffCount.Load()))
 
@@ -63,16 +137,48 @@ func listenEvents(
 				continue
 			}
 
+			// Replay historical logs only after the subscription is live, so that
+			// events emitted during the backfill are buffered rather than lost.
+			if lastBlock != 0 {
+				head, err := backfillEvents(ctx, client, contractAddress, chainID, lastBlock, enqueue)
+				if err != nil {
+					logger.Errorw("failed to backfill events", "error", err, "chainID", chainID, "contractAddress", contractAddress.String())
+					eventSub.Unsubscribe()
+					backOffCount.Add(1)
+					continue
+				}
+				headBlock = max(headBlock, head)
+				release()
+			}
+
 			eventSubscription = eventSub
 			logger.Infow("watching events", "chainID", chainID, "contractAddress", contractAddress.String())
 			backOffCount.Store(0)
 		}
 
 		select {
+		case <-ctx.Done():
+			eventSubscription.Unsubscribe()
+			eventSubscription = nil
 		case eventLog := <-currentCh:
-			lastBlock = eventLog.BlockNumber
-			logger.Debugw("received new event", "chainID", chainID, "contractAddress", contractAddress.String(), "blockNumber", lastBlock, "logIndex", eventLog.Index)
-			handler(eventLog)
+			logger.Debugw("received new event", "chainID", chainID, "contractAddress", contractAddress.String(), "blockNumber", eventLog.BlockNumber, "logIndex", eventLog.Index, "removed", eventLog.Removed)
+			if eventLog.Removed {
+				remove(eventLog)
+				continue
+			}
+			enqueue(eventLog)
+			release()
+		case <-confirmationTicker.C:
+			if len(pending) == 0 {
+				continue
+			}
+			head, err := client.HeaderByNumber(ctx, nil)
+			if err != nil {
+				logger.Errorw("failed to get latest block header", "error", err, "chainID", chainID)
+				continue
+			}
+			headBlock = max(headBlock, head.Number.Uint64())
+			release()
 		case err := <-eventSubscription.Err():
 			if err != nil {
 				logger.Errorw("event subscription error", "error", err, "chainID", chainID, "contractAddress", contractAddress.String())
@@ -86,6 +192,48 @@ func listenEvents(
 	}
 }
 
+// sameLog reports whether two logs refer to the same emission in the same block
+func sameLog(a, b types.Log) bool {
+	return a.TxHash == b.TxHash && a.Index == b.Index && a.BlockHash == b.BlockHash
+}
+
+// backfillEvents replays contract logs from fromBlock up to the current head in bounded block ranges.
+// It returns the head block the backfill ran up to.
+func backfillEvents(
+	ctx context.Context,
+	client bind.ContractBackend,
+	contractAddress common.Address,
+	chainID uint32,
+	fromBlock uint64,
+	handle func(types.Log),
+) (uint64, error) {
+	head, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get latest block header: %w", err)
+	}
+	headBlock := head.Number.Uint64()
+
+	logger.Infow("backfilling events", "chainID", chainID, "contractAddress", contractAddress.String(), "fromBlock", fromBlock, "toBlock", headBlock)
+	for start := fromBlock; start <= headBlock; start += maxBackfillBlockRange {
+		end := min(start+maxBackfillBlockRange-1, headBlock)
+
+		logs, err := client.FilterLogs(ctx, ethereum.FilterQuery{
+			FromBlock: new(big.Int).SetUint64(start),
+			ToBlock:   new(big.Int).SetUint64(end),
+			Addresses: []common.Address{contractAddress},
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to filter logs in blocks %d-%d: %w", start, end, err)
+		}
+
+		for _, l := range logs {
+			handle(l)
+		}
+	}
+
+	return headBlock, nil
+}
+
 // waitForBackOffTimeout implements exponential backoff between retries
 func waitForBackOffTimeout(backOffCount int) {
 	if backOffCount > maxBackOffCount {
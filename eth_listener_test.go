@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLogBackend delivers logs pushed by the test through a single subscription
+type fakeLogBackend struct {
+	bind.ContractBackend
+	logs chan types.Log
+	head uint64
+}
+
+func (b *fakeLogBackend) SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		for {
+			select {
+			case l := <-b.logs:
+				ch <- l
+			case <-quit:
+				return nil
+			}
+		}
+	}), nil
+}
+
+func (b *fakeLogBackend) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return &types.Header{Number: new(big.Int).SetUint64(b.head)}, nil
+}
+
+func TestListenEventsConfirmations(t *testing.T) {
+	backend := &fakeLogBackend{logs: make(chan types.Log)}
+	handled := make(chan types.Log, 10)
+	removed := make(chan bool, 10)
+
+	go listenEvents(context.Background(), backend, common.Address{}, 137, 0, 2,
+		func(l types.Log) error { handled <- l; return nil },
+		func(l types.Log, wasHandled bool) { removed <- wasHandled },
+	)
+
+	newLog := func(block uint64, hash string) types.Log {
+		return types.Log{BlockNumber: block, BlockHash: common.HexToHash(hash), TxHash: common.HexToHash(hash), Topics: []common.Hash{{}}}
+	}
+
+	// A log removed before reaching the confirmation depth is dropped
+	backend.logs <- newLog(10, "0x0a")
+	reorged := newLog(10, "0x0a")
+	reorged.Removed = true
+	backend.logs <- reorged
+	assert.False(t, <-removed)
+
+	// A log is released once a later block shows it is deep enough
+	backend.logs <- newLog(11, "0x0b")
+	backend.logs <- newLog(12, "0x0c")
+	select {
+	case l := <-handled:
+		t.Fatalf("unexpected log from block %d handled", l.BlockNumber)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	backend.logs <- newLog(13, "0x0d")
+	select {
+	case l := <-handled:
+		assert.Equal(t, uint64(11), l.BlockNumber)
+	case <-time.After(time.Second):
+		require.Fail(t, "confirmed log was not handled")
+	}
+
+	// Removing an already handled log reports it for reverting
+	reorged = newLog(11, "0x0b")
+	reorged.Removed = true
+	backend.logs <- reorged
+	assert.True(t, <-removed)
+}
+
+func TestListenEventsStopsOnCancel(t *testing.T) {
+	backend := &fakeLogBackend{logs: make(chan types.Log)}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		listenEvents(ctx, backend, common.Address{}, 137, 0, 0, func(types.Log) error { return nil }, func(types.Log, bool) {})
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		require.Fail(t, "listener did not stop after its context was cancelled")
+	}
+}
+
+func TestListenEventsRetriesFailedLog(t *testing.T) {
+	backend := &fakeLogBackend{logs: make(chan types.Log)}
+	handled := make(chan types.Log, 10)
+
+	var failed bool
+	go listenEvents(context.Background(), backend, common.Address{}, 137, 0, 0,
+		func(l types.Log) error {
+			if l.BlockNumber == 10 && !failed {
+				failed = true
+				return errors.New("database unavailable")
+			}
+			handled <- l
+			return nil
+		},
+		func(types.Log, bool) {},
+	)
+
+	newLog := func(block uint64, hash string) types.Log {
+		return types.Log{BlockNumber: block, BlockHash: common.HexToHash(hash), TxHash: common.HexToHash(hash), Topics: []common.Hash{{}}}
+	}
+
+	// A failed log is kept and handled again before the logs that follow it
+	backend.logs <- newLog(10, "0x0a")
+	backend.logs <- newLog(11, "0x0b")
+	for _, block := range []uint64{10, 11} {
+		select {
+		case l := <-handled:
+			assert.Equal(t, block, l.BlockNumber)
+		case <-time.After(time.Second):
+			require.Fail(t, "log was not handled")
+		}
+	}
+}
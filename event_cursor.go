@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// EventCursor tracks the position of the last custody contract log processed on a chain
+type EventCursor struct {
+	ChainID         uint32 `gorm:"column:chain_id;primaryKey"`
+	ContractAddress string `gorm:"column:contract_address;primaryKey"`
+	BlockNumber     uint64 `gorm:"column:block_number;not null"`
+	LogIndex        uint   `gorm:"column:log_index;not null"`
+	UpdatedAt       time.Time
+}
+
+// TableName specifies the table name for the EventCursor model
+func (EventCursor) TableName() string {
+	return "event_cursors"
+}
+
+// GetEventCursor returns the stored cursor for a contract on a chain, or nil if no event has been processed yet
+func GetEventCursor(tx *gorm.DB, chainID uint32, contractAddress string) (*EventCursor, error) {
+	var cursor EventCursor
+	err := tx.Where("chain_id = ? AND contract_address = ?", chainID, contractAddress).First(&cursor).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// SaveEventCursor moves the cursor for a contract on a chain to the given log position
+func SaveEventCursor(tx *gorm.DB, chainID uint32, contractAddress string, blockNumber uint64, logIndex uint) error {
+	cursor := EventCursor{
+		ChainID:         chainID,
+		ContractAddress: contractAddress,
+		BlockNumber:     blockNumber,
+		LogIndex:        logIndex,
+		UpdatedAt:       time.Now(),
+	}
+
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "chain_id"}, {Name: "contract_address"}},
+		DoUpdates: clause.AssignmentColumns([]string{"block_number", "log_index", "updated_at"}),
+	}).Create(&cursor).Error
+}
+
+// RewindEventCursor moves the cursor back to the given block if it is ahead of it,
+// so that logs from reorganised blocks are replayed on the next backfill
+func RewindEventCursor(tx *gorm.DB, chainID uint32, contractAddress string, blockNumber uint64) error {
+	return tx.Model(&EventCursor{}).
+		Where("chain_id = ? AND contract_address = ? AND block_number > ?", chainID, contractAddress, blockNumber).
+		Updates(map[string]interface{}{"block_number": blockNumber, "log_index": 0, "updated_at": time.Now()}).Error
+}
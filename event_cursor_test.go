@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventCursor(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	contract := "0xCustody"
+
+	// No cursor before any event is processed
+	cursor, err := GetEventCursor(db, 137, contract)
+	require.NoError(t, err)
+	assert.Nil(t, cursor)
+
+	require.NoError(t, SaveEventCursor(db, 137, contract, 100, 3))
+	require.NoError(t, SaveEventCursor(db, 8453, contract, 500, 0))
+
+	cursor, err = GetEventCursor(db, 137, contract)
+	require.NoError(t, err)
+	require.NotNil(t, cursor)
+	assert.Equal(t, uint64(100), cursor.BlockNumber)
+	assert.Equal(t, uint(3), cursor.LogIndex)
+
+	// Saving again moves the existing cursor instead of inserting a new row
+	require.NoError(t, SaveEventCursor(db, 137, contract, 101, 1))
+
+	cursor, err = GetEventCursor(db, 137, contract)
+	require.NoError(t, err)
+	require.NotNil(t, cursor)
+	assert.Equal(t, uint64(101), cursor.BlockNumber)
+	assert.Equal(t, uint(1), cursor.LogIndex)
+
+	var count int64
+	require.NoError(t, db.Model(&EventCursor{}).Count(&count).Error)
+	assert.Equal(t, int64(2), count)
+
+	// Cursors are tracked per chain
+	cursor, err = GetEventCursor(db, 8453, contract)
+	require.NoError(t, err)
+	require.NotNil(t, cursor)
+	assert.Equal(t, uint64(500), cursor.BlockNumber)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Gas modes of a network
+const (
+	GasModeLegacy  = "legacy"
+	GasModeEIP1559 = "eip1559"
+)
+
+// GasPolicy controls how the fees and gas limits of broker transactions are chosen on a network
+type GasPolicy struct {
+	Mode           string   // GasModeEIP1559 or GasModeLegacy
+	MaxFeePerGas   *big.Int // Cap of the max fee per gas, or of the gas price of legacy transactions; nil means no cap
+	TipMultiplier  float64  // Applied to the suggested priority fee, or to the suggested gas price of legacy transactions
+	GasLimitMargin uint64   // Percentage added to the estimated gas of a transaction
+}
+
+// DefaultGasPolicy returns the gas policy of networks without gas settings
+func DefaultGasPolicy() GasPolicy {
+	return GasPolicy{
+		Mode:           GasModeEIP1559,
+		TipMultiplier:  1.25,
+		GasLimitMargin: 20,
+	}
+}
+
+// Validate checks that the policy can price transactions
+func (p GasPolicy) Validate() error {
+	if p.Mode != GasModeEIP1559 && p.Mode != GasModeLegacy {
+		return fmt.Errorf("unknown gas mode: %s", p.Mode)
+	}
+	if p.TipMultiplier <= 0 {
+		return fmt.Errorf("tip multiplier must be positive, got %v", p.TipMultiplier)
+	}
+	if p.MaxFeePerGas != nil && p.MaxFeePerGas.Sign() <= 0 {
+		return fmt.Errorf("max fee per gas must be positive, got %s", p.MaxFeePerGas)
+	}
+	return nil
+}
+
+// TxFees are the fees of a transaction. TipCap is nil for legacy transactions, whose gas price is FeeCap.
+type TxFees struct {
+	FeeCap *big.Int
+	TipCap *big.Int
+}
+
+// SuggestFees prices a new transaction from the current network fees.
+// Under EIP-1559 the fee cap leaves room for the base fee to double before the transaction is priced out.
+// Chains without a base fee fall back to legacy transactions.
+func (p GasPolicy) SuggestFees(ctx context.Context, client TxBackend) (TxFees, error) {
+	if p.Mode == GasModeEIP1559 {
+		head, err := client.HeaderByNumber(ctx, nil)
+		if err != nil {
+			return TxFees{}, fmt.Errorf("failed to get latest header: %w", err)
+		}
+		if head.BaseFee != nil {
+			tip, err := client.SuggestGasTipCap(ctx)
+			if err != nil {
+				return TxFees{}, fmt.Errorf("failed to suggest gas tip cap: %w", err)
+			}
+			tipCap := multiplyBig(tip, p.TipMultiplier)
+			feeCap := p.capFee(new(big.Int).Add(new(big.Int).Mul(head.BaseFee, big.NewInt(2)), tipCap))
+			if tipCap.Cmp(feeCap) > 0 {
+				tipCap = new(big.Int).Set(feeCap)
+			}
+			return TxFees{FeeCap: feeCap, TipCap: tipCap}, nil
+		}
+	}
+
+	gasPrice, err := client.SuggestGasPrice(ctx)
+	if err != nil {
+		return TxFees{}, fmt.Errorf("failed to suggest gas price: %w", err)
+	}
+	return TxFees{FeeCap: p.capFee(multiplyBig(gasPrice, p.TipMultiplier))}, nil
+}
+
+// BumpFees raises the fees of a stuck transaction by txFeeBumpPercent, or to the current network fees if
+// they are higher. It returns false if the fee cap does not allow a replacement to be accepted by nodes.
+func (p GasPolicy) BumpFees(ctx context.Context, client TxBackend, fees TxFees) (TxFees, bool) {
+	bumped := TxFees{FeeCap: p.capFee(bumpFee(fees.FeeCap))}
+	if fees.TipCap != nil {
+		bumped.TipCap = bumpFee(fees.TipCap)
+	}
+
+	if suggested, err := p.SuggestFees(ctx, client); err == nil {
+		if suggested.FeeCap.Cmp(bumped.FeeCap) > 0 {
+			bumped.FeeCap = suggested.FeeCap
+		}
+		if suggested.TipCap != nil && bumped.TipCap != nil && suggested.TipCap.Cmp(bumped.TipCap) > 0 {
+			bumped.TipCap = suggested.TipCap
+		}
+	}
+	if bumped.TipCap != nil && bumped.TipCap.Cmp(bumped.FeeCap) > 0 {
+		bumped.TipCap = new(big.Int).Set(bumped.FeeCap)
+	}
+
+	// Nodes only accept a replacement paying at least 10% more on every fee
+	if !feeReplaceable(fees.FeeCap, bumped.FeeCap) || (fees.TipCap != nil && !feeReplaceable(fees.TipCap, bumped.TipCap)) {
+		return fees, false
+	}
+	return bumped, true
+}
+
+// EstimateGasLimit estimates the gas of a call and adds the safety margin of the policy
+func (p GasPolicy) EstimateGasLimit(ctx context.Context, client TxBackend, from, to common.Address, value *big.Int, data []byte) (uint64, error) {
+	gas, err := client.EstimateGas(ctx, ethereum.CallMsg{From: from, To: &to, Value: value, Data: data})
+	if err != nil {
+		return 0, fmt.Errorf("failed to estimate gas: %w", err)
+	}
+	return gas + gas*p.GasLimitMargin/100, nil
+}
+
+// capFee limits a fee to the maximum fee per gas of the policy
+func (p GasPolicy) capFee(fee *big.Int) *big.Int {
+	if p.MaxFeePerGas != nil && fee.Cmp(p.MaxFeePerGas) > 0 {
+		return new(big.Int).Set(p.MaxFeePerGas)
+	}
+	return fee
+}
+
+// bumpFee raises a fee by txFeeBumpPercent
+func bumpFee(fee *big.Int) *big.Int {
+	bumped := new(big.Int).Mul(fee, big.NewInt(100+txFeeBumpPercent))
+	return bumped.Div(bumped, big.NewInt(100))
+}
+
+// feeReplaceable reports whether next is at least 10% above prev
+func feeReplaceable(prev, next *big.Int) bool {
+	minimum := new(big.Int).Mul(prev, big.NewInt(110))
+	return new(big.Int).Mul(next, big.NewInt(100)).Cmp(minimum) >= 0
+}
+
+// multiplyBig multiplies an integer by a factor, rounding down
+func multiplyBig(value *big.Int, factor float64) *big.Int {
+	result, _ := new(big.Float).Mul(new(big.Float).SetInt(value), big.NewFloat(factor)).Int(nil)
+	return result
+}
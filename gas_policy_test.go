@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGasPolicySuggestFees(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manager, _ := setupTestTxManager(t, db, newTestSigner(t), types.GenesisAlloc{}, DefaultGasPolicy(), nil)
+	client := manager.client
+	ctx := context.Background()
+
+	head, err := client.HeaderByNumber(ctx, nil)
+	require.NoError(t, err)
+	require.NotNil(t, head.BaseFee)
+	tip, err := client.SuggestGasTipCap(ctx)
+	require.NoError(t, err)
+	gasPrice, err := client.SuggestGasPrice(ctx)
+	require.NoError(t, err)
+
+	// EIP-1559 fees leave room for the base fee to double
+	policy := GasPolicy{Mode: GasModeEIP1559, TipMultiplier: 2}
+	fees, err := policy.SuggestFees(ctx, client)
+	require.NoError(t, err)
+	expectedTip := new(big.Int).Mul(tip, big.NewInt(2))
+	assert.Equal(t, expectedTip, fees.TipCap)
+	assert.Equal(t, new(big.Int).Add(new(big.Int).Mul(head.BaseFee, big.NewInt(2)), expectedTip), fees.FeeCap)
+
+	// The fee cap limits both fees
+	policy.MaxFeePerGas = big.NewInt(1)
+	fees, err = policy.SuggestFees(ctx, client)
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(1), fees.FeeCap)
+	assert.Equal(t, big.NewInt(1), fees.TipCap)
+
+	// Legacy transactions only have a gas price
+	policy = GasPolicy{Mode: GasModeLegacy, TipMultiplier: 1.5}
+	fees, err = policy.SuggestFees(ctx, client)
+	require.NoError(t, err)
+	assert.Nil(t, fees.TipCap)
+	assert.Equal(t, multiplyBig(gasPrice, 1.5), fees.FeeCap)
+}
+
+func TestGasPolicyBumpFees(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manager, _ := setupTestTxManager(t, db, newTestSigner(t), types.GenesisAlloc{}, DefaultGasPolicy(), nil)
+	ctx := context.Background()
+
+	// Fees far above the network fees are raised by the bump percentage
+	policy := GasPolicy{Mode: GasModeEIP1559, TipMultiplier: 1}
+	fees := TxFees{FeeCap: big.NewInt(1e15), TipCap: big.NewInt(1e14)}
+	bumped, ok := policy.BumpFees(ctx, manager.client, fees)
+	require.True(t, ok)
+	assert.Equal(t, big.NewInt(12e14), bumped.FeeCap)
+	assert.Equal(t, big.NewInt(12e13), bumped.TipCap)
+
+	// A fee cap leaving less than the 10% required for a replacement prevents the bump
+	policy.MaxFeePerGas = big.NewInt(105e13)
+	bumped, ok = policy.BumpFees(ctx, manager.client, fees)
+	assert.False(t, ok)
+	assert.Equal(t, fees, bumped)
+
+	assert.EqualError(t, GasPolicy{Mode: "fast", TipMultiplier: 1}.Validate(), "unknown gas mode: fast")
+	assert.Error(t, GasPolicy{Mode: GasModeLegacy}.Validate())
+	assert.NoError(t, DefaultGasPolicy().Validate())
+}
+
+func TestTxManagerGasPolicy(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	signer := newTestSigner(t)
+	to := common.HexToAddress("0x00000000000000000000000000000000000c0de1")
+	ctx := context.Background()
+
+	for _, policy := range []GasPolicy{
+		{Mode: GasModeEIP1559, TipMultiplier: 1, GasLimitMargin: 50},
+		{Mode: GasModeLegacy, TipMultiplier: 1, GasLimitMargin: 50},
+	} {
+		manager, backend := setupTestTxManager(t, db, signer, types.GenesisAlloc{}, policy, nil)
+		network := fmt.Sprintf("%d", manager.chainID)
+		feesSpent := testutil.ToFloat64(testMetrics().BrokerTxFeesSpent.With(prometheus.Labels{"network": network}))
+
+		record, err := manager.Send(ctx, TxKindCheckpoint, "0xChannel", signer.GetAddress(), to, nil)
+		require.NoError(t, err)
+
+		// The gas limit is the estimated gas plus the margin
+		assert.Equal(t, uint64(21000*3/2), record.GasLimit)
+
+		tx, _, err := backend.Client().TransactionByHash(ctx, common.HexToHash(record.TxHash))
+		require.NoError(t, err)
+		if policy.Mode == GasModeEIP1559 {
+			assert.Equal(t, uint8(types.DynamicFeeTxType), tx.Type())
+			assert.Equal(t, record.GasTipCap, tx.GasTipCap().String())
+		} else {
+			assert.Equal(t, uint8(types.LegacyTxType), tx.Type())
+			assert.Empty(t, record.GasTipCap)
+		}
+		assert.Equal(t, record.GasPrice, tx.GasFeeCap().String())
+
+		// The fees paid by the mined transaction are tracked per network
+		backend.Commit()
+		manager.checkPending(ctx)
+		receipt, err := backend.Client().TransactionReceipt(ctx, tx.Hash())
+		require.NoError(t, err)
+		paid := new(big.Int).Mul(new(big.Int).SetUint64(receipt.GasUsed), receipt.EffectiveGasPrice)
+		assert.InDelta(t, weiToGwei(paid), testutil.ToFloat64(testMetrics().BrokerTxFeesSpent.With(prometheus.Labels{"network": network}))-feesSpent, 1e-6)
+	}
+}
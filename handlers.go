@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"slices"
 	"strings"
 	"time"
 
@@ -70,6 +71,55 @@ func (r CloseAppSignData) MarshalJSON() ([]byte, error) {
 	return json.Marshal(arr)
 }
 
+// SubmitAppStateParams represents parameters needed to update the allocations of an open virtual app
+type SubmitAppStateParams struct {
+	AppSessionID string          `json:"app_session_id"`
+	Version      uint64          `json:"version"`
+	Allocations  []AppAllocation `json:"allocations"`
+}
+
+type SubmitAppStateSignData struct {
+	RequestID uint64
+	Method    string
+	Params    []SubmitAppStateParams
+	Timestamp uint64
+}
+
+func (r SubmitAppStateSignData) MarshalJSON() ([]byte, error) {
+	arr := []interface{}{r.RequestID, r.Method, r.Params, r.Timestamp}
+	return json.Marshal(arr)
+}
+
+// TransferParams represents parameters needed for an off-chain transfer of unified balance
+type TransferParams struct {
+	Allocations []TransferAllocation `json:"allocations"`
+}
+
+// TransferAllocation represents the amount of an asset credited to a single destination
+type TransferAllocation struct {
+	Destination string          `json:"destination"`
+	AssetSymbol string          `json:"asset"`
+	Amount      decimal.Decimal `json:"amount"`
+}
+
+type TransferSignData struct {
+	RequestID uint64
+	Method    string
+	Params    []TransferParams
+	Timestamp uint64
+}
+
+func (r TransferSignData) MarshalJSON() ([]byte, error) {
+	arr := []interface{}{r.RequestID, r.Method, r.Params, r.Timestamp}
+	return json.Marshal(arr)
+}
+
+// TransferResponse represents the response for an off-chain transfer
+type TransferResponse struct {
+	From        string               `json:"from"`
+	Allocations []TransferAllocation `json:"allocations"`
+}
+
 // AppSessionResponse represents response data for application operations
 type AppSessionResponse struct {
 	AppSessionID string   `json:"app_session_id"`
@@ -138,6 +188,18 @@ type CloseChannelParams struct {
 	FundsDestination string `json:"funds_destination"`
 }
 
+type CloseChannelSignData struct {
+	RequestID uint64
+	Method    string
+	Params    []CloseChannelParams
+	Timestamp uint64
+}
+
+func (r CloseChannelSignData) MarshalJSON() ([]byte, error) {
+	arr := []interface{}{r.RequestID, r.Method, r.Params, r.Timestamp}
+	return json.Marshal(arr)
+}
+
 // CloseChannelResponse represents the response for closing a channel
 type CloseChannelResponse struct {
 	ChannelID        string       `json:"channel_id"`
@@ -149,6 +211,23 @@ type CloseChannelResponse struct {
 	Signature        Signature    `json:"server_signature"`
 }
 
+// GetChannelStateParams represents parameters for retrieving the latest mutually signed state of a channel
+type GetChannelStateParams struct {
+	ChannelID string `json:"channel_id"`
+}
+
+// ChannelStateResponse represents a channel state signed by both the user and the broker
+type ChannelStateResponse struct {
+	ChannelID     string       `json:"channel_id"`
+	Intent        uint8        `json:"intent"`
+	Version       uint64       `json:"version"`
+	StateData     string       `json:"state_data"`
+	Allocations   []Allocation `json:"allocations"`
+	StateHash     string       `json:"state_hash"`
+	UserSignature Signature    `json:"user_signature"`
+	Signature     Signature    `json:"server_signature"`
+}
+
 // ChannelResponse represents a channel's details in the response
 type ChannelResponse struct {
 	ChannelID   string        `json:"channel_id"`
@@ -186,8 +265,9 @@ type NetworkInfo struct {
 
 // BrokerConfig represents the broker configuration information
 type BrokerConfig struct {
-	BrokerAddress string        `json:"broker_address"`
-	Networks      []NetworkInfo `json:"networks"`
+	BrokerAddress   string        `json:"broker_address"`   // Key new channels are opened with
+	BrokerAddresses []string      `json:"broker_addresses"` // All broker keys, with a previous key during a rotation
+	Networks        []NetworkInfo `json:"networks"`
 }
 
 // RPCEntry represents an RPC record from history.
@@ -204,7 +284,7 @@ type RPCEntry struct {
 }
 
 // HandleGetConfig returns the broker configuration
-func HandleGetConfig(rpc *RPCMessage, config *Config, signer *Signer) (*RPCMessage, error) {
+func HandleGetConfig(rpc *RPCMessage, config *Config, keys *BrokerKeys) (*RPCMessage, error) {
 	supportedNetworks := []NetworkInfo{}
 
 	// Populate the supported networks from the config
@@ -216,9 +296,15 @@ func HandleGetConfig(rpc *RPCMessage, config *Config, signer *Signer) (*RPCMessa
 		})
 	}
 
+	var brokerAddresses []string
+	for _, signer := range keys.All() {
+		brokerAddresses = append(brokerAddresses, signer.GetAddress().Hex())
+	}
+
 	brokerConfig := BrokerConfig{
-		BrokerAddress: signer.GetAddress().Hex(),
-		Networks:      supportedNetworks,
+		BrokerAddress:   keys.Current().GetAddress().Hex(),
+		BrokerAddresses: brokerAddresses,
+		Networks:        supportedNetworks,
 	}
 
 	rpcResponse := CreateResponse(rpc.Req.RequestID, "get_config", []any{brokerConfig}, time.Now())
@@ -298,8 +384,114 @@ func HandleGetLedgerEntries(rpc *RPCMessage, address string, db *gorm.DB) (*RPCM
 	return rpcResponse, nil
 }
 
+// HandleTransfer moves unified balance from the sender to one or more destination accounts
+func HandleTransfer(rpc *RPCMessage, address string, db *gorm.DB, sigCtx SigningContext) (*RPCMessage, error) {
+	if len(rpc.Req.Params) < 1 {
+		return nil, errors.New("missing parameters")
+	}
+
+	var params TransferParams
+	paramsJSON, err := json.Marshal(rpc.Req.Params[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse parameters: %w", err)
+	}
+
+	if err := json.Unmarshal(paramsJSON, &params); err != nil {
+		return nil, fmt.Errorf("invalid parameters format: %w", err)
+	}
+
+	if len(params.Allocations) == 0 {
+		return nil, errors.New("missing allocations")
+	}
+
+	req := TransferSignData{
+		RequestID: rpc.Req.RequestID,
+		Method:    rpc.Req.Method,
+		Params:    []TransferParams{{Allocations: params.Allocations}},
+		Timestamp: rpc.Req.Timestamp,
+	}
+
+	digest, err := sigCtx.Digest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rpc.Sig) == 0 {
+		return nil, errors.New("missing signature")
+	}
+
+	sessionKey, err := verifyOwnerSignature(db, sigCtx, digest, rpc.Sig[0], address, rpc.Req.Method)
+	if err != nil {
+		return nil, err
+	}
+
+	totals := map[string]decimal.Decimal{}
+	for i, alloc := range params.Allocations {
+		if !common.IsHexAddress(alloc.Destination) {
+			return nil, fmt.Errorf("invalid destination address: %s", alloc.Destination)
+		}
+		if alloc.AssetSymbol == "" || !alloc.Amount.IsPositive() {
+			return nil, errors.New("invalid allocation row")
+		}
+		if err := CheckAmountPrecision(db, alloc.AssetSymbol, alloc.Amount); err != nil {
+			return nil, err
+		}
+
+		destination := common.HexToAddress(alloc.Destination).Hex()
+		if strings.EqualFold(destination, address) {
+			return nil, errors.New("cannot transfer to self")
+		}
+
+		params.Allocations[i].Destination = destination
+		totals[alloc.AssetSymbol] = totals[alloc.AssetSymbol].Add(alloc.Amount)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		senderLedger := GetParticipantLedger(tx, address)
+		for asset, amount := range totals {
+			balance, err := senderLedger.Balance(address, asset)
+			if err != nil {
+				return fmt.Errorf("failed to check participant balance: %w", err)
+			}
+			if amount.GreaterThan(balance) {
+				return fmt.Errorf("insufficient funds: %s", asset)
+			}
+			if sessionKey != nil {
+				if err := sessionKey.Spend(tx, asset, amount); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, alloc := range params.Allocations {
+			if err := senderLedger.Record(address, alloc.AssetSymbol, alloc.Amount.Neg()); err != nil {
+				return fmt.Errorf("failed to debit sender: %w", err)
+			}
+
+			destinationLedger := GetParticipantLedger(tx, alloc.Destination)
+			if err := destinationLedger.Record(alloc.Destination, alloc.AssetSymbol, alloc.Amount); err != nil {
+				return fmt.Errorf("failed to credit destination: %w", err)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	response := TransferResponse{
+		From:        address,
+		Allocations: params.Allocations,
+	}
+
+	rpcResponse := CreateResponse(rpc.Req.RequestID, rpc.Req.Method, []any{response}, time.Now())
+	return rpcResponse, nil
+}
+
 // HandleCreateApplication creates a virtual application between participants
-func HandleCreateApplication(rpc *RPCMessage, db *gorm.DB) (*RPCMessage, error) {
+func HandleCreateApplication(rpc *RPCMessage, db *gorm.DB, sigCtx SigningContext) (*RPCMessage, error) {
 	if len(rpc.Req.Params) < 1 {
 		return nil, errors.New("missing parameters")
 	}
@@ -347,18 +539,42 @@ func HandleCreateApplication(rpc *RPCMessage, db *gorm.DB) (*RPCMessage, error)
 		Timestamp: rpc.Req.Timestamp,
 	}
 
-	reqBytes, err := json.Marshal(req)
+	digest, err := sigCtx.Digest(req)
 	if err != nil {
-		return nil, errors.New("error serializing message")
+		return nil, err
+	}
+
+	if len(rpc.Sig) > len(createApp.Definition.Participants) {
+		return nil, fmt.Errorf("too many signatures: %d for %d participants", len(rpc.Sig), len(createApp.Definition.Participants))
 	}
 
-	recoveredAddresses := map[string]bool{}
+	// Signers resolved to the wallets they act for, with the session key used if any
+	recoveredAddresses := map[string]*SessionKey{}
 	for _, sig := range rpc.Sig {
-		addr, err := RecoverAddress(reqBytes, sig)
-		if err != nil {
+		addr, err := RecoverAddressFromHash(digest, sig)
+		if err == nil {
+			owner, sessionKey, err := ResolveSigner(db, addr, createApp.Definition.Participants, rpc.Req.Method, createApp.Definition.Protocol)
+			if err != nil {
+				return nil, err
+			}
+			if slices.Contains(createApp.Definition.Participants, owner) {
+				recoveredAddresses[owner] = sessionKey
+				continue
+			}
+		}
+
+		// Not signed by the key of a participant, it may be the signature of a contract wallet
+		var unsigned []string
+		for _, participant := range createApp.Definition.Participants {
+			if _, ok := recoveredAddresses[participant]; !ok {
+				unsigned = append(unsigned, participant)
+			}
+		}
+		if wallet, ok := sigCtx.contractSigner(digest, sig, unsigned); ok {
+			recoveredAddresses[wallet] = nil
+		} else if err != nil {
 			return nil, errors.New("invalid signature")
 		}
-		recoveredAddresses[addr] = true
 	}
 
 	// Use a transaction to ensure atomicity for the entire operation
@@ -367,10 +583,19 @@ func HandleCreateApplication(rpc *RPCMessage, db *gorm.DB) (*RPCMessage, error)
 			if allocation.Amount.IsNegative() {
 				return errors.New("invalid allocation")
 			}
+			if err := CheckAmountPrecision(tx, allocation.AssetSymbol, allocation.Amount); err != nil {
+				return err
+			}
 			if allocation.Amount.IsPositive() {
-				if !recoveredAddresses[allocation.Participant] {
+				sessionKey, ok := recoveredAddresses[allocation.Participant]
+				if !ok {
 					return fmt.Errorf("missing signature for participant %s", allocation.Participant)
 				}
+				if sessionKey != nil {
+					if err := sessionKey.Spend(tx, allocation.AssetSymbol, allocation.Amount); err != nil {
+						return err
+					}
+				}
 			}
 
 			participantLedger := GetParticipantLedger(tx, allocation.Participant)
@@ -428,7 +653,7 @@ func HandleCreateApplication(rpc *RPCMessage, db *gorm.DB) (*RPCMessage, error)
 }
 
 // HandleCloseApplication closes a virtual app session and redistributes funds to participants
-func HandleCloseApplication(rpc *RPCMessage, db *gorm.DB) (*RPCMessage, error) {
+func HandleCloseApplication(rpc *RPCMessage, db *gorm.DB, sigCtx SigningContext) (*RPCMessage, error) {
 	if len(rpc.Req.Params) == 0 {
 		return nil, errors.New("missing parameters")
 	}
@@ -452,6 +677,9 @@ func HandleCloseApplication(rpc *RPCMessage, db *gorm.DB) (*RPCMessage, error) {
 		if a.Participant == "" || a.AssetSymbol == "" || a.Amount.IsNegative() {
 			return nil, errors.New("invalid allocation row")
 		}
+		if err := CheckAmountPrecision(db, a.AssetSymbol, a.Amount); err != nil {
+			return nil, err
+		}
 		assets[a.AssetSymbol] = struct{}{}
 	}
 
@@ -462,46 +690,25 @@ func HandleCloseApplication(rpc *RPCMessage, db *gorm.DB) (*RPCMessage, error) {
 		Timestamp: rpc.Req.Timestamp,
 	}
 
-	reqBytes, err := json.Marshal(req)
+	digest, err := sigCtx.Digest(req)
 	if err != nil {
-		return nil, errors.New("error serializing message")
+		return nil, err
 	}
 
-	err = db.Transaction(func(tx *gorm.DB) error {
-		var appSession AppSession
-		if err := tx.Where("session_id = ? AND status = ?", params.AppSessionID, ChannelStatusOpen).Order("nonce DESC").
-			First(&appSession).Error; err != nil {
-			return fmt.Errorf("virtual app not found or not open: %w", err)
-		}
-
-		participantWeights := map[string]int64{}
-		for i, addr := range appSession.Participants {
-			participantWeights[strings.ToLower(addr)] = appSession.Weights[i]
-		}
+	// Signatures are verified before the transaction, since contract wallet checks call the chain
+	appSession, err := getOpenAppSession(db, params.AppSessionID)
+	if err != nil {
+		return nil, err
+	}
+	participantWeights, sessionKeys, err := verifyQuorum(db, sigCtx, *appSession, rpc.Req.Method, digest, rpc.Sig)
+	if err != nil {
+		return nil, err
+	}
 
-		seen := map[string]bool{}
-		var totalWeight int64
-		for _, sigHex := range rpc.Sig {
-			recovered, err := RecoverAddress(reqBytes, sigHex)
-			if err != nil {
-				return err
-			}
-			recovered = strings.ToLower(recovered)
-			if seen[recovered] {
-				return errors.New("duplicate signature")
-			}
-			seen[recovered] = true
-			weight, ok := participantWeights[recovered]
-			if !ok {
-				return fmt.Errorf("signature from unknown participant %s", recovered)
-			}
-			if weight <= 0 {
-				return fmt.Errorf("zero weight for signer %s", recovered)
-			}
-			totalWeight += weight
-		}
-		if totalWeight < int64(appSession.Quorum) {
-			return fmt.Errorf("quorum not met: %d / %d", totalWeight, appSession.Quorum)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		appSession, err := getOpenAppSession(tx, params.AppSessionID)
+		if err != nil {
+			return err
 		}
 
 		appSessionBalance := map[string]decimal.Decimal{}
@@ -535,6 +742,13 @@ func HandleCloseApplication(rpc *RPCMessage, db *gorm.DB) (*RPCMessage, error) {
 				return fmt.Errorf("failed to get participant balance: %w", err)
 			}
 
+			// Funds a participant gives up by signing with a session key count against its allowance
+			if key := sessionKeys[addr]; key != nil {
+				if err := key.Spend(tx, alloc.AssetSymbol, balance.Sub(alloc.Amount)); err != nil {
+					return err
+				}
+			}
+
 			// Debit session, credit participant
 			if err := ledger.Record(appSession.SessionID, alloc.AssetSymbol, balance.Neg()); err != nil {
 				return fmt.Errorf("failed to debit session: %w", err)
@@ -562,7 +776,7 @@ func HandleCloseApplication(rpc *RPCMessage, db *gorm.DB) (*RPCMessage, error) {
 			}
 		}
 
-		return tx.Model(&appSession).Updates(map[string]any{
+		return tx.Model(appSession).Updates(map[string]any{
 			"status": ChannelStatusClosed,
 		}).Error
 	})
@@ -574,12 +788,234 @@ func HandleCloseApplication(rpc *RPCMessage, db *gorm.DB) (*RPCMessage, error) {
 	response := &AppSessionResponse{
 		AppSessionID: params.AppSessionID,
 		Status:       string(ChannelStatusClosed),
+		Participants: appSession.Participants,
+	}
+
+	rpcResponse := CreateResponse(rpc.Req.RequestID, rpc.Req.Method, []any{response}, time.Now())
+	return rpcResponse, nil
+}
+
+// HandleSubmitAppState rebalances the allocations of an open virtual app session without closing it
+func HandleSubmitAppState(rpc *RPCMessage, db *gorm.DB, sigCtx SigningContext) (*RPCMessage, error) {
+	if len(rpc.Req.Params) == 0 {
+		return nil, errors.New("missing parameters")
+	}
+
+	var params SubmitAppStateParams
+	paramsJSON, err := json.Marshal(rpc.Req.Params[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse parameters: %w", err)
+	}
+
+	if err := json.Unmarshal(paramsJSON, &params); err != nil {
+		return nil, fmt.Errorf("invalid parameters format: %w", err)
+	}
+
+	if params.AppSessionID == "" || len(params.Allocations) == 0 {
+		return nil, errors.New("missing required parameters: app_id or allocations")
+	}
+
+	for _, a := range params.Allocations {
+		if a.Participant == "" || a.AssetSymbol == "" || a.Amount.IsNegative() {
+			return nil, errors.New("invalid allocation row")
+		}
+		if err := CheckAmountPrecision(db, a.AssetSymbol, a.Amount); err != nil {
+			return nil, err
+		}
+	}
+
+	req := SubmitAppStateSignData{
+		RequestID: rpc.Req.RequestID,
+		Method:    rpc.Req.Method,
+		Params:    []SubmitAppStateParams{{AppSessionID: params.AppSessionID, Version: params.Version, Allocations: params.Allocations}},
+		Timestamp: rpc.Req.Timestamp,
+	}
+
+	digest, err := sigCtx.Digest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Signatures are verified before the transaction, since contract wallet checks call the chain
+	appSession, err := getOpenAppSession(db, params.AppSessionID)
+	if err != nil {
+		return nil, err
+	}
+	participantWeights, sessionKeys, err := verifyQuorum(db, sigCtx, *appSession, rpc.Req.Method, digest, rpc.Sig)
+	if err != nil {
+		return nil, err
+	}
+
+	var newVersion uint64
+	err = db.Transaction(func(tx *gorm.DB) error {
+		appSession, err := getOpenAppSession(tx, params.AppSessionID)
+		if err != nil {
+			return err
+		}
+
+		// Signed states are only accepted once and in order, so an older state cannot be replayed
+		if params.Version != appSession.Version+1 {
+			return fmt.Errorf("invalid app state version %d, expected %d", params.Version, appSession.Version+1)
+		}
+
+		// Current session balance of every participant, keyed by lowercase address and asset.
+		currentBalances := map[string]map[string]decimal.Decimal{}
+		appSessionBalance := map[string]decimal.Decimal{}
+		for _, p := range appSession.Participants {
+			balances, err := GetParticipantLedger(tx, p).GetBalances(appSession.SessionID)
+			if err != nil {
+				return fmt.Errorf("failed to read balances for %s: %w", p, err)
+			}
+			currentBalances[strings.ToLower(p)] = map[string]decimal.Decimal{}
+			for _, b := range balances {
+				currentBalances[strings.ToLower(p)][b.Asset] = b.Amount
+				appSessionBalance[b.Asset] = appSessionBalance[b.Asset].Add(b.Amount)
+			}
+		}
+
+		newBalances := map[string]map[string]decimal.Decimal{}
+		allocationSum := map[string]decimal.Decimal{}
+		for _, alloc := range params.Allocations {
+			addr := strings.ToLower(alloc.Participant)
+			if _, ok := participantWeights[addr]; !ok {
+				return fmt.Errorf("allocation to non-participant %s", alloc.Participant)
+			}
+			if newBalances[addr] == nil {
+				newBalances[addr] = map[string]decimal.Decimal{}
+			}
+			if _, ok := newBalances[addr][alloc.AssetSymbol]; ok {
+				return fmt.Errorf("participant %s appears more than once for asset %s", alloc.Participant, alloc.AssetSymbol)
+			}
+			newBalances[addr][alloc.AssetSymbol] = alloc.Amount
+			allocationSum[alloc.AssetSymbol] = allocationSum[alloc.AssetSymbol].Add(alloc.Amount)
+		}
+
+		for asset, bal := range appSessionBalance {
+			if alloc, ok := allocationSum[asset]; !ok || !bal.Equal(alloc) {
+				return fmt.Errorf("asset %s total does not match session balance", asset)
+			}
+		}
+		for asset := range allocationSum {
+			if _, ok := appSessionBalance[asset]; !ok {
+				return fmt.Errorf("allocation references unknown asset %s", asset)
+			}
+		}
+
+		// Move funds between participants' session accounts to match the new allocations.
+		for _, p := range appSession.Participants {
+			addr := strings.ToLower(p)
+			ledger := GetParticipantLedger(tx, p)
+			for asset := range appSessionBalance {
+				delta := newBalances[addr][asset].Sub(currentBalances[addr][asset])
+				// Funds a participant gives up by signing with a session key count against its allowance
+				if key := sessionKeys[addr]; key != nil {
+					if err := key.Spend(tx, asset, delta.Neg()); err != nil {
+						return err
+					}
+				}
+				if err := ledger.Record(appSession.SessionID, asset, delta); err != nil {
+					return fmt.Errorf("failed to update session balance for %s: %w", p, err)
+				}
+			}
+		}
+
+		newVersion = appSession.Version + 1
+		return tx.Model(appSession).Updates(map[string]any{
+			"version": newVersion,
+		}).Error
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	response := &AppSessionResponse{
+		AppSessionID: params.AppSessionID,
+		Status:       string(ChannelStatusOpen),
+		Participants: appSession.Participants,
+		Version:      newVersion,
 	}
 
 	rpcResponse := CreateResponse(rpc.Req.RequestID, rpc.Req.Method, []any{response}, time.Now())
 	return rpcResponse, nil
 }
 
+// getOpenAppSession returns the latest open app session with the given ID
+func getOpenAppSession(tx *gorm.DB, sessionID string) (*AppSession, error) {
+	var appSession AppSession
+	if err := tx.Where("session_id = ? AND status = ?", sessionID, ChannelStatusOpen).Order("nonce DESC").
+		First(&appSession).Error; err != nil {
+		return nil, fmt.Errorf("virtual app not found or not open: %w", err)
+	}
+	return &appSession, nil
+}
+
+// verifyQuorum recovers the signers of the request digest and checks that their combined weight meets the session quorum.
+// Session key signatures count for the wallet that owns the key, and signatures that do not recover to a participant
+// are checked against the participants that are contract wallets.
+// It is called outside of database transactions. It returns the signature weight of every session participant and the session key of every participant that signed
+// with one, both keyed by lowercase address.
+func verifyQuorum(tx *gorm.DB, sigCtx SigningContext, appSession AppSession, method string, digest []byte, sigs []string) (map[string]int64, map[string]*SessionKey, error) {
+	// Every signature may cost a contract wallet call, so no more than one per participant is checked
+	if len(sigs) > len(appSession.Participants) {
+		return nil, nil, fmt.Errorf("too many signatures: %d for %d participants", len(sigs), len(appSession.Participants))
+	}
+
+	participantWeights := map[string]int64{}
+	for i, addr := range appSession.Participants {
+		participantWeights[strings.ToLower(addr)] = appSession.Weights[i]
+	}
+
+	seen := map[string]bool{}
+	sessionKeys := map[string]*SessionKey{}
+	var totalWeight int64
+	for _, sigHex := range sigs {
+		var key *SessionKey
+		recovered, err := RecoverAddressFromHash(digest, sigHex)
+		if err == nil {
+			recovered, key, err = ResolveSigner(tx, recovered, appSession.Participants, method, appSession.Protocol)
+			if err != nil {
+				return nil, nil, err
+			}
+			recovered = strings.ToLower(recovered)
+		}
+		if _, ok := participantWeights[recovered]; !ok {
+			var unsigned []string
+			for _, participant := range appSession.Participants {
+				if !seen[strings.ToLower(participant)] {
+					unsigned = append(unsigned, participant)
+				}
+			}
+			if wallet, ok := sigCtx.contractSigner(digest, sigHex, unsigned); ok {
+				recovered, key, err = strings.ToLower(wallet), nil, nil
+			}
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if seen[recovered] {
+			return nil, nil, errors.New("duplicate signature")
+		}
+		seen[recovered] = true
+		weight, ok := participantWeights[recovered]
+		if !ok {
+			return nil, nil, fmt.Errorf("signature from unknown participant %s", recovered)
+		}
+		if weight <= 0 {
+			return nil, nil, fmt.Errorf("zero weight for signer %s", recovered)
+		}
+		if key != nil {
+			sessionKeys[recovered] = key
+		}
+		totalWeight += weight
+	}
+	if totalWeight < int64(appSession.Quorum) {
+		return nil, nil, fmt.Errorf("quorum not met: %d / %d", totalWeight, appSession.Quorum)
+	}
+
+	return participantWeights, sessionKeys, nil
+}
+
 // HandleGetAppDefinition returns the application definition for a ledger account
 func HandleGetAppDefinition(rpc *RPCMessage, db *gorm.DB) (*RPCMessage, error) {
 	var sessionID string
@@ -663,7 +1099,7 @@ func HandleGetAppSessions(rpc *RPCMessage, db *gorm.DB) (*RPCMessage, error) {
 }
 
 // HandleResizeChannel processes a request to resize a payment channel
-func HandleResizeChannel(rpc *RPCMessage, db *gorm.DB, signer *Signer) (*RPCMessage, error) {
+func HandleResizeChannel(rpc *RPCMessage, db *gorm.DB, keys *BrokerKeys, liquidity Liquidity, settlementTTL time.Duration, sigCtx SigningContext) (*RPCMessage, error) {
 	if len(rpc.Req.Params) < 1 {
 		return nil, errors.New("missing parameters")
 	}
@@ -686,6 +1122,15 @@ func HandleResizeChannel(rpc *RPCMessage, db *gorm.DB, signer *Signer) (*RPCMess
 	if err != nil {
 		return nil, fmt.Errorf("failed to find channel: %w", err)
 	}
+	if channel == nil {
+		return nil, fmt.Errorf("channel %s not found", params.ChannelID)
+	}
+
+	// The channel keeps being signed with the broker key it was opened with
+	signer, err := keys.ForChannel(*channel)
+	if err != nil {
+		return nil, err
+	}
 
 	req := ResizeChannelSignData{
 		RequestID: rpc.Req.RequestID,
@@ -694,17 +1139,22 @@ func HandleResizeChannel(rpc *RPCMessage, db *gorm.DB, signer *Signer) (*RPCMess
 		Timestamp: rpc.Req.Timestamp,
 	}
 
-	reqBytes, err := json.Marshal(req)
+	// Typed data of a resize is signed in the domain of the channel's chain
+	digest, err := sigCtx.WithChain(channel.ChainID).Digest(req)
 	if err != nil {
-		return nil, errors.New("error serializing message")
+		return nil, err
 	}
 
-	isValid, err := ValidateSignature(reqBytes, rpc.Sig[0], channel.Participant)
-	if err != nil || !isValid {
-		return nil, errors.New("invalid signature")
+	sessionKey, err := verifyOwnerSignature(db, sigCtx.WithChain(channel.ChainID), digest, rpc.Sig[0], channel.Participant, rpc.Req.Method)
+	if err != nil {
+		return nil, err
 	}
 
-	asset, err := GetAssetByToken(db, channel.Token, channel.ChainID)
+	if channel.Status == ChannelStatusChallenged {
+		return nil, errors.New("channel is challenged")
+	}
+
+	asset, err := GetLedgerAsset(db, channel.Token, channel.ChainID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find asset: %w", err)
 	}
@@ -720,34 +1170,19 @@ func HandleResizeChannel(rpc *RPCMessage, db *gorm.DB, signer *Signer) (*RPCMess
 		params.AllocateAmount = big.NewInt(0)
 	}
 
-	ledger := GetParticipantLedger(db, channel.Participant)
-	balance, err := ledger.Balance(channel.Participant, asset.Symbol)
-	if err != nil {
-		return nil, fmt.Errorf("failed to check participant A balance: %w", err)
-	}
-
-	rawBalance := balance.Shift(int32(asset.Decimals)).BigInt()
-
-	newChannelAmount := new(big.Int).Add(new(big.Int).SetUint64(channel.Amount), params.AllocateAmount)
-	if rawBalance.Cmp(newChannelAmount) < 0 {
-		return nil, errors.New("insufficient unified balance")
-	}
-
-	newChannelAmount.Add(newChannelAmount, params.ResizeAmount)
-	if newChannelAmount.Cmp(big.NewInt(0)) < 0 {
-		return nil, errors.New("new channel amount must be positive")
-	}
-	allocations := []nitrolite.Allocation{
-		{
-			Destination: common.HexToAddress(params.FundsDestination),
-			Token:       common.HexToAddress(channel.Token),
-			Amount:      newChannelAmount,
-		},
-		{
-			Destination: signer.GetAddress(),
-			Token:       common.HexToAddress(channel.Token),
-			Amount:      big.NewInt(0),
-		},
+	// The allocated amount is deposited into the channel from the broker's custody balance on the channel's chain.
+	// It is checked against the allocations of the chain and token and reserved in one critical section,
+	// so concurrent resizes cannot allocate the same funds.
+	if params.AllocateAmount.Sign() > 0 {
+		available, err := brokerLiquidity(liquidity, *channel, signer.GetAddress())
+		if err != nil {
+			return nil, err
+		}
+		unlock := lockAllocations(channel.ChainID, channel.Token)
+		defer unlock()
+		if err := checkBrokerLiquidity(db, *channel, signer.GetAddress(), asset, available, params.AllocateAmount); err != nil {
+			return nil, err
+		}
 	}
 
 	resizeAmounts := []*big.Int{new(big.Int).Neg(params.ResizeAmount), params.AllocateAmount}
@@ -766,18 +1201,87 @@ func HandleResizeChannel(rpc *RPCMessage, db *gorm.DB, signer *Signer) (*RPCMess
 		return nil, fmt.Errorf("failed to pack intentions: %w", err)
 	}
 
-	// Encode the channel ID and state for signing
-	channelID := common.HexToHash(channel.ChannelID)
-	encodedState, err := nitrolite.EncodeState(channelID, nitrolite.IntentRESIZE, big.NewInt(int64(channel.Version)+1), encodedIntentions, allocations)
-	if err != nil {
-		return nil, fmt.Errorf("failed to encode state hash: %w", err)
-	}
+	var allocations []nitrolite.Allocation
+	var stateHash string
+	var sig nitrolite.Signature
+	err = db.Transaction(func(tx *gorm.DB) error {
+		ledger := GetParticipantLedger(tx, channel.Participant)
+		balance, err := ledger.Balance(channel.Participant, asset.Symbol)
+		if err != nil {
+			return fmt.Errorf("failed to check participant A balance: %w", err)
+		}
+
+		// Funds locked for a previously signed state of this channel are still available to it
+		locked, err := GetLockedSettlement(tx, *channel, asset.Symbol)
+		if err != nil {
+			return fmt.Errorf("failed to check locked settlement: %w", err)
+		}
+
+		// The unified balance may come from other chains of the asset group
+		rawBalance := asset.ToRaw(balance.Add(locked))
+
+		newChannelAmount := new(big.Int).Add(new(big.Int).SetUint64(channel.Amount), params.AllocateAmount)
+		if rawBalance.Cmp(newChannelAmount) < 0 {
+			return errors.New("insufficient unified balance")
+		}
+
+		newChannelAmount.Add(newChannelAmount, params.ResizeAmount)
+		if newChannelAmount.Cmp(big.NewInt(0)) < 0 {
+			return errors.New("new channel amount must be positive")
+		}
+		allocations = []nitrolite.Allocation{
+			{
+				Destination: common.HexToAddress(params.FundsDestination),
+				Token:       common.HexToAddress(channel.Token),
+				Amount:      newChannelAmount,
+			},
+			{
+				Destination: signer.GetAddress(),
+				Token:       common.HexToAddress(channel.Token),
+				Amount:      big.NewInt(0),
+			},
+		}
+
+		// Encode the channel ID and state for signing
+		channelID := common.HexToHash(channel.ChannelID)
+		encodedState, err := nitrolite.EncodeState(channelID, nitrolite.IntentRESIZE, big.NewInt(int64(channel.Version)+1), encodedIntentions, allocations)
+		if err != nil {
+			return fmt.Errorf("failed to encode state hash: %w", err)
+		}
+
+		// Generate state hash and sign it
+		stateHash = crypto.Keccak256Hash(encodedState).Hex()
+		sig, err = signer.NitroSign(encodedState)
+		if err != nil {
+			return fmt.Errorf("failed to sign state: %w", err)
+		}
 
-	// Generate state hash and sign it
-	stateHash := crypto.Keccak256Hash(encodedState).Hex()
-	sig, err := signer.NitroSign(encodedState)
+		if _, err := SaveChannelState(tx, channel.ChannelID, nitrolite.IntentRESIZE, channel.Version+1, encodedIntentions, allocations, sig, nil); err != nil {
+			return err
+		}
+
+		// The Resized event records the participant's delta on the ledger; a debit is locked until then
+		debit := decimal.Zero
+		if ledgerDelta := asset.ToLedger(resizeAmounts[0]); ledgerDelta.IsNegative() {
+			debit = ledgerDelta.Neg()
+			if sessionKey != nil {
+				// Funds already locked for this channel were accounted for when they were locked
+				if err := sessionKey.Spend(tx, asset.Symbol, debit.Sub(locked)); err != nil {
+					return err
+				}
+			}
+		}
+		if debit.IsZero() && params.AllocateAmount.Sign() == 0 {
+			return nil
+		}
+		if err := LockSettlement(tx, *channel, asset.Symbol, debit, channel.Version+1, time.Now().Add(settlementTTL)); err != nil {
+			return err
+		}
+		// Broker funds allocated by the state stay reserved until it lands
+		return ReserveAllocation(tx, channel.ChannelID, asset.ToLedger(params.AllocateAmount))
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to sign state: %w", err)
+		return nil, err
 	}
 
 	response := ResizeChannelResponse{
@@ -806,7 +1310,7 @@ func HandleResizeChannel(rpc *RPCMessage, db *gorm.DB, signer *Signer) (*RPCMess
 }
 
 // HandleCloseChannel processes a request to close a payment channel
-func HandleCloseChannel(rpc *RPCMessage, db *gorm.DB, signer *Signer) (*RPCMessage, error) {
+func HandleCloseChannel(rpc *RPCMessage, db *gorm.DB, keys *BrokerKeys, settlementTTL time.Duration, sigCtx SigningContext) (*RPCMessage, error) {
 	if len(rpc.Req.Params) < 1 {
 		return nil, errors.New("missing parameters")
 	}
@@ -825,53 +1329,48 @@ func HandleCloseChannel(rpc *RPCMessage, db *gorm.DB, signer *Signer) (*RPCMessa
 	if err != nil {
 		return nil, fmt.Errorf("failed to find channel: %w", err)
 	}
+	if channel == nil {
+		return nil, fmt.Errorf("channel %s not found", params.ChannelID)
+	}
 
-	reqBytes, err := json.Marshal(rpc.Req)
+	// The channel keeps being signed with the broker key it was opened with
+	signer, err := keys.ForChannel(*channel)
 	if err != nil {
-		return nil, errors.New("error serializing message")
+		return nil, err
 	}
 
-	isValid, err := ValidateSignature(reqBytes, rpc.Sig[0], channel.Participant)
-	if err != nil || !isValid {
-		return nil, errors.New("invalid signature")
+	req := CloseChannelSignData{
+		RequestID: rpc.Req.RequestID,
+		Method:    rpc.Req.Method,
+		Params:    []CloseChannelParams{params},
+		Timestamp: rpc.Req.Timestamp,
 	}
 
-	asset, err := GetAssetByToken(db, channel.Token, channel.ChainID)
+	// Like resize_channel, the request is signed in the domain of the channel's chain
+	digest, err := sigCtx.WithChain(channel.ChainID).Digest(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to find asset: %w", err)
+		return nil, err
 	}
-	if asset == nil {
-		return nil, fmt.Errorf("asset not found: %s", channel.Token)
+
+	if len(rpc.Sig) == 0 {
+		return nil, errors.New("missing signature")
 	}
 
-	ledger := GetParticipantLedger(db, channel.Participant)
-	balance, err := ledger.Balance(channel.Participant, asset.Symbol)
+	sessionKey, err := verifyOwnerSignature(db, sigCtx.WithChain(channel.ChainID), digest, rpc.Sig[0], channel.Participant, rpc.Req.Method)
 	if err != nil {
-		return nil, fmt.Errorf("failed to check participant A balance: %w", err)
+		return nil, err
 	}
 
-	if balance.IsNegative() {
-		return nil, errors.New("insufficient funds for participant: " + channel.Token)
+	if channel.Status == ChannelStatusChallenged {
+		return nil, errors.New("channel is challenged")
 	}
 
-	rawBalance := balance.Shift(int32(asset.Decimals)).BigInt()
-
-	channelAmount := new(big.Int).SetUint64(channel.Amount)
-	if channelAmount.Cmp(rawBalance) < 0 {
-		return nil, errors.New("resize this channel first")
+	asset, err := GetLedgerAsset(db, channel.Token, channel.ChainID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find asset: %w", err)
 	}
-
-	allocations := []nitrolite.Allocation{
-		{
-			Destination: common.HexToAddress(params.FundsDestination),
-			Token:       common.HexToAddress(channel.Token),
-			Amount:      rawBalance,
-		},
-		{
-			Destination: signer.GetAddress(),
-			Token:       common.HexToAddress(channel.Token),
-			Amount:      new(big.Int).Sub(channelAmount, rawBalance), // Broker receives the remaining amount
-		},
+	if asset == nil {
+		return nil, fmt.Errorf("asset not found: %s", channel.Token)
 	}
 
 	stateDataStr := "0x"
@@ -880,16 +1379,78 @@ func HandleCloseChannel(rpc *RPCMessage, db *gorm.DB, signer *Signer) (*RPCMessa
 		return nil, fmt.Errorf("failed to decode state data: %w", err)
 	}
 
-	channelID := common.HexToHash(channel.ChannelID)
-	encodedState, err := nitrolite.EncodeState(channelID, nitrolite.IntentFINALIZE, big.NewInt(int64(channel.Version)+1), stateData, allocations)
-	if err != nil {
-		return nil, fmt.Errorf("failed to encode state hash: %w", err)
-	}
+	var allocations []nitrolite.Allocation
+	var stateHash string
+	var sig nitrolite.Signature
+	err = db.Transaction(func(tx *gorm.DB) error {
+		ledger := GetParticipantLedger(tx, channel.Participant)
+		balance, err := ledger.Balance(channel.Participant, asset.Symbol)
+		if err != nil {
+			return fmt.Errorf("failed to check participant A balance: %w", err)
+		}
 
-	stateHash := crypto.Keccak256Hash(encodedState).Hex()
-	sig, err := signer.NitroSign(encodedState)
+		// Funds locked for a previously signed state of this channel are still available to it
+		locked, err := GetLockedSettlement(tx, *channel, asset.Symbol)
+		if err != nil {
+			return fmt.Errorf("failed to check locked settlement: %w", err)
+		}
+		balance = balance.Add(locked)
+
+		if balance.IsNegative() {
+			return errors.New("insufficient funds for participant: " + channel.Token)
+		}
+
+		// A balance finer than the token decimals is paid out rounded down, the rest stays in the unified balance
+		rawBalance := asset.ToRaw(balance)
+		balance = asset.ToLedger(rawBalance)
+
+		channelAmount := new(big.Int).SetUint64(channel.Amount)
+		if channelAmount.Cmp(rawBalance) < 0 {
+			// Paying out the balance needs a resize funded by the broker on the channel's chain
+			return errors.New("resize this channel first")
+		}
+
+		allocations = []nitrolite.Allocation{
+			{
+				Destination: common.HexToAddress(params.FundsDestination),
+				Token:       common.HexToAddress(channel.Token),
+				Amount:      rawBalance,
+			},
+			{
+				Destination: signer.GetAddress(),
+				Token:       common.HexToAddress(channel.Token),
+				Amount:      new(big.Int).Sub(channelAmount, rawBalance), // Broker receives the remaining amount
+			},
+		}
+
+		channelID := common.HexToHash(channel.ChannelID)
+		encodedState, err := nitrolite.EncodeState(channelID, nitrolite.IntentFINALIZE, big.NewInt(int64(channel.Version)+1), stateData, allocations)
+		if err != nil {
+			return fmt.Errorf("failed to encode state hash: %w", err)
+		}
+
+		stateHash = crypto.Keccak256Hash(encodedState).Hex()
+		sig, err = signer.NitroSign(encodedState)
+		if err != nil {
+			return fmt.Errorf("failed to sign state: %w", err)
+		}
+
+		if _, err := SaveChannelState(tx, channel.ChannelID, nitrolite.IntentFINALIZE, channel.Version+1, stateData, allocations, sig, nil); err != nil {
+			return err
+		}
+
+		if sessionKey != nil {
+			// Funds already locked for this channel were accounted for when they were locked
+			if err := sessionKey.Spend(tx, asset.Symbol, balance.Sub(locked)); err != nil {
+				return err
+			}
+		}
+
+		// The participant's balance is settled by the final state, so it stays locked until the Closed event
+		return LockSettlement(tx, *channel, asset.Symbol, balance, channel.Version+1, time.Now().Add(settlementTTL))
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to sign state: %w", err)
+		return nil, err
 	}
 
 	response := CloseChannelResponse{
@@ -965,6 +1526,86 @@ func HandleGetChannels(rpc *RPCMessage, db *gorm.DB) (*RPCMessage, error) {
 	return rpcResponse, nil
 }
 
+// HandleGetChannelState returns the latest state of a channel of the authenticated participant signed by both the user and the broker
+func HandleGetChannelState(rpc *RPCMessage, address string, db *gorm.DB) (*RPCMessage, error) {
+	if len(rpc.Req.Params) < 1 {
+		return nil, errors.New("missing parameters")
+	}
+
+	var params GetChannelStateParams
+	paramsJSON, err := json.Marshal(rpc.Req.Params[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse parameters: %w", err)
+	}
+
+	if err := json.Unmarshal(paramsJSON, &params); err != nil {
+		return nil, fmt.Errorf("invalid parameters format: %w", err)
+	}
+
+	if params.ChannelID == "" {
+		return nil, errors.New("missing channel_id parameter")
+	}
+
+	channel, err := GetChannelByID(db, params.ChannelID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find channel: %w", err)
+	}
+	if channel == nil {
+		return nil, fmt.Errorf("channel not found: %s", params.ChannelID)
+	}
+	if !strings.EqualFold(channel.Participant, address) {
+		return nil, fmt.Errorf("channel %s does not belong to %s", params.ChannelID, address)
+	}
+
+	state, err := GetLatestSignedChannelState(db, params.ChannelID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get channel state: %w", err)
+	}
+	if state == nil {
+		return nil, fmt.Errorf("no signed state found for channel: %s", params.ChannelID)
+	}
+
+	nitroState, err := state.NitroState()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode channel state: %w", err)
+	}
+
+	encodedState, err := nitrolite.EncodeState(common.HexToHash(state.ChannelID), nitrolite.Intent(nitroState.Intent), nitroState.Version, nitroState.Data, nitroState.Allocations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode state hash: %w", err)
+	}
+
+	userSig, brokerSig := nitroState.Sigs[0], nitroState.Sigs[1]
+	response := ChannelStateResponse{
+		ChannelID: state.ChannelID,
+		Intent:    state.Intent,
+		Version:   state.Version,
+		StateData: state.StateData,
+		StateHash: crypto.Keccak256Hash(encodedState).Hex(),
+		UserSignature: Signature{
+			V: userSig.V,
+			R: hexutil.Encode(userSig.R[:]),
+			S: hexutil.Encode(userSig.S[:]),
+		},
+		Signature: Signature{
+			V: brokerSig.V,
+			R: hexutil.Encode(brokerSig.R[:]),
+			S: hexutil.Encode(brokerSig.S[:]),
+		},
+	}
+
+	for _, alloc := range nitroState.Allocations {
+		response.Allocations = append(response.Allocations, Allocation{
+			Participant:  alloc.Destination.Hex(),
+			TokenAddress: alloc.Token.Hex(),
+			Amount:       alloc.Amount,
+		})
+	}
+
+	rpcResponse := CreateResponse(rpc.Req.RequestID, rpc.Req.Method, []any{response}, time.Now())
+	return rpcResponse, nil
+}
+
 func HandleGetRPCHistory(participant string, rpc *RPCMessage, store *RPCStore) (*RPCMessage, error) {
 	if participant == "" {
 		return nil, errors.New("missing participant parameter")
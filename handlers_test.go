@@ -11,6 +11,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/erc7824/go-nitrolite"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/google/uuid"
@@ -36,7 +38,7 @@ func setupTestSqlite(t testing.TB) *gorm.DB {
 	require.NoError(t, err)
 
 	// Auto migrate all required models
-	err = db.AutoMigrate(&Entry{}, &Channel{}, &AppSession{}, &RPCRecord{}, &Asset{})
+	err = db.AutoMigrate(&Entry{}, &Channel{}, &AppSession{}, &RPCRecord{}, &Asset{}, &EventCursor{}, &ContractEvent{}, &ChannelState{}, &PendingSettlement{}, &SessionKey{}, &SessionKeyAllowance{}, &BrokerTransaction{}, &AssetGroup{})
 	require.NoError(t, err)
 
 	return db
@@ -77,7 +79,7 @@ func setupTestPostgres(ctx context.Context, t testing.TB) (*gorm.DB, testcontain
 	require.NoError(t, err)
 
 	// Auto migrate all required models
-	err = db.AutoMigrate(&Entry{}, &Channel{}, &AppSession{}, &RPCRecord{}, &Asset{})
+	err = db.AutoMigrate(&Entry{}, &Channel{}, &AppSession{}, &RPCRecord{}, &Asset{}, &EventCursor{}, &ContractEvent{}, &ChannelState{}, &PendingSettlement{}, &SessionKey{}, &SessionKeyAllowance{}, &BrokerTransaction{}, &AssetGroup{})
 	require.NoError(t, err)
 
 	return db, postgresContainer
@@ -207,7 +209,7 @@ func TestHandleCloseVirtualApp(t *testing.T) {
 	sig, _ := signer.Sign(signBytes)
 	req.Sig = []string{hexutil.Encode(sig)}
 
-	resp, err := HandleCloseApplication(req, db)
+	resp, err := HandleCloseApplication(req, db, SigningContext{Mode: SignatureModeRaw})
 	require.NoError(t, err)
 	assert.Equal(t, "close_app_session", resp.Res.Method)
 	var updated AppSession
@@ -295,7 +297,7 @@ func TestHandleCreateVirtualApp(t *testing.T) {
 	sigB, _ := signerB.Sign(signBytes)
 	rpcReq.Sig = []string{hexutil.Encode(sigA), hexutil.Encode(sigB)}
 
-	resp, err := HandleCreateApplication(rpcReq, db)
+	resp, err := HandleCreateApplication(rpcReq, db, SigningContext{Mode: SignatureModeRaw})
 	require.NoError(t, err)
 
 	// ► response sanity
@@ -322,6 +324,246 @@ func TestHandleCreateVirtualApp(t *testing.T) {
 	assert.Equal(t, decimal.NewFromInt(200).String(), vBalB.String())
 }
 
+// TestHandleSubmitAppState tests the intermediate app session state handler functionality
+func TestHandleSubmitAppState(t *testing.T) {
+	rawA, _ := crypto.GenerateKey()
+	rawB, _ := crypto.GenerateKey()
+	signerA := Signer{privateKey: rawA}
+	signerB := Signer{privateKey: rawB}
+	addrA := signerA.GetAddress().Hex()
+	addrB := signerB.GetAddress().Hex()
+
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	vAppID := "0xVApp456"
+	require.NoError(t, db.Create(&AppSession{
+		SessionID:    vAppID,
+		Participants: []string{addrA, addrB},
+		Status:       ChannelStatusOpen,
+		Challenge:    60,
+		Weights:      []int64{50, 50},
+		Quorum:       100,
+		Version:      1,
+	}).Error)
+
+	require.NoError(t, GetParticipantLedger(db, addrA).Record(vAppID, "usdc", decimal.NewFromInt(100)))
+	require.NoError(t, GetParticipantLedger(db, addrB).Record(vAppID, "usdc", decimal.NewFromInt(200)))
+
+	newSubmitRequest := func(requestID uint64, params SubmitAppStateParams, signers ...Signer) *RPCMessage {
+		rpcReq := &RPCMessage{
+			Req: &RPCData{
+				RequestID: requestID,
+				Method:    "submit_app_state",
+				Params:    []any{params},
+				Timestamp: uint64(time.Now().Unix()),
+			},
+		}
+
+		signData := SubmitAppStateSignData{
+			RequestID: rpcReq.Req.RequestID,
+			Method:    rpcReq.Req.Method,
+			Params:    []SubmitAppStateParams{params},
+			Timestamp: rpcReq.Req.Timestamp,
+		}
+		signBytes, err := signData.MarshalJSON()
+		require.NoError(t, err)
+		for _, s := range signers {
+			sig, err := s.Sign(signBytes)
+			require.NoError(t, err)
+			rpcReq.Sig = append(rpcReq.Sig, hexutil.Encode(sig))
+		}
+		return rpcReq
+	}
+
+	// Test Case 1: Rebalance with full quorum
+	firstState := SubmitAppStateParams{
+		AppSessionID: vAppID,
+		Version:      2,
+		Allocations: []AppAllocation{
+			{Participant: addrA, AssetSymbol: "usdc", Amount: decimal.NewFromInt(250)},
+			{Participant: addrB, AssetSymbol: "usdc", Amount: decimal.NewFromInt(50)},
+		},
+	}
+	resp, err := HandleSubmitAppState(newSubmitRequest(1, firstState, signerA, signerB), db, SigningContext{Mode: SignatureModeRaw})
+	require.NoError(t, err)
+	assert.Equal(t, "submit_app_state", resp.Res.Method)
+
+	appResp, ok := resp.Res.Params[0].(*AppSessionResponse)
+	require.True(t, ok)
+	assert.Equal(t, string(ChannelStatusOpen), appResp.Status)
+	assert.Equal(t, uint64(2), appResp.Version)
+
+	var vApp AppSession
+	require.NoError(t, db.Where("session_id = ?", vAppID).First(&vApp).Error)
+	assert.Equal(t, uint64(2), vApp.Version)
+	assert.Equal(t, ChannelStatusOpen, vApp.Status)
+
+	balA, _ := GetParticipantLedger(db, addrA).Balance(vAppID, "usdc")
+	balB, _ := GetParticipantLedger(db, addrB).Balance(vAppID, "usdc")
+	assert.Equal(t, decimal.NewFromInt(250).String(), balA.String())
+	assert.Equal(t, decimal.NewFromInt(50).String(), balB.String())
+
+	// Test Case 2: Quorum not met
+	_, err = HandleSubmitAppState(newSubmitRequest(2, SubmitAppStateParams{
+		AppSessionID: vAppID,
+		Version:      3,
+		Allocations: []AppAllocation{
+			{Participant: addrA, AssetSymbol: "usdc", Amount: decimal.NewFromInt(300)},
+			{Participant: addrB, AssetSymbol: "usdc", Amount: decimal.NewFromInt(0)},
+		},
+	}, signerA), db, SigningContext{Mode: SignatureModeRaw})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "quorum not met")
+
+	// Test Case 3: Totals must stay unchanged
+	_, err = HandleSubmitAppState(newSubmitRequest(3, SubmitAppStateParams{
+		AppSessionID: vAppID,
+		Version:      3,
+		Allocations: []AppAllocation{
+			{Participant: addrA, AssetSymbol: "usdc", Amount: decimal.NewFromInt(300)},
+			{Participant: addrB, AssetSymbol: "usdc", Amount: decimal.NewFromInt(50)},
+		},
+	}, signerA, signerB), db, SigningContext{Mode: SignatureModeRaw})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "does not match session balance")
+
+	// Test Case 4: A co-signed state cannot be replayed once the session moved past its version
+	_, err = HandleSubmitAppState(newSubmitRequest(4, firstState, signerA, signerB), db, SigningContext{Mode: SignatureModeRaw})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid app state version 2, expected 3")
+
+	// Test Case 5: More signatures than participants are rejected before any is checked
+	_, err = HandleSubmitAppState(newSubmitRequest(5, SubmitAppStateParams{
+		AppSessionID: vAppID,
+		Version:      3,
+		Allocations:  firstState.Allocations,
+	}, signerA, signerB, signerA), db, SigningContext{Mode: SignatureModeRaw})
+	assert.EqualError(t, err, "too many signatures: 3 for 2 participants")
+
+	// Failed submissions leave balances and version untouched
+	require.NoError(t, db.Where("session_id = ?", vAppID).First(&vApp).Error)
+	assert.Equal(t, uint64(2), vApp.Version)
+	balA, _ = GetParticipantLedger(db, addrA).Balance(vAppID, "usdc")
+	assert.Equal(t, decimal.NewFromInt(250).String(), balA.String())
+}
+
+// TestHandleTransfer tests the off-chain transfer handler functionality
+func TestHandleTransfer(t *testing.T) {
+	rawKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	signer := Signer{privateKey: rawKey}
+	senderAddr := signer.GetAddress().Hex()
+	recipientA := "0x1111111111111111111111111111111111111111"
+	recipientB := "0x2222222222222222222222222222222222222222"
+
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	require.NoError(t, GetParticipantLedger(db, senderAddr).Record(senderAddr, "usdc", decimal.NewFromInt(1000)))
+
+	newTransferRequest := func(requestID uint64, params TransferParams) *RPCMessage {
+		rpcReq := &RPCMessage{
+			Req: &RPCData{
+				RequestID: requestID,
+				Method:    "transfer",
+				Params:    []any{params},
+				Timestamp: uint64(time.Now().Unix()),
+			},
+		}
+
+		signData := TransferSignData{
+			RequestID: rpcReq.Req.RequestID,
+			Method:    rpcReq.Req.Method,
+			Params:    []TransferParams{params},
+			Timestamp: rpcReq.Req.Timestamp,
+		}
+		signBytes, err := signData.MarshalJSON()
+		require.NoError(t, err)
+		sig, err := signer.Sign(signBytes)
+		require.NoError(t, err)
+		rpcReq.Sig = []string{hexutil.Encode(sig)}
+		return rpcReq
+	}
+
+	// Test Case 1: Transfer to multiple destinations
+	resp, err := HandleTransfer(newTransferRequest(1, TransferParams{
+		Allocations: []TransferAllocation{
+			{Destination: recipientA, AssetSymbol: "usdc", Amount: decimal.NewFromInt(300)},
+			{Destination: recipientB, AssetSymbol: "usdc", Amount: decimal.NewFromInt(200)},
+		},
+	}), senderAddr, db, SigningContext{Mode: SignatureModeRaw})
+	require.NoError(t, err)
+	assert.Equal(t, "transfer", resp.Res.Method)
+
+	transferResp, ok := resp.Res.Params[0].(TransferResponse)
+	require.True(t, ok, "Response parameter should be a TransferResponse")
+	assert.Equal(t, senderAddr, transferResp.From)
+	require.Len(t, transferResp.Allocations, 2)
+
+	senderBalance, err := GetParticipantLedger(db, senderAddr).Balance(senderAddr, "usdc")
+	require.NoError(t, err)
+	assert.Equal(t, decimal.NewFromInt(500).String(), senderBalance.String())
+
+	balanceA, err := GetParticipantLedger(db, recipientA).Balance(recipientA, "usdc")
+	require.NoError(t, err)
+	assert.Equal(t, decimal.NewFromInt(300).String(), balanceA.String())
+
+	balanceB, err := GetParticipantLedger(db, recipientB).Balance(recipientB, "usdc")
+	require.NoError(t, err)
+	assert.Equal(t, decimal.NewFromInt(200).String(), balanceB.String())
+
+	// Test Case 2: Overdraft is rejected and nothing is recorded
+	_, err = HandleTransfer(newTransferRequest(2, TransferParams{
+		Allocations: []TransferAllocation{
+			{Destination: recipientA, AssetSymbol: "usdc", Amount: decimal.NewFromInt(400)},
+			{Destination: recipientB, AssetSymbol: "usdc", Amount: decimal.NewFromInt(200)},
+		},
+	}), senderAddr, db, SigningContext{Mode: SignatureModeRaw})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "insufficient funds")
+
+	senderBalance, err = GetParticipantLedger(db, senderAddr).Balance(senderAddr, "usdc")
+	require.NoError(t, err)
+	assert.Equal(t, decimal.NewFromInt(500).String(), senderBalance.String())
+
+	// Test Case 3: Signature from someone other than the sender is rejected
+	_, err = HandleTransfer(newTransferRequest(3, TransferParams{
+		Allocations: []TransferAllocation{
+			{Destination: recipientA, AssetSymbol: "usdc", Amount: decimal.NewFromInt(10)},
+		},
+	}), recipientB, db, SigningContext{Mode: SignatureModeRaw})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid signature")
+
+	// Test Case 4: Non-positive amounts are rejected
+	_, err = HandleTransfer(newTransferRequest(4, TransferParams{
+		Allocations: []TransferAllocation{
+			{Destination: recipientA, AssetSymbol: "usdc", Amount: decimal.NewFromInt(-10)},
+		},
+	}), senderAddr, db, SigningContext{Mode: SignatureModeRaw})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid allocation")
+
+	// Test Case 5: Transfer to self is rejected
+	_, err = HandleTransfer(newTransferRequest(5, TransferParams{
+		Allocations: []TransferAllocation{
+			{Destination: senderAddr, AssetSymbol: "usdc", Amount: decimal.NewFromInt(10)},
+		},
+	}), senderAddr, db, SigningContext{Mode: SignatureModeRaw})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot transfer to self")
+
+	// Test Case 6: Amounts finer than the decimals of the asset group are rejected
+	require.NoError(t, db.Create(&AssetGroup{Symbol: "usdc", Decimals: 6}).Error)
+	_, err = HandleTransfer(newTransferRequest(6, TransferParams{
+		Allocations: []TransferAllocation{
+			{Destination: recipientA, AssetSymbol: "usdc", Amount: decimal.RequireFromString("0.0000001")},
+		},
+	}), senderAddr, db, SigningContext{Mode: SignatureModeRaw})
+	assert.EqualError(t, err, "amount 0.0000001 of usdc has more than 6 decimals")
+}
+
 // TestHandleGetLedgerBalances tests the get ledger balances handler functionality
 func TestHandleGetLedgerBalances(t *testing.T) {
 	// Set up test database with cleanup
@@ -424,7 +666,7 @@ func TestHandleGetConfig(t *testing.T) {
 
 	signer := Signer{privateKey: raw}
 
-	response, err := HandleGetConfig(rpcRequest, mockConfig, &signer)
+	response, err := HandleGetConfig(rpcRequest, mockConfig, NewBrokerKeys(&signer, nil))
 	require.NoError(t, err)
 	assert.NotNil(t, response)
 
@@ -724,6 +966,161 @@ func TestHandleGetChannels(t *testing.T) {
 }
 
 // TestHandleGetAssets tests the get assets handler functionality
+func TestHandleGetChannelState(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	brokerKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	userKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	user := crypto.PubkeyToAddress(userKey.PublicKey).Hex()
+
+	channelID := common.HexToHash("0xc0ffee")
+	token := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	require.NoError(t, db.Create(&Channel{
+		ChannelID:   channelID.Hex(),
+		ChainID:     137,
+		Participant: user,
+		Token:       token.Hex(),
+		Status:      ChannelStatusOpen,
+	}).Error)
+	allocations := []nitrolite.Allocation{
+		{Destination: crypto.PubkeyToAddress(userKey.PublicKey), Token: token, Amount: big.NewInt(1000)},
+		{Destination: crypto.PubkeyToAddress(brokerKey.PublicKey), Token: token, Amount: big.NewInt(0)},
+	}
+	encoded, err := nitrolite.EncodeState(channelID, nitrolite.IntentRESIZE, big.NewInt(3), []byte{}, allocations)
+	require.NoError(t, err)
+	brokerSig, err := nitrolite.Sign(encoded, brokerKey)
+	require.NoError(t, err)
+	userSig, err := nitrolite.Sign(encoded, userKey)
+	require.NoError(t, err)
+
+	newRequest := func(channelID string) *RPCMessage {
+		return &RPCMessage{
+			Req: &RPCData{
+				RequestID: 1,
+				Method:    "get_channel_state",
+				Params:    []any{map[string]string{"channel_id": channelID}},
+				Timestamp: uint64(time.Now().Unix()),
+			},
+		}
+	}
+
+	// A state signed only by the broker is not returned
+	_, err = SaveChannelState(db, channelID.Hex(), nitrolite.IntentRESIZE, 3, []byte{}, allocations, brokerSig, nil)
+	require.NoError(t, err)
+	_, err = HandleGetChannelState(newRequest(channelID.Hex()), user, db)
+	require.Error(t, err)
+
+	// Once the user signature is known the state becomes available
+	require.NoError(t, SaveCounterSignedState(db, channelID.Hex(), nitrolite.State{
+		Intent:      uint8(nitrolite.IntentRESIZE),
+		Version:     big.NewInt(3),
+		Data:        []byte{},
+		Allocations: allocations,
+		Sigs:        []nitrolite.Signature{userSig, brokerSig},
+	}))
+
+	var count int64
+	require.NoError(t, db.Model(&ChannelState{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+
+	response, err := HandleGetChannelState(newRequest(channelID.Hex()), user, db)
+	require.NoError(t, err)
+	state, ok := response.Res.Params[0].(ChannelStateResponse)
+	require.True(t, ok)
+
+	assert.Equal(t, channelID.Hex(), state.ChannelID)
+	assert.Equal(t, uint64(3), state.Version)
+	assert.Equal(t, uint8(nitrolite.IntentRESIZE), state.Intent)
+	assert.Equal(t, crypto.Keccak256Hash(encoded).Hex(), state.StateHash)
+	assert.Equal(t, hexutil.Encode(userSig.R[:]), state.UserSignature.R)
+	assert.Equal(t, hexutil.Encode(brokerSig.R[:]), state.Signature.R)
+	require.Len(t, state.Allocations, 2)
+	assert.Equal(t, "1000", state.Allocations[0].Amount.String())
+
+	// Only the participant of the channel can read its state
+	_, err = HandleGetChannelState(newRequest(channelID.Hex()), "0x1111111111111111111111111111111111111111", db)
+	assert.EqualError(t, err, fmt.Sprintf("channel %s does not belong to 0x1111111111111111111111111111111111111111", channelID.Hex()))
+
+	_, err = HandleGetChannelState(newRequest("0xunknown"), user, db)
+	require.Error(t, err)
+}
+
+func TestHandleCloseChannelLocksFunds(t *testing.T) {
+	rawKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	signer := Signer{privateKey: rawKey}
+	participant := signer.GetAddress().Hex()
+
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	token := "0x2222222222222222222222222222222222222222"
+	require.NoError(t, db.Create(&Asset{Token: token, ChainID: 137, Symbol: "usdc", Decimals: 6}).Error)
+	channel := Channel{
+		ChannelID:   "0xChannelClose",
+		Participant: participant,
+		Status:      ChannelStatusOpen,
+		Token:       token,
+		ChainID:     137,
+		Amount:      2000000,
+		Adjudicator: "0xAdj",
+	}
+	require.NoError(t, db.Create(&channel).Error)
+
+	ledger := GetParticipantLedger(db, participant)
+	require.NoError(t, ledger.Record(participant, "usdc", decimal.NewFromInt(2)))
+
+	newRequest := func(id uint64) *RPCMessage {
+		rpcRequest := &RPCMessage{
+			Req: &RPCData{
+				RequestID: id,
+				Method:    "close_channel",
+				Params:    []any{CloseChannelParams{ChannelID: channel.ChannelID, FundsDestination: participant}},
+				Timestamp: uint64(time.Now().Unix()),
+			},
+		}
+		reqBytes, err := json.Marshal(rpcRequest.Req)
+		require.NoError(t, err)
+		signed, err := signer.Sign(reqBytes)
+		require.NoError(t, err)
+		rpcRequest.Sig = []string{hexutil.Encode(signed)}
+		return rpcRequest
+	}
+
+	response, err := HandleCloseChannel(newRequest(1), db, NewBrokerKeys(&signer, nil), time.Hour, SigningContext{Mode: SignatureModeRaw})
+	require.NoError(t, err)
+	closeResponse, ok := response.Res.Params[0].(CloseChannelResponse)
+	require.True(t, ok)
+	assert.Equal(t, "2000000", closeResponse.FinalAllocations[0].Amount.String())
+
+	// The balance settled by the final state can no longer be spent
+	balance, err := ledger.Balance(participant, "usdc")
+	require.NoError(t, err)
+	assert.True(t, balance.IsZero())
+
+	locked, err := GetLockedSettlement(db, channel, "usdc")
+	require.NoError(t, err)
+	assert.Equal(t, decimal.NewFromInt(2).String(), locked.String())
+
+	// Requesting the state again signs the same allocation without locking twice
+	response, err = HandleCloseChannel(newRequest(2), db, NewBrokerKeys(&signer, nil), time.Hour, SigningContext{Mode: SignatureModeRaw})
+	require.NoError(t, err)
+	closeResponse, ok = response.Res.Params[0].(CloseChannelResponse)
+	require.True(t, ok)
+	assert.Equal(t, "2000000", closeResponse.FinalAllocations[0].Amount.String())
+
+	locked, err = GetLockedSettlement(db, channel, "usdc")
+	require.NoError(t, err)
+	assert.Equal(t, decimal.NewFromInt(2).String(), locked.String())
+
+	var states int64
+	require.NoError(t, db.Model(&ChannelState{}).Where("channel_id = ?", channel.ChannelID).Count(&states).Error)
+	assert.Equal(t, int64(2), states)
+}
+
 func TestHandleGetAssets(t *testing.T) {
 	// Set up test database with cleanup
 	db, cleanup := setupTestDB(t)
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/erc7824/go-nitrolite"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// KeystoreSigner signs with a key from an encrypted Ethereum keystore file.
+// The key is unlocked inside the keystore once at startup and never exposed to the rest of the node.
+type KeystoreSigner struct {
+	keystore *keystore.KeyStore
+	account  accounts.Account
+}
+
+// NewKeystoreSigner opens the keystore file at path and unlocks its key with passphrase
+func NewKeystoreSigner(path, passphrase string) (*KeystoreSigner, error) {
+	if path == "" {
+		return nil, errors.New("BROKER_KEYSTORE_PATH is required for the keystore signer")
+	}
+
+	keyJSON, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keystore file: %w", err)
+	}
+	var keyFile struct {
+		Address string `json:"address"`
+	}
+	if err := json.Unmarshal(keyJSON, &keyFile); err != nil {
+		return nil, fmt.Errorf("invalid keystore file: %w", err)
+	}
+	if !common.IsHexAddress(keyFile.Address) {
+		return nil, errors.New("invalid keystore file: missing address")
+	}
+
+	// The keystore scans the directory of the file; the account is looked up by its address
+	ks := keystore.NewKeyStore(filepath.Dir(path), keystore.StandardScryptN, keystore.StandardScryptP)
+	account, err := ks.Find(accounts.Account{Address: common.HexToAddress(keyFile.Address)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find keystore account: %w", err)
+	}
+	if err := ks.Unlock(account, passphrase); err != nil {
+		return nil, fmt.Errorf("failed to unlock keystore: %w", err)
+	}
+
+	return &KeystoreSigner{keystore: ks, account: account}, nil
+}
+
+// Sign creates an ECDSA signature for the provided data
+func (s *KeystoreSigner) Sign(data []byte) ([]byte, error) {
+	return signKeccak(s.SignHash, data)
+}
+
+// SignHash creates an ECDSA signature of a digest
+func (s *KeystoreSigner) SignHash(hash []byte) ([]byte, error) {
+	return s.keystore.SignHash(s.account, hash)
+}
+
+// NitroSign creates a signature for the provided state in nitrolite.Signature format
+func (s *KeystoreSigner) NitroSign(encodedState []byte) (nitrolite.Signature, error) {
+	sig, err := s.Sign(encodedState)
+	if err != nil {
+		return nitrolite.Signature{}, fmt.Errorf("failed to sign encoded state: %w", err)
+	}
+	return toNitroSignature(sig), nil
+}
+
+// GetAddress returns the address of the keystore account
+func (s *KeystoreSigner) GetAddress() common.Address {
+	return s.account.Address
+}
+
+// NewTransactor returns transaction options signing with the keystore account
+func (s *KeystoreSigner) NewTransactor(chainID *big.Int) (*bind.TransactOpts, error) {
+	return bind.NewKeyStoreTransactorWithChainID(s.keystore, s.account, chainID)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// liquidityCallTimeout bounds the custody calls reading the broker liquidity
+const liquidityCallTimeout = 10 * time.Second
+
+// allocationLocks serializes the broker liquidity check of a resize with the reservation of its allocation
+// for every chain and token, so that concurrent resizes cannot allocate the same broker funds
+var allocationLocks sync.Map
+
+// lockAllocations locks the allocations of broker funds of a token on a chain and returns the unlock function
+func lockAllocations(chainID uint32, token string) func() {
+	key := fmt.Sprintf("%d:%s", chainID, common.HexToAddress(token).Hex())
+	mu, _ := allocationLocks.LoadOrStore(key, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// Liquidity reports the custody balances the broker can allocate to channels on each chain
+type Liquidity interface {
+	// ChainIDs returns the chains with a running custody client
+	ChainIDs() []uint32
+	// Available returns the available custody balance of a broker address for a token on a chain
+	Available(ctx context.Context, chainID uint32, broker, token common.Address) (*big.Int, error)
+}
+
+// brokerLiquidity reads the available custody balance of the broker key of a channel on the channel's chain.
+// It is read before the allocation lock is taken, so that a slow RPC endpoint does not hold up other resizes.
+func brokerLiquidity(liquidity Liquidity, channel Channel, broker common.Address) (*big.Int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), liquidityCallTimeout)
+	defer cancel()
+	available, err := liquidity.Available(ctx, channel.ChainID, broker, common.HexToAddress(channel.Token))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check broker liquidity on chain %d: %w", channel.ChainID, err)
+	}
+	return available, nil
+}
+
+// checkBrokerLiquidity rejects an allocation to a channel that the broker key of the channel cannot fund
+// from its available custody balance on the channel's chain, as read by brokerLiquidity. Unified balances
+// are deposited on any chain of the asset group, so a withdrawal on another chain is paid out of the broker's
+// funds there. Funds allocated to other channels of the chain and token by signed states that have not landed
+// yet are not available, nor are funds of pending treasury withdrawals of the broker key that the custody
+// balance does not reflect yet. The caller holds the lock of lockAllocations until the allocation is reserved.
+func checkBrokerLiquidity(db *gorm.DB, channel Channel, broker common.Address, asset *LedgerAsset, available, required *big.Int) error {
+	if required.Sign() <= 0 {
+		return nil
+	}
+
+	withdrawing, err := PendingWithdrawals(db, channel.ChainID, broker, channel.Token)
+	if err != nil {
+		return err
+	}
+
+	outstanding, err := OutstandingAllocations(db, channel.ChainID, channel.Token, channel.ChannelID)
+	if err != nil {
+		return err
+	}
+	available = new(big.Int).Sub(available, asset.ToRaw(outstanding))
+	available.Sub(available, withdrawing)
+	if available.Sign() < 0 {
+		available.SetInt64(0)
+	}
+
+	if available.Cmp(required) < 0 {
+		return fmt.Errorf("insufficient broker liquidity on chain %d: %s %s available, %s required",
+			channel.ChainID, asset.ToLedger(available), asset.Symbol, asset.ToLedger(required))
+	}
+	return nil
+}
+
+// LiquidityResponse is the broker liquidity of an asset on a chain
+type LiquidityResponse struct {
+	ChainID   uint32          `json:"chain_id"`
+	Token     string          `json:"token"`
+	Asset     string          `json:"asset"`
+	Available decimal.Decimal `json:"available"`
+}
+
+// HandleGetLiquidity returns the available custody balance of all broker keys for every asset of the running chains,
+// less the funds allocated by signed states that have not landed yet, optionally filtered by chain_id
+func HandleGetLiquidity(rpc *RPCMessage, db *gorm.DB, keys *BrokerKeys, liquidity Liquidity) (*RPCMessage, error) {
+	var params struct {
+		ChainID *uint32 `json:"chain_id,omitempty"`
+	}
+	if len(rpc.Req.Params) > 0 {
+		paramsJSON, err := json.Marshal(rpc.Req.Params[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse parameters: %w", err)
+		}
+		if err := json.Unmarshal(paramsJSON, &params); err != nil {
+			return nil, fmt.Errorf("invalid parameters format: %w", err)
+		}
+	}
+
+	chainIDs := liquidity.ChainIDs()
+	if params.ChainID != nil {
+		chainIDs = slices.DeleteFunc(chainIDs, func(chainID uint32) bool { return chainID != *params.ChainID })
+	}
+	slices.Sort(chainIDs)
+
+	ctx, cancel := context.WithTimeout(context.Background(), liquidityCallTimeout)
+	defer cancel()
+
+	response := make([]LiquidityResponse, 0)
+	for _, chainID := range chainIDs {
+		assets, err := GetAllAssets(db, &chainID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve assets: %w", err)
+		}
+
+		for _, asset := range assets {
+			total := new(big.Int)
+			var failed bool
+			for _, signer := range keys.All() {
+				available, err := liquidity.Available(ctx, chainID, signer.GetAddress(), common.HexToAddress(asset.Token))
+				if err != nil {
+					log.Printf("Error reading broker liquidity of %s on chain %d: %v", asset.Token, chainID, err)
+					failed = true
+					break
+				}
+				total.Add(total, available)
+			}
+			if failed {
+				continue
+			}
+
+			// Funds allocated by signed states that have not landed yet cannot be allocated again
+			outstanding, err := OutstandingAllocations(db, chainID, asset.Token, "")
+			if err != nil {
+				return nil, err
+			}
+			available := decimal.NewFromBigInt(total, -int32(asset.Decimals)).Sub(outstanding)
+			if available.IsNegative() {
+				available = decimal.Zero
+			}
+
+			response = append(response, LiquidityResponse{
+				ChainID:   chainID,
+				Token:     asset.Token,
+				Asset:     asset.Symbol,
+				Available: available,
+			})
+		}
+	}
+
+	rpcResponse := CreateResponse(rpc.Req.RequestID, rpc.Req.Method, []any{response}, time.Now())
+	return rpcResponse, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLiquidity holds the available custody balance of every broker and token per chain
+type fakeLiquidity map[uint32]map[common.Address]*big.Int
+
+func (l fakeLiquidity) ChainIDs() []uint32 {
+	chainIDs := make([]uint32, 0, len(l))
+	for chainID := range l {
+		chainIDs = append(chainIDs, chainID)
+	}
+	return chainIDs
+}
+
+func (l fakeLiquidity) Available(ctx context.Context, chainID uint32, broker, token common.Address) (*big.Int, error) {
+	balances, ok := l[chainID]
+	if !ok {
+		return nil, fmt.Errorf("no network is running for chain %d", chainID)
+	}
+	if available, ok := balances[broker]; ok {
+		return available, nil
+	}
+	return big.NewInt(0), nil
+}
+
+func TestHandleResizeChannelBrokerLiquidity(t *testing.T) {
+	rawKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	signer := Signer{privateKey: rawKey}
+	participant := signer.GetAddress().Hex()
+
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	token := "0x2222222222222222222222222222222222222222"
+	require.NoError(t, db.Create(&Asset{Token: token, ChainID: 137, Symbol: "usdc", Decimals: 6}).Error)
+	channel := Channel{
+		ChannelID:   "0xChannelLiquidity",
+		Participant: participant,
+		Status:      ChannelStatusOpen,
+		Token:       token,
+		ChainID:     137,
+		Adjudicator: "0xAdj",
+	}
+	require.NoError(t, db.Create(&channel).Error)
+
+	ledger := GetParticipantLedger(db, participant)
+	require.NoError(t, ledger.Record(participant, "usdc", decimal.NewFromInt(10)))
+
+	newRequest := func(requestID uint64, channelID string) *RPCMessage {
+		params := ResizeChannelParams{ChannelID: channelID, AllocateAmount: big.NewInt(5000000), FundsDestination: participant}
+		timestamp := uint64(time.Now().Unix())
+		reqBytes, err := json.Marshal(ResizeChannelSignData{RequestID: requestID, Method: "resize_channel", Params: []ResizeChannelParams{params}, Timestamp: timestamp})
+		require.NoError(t, err)
+		signed, err := signer.Sign(reqBytes)
+		require.NoError(t, err)
+		return &RPCMessage{
+			Req: &RPCData{RequestID: requestID, Method: "resize_channel", Params: []any{params}, Timestamp: timestamp},
+			Sig: []string{hexutil.Encode(signed)},
+		}
+	}
+
+	keys := NewBrokerKeys(&signer, nil)
+	liquidity := fakeLiquidity{137: {signer.GetAddress(): big.NewInt(2000000)}}
+
+	// The broker cannot deposit more than its available custody balance on the channel's chain
+	_, err = HandleResizeChannel(newRequest(1, channel.ChannelID), db, keys, liquidity, time.Hour, SigningContext{Mode: SignatureModeRaw})
+	assert.EqualError(t, err, "insufficient broker liquidity on chain 137: 2 usdc available, 5 required")
+
+	_, err = HandleResizeChannel(newRequest(2, channel.ChannelID), db, keys, fakeLiquidity{}, time.Hour, SigningContext{Mode: SignatureModeRaw})
+	assert.EqualError(t, err, "failed to check broker liquidity on chain 137: no network is running for chain 137")
+
+	liquidity[137][signer.GetAddress()] = big.NewInt(5000000)
+	response, err := HandleResizeChannel(newRequest(3, channel.ChannelID), db, keys, liquidity, time.Hour, SigningContext{Mode: SignatureModeRaw})
+	require.NoError(t, err)
+	resizeResponse, ok := response.Res.Params[0].(ResizeChannelResponse)
+	require.True(t, ok)
+	assert.Equal(t, "5000000", resizeResponse.Allocations[0].Amount.String())
+
+	// The allocation is reserved until the state lands, so another channel cannot be funded from the same liquidity
+	other := channel
+	other.ChannelID = "0xOtherChannelLiquidity"
+	require.NoError(t, db.Create(&other).Error)
+	_, err = HandleResizeChannel(newRequest(4, other.ChannelID), db, keys, liquidity, time.Hour, SigningContext{Mode: SignatureModeRaw})
+	assert.EqualError(t, err, "insufficient broker liquidity on chain 137: 0 usdc available, 5 required")
+
+	// Signing a new state for the same channel replaces its own reservation
+	_, err = HandleResizeChannel(newRequest(5, channel.ChannelID), db, keys, liquidity, time.Hour, SigningContext{Mode: SignatureModeRaw})
+	require.NoError(t, err)
+
+	_, _, err = ReleaseSettlement(db, channel.ChannelID)
+	require.NoError(t, err)
+	_, err = HandleResizeChannel(newRequest(6, other.ChannelID), db, keys, liquidity, time.Hour, SigningContext{Mode: SignatureModeRaw})
+	require.NoError(t, err)
+}
+
+func TestHandleGetLiquidity(t *testing.T) {
+	current := newTestSigner(t)
+	previous := newTestSigner(t)
+
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	require.NoError(t, db.Create(&Asset{Token: "0x2222222222222222222222222222222222222222", ChainID: 137, Symbol: "usdc", Decimals: 6}).Error)
+	require.NoError(t, db.Create(&Asset{Token: "0x3333333333333333333333333333333333333333", ChainID: 56, Symbol: "usdc", Decimals: 18}).Error)
+	require.NoError(t, db.Create(&Asset{Token: "0x4444444444444444444444444444444444444444", ChainID: 1, Symbol: "usdc", Decimals: 6}).Error)
+
+	// Chain 1 is not running, the balances of all broker keys are added up
+	liquidity := fakeLiquidity{
+		137: {current.GetAddress(): big.NewInt(1500000), previous.GetAddress(): big.NewInt(500000)},
+		56:  {current.GetAddress(): new(big.Int).Mul(big.NewInt(3), big.NewInt(1e18))},
+	}
+
+	rpcRequest := &RPCMessage{Req: &RPCData{RequestID: 1, Method: "get_liquidity", Timestamp: uint64(time.Now().Unix())}}
+	response, err := HandleGetLiquidity(rpcRequest, db, NewBrokerKeys(current, previous), liquidity)
+	require.NoError(t, err)
+
+	liquidityResponse, ok := response.Res.Params[0].([]LiquidityResponse)
+	require.True(t, ok)
+	require.Len(t, liquidityResponse, 2)
+	assert.Equal(t, uint32(56), liquidityResponse[0].ChainID)
+	assert.Equal(t, "3", liquidityResponse[0].Available.String())
+	assert.Equal(t, uint32(137), liquidityResponse[1].ChainID)
+	assert.Equal(t, "usdc", liquidityResponse[1].Asset)
+	assert.Equal(t, "2", liquidityResponse[1].Available.String())
+
+	rpcRequest = &RPCMessage{Req: &RPCData{RequestID: 2, Method: "get_liquidity", Params: []any{map[string]any{"chain_id": 56}}, Timestamp: uint64(time.Now().Unix())}}
+	response, err = HandleGetLiquidity(rpcRequest, db, NewBrokerKeys(current, previous), liquidity)
+	require.NoError(t, err)
+	liquidityResponse, ok = response.Res.Params[0].([]LiquidityResponse)
+	require.True(t, ok)
+	require.Len(t, liquidityResponse, 1)
+	assert.Equal(t, "0x3333333333333333333333333333333333333333", liquidityResponse[0].Token)
+}
+
+func TestLockAllocations(t *testing.T) {
+	token := "0x2222222222222222222222222222222222222222"
+	unlock := lockAllocations(137, token)
+
+	locked := func(chainID uint32, token string) bool {
+		acquired := make(chan func())
+		go func() { acquired <- lockAllocations(chainID, token) }()
+		select {
+		case unlock := <-acquired:
+			unlock()
+			return false
+		case <-time.After(50 * time.Millisecond):
+			go func() { (<-acquired)() }()
+			return true
+		}
+	}
+
+	// Allocations of other chains and tokens are not held up
+	assert.False(t, locked(56, token))
+	assert.False(t, locked(137, "0x3333333333333333333333333333333333333333"))
+	assert.True(t, locked(137, "0x2222222222222222222222222222222222222222"))
+
+	unlock()
+	assert.False(t, locked(56, token))
+}
+
+func TestCheckBrokerLiquidityPendingWithdrawal(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	broker := newTestSigner(t).GetAddress()
+	token := "0x2222222222222222222222222222222222222222"
+	asset := &LedgerAsset{Asset: Asset{Token: token, ChainID: 137, Symbol: "usdc", Decimals: 6}}
+	channel := Channel{ChannelID: "0xChannelWithdrawing", Token: token, ChainID: 137}
+
+	data, err := custodyAbi.Pack("withdraw", common.HexToAddress(token), big.NewInt(3000000))
+	require.NoError(t, err)
+	withdrawal := BrokerTransaction{ChainID: 137, Kind: TxKindWithdraw, From: broker.Hex(), To: "0x00000000000000000000000000000000000c0de1", Data: data, Status: TxStatusPending, TxHash: "0x01"}
+	require.NoError(t, db.Create(&withdrawal).Error)
+
+	// A pending withdrawal is not reflected in the custody balance yet, but cannot be allocated
+	err = checkBrokerLiquidity(db, channel, broker, asset, big.NewInt(5000000), big.NewInt(5000000))
+	assert.EqualError(t, err, "insufficient broker liquidity on chain 137: 2 usdc available, 5 required")
+
+	require.NoError(t, db.Model(&withdrawal).Update("status", TxStatusConfirmed).Error)
+	assert.NoError(t, checkBrokerLiquidity(db, channel, broker, asset, big.NewInt(5000000), big.NewInt(5000000)))
+}
@@ -27,30 +27,49 @@ func main() {
 		log.Fatalf("Failed to setup database: %v", err)
 	}
 
-	signer, err := NewSigner(config.privateKeyHex)
+	brokerKeys, err := LoadBrokerKeys(config.signerConf)
 	if err != nil {
 		log.Fatalf("failed to initialise signer: %v", err)
 	}
+	if err := AssignLegacyChannelKeys(db, brokerKeys); err != nil {
+		log.Fatalf("Failed to assign broker keys: %v", err)
+	}
 	rpcStore := NewRPCStore(db)
 
 	// Initialize Prometheus metrics
 	metrics := NewMetrics()
-	// Map to store custody clients for later reference
-	custodyClients := make(map[string]*Custody)
-
-	go metrics.RecordMetricsPeriodically(db, custodyClients)
 
-	unifiedWSHandler := NewUnifiedWSHandler(signer, db, metrics, rpcStore, config)
-	http.HandleFunc("/ws", unifiedWSHandler.HandleConnection)
+	// Contract wallet signatures are verified through the custody clients of each chain
+	contractWallets := NewContractWallets(config.contractSignatureCacheTTL)
 
-	for name, network := range config.networks {
-		client, err := NewCustody(signer, db, unifiedWSHandler.sendBalanceUpdate, unifiedWSHandler.sendChannelUpdate, network.InfuraURL, network.CustodyAddress, network.ChainID)
+	// Custody clients are started for the configured networks and follow changes of the config file.
+	// They notify the WebSocket handler, which reads the broker liquidity from them.
+	var unifiedWSHandler *UnifiedWSHandler
+	networks := NewNetworkManager(db, metrics, contractWallets, func(ctx context.Context, network *NetworkConfig) (*Custody, error) {
+		client, err := NewCustody(ctx, brokerKeys, db, metrics, unifiedWSHandler.sendBalanceUpdate, unifiedWSHandler.sendChannelUpdate, network, config.txConf)
 		if err != nil {
-			log.Printf("Warning: Failed to initialize %s blockchain client: %v", name, err)
-			continue
+			return nil, err
 		}
-		custodyClients[name] = client
-		go client.ListenEvents(context.Background())
+		go client.ListenEvents(ctx)
+		go client.txManager.MonitorPending(ctx)
+		return client, nil
+	})
+
+	unifiedWSHandler = NewUnifiedWSHandler(brokerKeys, db, metrics, rpcStore, config, contractWallets, networks)
+	http.HandleFunc("/ws", unifiedWSHandler.HandleConnection)
+
+	go ExpireSettlementsPeriodically(db, metrics, unifiedWSHandler.sendBalanceUpdate)
+
+	networks.Apply(config.networks)
+	go networks.WatchConfig(context.Background(), config.configReloadInterval)
+
+	go metrics.RecordMetricsPeriodically(db, networks)
+
+	// The treasury keeps the broker liquidity of each chain within the targets of its assets
+	var treasury *Treasury
+	if config.treasuryInterval > 0 {
+		treasury = NewTreasury(db, brokerKeys, metrics, networks.TreasuryChains, config.treasuryAutoExecute)
+		go treasury.Run(context.Background(), config.treasuryInterval)
 	}
 
 	// Set up a separate mux for metrics
@@ -59,20 +78,37 @@ func main() {
 
 	// Start metrics server on a separate port
 	metricsServer := &http.Server{
-		Addr:    ":4242",
+		Addr:    config.metricsAddr,
 		Handler: metricsMux,
 	}
 	go func() {
-		log.Printf("Prometheus metrics available at http://localhost:4242/metrics")
+		log.Printf("Prometheus metrics available at %s/metrics", config.metricsAddr)
 		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Printf("Error starting metrics server: %v", err)
 		}
 	}()
 
+	// Start the admin API server, only reachable with the admin token
+	var adminServer *http.Server
+	if config.adminToken != "" {
+		adminServer = &http.Server{
+			Addr:    config.adminAddr,
+			Handler: NewAdminAPI(db, config.adminToken, networks.ContractCaller, treasury).Handler(),
+		}
+		go func() {
+			log.Printf("Admin API available at %s", config.adminAddr)
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("Error starting admin server: %v", err)
+			}
+		}()
+	} else {
+		log.Println("Admin API disabled, ADMIN_API_TOKEN is not set")
+	}
+
 	// Start the main HTTP server.
 	go func() {
-		log.Printf("Starting server, visit http://localhost:8000")
-		if err := http.ListenAndServe(":8000", nil); err != nil {
+		log.Printf("Starting server on %s", config.listenAddr)
+		if err := http.ListenAndServe(config.listenAddr, nil); err != nil {
 			log.Fatal(err)
 		}
 	}()
@@ -90,7 +126,13 @@ func main() {
 	if err := metricsServer.Shutdown(ctx); err != nil {
 		log.Printf("Error shutting down metrics server: %v", err)
 	}
+	if adminServer != nil {
+		if err := adminServer.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down admin server: %v", err)
+		}
+	}
 
 	unifiedWSHandler.CloseAllConnections()
+	networks.Stop()
 	log.Println("Server stopped")
 }
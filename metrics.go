@@ -18,6 +18,11 @@ type Metrics struct {
 	MessageReceived  prometheus.Counter
 	MessageSent      prometheus.Counter
 
+	// WebSocket outbound queue metrics
+	OutboundQueueDepth      prometheus.Gauge
+	SlowConsumerDisconnects prometheus.Counter
+	IdleConnectionsReaped   prometheus.Counter
+
 	// Authentication metrics
 	AuthRequests prometheus.Counter
 	AuthSuccess  prometheus.Counter
@@ -37,6 +42,28 @@ type Metrics struct {
 	// Smart contract metrics
 	BrokerBalanceAvailable *prometheus.GaugeVec
 	BrokerChannelCount     *prometheus.GaugeVec
+
+	// Blockchain event metrics
+	ReorgedEvents *prometheus.CounterVec
+
+	// Settlement metrics
+	OverdueSettlements prometheus.Gauge
+
+	// Broker transaction metrics
+	BrokerTransactions *prometheus.CounterVec
+	BrokerTxFeeCap     *prometheus.GaugeVec
+	BrokerTxTipCap     *prometheus.GaugeVec
+	BrokerTxFeesSpent  *prometheus.CounterVec
+	BrokerTxGasUsed    *prometheus.CounterVec
+
+	// Configuration reload metrics
+	ConfigReloads  *prometheus.CounterVec
+	NetworkChanges *prometheus.CounterVec
+	ActiveNetworks prometheus.Gauge
+
+	// Treasury metrics
+	TreasuryAlerts     *prometheus.GaugeVec
+	TreasuryRebalances *prometheus.CounterVec
 }
 
 // NewMetrics initializes and registers Prometheus metrics
@@ -58,6 +85,18 @@ func NewMetrics() *Metrics {
 			Name: "clearnet_ws_messages_sent_total",
 			Help: "The total number of WebSocket messages sent",
 		}),
+		OutboundQueueDepth: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: "clearnet_ws_outbound_queue_depth",
+			Help: "The number of messages waiting in WebSocket outbound queues",
+		}),
+		SlowConsumerDisconnects: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "clearnet_ws_slow_consumer_disconnects_total",
+			Help: "The total number of WebSocket connections closed because their outbound queue stayed full",
+		}),
+		IdleConnectionsReaped: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "clearnet_ws_idle_connections_reaped_total",
+			Help: "The total number of WebSocket connections closed because the peer stopped answering pings",
+		}),
 		AuthRequests: promauto.NewCounter(prometheus.CounterOpts{
 			Name: "clearnet_auth_requests_total",
 			Help: "The total number of authentication requests",
@@ -107,12 +146,90 @@ func NewMetrics() *Metrics {
 			},
 			[]string{"network", "token"},
 		),
+		ReorgedEvents: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "clearnet_reorged_events_total",
+				Help: "The total number of custody contract logs removed by chain reorgs",
+			},
+			[]string{"network", "stage"},
+		),
+		OverdueSettlements: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: "clearnet_overdue_settlements",
+			Help: "The current number of expired settlement locks kept because their signed state can still land on-chain",
+		}),
+		BrokerTransactions: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "clearnet_broker_transactions_total",
+				Help: "The total number of finished broker transactions by kind and status",
+			},
+			[]string{"network", "kind", "status"},
+		),
+		BrokerTxFeeCap: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "clearnet_broker_tx_fee_cap_gwei",
+				Help: "Gas price, or max fee per gas, of the last broker transaction sent in gwei",
+			},
+			[]string{"network"},
+		),
+		BrokerTxTipCap: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "clearnet_broker_tx_tip_cap_gwei",
+				Help: "Max priority fee per gas of the last EIP-1559 broker transaction sent in gwei",
+			},
+			[]string{"network"},
+		),
+		BrokerTxFeesSpent: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "clearnet_broker_tx_fees_spent_gwei_total",
+				Help: "The total fees paid by mined broker transactions in gwei",
+			},
+			[]string{"network"},
+		),
+		BrokerTxGasUsed: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "clearnet_broker_tx_gas_used_total",
+				Help: "The total gas used by mined broker transactions",
+			},
+			[]string{"network"},
+		),
+		ConfigReloads: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "clearnet_config_reloads_total",
+				Help: "The total number of configuration reloads by result",
+			},
+			[]string{"result"},
+		),
+		NetworkChanges: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "clearnet_network_changes_total",
+				Help: "The total number of networks started, stopped, restarted or failed to start by configuration loads",
+			},
+			[]string{"network", "action"},
+		),
+		ActiveNetworks: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: "clearnet_active_networks",
+			Help: "The current number of networks with a running custody client",
+		}),
+		TreasuryAlerts: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "clearnet_treasury_alert",
+				Help: "Whether the broker liquidity of a token is below its minimum or above its maximum target",
+			},
+			[]string{"network", "token", "alert"},
+		),
+		TreasuryRebalances: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "clearnet_treasury_rebalances_total",
+				Help: "The total number of rebalancing transactions sent by the treasury by action and result",
+			},
+			[]string{"network", "token", "action", "result"},
+		),
 	}
 
 	return metrics
 }
 
-func (m *Metrics) RecordMetricsPeriodically(db *gorm.DB, custodyClients map[string]*Custody) {
+func (m *Metrics) RecordMetricsPeriodically(db *gorm.DB, networks *NetworkManager) {
 	dbTicker := time.NewTicker(15 * time.Second)
 	defer dbTicker.Stop()
 
@@ -128,7 +245,7 @@ func (m *Metrics) RecordMetricsPeriodically(db *gorm.DB, custodyClients map[stri
 			monitoredTokens := GetUniqueTokenAddresses(db)
 
 			// Update metrics for each custody client
-			for _, client := range custodyClients {
+			for _, client := range networks.Clients() {
 				client.UpdateBalanceMetrics(context.Background(), monitoredTokens, m)
 			}
 		}
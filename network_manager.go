@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"gorm.io/gorm"
+)
+
+// NetworkManager runs a custody client for every configured network. Applying a new set of networks
+// starts the added ones, stops the removed ones and restarts the ones whose settings changed,
+// so networks are added or removed without a restart dropping the WebSocket sessions.
+type NetworkManager struct {
+	// applyMu serializes changes of the networks, which connect to RPC endpoints, while mu only guards
+	// the running networks so that their readers are never blocked by a slow endpoint
+	applyMu         sync.Mutex
+	mu              sync.RWMutex
+	db              *gorm.DB
+	metrics         *Metrics
+	contractWallets *ContractWallets
+	running         map[string]*runningNetwork
+	desired         map[string]*NetworkConfig // guarded by applyMu
+
+	// start connects to a network and starts its background work, which stops when ctx is cancelled
+	start func(ctx context.Context, network *NetworkConfig) (*Custody, error)
+}
+
+// runningNetwork is a started custody client with the configuration it was started with
+type runningNetwork struct {
+	network *NetworkConfig
+	custody *Custody
+	cancel  context.CancelFunc
+}
+
+// NewNetworkManager creates a manager without networks, starting custody clients with start
+func NewNetworkManager(db *gorm.DB, metrics *Metrics, contractWallets *ContractWallets, start func(ctx context.Context, network *NetworkConfig) (*Custody, error)) *NetworkManager {
+	return &NetworkManager{
+		db:              db,
+		metrics:         metrics,
+		contractWallets: contractWallets,
+		running:         make(map[string]*runningNetwork),
+		desired:         make(map[string]*NetworkConfig),
+		start:           start,
+	}
+}
+
+// Clients returns the custody clients of the running networks
+func (m *NetworkManager) Clients() []*Custody {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	clients := make([]*Custody, 0, len(m.running))
+	for _, running := range m.running {
+		clients = append(clients, running.custody)
+	}
+	return clients
+}
+
+// ContractCaller returns the RPC client of the running network of a chain, or nil
+func (m *NetworkManager) ContractCaller(chainID uint32) bind.ContractCaller {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, running := range m.running {
+		if running.custody.chainID == chainID && running.custody.client != nil {
+			return running.custody.client
+		}
+	}
+	return nil
+}
+
+// ChainIDs returns the chains of the running networks
+func (m *NetworkManager) ChainIDs() []uint32 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	chainIDs := make([]uint32, 0, len(m.running))
+	for _, running := range m.running {
+		chainIDs = append(chainIDs, running.custody.chainID)
+	}
+	return chainIDs
+}
+
+// Available returns the available custody balance of a broker address for a token on a chain
+func (m *NetworkManager) Available(ctx context.Context, chainID uint32, broker, token common.Address) (*big.Int, error) {
+	var custody *Custody
+	m.mu.RLock()
+	for _, running := range m.running {
+		if running.custody.chainID == chainID {
+			custody = running.custody
+			break
+		}
+	}
+	m.mu.RUnlock()
+
+	if custody == nil {
+		return nil, fmt.Errorf("no network is running for chain %d", chainID)
+	}
+	return custody.AvailableBalance(ctx, broker, token)
+}
+
+// TreasuryChains returns the running networks with the assets the treasury keeps liquid
+func (m *NetworkManager) TreasuryChains() []TreasuryChain {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	chains := make([]TreasuryChain, 0, len(m.running))
+	for _, running := range m.running {
+		if running.custody.client == nil {
+			continue
+		}
+		chains = append(chains, TreasuryChain{
+			ChainID:   running.custody.chainID,
+			Custody:   running.custody.custodyAddr,
+			Backend:   running.custody.client,
+			TxManager: running.custody.txManager,
+			Assets:    running.network.Assets,
+		})
+	}
+	return chains
+}
+
+// Apply makes the running networks match the given networks and adds their configured assets.
+// A network that fails to start is retried by RetryFailed.
+func (m *NetworkManager) Apply(networks map[string]*NetworkConfig) {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+
+	m.desired = networks
+
+	m.mu.Lock()
+	restarted := make(map[string]bool)
+	for name, running := range m.running {
+		network, ok := networks[name]
+		if ok && sameNetwork(running.network, network) {
+			// Assets do not need a restart of the custody client
+			running.network = network
+			continue
+		}
+
+		m.stop(name)
+		if ok {
+			restarted[name] = true
+		} else {
+			log.Printf("Stopped network %s", name)
+			m.metrics.NetworkChanges.WithLabelValues(name, "stopped").Inc()
+		}
+	}
+	m.mu.Unlock()
+
+	for name, network := range networks {
+		if err := UpsertAssets(m.db, network.ChainID, network.Assets); err != nil {
+			log.Printf("Error adding assets of network %s: %v", name, err)
+		}
+		if m.isRunning(name) {
+			continue
+		}
+
+		if err := m.startNetwork(name, network); err != nil {
+			log.Printf("Warning: Failed to initialize %s blockchain client: %v", name, err)
+			m.metrics.NetworkChanges.WithLabelValues(name, "failed").Inc()
+			continue
+		}
+		if restarted[name] {
+			log.Printf("Restarted network %s with its new configuration", name)
+			m.metrics.NetworkChanges.WithLabelValues(name, "restarted").Inc()
+		} else {
+			log.Printf("Started network %s", name)
+			m.metrics.NetworkChanges.WithLabelValues(name, "started").Inc()
+		}
+	}
+
+	m.metrics.ActiveNetworks.Set(float64(len(m.Clients())))
+}
+
+// RetryFailed starts the configured networks that failed to start, such as networks with an unreachable RPC endpoint
+func (m *NetworkManager) RetryFailed() {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+
+	for name, network := range m.desired {
+		if m.isRunning(name) {
+			continue
+		}
+		if err := m.startNetwork(name, network); err != nil {
+			log.Printf("Warning: Failed to initialize %s blockchain client: %v", name, err)
+			m.metrics.NetworkChanges.WithLabelValues(name, "failed").Inc()
+			continue
+		}
+		log.Printf("Started network %s", name)
+		m.metrics.NetworkChanges.WithLabelValues(name, "started").Inc()
+	}
+
+	m.metrics.ActiveNetworks.Set(float64(len(m.Clients())))
+}
+
+// Stop stops every running network
+func (m *NetworkManager) Stop() {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for name := range m.running {
+		m.stop(name)
+	}
+	m.metrics.ActiveNetworks.Set(0)
+}
+
+// isRunning reports whether the named network has a running custody client
+func (m *NetworkManager) isRunning(name string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.running[name]
+	return ok
+}
+
+// startNetwork starts the custody client of a network and registers its chain for contract wallet checks.
+// The client connects without holding mu, which is only taken to add it to the running networks.
+// It must be called with applyMu held.
+func (m *NetworkManager) startNetwork(name string, network *NetworkConfig) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	custody, err := m.start(ctx, network)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	m.mu.Lock()
+	m.running[name] = &runningNetwork{network: network, custody: custody, cancel: cancel}
+	m.mu.Unlock()
+	if custody.client != nil {
+		m.contractWallets.AddChain(custody.chainID, custody.client)
+	}
+	return nil
+}
+
+// stop cancels the background work of a running network and closes its RPC connection. It must be called with mu held.
+func (m *NetworkManager) stop(name string) {
+	running := m.running[name]
+	delete(m.running, name)
+
+	running.cancel()
+	m.contractWallets.RemoveChain(running.custody.chainID)
+	if running.custody.client != nil {
+		running.custody.client.Close()
+	}
+}
+
+// sameNetwork reports whether two configurations of a network run the same custody client
+func sameNetwork(a, b *NetworkConfig) bool {
+	x, y := *a, *b
+	x.Assets, y.Assets = nil, nil
+	return reflect.DeepEqual(x, y)
+}
+
+// WatchConfig reloads the networks when the config file changes, checked every interval, or on SIGHUP.
+// Networks that failed to start are retried at the same interval. An invalid configuration is
+// logged and the running networks are kept.
+func (m *NetworkManager) WatchConfig(ctx context.Context, interval time.Duration) {
+	configFile := os.Getenv("CLEARNODE_CONFIG_FILE")
+	lastHash := fileHash(configFile)
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	// A nil channel never fires, so only SIGHUP reloads without an interval
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			log.Println("Received SIGHUP, reloading networks")
+			lastHash = fileHash(configFile)
+			m.Reload()
+		case <-tick:
+			if hash := fileHash(configFile); !bytes.Equal(hash, lastHash) {
+				log.Printf("Config file %s changed, reloading networks", configFile)
+				lastHash = hash
+				m.Reload()
+				continue
+			}
+			m.RetryFailed()
+		}
+	}
+}
+
+// Reload reads the networks from the config file and the environment and applies them
+func (m *NetworkManager) Reload() {
+	networks, err := LoadNetworks()
+	if err != nil {
+		log.Printf("Error reloading networks, keeping the current networks: %v", err)
+		m.metrics.ConfigReloads.WithLabelValues("failure").Inc()
+		return
+	}
+
+	m.Apply(networks)
+	m.metrics.ConfigReloads.WithLabelValues("success").Inc()
+}
+
+// fileHash returns the hash of a file's content, or nil if it cannot be read
+func fileHash(path string) []byte {
+	if path == "" {
+		return nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	hash := sha256.Sum256(content)
+	return hash[:]
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// setupTestNetworkManager creates a manager starting test custody clients and records their contexts
+func setupTestNetworkManager(t *testing.T, db *gorm.DB, failing map[string]bool) (*NetworkManager, map[string]context.Context) {
+	started := make(map[string]context.Context)
+	manager := NewNetworkManager(db, testMetrics(), NewContractWallets(0), func(ctx context.Context, network *NetworkConfig) (*Custody, error) {
+		if failing[network.Name] {
+			return nil, errors.New("connection refused")
+		}
+		started[network.Name] = ctx
+		return newTestCustody(t, db, network.ChainID), nil
+	})
+	t.Cleanup(manager.Stop)
+	return manager, started
+}
+
+func TestNetworkManagerApply(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manager, started := setupTestNetworkManager(t, db, nil)
+	networkChanges := func(name, action string) float64 {
+		return testutil.ToFloat64(testMetrics().NetworkChanges.WithLabelValues(name, action))
+	}
+
+	alpha := &NetworkConfig{Name: "apply_alpha", ChainID: 1001, Confirmations: 1}
+	beta := &NetworkConfig{Name: "apply_beta", ChainID: 1002, Assets: []AssetConfig{
+		{Token: "0x2222222222222222222222222222222222222222", Symbol: "USDC", Decimals: 6},
+	}}
+	manager.Apply(map[string]*NetworkConfig{alpha.Name: alpha, beta.Name: beta})
+
+	assert.Len(t, manager.Clients(), 2)
+	assert.Equal(t, 2.0, testutil.ToFloat64(testMetrics().ActiveNetworks))
+	assert.Equal(t, 1.0, networkChanges(alpha.Name, "started"))
+
+	// Configured assets are visible to asset lookups
+	asset, err := GetAssetByToken(db, "0x2222222222222222222222222222222222222222", 1002)
+	require.NoError(t, err)
+	require.NotNil(t, asset)
+	assert.Equal(t, "usdc", asset.Symbol)
+	assert.Equal(t, uint8(6), asset.Decimals)
+
+	alphaCtx, betaCtx := started[alpha.Name], started[beta.Name]
+
+	// A new asset is added without restarting the network
+	betaWithAsset := *beta
+	betaWithAsset.Assets = append(betaWithAsset.Assets, AssetConfig{Token: "0x3333333333333333333333333333333333333333", Symbol: "weth", Decimals: 18})
+	manager.Apply(map[string]*NetworkConfig{alpha.Name: alpha, beta.Name: &betaWithAsset})
+	assert.NoError(t, betaCtx.Err())
+	asset, err = GetAssetByToken(db, "0x3333333333333333333333333333333333333333", 1002)
+	require.NoError(t, err)
+	require.NotNil(t, asset)
+	assert.Equal(t, "weth", asset.Symbol)
+
+	// Changed networks are restarted, removed ones stopped and added ones started
+	changedAlpha := &NetworkConfig{Name: alpha.Name, ChainID: 1001, Confirmations: 5}
+	gamma := &NetworkConfig{Name: "apply_gamma", ChainID: 1003}
+	manager.Apply(map[string]*NetworkConfig{alpha.Name: changedAlpha, gamma.Name: gamma})
+
+	assert.Error(t, alphaCtx.Err())
+	assert.Error(t, betaCtx.Err())
+	assert.NoError(t, started[alpha.Name].Err())
+	assert.NoError(t, started[gamma.Name].Err())
+	assert.Len(t, manager.Clients(), 2)
+	assert.Equal(t, 1.0, networkChanges(alpha.Name, "restarted"))
+	assert.Equal(t, 1.0, networkChanges(beta.Name, "stopped"))
+	assert.Equal(t, 1.0, networkChanges(gamma.Name, "started"))
+}
+
+func TestNetworkManagerRetryFailed(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	failing := map[string]bool{"retry_alpha": true}
+	manager, _ := setupTestNetworkManager(t, db, failing)
+
+	manager.Apply(map[string]*NetworkConfig{"retry_alpha": {Name: "retry_alpha", ChainID: 1001}})
+	assert.Empty(t, manager.Clients())
+	assert.Equal(t, 1.0, testutil.ToFloat64(testMetrics().NetworkChanges.WithLabelValues("retry_alpha", "failed")))
+
+	// The network is started once its endpoint is reachable
+	failing["retry_alpha"] = false
+	manager.RetryFailed()
+	assert.Len(t, manager.Clients(), 1)
+	assert.Equal(t, 1.0, testutil.ToFloat64(testMetrics().NetworkChanges.WithLabelValues("retry_alpha", "started")))
+}
+
+func TestNetworkManagerReload(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manager, started := setupTestNetworkManager(t, db, nil)
+	reloads := func(result string) float64 {
+		return testutil.ToFloat64(testMetrics().ConfigReloads.WithLabelValues(result))
+	}
+	successes, failures := reloads("success"), reloads("failure")
+
+	writeTestConfigFile(t, "clearnode.yaml", `
+networks:
+  - name: reload_alpha
+    chain_id: 1001
+    rpc_url: http://localhost:8545
+    custody_address: "0x3333333333333333333333333333333333333333"
+    assets:
+      - token: "0x2222222222222222222222222222222222222222"
+        symbol: usdc
+        decimals: 6
+`)
+	manager.Reload()
+	require.Contains(t, started, "reload_alpha")
+	assert.Len(t, manager.Clients(), 1)
+	assert.Equal(t, successes+1, reloads("success"))
+
+	asset, err := GetAssetByToken(db, "0x2222222222222222222222222222222222222222", 1001)
+	require.NoError(t, err)
+	require.NotNil(t, asset)
+
+	// An invalid file keeps the running networks
+	writeTestConfigFile(t, "clearnode.yaml", `
+networks:
+  - name: reload_alpha
+    chain_id: 1001
+`)
+	manager.Reload()
+	assert.NoError(t, started["reload_alpha"].Err())
+	assert.Len(t, manager.Clients(), 1)
+	assert.Equal(t, failures+1, reloads("failure"))
+}
+
+func TestNetworkManagerStartDoesNotBlockReaders(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	connecting := make(chan struct{})
+	release := make(chan struct{})
+	manager := NewNetworkManager(db, testMetrics(), NewContractWallets(0), func(ctx context.Context, network *NetworkConfig) (*Custody, error) {
+		close(connecting)
+		<-release
+		return newTestCustody(t, db, network.ChainID), nil
+	})
+	t.Cleanup(manager.Stop)
+
+	applied := make(chan struct{})
+	go func() {
+		defer close(applied)
+		manager.Apply(map[string]*NetworkConfig{"slow_alpha": {Name: "slow_alpha", ChainID: 1001}})
+	}()
+
+	// The running networks can be read while a network is still connecting
+	<-connecting
+	assert.Empty(t, manager.Clients())
+	assert.Empty(t, manager.ChainIDs())
+
+	close(release)
+	<-applied
+	assert.Len(t, manager.Clients(), 1)
+}
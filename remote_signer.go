@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/erc7824/go-nitrolite"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// RemoteSigner signs through a remote signing service over HTTP, so the broker key never leaves it.
+//
+// The broker signs digests it computed itself (state hashes, EIP-712 digests and transaction hashes),
+// so the service has to sign a 32 byte digest as is, without hashing or prefixing it:
+//
+//	POST {url}/api/v1/sign/{address}
+//	{"digest": "0x<32 bytes>"}
+//
+// answered with status 200 and {"signature": "0x<65 bytes>"}, V being 0, 1, 27 or 28.
+// Web3Signer's eth1 sign endpoint hashes the data it receives and cannot be used directly.
+type RemoteSigner struct {
+	url     string
+	address common.Address
+	token   string
+	client  *http.Client
+}
+
+type remoteSignRequest struct {
+	Digest string `json:"digest"`
+}
+
+type remoteSignResponse struct {
+	Signature string `json:"signature"`
+}
+
+// NewRemoteSigner creates a signer for the key with the given address held by the service at url.
+// A non empty token is sent as a bearer token.
+func NewRemoteSigner(url, address, token string, timeout time.Duration) (*RemoteSigner, error) {
+	if url == "" {
+		return nil, errors.New("BROKER_REMOTE_SIGNER_URL is required for the remote signer")
+	}
+	if !common.IsHexAddress(address) {
+		return nil, fmt.Errorf("invalid remote signer address: %q", address)
+	}
+
+	return &RemoteSigner{
+		url:     strings.TrimRight(url, "/"),
+		address: common.HexToAddress(address),
+		token:   token,
+		client:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Sign creates an ECDSA signature for the provided data
+func (s *RemoteSigner) Sign(data []byte) ([]byte, error) {
+	return signKeccak(s.SignHash, data)
+}
+
+// SignHash requests a signature of a digest from the remote service.
+// The signature is checked against the broker address before it is used.
+func (s *RemoteSigner) SignHash(hash []byte) ([]byte, error) {
+	if len(hash) != common.HashLength {
+		return nil, fmt.Errorf("invalid digest length %d", len(hash))
+	}
+	body, err := json.Marshal(remoteSignRequest{Digest: hexutil.Encode(hash)})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url+"/api/v1/sign/"+s.address.Hex(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("remote signer request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read remote signer response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("remote signer returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	var signed remoteSignResponse
+	if err := json.Unmarshal(respBody, &signed); err != nil {
+		return nil, fmt.Errorf("invalid remote signer response: %w", err)
+	}
+	sig, err := hexutil.Decode(signed.Signature)
+	if err != nil || len(sig) != 65 {
+		return nil, errors.New("invalid signature from remote signer")
+	}
+	if sig[64] >= 27 {
+		sig[64] -= 27
+	}
+
+	recovered, err := RecoverAddressFromHash(hash, hexutil.Encode(sig))
+	if err != nil || recovered != s.address.Hex() {
+		return nil, errors.New("remote signer signature does not match the broker address")
+	}
+	return sig, nil
+}
+
+// NitroSign creates a signature for the provided state in nitrolite.Signature format
+func (s *RemoteSigner) NitroSign(encodedState []byte) (nitrolite.Signature, error) {
+	sig, err := s.Sign(encodedState)
+	if err != nil {
+		return nitrolite.Signature{}, fmt.Errorf("failed to sign encoded state: %w", err)
+	}
+	return toNitroSignature(sig), nil
+}
+
+// GetAddress returns the address of the remote key
+func (s *RemoteSigner) GetAddress() common.Address {
+	return s.address
+}
+
+// NewTransactor returns transaction options that sign transaction hashes through the remote service
+func (s *RemoteSigner) NewTransactor(chainID *big.Int) (*bind.TransactOpts, error) {
+	return hashTransactor(s.address, chainID, s.SignHash), nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// SessionKey is an ephemeral key a wallet delegated request signing to during authentication
+type SessionKey struct {
+	Address      string         `gorm:"column:address;primaryKey"`
+	Owner        string         `gorm:"column:owner;not null;index"`
+	Methods      pq.StringArray `gorm:"type:text[];column:methods;not null"`
+	AppProtocols pq.StringArray `gorm:"type:text[];column:app_protocols"`
+	ExpiresAt    time.Time      `gorm:"column:expires_at;not null"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// TableName specifies the table name for the SessionKey model
+func (SessionKey) TableName() string {
+	return "session_keys"
+}
+
+// SessionKeyAllowance is the amount of an asset a session key may spend from its owner's unified balance
+type SessionKeyAllowance struct {
+	SessionKey  string          `gorm:"column:session_key;primaryKey"`
+	AssetSymbol string          `gorm:"column:asset_symbol;primaryKey"`
+	Allowance   decimal.Decimal `gorm:"column:allowance;type:decimal(38,18);not null"`
+	Spent       decimal.Decimal `gorm:"column:spent;type:decimal(38,18);not null"`
+}
+
+// TableName specifies the table name for the SessionKeyAllowance model
+func (SessionKeyAllowance) TableName() string {
+	return "session_key_allowances"
+}
+
+// SessionKeyGrant is the delegation to a session key, signed by the owner's wallet and by the session key as part of auth_verify
+type SessionKeyGrant struct {
+	Address      string            `json:"address"`       // Address of the session key
+	ExpiresAt    uint64            `json:"expires_at"`    // Unix time in milliseconds
+	Methods      []string          `json:"methods"`       // RPC methods the key may sign
+	AppProtocols []string          `json:"app_protocols"` // Application protocols the key may be used with, any if empty
+	Allowances   []AllowanceParams `json:"allowances"`    // Spending limits per asset
+}
+
+// AllowanceParams is the spending limit of a session key for one asset
+type AllowanceParams struct {
+	AssetSymbol string          `json:"asset"`
+	Amount      decimal.Decimal `json:"amount"`
+}
+
+// Validate checks a grant before it is registered
+func (g SessionKeyGrant) Validate(owner string) error {
+	if !common.IsHexAddress(g.Address) {
+		return fmt.Errorf("invalid session key address: %s", g.Address)
+	}
+	if strings.EqualFold(common.HexToAddress(g.Address).Hex(), owner) {
+		return errors.New("session key must differ from the wallet address")
+	}
+	if g.ExpiresAt <= uint64(time.Now().UnixMilli()) {
+		return errors.New("session key expiry must be in the future")
+	}
+	if len(g.Methods) == 0 {
+		return errors.New("session key must allow at least one method")
+	}
+
+	assets := map[string]bool{}
+	for _, a := range g.Allowances {
+		if a.AssetSymbol == "" || a.Amount.IsNegative() {
+			return errors.New("invalid session key allowance")
+		}
+		if assets[a.AssetSymbol] {
+			return fmt.Errorf("duplicate session key allowance for asset %s", a.AssetSymbol)
+		}
+		assets[a.AssetSymbol] = true
+	}
+
+	return nil
+}
+
+// RegisterSessionKey stores a grant for a session key of owner, replacing a previous grant of the same owner
+func RegisterSessionKey(tx *gorm.DB, owner string, grant SessionKeyGrant) (*SessionKey, error) {
+	if err := grant.Validate(owner); err != nil {
+		return nil, err
+	}
+
+	owner = common.HexToAddress(owner).Hex()
+	key := SessionKey{
+		Address:      common.HexToAddress(grant.Address).Hex(),
+		Owner:        owner,
+		Methods:      grant.Methods,
+		AppProtocols: grant.AppProtocols,
+		ExpiresAt:    time.UnixMilli(int64(grant.ExpiresAt)),
+	}
+
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		existing, err := GetSessionKey(tx, key.Address)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			if existing.Owner != owner {
+				return errors.New("session key is registered to another wallet")
+			}
+			key.CreatedAt = existing.CreatedAt
+		}
+
+		if err := tx.Save(&key).Error; err != nil {
+			return fmt.Errorf("failed to save session key: %w", err)
+		}
+
+		if err := tx.Where("session_key = ?", key.Address).Delete(&SessionKeyAllowance{}).Error; err != nil {
+			return fmt.Errorf("failed to reset session key allowances: %w", err)
+		}
+		for _, a := range grant.Allowances {
+			allowance := SessionKeyAllowance{
+				SessionKey:  key.Address,
+				AssetSymbol: a.AssetSymbol,
+				Allowance:   a.Amount,
+				Spent:       decimal.Zero,
+			}
+			if err := tx.Create(&allowance).Error; err != nil {
+				return fmt.Errorf("failed to save session key allowance: %w", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// GetSessionKey returns the session key with the given address, or nil if there is none
+func GetSessionKey(tx *gorm.DB, address string) (*SessionKey, error) {
+	var key SessionKey
+	if err := tx.Where("address = ?", address).First(&key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get session key: %w", err)
+	}
+	return &key, nil
+}
+
+// ResolveSigner maps the recovered signer of a request to the wallet it acts for.
+// A signer that is one of the wallets taking part in the request resolves to itself, whether or not a session key
+// is registered at its address. Otherwise a session key resolves to its owner if it has not expired and its grant
+// covers the method and, for application methods, the application protocol. Any other signer resolves to itself.
+func ResolveSigner(tx *gorm.DB, signer string, wallets []string, method, protocol string) (string, *SessionKey, error) {
+	if slices.ContainsFunc(wallets, func(wallet string) bool { return strings.EqualFold(wallet, signer) }) {
+		return signer, nil, nil
+	}
+
+	key, err := GetSessionKey(tx, signer)
+	if err != nil {
+		return "", nil, err
+	}
+	if key == nil {
+		return signer, nil, nil
+	}
+
+	if time.Now().After(key.ExpiresAt) {
+		return "", nil, errors.New("session key expired")
+	}
+	if !slices.Contains(key.Methods, method) {
+		return "", nil, fmt.Errorf("session key is not allowed to call %s", method)
+	}
+	if protocol != "" && len(key.AppProtocols) > 0 && !slices.Contains(key.AppProtocols, protocol) {
+		return "", nil, fmt.Errorf("session key is not allowed to use protocol %s", protocol)
+	}
+
+	return key.Owner, key, nil
+}
+
+// verifyOwnerSignature checks that sig over the request digest was made by owner, by a session key of owner allowed
+// to call method, or by owner as a contract wallet. It returns the session key, or nil for a wallet signature.
+func verifyOwnerSignature(tx *gorm.DB, sigCtx SigningContext, digest []byte, sig, owner, method string) (*SessionKey, error) {
+	recovered, err := RecoverAddressFromHash(digest, sig)
+	if err == nil {
+		if strings.EqualFold(recovered, owner) {
+			return nil, nil
+		}
+
+		resolved, key, err := ResolveSigner(tx, recovered, []string{owner}, method, "")
+		if err != nil {
+			return nil, err
+		}
+		if key != nil && strings.EqualFold(resolved, owner) {
+			return key, nil
+		}
+	}
+
+	if sigCtx.Wallets.IsValidSignature(sigCtx.ChainID, owner, digest, sig) {
+		return nil, nil
+	}
+	return nil, errors.New("invalid signature")
+}
+
+// Spend records amount of an asset spent by the session key, failing if it exceeds the remaining allowance
+func (k *SessionKey) Spend(tx *gorm.DB, assetSymbol string, amount decimal.Decimal) error {
+	if !amount.IsPositive() {
+		return nil
+	}
+
+	// Check and update in one statement so that concurrent requests cannot overspend
+	res := tx.Model(&SessionKeyAllowance{}).
+		Where("session_key = ? AND asset_symbol = ? AND spent + ? <= allowance", k.Address, assetSymbol, amount).
+		Update("spent", gorm.Expr("spent + ?", amount))
+	if res.Error != nil {
+		return fmt.Errorf("failed to update session key allowance: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("session key allowance exceeded for asset %s", assetSymbol)
+	}
+	return nil
+}
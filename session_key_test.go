@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionKey(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	owner := "0x1111111111111111111111111111111111111111"
+	keyAddr := "0x2222222222222222222222222222222222222222"
+	grant := SessionKeyGrant{
+		Address:      keyAddr,
+		ExpiresAt:    uint64(time.Now().Add(time.Hour).UnixMilli()),
+		Methods:      []string{"transfer", "create_app_session"},
+		AppProtocols: []string{"NitroRPC/0.2"},
+		Allowances:   []AllowanceParams{{AssetSymbol: "usdc", Amount: decimal.NewFromInt(100)}},
+	}
+
+	t.Run("invalid grants", func(t *testing.T) {
+		expired := grant
+		expired.ExpiresAt = uint64(time.Now().Add(-time.Minute).UnixMilli())
+		assert.EqualError(t, expired.Validate(owner), "session key expiry must be in the future")
+
+		self := grant
+		self.Address = owner
+		assert.EqualError(t, self.Validate(owner), "session key must differ from the wallet address")
+
+		noMethods := grant
+		noMethods.Methods = nil
+		assert.EqualError(t, noMethods.Validate(owner), "session key must allow at least one method")
+	})
+
+	key, err := RegisterSessionKey(db, owner, grant)
+	require.NoError(t, err)
+
+	// Another wallet cannot take over the key
+	_, err = RegisterSessionKey(db, "0x3333333333333333333333333333333333333333", grant)
+	assert.EqualError(t, err, "session key is registered to another wallet")
+
+	t.Run("resolve signer", func(t *testing.T) {
+		resolved, resolvedKey, err := ResolveSigner(db, key.Address, nil, "transfer", "")
+		require.NoError(t, err)
+		assert.Equal(t, owner, resolved)
+		require.NotNil(t, resolvedKey)
+
+		resolved, resolvedKey, err = ResolveSigner(db, owner, nil, "close_channel", "")
+		require.NoError(t, err)
+		assert.Equal(t, owner, resolved)
+		assert.Nil(t, resolvedKey)
+
+		_, _, err = ResolveSigner(db, key.Address, nil, "close_channel", "")
+		assert.EqualError(t, err, "session key is not allowed to call close_channel")
+
+		_, _, err = ResolveSigner(db, key.Address, nil, "create_app_session", "OtherProtocol")
+		assert.EqualError(t, err, "session key is not allowed to use protocol OtherProtocol")
+
+		// A wallet taking part in the request signs for itself even if a session key is registered at its address
+		resolved, resolvedKey, err = ResolveSigner(db, key.Address, []string{key.Address}, "transfer", "")
+		require.NoError(t, err)
+		assert.Equal(t, key.Address, resolved)
+		assert.Nil(t, resolvedKey)
+	})
+
+	t.Run("spend allowance", func(t *testing.T) {
+		require.NoError(t, key.Spend(db, "usdc", decimal.NewFromInt(60)))
+		assert.EqualError(t, key.Spend(db, "usdc", decimal.NewFromInt(50)), "session key allowance exceeded for asset usdc")
+		require.NoError(t, key.Spend(db, "usdc", decimal.NewFromInt(40)))
+		assert.EqualError(t, key.Spend(db, "eth", decimal.NewFromInt(1)), "session key allowance exceeded for asset eth")
+
+		var allowance SessionKeyAllowance
+		require.NoError(t, db.Where("session_key = ? AND asset_symbol = ?", key.Address, "usdc").First(&allowance).Error)
+		assert.True(t, allowance.Spent.Equal(decimal.NewFromInt(100)))
+	})
+
+	t.Run("expired key", func(t *testing.T) {
+		require.NoError(t, db.Model(&SessionKey{}).Where("address = ?", key.Address).Update("expires_at", time.Now().Add(-time.Second)).Error)
+
+		_, _, err := ResolveSigner(db, key.Address, nil, "transfer", "")
+		assert.EqualError(t, err, "session key expired")
+	})
+}
+
+func TestHandleTransferWithSessionKey(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	walletKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	owner := crypto.PubkeyToAddress(walletKey.PublicKey).Hex()
+
+	sessionPrivKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	sessionSigner := Signer{privateKey: sessionPrivKey}
+
+	recipient := "0x1111111111111111111111111111111111111111"
+	require.NoError(t, GetParticipantLedger(db, owner).Record(owner, "usdc", decimal.NewFromInt(1000)))
+
+	_, err = RegisterSessionKey(db, owner, SessionKeyGrant{
+		Address:    sessionSigner.GetAddress().Hex(),
+		ExpiresAt:  uint64(time.Now().Add(time.Hour).UnixMilli()),
+		Methods:    []string{"transfer"},
+		Allowances: []AllowanceParams{{AssetSymbol: "usdc", Amount: decimal.NewFromInt(100)}},
+	})
+	require.NoError(t, err)
+
+	newTransferRequest := func(requestID uint64, amount int64) *RPCMessage {
+		params := TransferParams{
+			Allocations: []TransferAllocation{{Destination: recipient, AssetSymbol: "usdc", Amount: decimal.NewFromInt(amount)}},
+		}
+		rpcReq := &RPCMessage{
+			Req: &RPCData{
+				RequestID: requestID,
+				Method:    "transfer",
+				Params:    []any{params},
+				Timestamp: uint64(time.Now().Unix()),
+			},
+		}
+
+		signBytes, err := TransferSignData{
+			RequestID: rpcReq.Req.RequestID,
+			Method:    rpcReq.Req.Method,
+			Params:    []TransferParams{params},
+			Timestamp: rpcReq.Req.Timestamp,
+		}.MarshalJSON()
+		require.NoError(t, err)
+		sig, err := sessionSigner.Sign(signBytes)
+		require.NoError(t, err)
+		rpcReq.Sig = []string{hexutil.Encode(sig)}
+		return rpcReq
+	}
+
+	// Within the allowance the session key acts for the wallet
+	_, err = HandleTransfer(newTransferRequest(1, 70), owner, db, SigningContext{Mode: SignatureModeRaw})
+	require.NoError(t, err)
+
+	balance, err := GetParticipantLedger(db, owner).Balance(owner, "usdc")
+	require.NoError(t, err)
+	assert.Equal(t, decimal.NewFromInt(930).String(), balance.String())
+
+	// Beyond the allowance the transfer is rejected and nothing is recorded
+	_, err = HandleTransfer(newTransferRequest(2, 40), owner, db, SigningContext{Mode: SignatureModeRaw})
+	assert.EqualError(t, err, "session key allowance exceeded for asset usdc")
+
+	balance, err = GetParticipantLedger(db, owner).Balance(owner, "usdc")
+	require.NoError(t, err)
+	assert.Equal(t, decimal.NewFromInt(930).String(), balance.String())
+
+	// The key cannot sign for another wallet
+	_, err = HandleTransfer(newTransferRequest(3, 10), recipient, db, SigningContext{Mode: SignatureModeRaw})
+	assert.EqualError(t, err, "invalid signature")
+}
+
+func TestAppSessionWithSessionKey(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	signerA := newTestSigner(t)
+	signerB := newTestSigner(t)
+	sessionSigner := newTestSigner(t)
+	addrA := signerA.GetAddress().Hex()
+	addrB := signerB.GetAddress().Hex()
+
+	appID := "0xSessionKeyApp"
+	require.NoError(t, db.Create(&AppSession{
+		SessionID:    appID,
+		Protocol:     "NitroRPC/0.2",
+		Participants: []string{addrA, addrB},
+		Status:       ChannelStatusOpen,
+		Challenge:    60,
+		Weights:      []int64{50, 50},
+		Quorum:       100,
+		Version:      1,
+	}).Error)
+	require.NoError(t, GetParticipantLedger(db, addrA).Record(appID, "usdc", decimal.NewFromInt(100)))
+	require.NoError(t, GetParticipantLedger(db, addrB).Record(appID, "usdc", decimal.NewFromInt(100)))
+
+	_, err := RegisterSessionKey(db, addrB, SessionKeyGrant{
+		Address:    sessionSigner.GetAddress().Hex(),
+		ExpiresAt:  uint64(time.Now().Add(time.Hour).UnixMilli()),
+		Methods:    []string{"submit_app_state", "close_app_session"},
+		Allowances: []AllowanceParams{{AssetSymbol: "usdc", Amount: decimal.NewFromInt(60)}},
+	})
+	require.NoError(t, err)
+
+	newRequest := func(requestID uint64, method string, params any, signData json.Marshaler, signers ...*Signer) *RPCMessage {
+		signBytes, err := signData.MarshalJSON()
+		require.NoError(t, err)
+		rpcReq := &RPCMessage{Req: &RPCData{RequestID: requestID, Method: method, Params: []any{params}}}
+		for _, s := range signers {
+			sig, err := s.Sign(signBytes)
+			require.NoError(t, err)
+			rpcReq.Sig = append(rpcReq.Sig, hexutil.Encode(sig))
+		}
+		return rpcReq
+	}
+	submit := func(requestID, version uint64, amountA, amountB int64) error {
+		params := SubmitAppStateParams{
+			AppSessionID: appID,
+			Version:      version,
+			Allocations: []AppAllocation{
+				{Participant: addrA, AssetSymbol: "usdc", Amount: decimal.NewFromInt(amountA)},
+				{Participant: addrB, AssetSymbol: "usdc", Amount: decimal.NewFromInt(amountB)},
+			},
+		}
+		signData := SubmitAppStateSignData{RequestID: requestID, Method: "submit_app_state", Params: []SubmitAppStateParams{params}}
+		_, err := HandleSubmitAppState(newRequest(requestID, "submit_app_state", params, signData, signerA, sessionSigner), db, SigningContext{Mode: SignatureModeRaw})
+		return err
+	}
+	closeApp := func(requestID uint64, amountA, amountB int64) error {
+		params := CloseAppSessionParams{
+			AppSessionID: appID,
+			Allocations: []AppAllocation{
+				{Participant: addrA, AssetSymbol: "usdc", Amount: decimal.NewFromInt(amountA)},
+				{Participant: addrB, AssetSymbol: "usdc", Amount: decimal.NewFromInt(amountB)},
+			},
+		}
+		signData := CloseAppSignData{RequestID: requestID, Method: "close_app_session", Params: []CloseAppSessionParams{params}}
+		_, err := HandleCloseApplication(newRequest(requestID, "close_app_session", params, signData, signerA, sessionSigner), db, SigningContext{Mode: SignatureModeRaw})
+		return err
+	}
+
+	// Funds the owner of the key gives up count against its allowance, funds it receives do not
+	require.NoError(t, submit(1, 2, 150, 50))
+	require.NoError(t, submit(2, 3, 100, 100))
+	assert.EqualError(t, submit(3, 4, 120, 80), "session key allowance exceeded for asset usdc")
+
+	assert.EqualError(t, closeApp(4, 120, 80), "session key allowance exceeded for asset usdc")
+	require.NoError(t, closeApp(5, 110, 90))
+
+	balanceB, err := GetParticipantLedger(db, addrB).Balance(addrB, "usdc")
+	require.NoError(t, err)
+	assert.Equal(t, "90", balanceB.String())
+
+	var allowance SessionKeyAllowance
+	require.NoError(t, db.Where("session_key = ? AND asset_symbol = ?", sessionSigner.GetAddress().Hex(), "usdc").First(&allowance).Error)
+	assert.Equal(t, "60", allowance.Spent.String())
+}
+
+func TestSessionKeyCannotCaptureParticipant(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	signerA := newTestSigner(t)
+	signerB := newTestSigner(t)
+	addrA := signerA.GetAddress().Hex()
+	addrB := signerB.GetAddress().Hex()
+
+	// A key registered at the address of A by another wallet does not take over the signatures of A
+	_, err := RegisterSessionKey(db, "0x3333333333333333333333333333333333333333", SessionKeyGrant{
+		Address:   addrA,
+		ExpiresAt: uint64(time.Now().Add(time.Hour).UnixMilli()),
+		Methods:   []string{"submit_app_state"},
+	})
+	require.NoError(t, err)
+
+	appSession := AppSession{
+		SessionID:    "0xCapturedApp",
+		Participants: []string{addrA, addrB},
+		Weights:      []int64{50, 50},
+		Quorum:       100,
+	}
+	digest := crypto.Keccak256([]byte("state"))
+	var sigs []string
+	for _, s := range []*Signer{signerA, signerB} {
+		sig, err := crypto.Sign(digest, s.privateKey)
+		require.NoError(t, err)
+		sigs = append(sigs, hexutil.Encode(sig))
+	}
+
+	_, sessionKeys, err := verifyQuorum(db, SigningContext{Mode: SignatureModeRaw}, appSession, "submit_app_state", digest, sigs)
+	require.NoError(t, err)
+	assert.Empty(t, sessionKeys)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	// sessionTokenAlgorithm is ECDSA over secp256k1 with SHA-256, signed with the broker key
+	sessionTokenAlgorithm = "ES256K"
+	sessionTokenIssuer    = "clearnode"
+	// ScopeAll grants access to every RPC method
+	ScopeAll = "all"
+)
+
+// SessionClaims are the claims of a session token issued after a successful auth_verify
+type SessionClaims struct {
+	Issuer    string `json:"iss"`
+	Address   string `json:"sub"`
+	Scope     string `json:"scope"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+
+	// Signature mode of the session and the chain of its EIP-712 domain
+	SignatureMode SignatureMode `json:"sig_mode,omitempty"`
+	ChainID       uint32        `json:"chain_id,omitempty"`
+}
+
+type sessionTokenHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+}
+
+// IssueSessionToken creates a JWT for the claims of an authenticated session, signed with the broker key.
+// Any clearnode instance sharing the broker key can verify it, so a client can reconnect to
+// another replica without signing a new challenge.
+func IssueSessionToken(signer BrokerSigner, session SessionClaims, ttl time.Duration) (string, *SessionClaims, error) {
+	now := time.Now()
+	claims := &session
+	claims.Issuer = sessionTokenIssuer
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = now.Add(ttl).Unix()
+
+	headerJSON, err := json.Marshal(sessionTokenHeader{Algorithm: sessionTokenAlgorithm, Type: "JWT"})
+	if err != nil {
+		return "", nil, err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", nil, err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	hash := sha256.Sum256([]byte(signingInput))
+	sig, err := signer.SignHash(hash[:])
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to sign session token: %w", err)
+	}
+
+	// JWS uses the 64 byte r||s form without the recovery id
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig[:64]), claims, nil
+}
+
+// VerifySessionToken checks that a session token is signed by the broker and not expired, and returns its claims
+func VerifySessionToken(broker common.Address, token string) (*SessionClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed session token")
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("malformed session token header")
+	}
+	var header sessionTokenHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, errors.New("malformed session token header")
+	}
+	if header.Algorithm != sessionTokenAlgorithm {
+		return nil, fmt.Errorf("unsupported session token algorithm: %s", header.Algorithm)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		return nil, errors.New("malformed session token signature")
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !signedByAddress(hash[:], sig, broker) {
+		return nil, errors.New("invalid session token signature")
+	}
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed session token claims")
+	}
+	var claims SessionClaims
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return nil, errors.New("malformed session token claims")
+	}
+
+	if claims.Issuer != sessionTokenIssuer || claims.Address == "" {
+		return nil, errors.New("invalid session token claims")
+	}
+	if claims.SignatureMode == "" {
+		claims.SignatureMode = SignatureModeRaw
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, errors.New("session token expired")
+	}
+
+	return &claims, nil
+}
+
+// signedByAddress reports whether a 64 byte r||s signature of hash was made by the key of address.
+// The JWS form has no recovery id, so both candidates are tried.
+func signedByAddress(hash, sig []byte, address common.Address) bool {
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	for v := byte(0); v < 2; v++ {
+		if !crypto.ValidateSignatureValues(v, r, s, true) {
+			return false
+		}
+		pub, err := crypto.SigToPub(hash, append(sig[:64:64], v))
+		if err == nil && crypto.PubkeyToAddress(*pub) == address {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopeAllows reports whether a session scope grants access to an RPC method.
+// A scope is either ScopeAll or a comma separated list of method names.
+func ScopeAllows(scope, method string) bool {
+	if scope == "" || scope == ScopeAll || method == "ping" {
+		return true
+	}
+	return slices.Contains(strings.Split(scope, ","), method)
+}
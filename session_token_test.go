@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionToken(t *testing.T) {
+	brokerKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	signer := &Signer{privateKey: brokerKey}
+	address := "0x1234567890123456789012345678901234567890"
+
+	token, claims, err := IssueSessionToken(signer, SessionClaims{Address: address, Scope: ScopeAll, SignatureMode: SignatureModeRaw}, time.Hour)
+	require.NoError(t, err)
+	assert.Len(t, strings.Split(token, "."), 3)
+	assert.Equal(t, address, claims.Address)
+
+	verified, err := VerifySessionToken(signer.GetAddress(), token)
+	require.NoError(t, err)
+	assert.Equal(t, *claims, *verified)
+
+	t.Run("tampered claims", func(t *testing.T) {
+		other, _, err := IssueSessionToken(signer, SessionClaims{Address: "0xabcdefabcdefabcdefabcdefabcdefabcdefabcd", Scope: ScopeAll}, time.Hour)
+		require.NoError(t, err)
+		parts := strings.Split(token, ".")
+		parts[1] = strings.Split(other, ".")[1]
+
+		_, err = VerifySessionToken(signer.GetAddress(), strings.Join(parts, "."))
+		assert.EqualError(t, err, "invalid session token signature")
+	})
+
+	t.Run("other broker key", func(t *testing.T) {
+		otherKey, err := crypto.GenerateKey()
+		require.NoError(t, err)
+
+		_, err = VerifySessionToken(crypto.PubkeyToAddress(otherKey.PublicKey), token)
+		assert.EqualError(t, err, "invalid session token signature")
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		expired, _, err := IssueSessionToken(signer, SessionClaims{Address: address, Scope: ScopeAll}, -time.Second)
+		require.NoError(t, err)
+
+		_, err = VerifySessionToken(signer.GetAddress(), expired)
+		assert.EqualError(t, err, "session token expired")
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		_, err := VerifySessionToken(signer.GetAddress(), "not-a-token")
+		assert.EqualError(t, err, "malformed session token")
+	})
+}
+
+func TestScopeAllows(t *testing.T) {
+	assert.True(t, ScopeAllows(ScopeAll, "transfer"))
+	assert.True(t, ScopeAllows("get_ledger_balances,transfer", "transfer"))
+	assert.False(t, ScopeAllows("get_ledger_balances", "transfer"))
+	assert.True(t, ScopeAllows("get_ledger_balances", "ping"))
+}
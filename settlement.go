@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// settlementExpiryInterval is how often expired settlement locks are released
+const settlementExpiryInterval = time.Minute
+
+// PendingSettlement tracks funds locked for a resize or close state signed by the broker.
+// The funds are held in the ledger account of the channel until the state lands on-chain, or until the lock
+// has expired and the state can no longer land because the channel moved past its version.
+type PendingSettlement struct {
+	ChannelID   string          `gorm:"column:channel_id;primaryKey"`
+	Participant string          `gorm:"column:participant;not null"`
+	AssetSymbol string          `gorm:"column:asset_symbol;not null"`
+	Version     uint64          `gorm:"column:version;not null"`
+	Allocated   decimal.Decimal `gorm:"column:allocated;type:decimal(38,18);not null;default:0"` // Broker funds the signed state deposits into the channel
+	ExpiresAt   time.Time       `gorm:"column:expires_at;not null;index"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// TableName specifies the table name for the PendingSettlement model
+func (PendingSettlement) TableName() string {
+	return "pending_settlements"
+}
+
+// GetLockedSettlement returns the amount locked for a channel, or zero if there is no pending settlement
+func GetLockedSettlement(tx *gorm.DB, channel Channel, assetSymbol string) (decimal.Decimal, error) {
+	var settlement PendingSettlement
+	if err := tx.Where("channel_id = ?", channel.ChannelID).First(&settlement).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return decimal.Zero, nil
+		}
+		return decimal.Zero, err
+	}
+
+	return GetParticipantLedger(tx, channel.Participant).Balance(channel.ChannelID, assetSymbol)
+}
+
+// LockSettlement moves funds from the participant's unified balance into the channel account so that at least
+// amount is locked until the signed state of the given version lands on-chain or expiresAt passes.
+// A channel has a single lock: both the previous and the new state share a version and only one of them can land,
+// so an existing lock is only topped up.
+func LockSettlement(tx *gorm.DB, channel Channel, assetSymbol string, amount decimal.Decimal, version uint64, expiresAt time.Time) error {
+	locked, err := GetLockedSettlement(tx, channel, assetSymbol)
+	if err != nil {
+		return fmt.Errorf("failed to get locked amount: %w", err)
+	}
+
+	var settlement PendingSettlement
+	if err := tx.Where("channel_id = ?", channel.ChannelID).First(&settlement).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to get pending settlement: %w", err)
+		}
+		settlement = PendingSettlement{
+			ChannelID: channel.ChannelID,
+			Allocated: decimal.Zero,
+			CreatedAt: time.Now(),
+		}
+	}
+	settlement.Participant = channel.Participant
+	settlement.AssetSymbol = assetSymbol
+	settlement.Version = version
+	settlement.ExpiresAt = expiresAt
+	settlement.UpdatedAt = time.Now()
+	if err := tx.Save(&settlement).Error; err != nil {
+		return fmt.Errorf("failed to save pending settlement: %w", err)
+	}
+
+	topUp := amount.Sub(locked)
+	if !topUp.IsPositive() {
+		return nil
+	}
+
+	ledger := GetParticipantLedger(tx, channel.Participant)
+	if err := ledger.Record(channel.Participant, assetSymbol, topUp.Neg()); err != nil {
+		return err
+	}
+	return ledger.Record(channel.ChannelID, assetSymbol, topUp)
+}
+
+// ReserveAllocation records amount as the broker funds allocated to a channel by the signed state of its lock,
+// until the state lands on-chain or the lock is released. As either the previous or the new state of the lock's
+// version can land, the larger allocation is kept.
+func ReserveAllocation(tx *gorm.DB, channelID string, amount decimal.Decimal) error {
+	return tx.Model(&PendingSettlement{}).
+		Where("channel_id = ? AND allocated < ?", channelID, amount).
+		Update("allocated", amount).Error
+}
+
+// OutstandingAllocations returns the broker funds allocated to channels of a chain and token by signed states that
+// have not landed on-chain yet, without the allocation to the excluded channel
+func OutstandingAllocations(tx *gorm.DB, chainID uint32, token, excludedChannelID string) (decimal.Decimal, error) {
+	var result struct {
+		Allocated decimal.Decimal `gorm:"column:allocated"`
+	}
+	err := tx.Model(&PendingSettlement{}).
+		Joins("JOIN channels ON channels.channel_id = pending_settlements.channel_id").
+		Where("channels.chain_id = ? AND channels.token = ? AND channels.channel_id <> ?", chainID, token, excludedChannelID).
+		Select("COALESCE(SUM(pending_settlements.allocated),0) AS allocated").
+		Scan(&result).Error
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("failed to sum outstanding allocations: %w", err)
+	}
+	return result.Allocated, nil
+}
+
+// ReleaseSettlement returns the funds locked for a channel to the participant's unified balance.
+// It returns the released lock with the amount it held, or nil if the channel has no pending settlement.
+func ReleaseSettlement(tx *gorm.DB, channelID string) (*PendingSettlement, decimal.Decimal, error) {
+	var settlement PendingSettlement
+	if err := tx.Where("channel_id = ?", channelID).First(&settlement).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, decimal.Zero, nil
+		}
+		return nil, decimal.Zero, err
+	}
+
+	ledger := GetParticipantLedger(tx, settlement.Participant)
+	locked, err := ledger.Balance(channelID, settlement.AssetSymbol)
+	if err != nil {
+		return nil, decimal.Zero, fmt.Errorf("failed to get locked amount: %w", err)
+	}
+
+	if err := ledger.Record(channelID, settlement.AssetSymbol, locked.Neg()); err != nil {
+		return nil, decimal.Zero, err
+	}
+	if err := ledger.Record(settlement.Participant, settlement.AssetSymbol, locked); err != nil {
+		return nil, decimal.Zero, err
+	}
+
+	if err := tx.Delete(&settlement).Error; err != nil {
+		return nil, decimal.Zero, fmt.Errorf("failed to delete pending settlement: %w", err)
+	}
+	return &settlement, locked, nil
+}
+
+// settlementSuperseded reports whether the signed state of a lock can no longer land on-chain,
+// because its channel is gone or closed or the on-chain version of the channel reached the version of the state
+func settlementSuperseded(tx *gorm.DB, settlement PendingSettlement) (bool, error) {
+	var channel Channel
+	if err := tx.Where("channel_id = ?", settlement.ChannelID).First(&channel).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return true, nil
+		}
+		return false, err
+	}
+	return channel.Status == ChannelStatusClosed || channel.Version >= settlement.Version, nil
+}
+
+// ReleaseExpiredSettlements releases the settlement locks that expired before now and whose signed state is superseded.
+// Expired locks of states that can still be submitted on-chain are kept, as releasing them would let the participant
+// spend the funds a second time, and are returned as overdue.
+func ReleaseExpiredSettlements(db *gorm.DB, now time.Time) (released, overdue []PendingSettlement, err error) {
+	var expired []PendingSettlement
+	if err := db.Where("expires_at < ?", now).Find(&expired).Error; err != nil {
+		return nil, nil, err
+	}
+
+	for _, settlement := range expired {
+		var ok, stale bool
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// The lock may have been renewed by a newer signed state in the meantime
+			var current PendingSettlement
+			if err := tx.Where("channel_id = ? AND expires_at < ?", settlement.ChannelID, now).First(&current).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return nil
+				}
+				return err
+			}
+
+			superseded, err := settlementSuperseded(tx, current)
+			if err != nil {
+				return fmt.Errorf("failed to check channel version: %w", err)
+			}
+			if !superseded {
+				stale = true
+				return nil
+			}
+
+			_, _, err = ReleaseSettlement(tx, settlement.ChannelID)
+			ok = err == nil
+			return err
+		})
+		if err != nil {
+			return released, overdue, fmt.Errorf("failed to release settlement for channel %s: %w", settlement.ChannelID, err)
+		}
+		if ok {
+			released = append(released, settlement)
+		}
+		if stale {
+			overdue = append(overdue, settlement)
+		}
+	}
+	return released, overdue, nil
+}
+
+// ExpireSettlementsPeriodically releases expired settlement locks of superseded states and notifies the affected participants.
+// Expired locks that are kept are logged and counted in the overdue settlements metric.
+func ExpireSettlementsPeriodically(db *gorm.DB, metrics *Metrics, sendBalanceUpdate func(string)) {
+	ticker := time.NewTicker(settlementExpiryInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		released, overdue, err := ReleaseExpiredSettlements(db, time.Now())
+		if err != nil {
+			log.Printf("Error releasing expired settlements: %v", err)
+		}
+		for _, settlement := range released {
+			log.Printf("Released expired settlement lock for channel %s (version %d)", settlement.ChannelID, settlement.Version)
+			sendBalanceUpdate(settlement.Participant)
+		}
+		for _, settlement := range overdue {
+			log.Printf("Warning: settlement lock for channel %s expired at %s, but state version %d can still be submitted on-chain",
+				settlement.ChannelID, settlement.ExpiresAt.Format(time.RFC3339), settlement.Version)
+		}
+		metrics.OverdueSettlements.Set(float64(len(overdue)))
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSettlementLock(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	participant := "0x1111111111111111111111111111111111111111"
+	channel := Channel{ChannelID: "0xChannel1", Participant: participant}
+	ledger := GetParticipantLedger(db, participant)
+	require.NoError(t, ledger.Record(participant, "usdc", decimal.NewFromInt(100)))
+
+	assertBalances := func(unified, locked int64) {
+		t.Helper()
+		balance, err := ledger.Balance(participant, "usdc")
+		require.NoError(t, err)
+		assert.Equal(t, decimal.NewFromInt(unified).String(), balance.String())
+
+		lockedAmount, err := GetLockedSettlement(db, channel, "usdc")
+		require.NoError(t, err)
+		assert.Equal(t, decimal.NewFromInt(locked).String(), lockedAmount.String())
+	}
+
+	require.NoError(t, LockSettlement(db, channel, "usdc", decimal.NewFromInt(30), 1, time.Now().Add(time.Hour)))
+	assertBalances(70, 30)
+
+	// Signing another state of the same version only tops the lock up
+	require.NoError(t, LockSettlement(db, channel, "usdc", decimal.NewFromInt(20), 1, time.Now().Add(time.Hour)))
+	assertBalances(70, 30)
+	require.NoError(t, LockSettlement(db, channel, "usdc", decimal.NewFromInt(50), 1, time.Now().Add(time.Hour)))
+	assertBalances(50, 50)
+
+	released, amount, err := ReleaseSettlement(db, channel.ChannelID)
+	require.NoError(t, err)
+	require.NotNil(t, released)
+	assert.Equal(t, decimal.NewFromInt(50).String(), amount.String())
+	assertBalances(100, 0)
+
+	released, _, err = ReleaseSettlement(db, channel.ChannelID)
+	require.NoError(t, err)
+	assert.Nil(t, released)
+}
+
+func TestReleaseExpiredSettlements(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	participant := "0x1111111111111111111111111111111111111111"
+	superseded := Channel{ChannelID: "0xSuperseded", Participant: participant, Status: ChannelStatusOpen, Version: 1}
+	pending := Channel{ChannelID: "0xPending", Participant: participant, Status: ChannelStatusOpen}
+	active := Channel{ChannelID: "0xActive", Participant: participant, Status: ChannelStatusOpen}
+	for _, channel := range []Channel{superseded, pending, active} {
+		require.NoError(t, db.Create(&channel).Error)
+	}
+	ledger := GetParticipantLedger(db, participant)
+	require.NoError(t, ledger.Record(participant, "usdc", decimal.NewFromInt(100)))
+
+	now := time.Now()
+	require.NoError(t, LockSettlement(db, superseded, "usdc", decimal.NewFromInt(10), 1, now.Add(-time.Minute)))
+	require.NoError(t, LockSettlement(db, pending, "usdc", decimal.NewFromInt(15), 1, now.Add(-time.Minute)))
+	require.NoError(t, LockSettlement(db, active, "usdc", decimal.NewFromInt(20), 1, now.Add(time.Hour)))
+
+	// Only the lock of a state the channel moved past is released, the lock of a state that can still land is kept
+	released, overdue, err := ReleaseExpiredSettlements(db, now)
+	require.NoError(t, err)
+	require.Len(t, released, 1)
+	assert.Equal(t, superseded.ChannelID, released[0].ChannelID)
+	require.Len(t, overdue, 1)
+	assert.Equal(t, pending.ChannelID, overdue[0].ChannelID)
+
+	balance, err := ledger.Balance(participant, "usdc")
+	require.NoError(t, err)
+	assert.Equal(t, decimal.NewFromInt(65).String(), balance.String())
+
+	var count int64
+	require.NoError(t, db.Model(&PendingSettlement{}).Count(&count).Error)
+	assert.Equal(t, int64(2), count)
+
+	// Once the channel is closed the state can no longer land and the lock is released
+	require.NoError(t, db.Model(&Channel{}).Where("channel_id = ?", pending.ChannelID).Update("status", ChannelStatusClosed).Error)
+	released, overdue, err = ReleaseExpiredSettlements(db, now)
+	require.NoError(t, err)
+	require.Len(t, released, 1)
+	assert.Empty(t, overdue)
+
+	balance, err = ledger.Balance(participant, "usdc")
+	require.NoError(t, err)
+	assert.Equal(t, decimal.NewFromInt(80).String(), balance.String())
+}
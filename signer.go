@@ -1,18 +1,117 @@
 package main
 
 import (
+	"context"
 	"crypto/ecdsa"
+	"errors"
 	"fmt"
 	"log"
+	"math/big"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/erc7824/go-nitrolite"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
-// Signer handles signing operations using a private key
+// BrokerSigner signs on behalf of the broker: RPC messages, channel states, session tokens and transactions.
+// Signatures are 65 bytes with the recovery id 0 or 1 in the last byte, unless stated otherwise.
+type BrokerSigner interface {
+	// Sign signs the Keccak256 hash of data
+	Sign(data []byte) ([]byte, error)
+	// SignHash signs a 32 byte digest as is
+	SignHash(hash []byte) ([]byte, error)
+	// NitroSign signs the Keccak256 hash of an encoded channel state, with V as 27 or 28
+	NitroSign(encodedState []byte) (nitrolite.Signature, error)
+	// GetAddress returns the broker address
+	GetAddress() common.Address
+	// NewTransactor returns transaction options signing for the broker on a chain
+	NewTransactor(chainID *big.Int) (*bind.TransactOpts, error)
+}
+
+const (
+	SignerBackendLocal    = "local"
+	SignerBackendKeystore = "keystore"
+	SignerBackendRemote   = "remote"
+)
+
+// SignerConfig selects and configures the broker signer backend
+type SignerConfig struct {
+	// Backend is one of local, keystore or remote
+	Backend string `env:"BROKER_SIGNER" env-default:"local"`
+	// Hex encoded private key of the local backend
+	PrivateKey string `env:"BROKER_PRIVATE_KEY"`
+
+	// Encrypted keystore file and its passphrase, given directly or in a mounted file
+	KeystorePath         string `env:"BROKER_KEYSTORE_PATH"`
+	KeystorePassword     string `env:"BROKER_KEYSTORE_PASSWORD"`
+	KeystorePasswordFile string `env:"BROKER_KEYSTORE_PASSWORD_FILE"`
+
+	// Remote signing service, the address of the key it holds and an optional bearer token
+	RemoteURL     string        `env:"BROKER_REMOTE_SIGNER_URL"`
+	RemoteAddress string        `env:"BROKER_REMOTE_SIGNER_ADDRESS"`
+	RemoteToken   string        `env:"BROKER_REMOTE_SIGNER_TOKEN"`
+	RemoteTimeout time.Duration `env:"BROKER_REMOTE_SIGNER_TIMEOUT" env-default:"10s"`
+
+	// Previous broker key during a key rotation, held by the same backend
+	PreviousPrivateKey    string `env:"BROKER_PREVIOUS_PRIVATE_KEY"`
+	PreviousKeystorePath  string `env:"BROKER_PREVIOUS_KEYSTORE_PATH"`
+	PreviousRemoteAddress string `env:"BROKER_PREVIOUS_REMOTE_SIGNER_ADDRESS"`
+}
+
+// previous returns the configuration of the previous broker key, or false if none is configured
+func (c SignerConfig) previous() (SignerConfig, bool) {
+	prev := c
+	switch c.Backend {
+	case "", SignerBackendLocal:
+		prev.PrivateKey = c.PreviousPrivateKey
+	case SignerBackendKeystore:
+		prev.KeystorePath = c.PreviousKeystorePath
+	case SignerBackendRemote:
+		prev.RemoteAddress = c.PreviousRemoteAddress
+	}
+	return prev, c.PreviousPrivateKey != "" || c.PreviousKeystorePath != "" || c.PreviousRemoteAddress != ""
+}
+
+// NewBrokerSigner creates the signer backend selected by the configuration
+func NewBrokerSigner(conf SignerConfig) (BrokerSigner, error) {
+	var signer BrokerSigner
+	var err error
+	switch conf.Backend {
+	case "", SignerBackendLocal:
+		if conf.PrivateKey == "" {
+			return nil, errors.New("BROKER_PRIVATE_KEY is required for the local signer")
+		}
+		signer, err = NewSigner(conf.PrivateKey)
+	case SignerBackendKeystore:
+		passphrase := conf.KeystorePassword
+		if conf.KeystorePasswordFile != "" {
+			content, readErr := os.ReadFile(conf.KeystorePasswordFile)
+			if readErr != nil {
+				return nil, fmt.Errorf("failed to read keystore password file: %w", readErr)
+			}
+			passphrase = strings.TrimRight(string(content), "\r\n")
+		}
+		signer, err = NewKeystoreSigner(conf.KeystorePath, passphrase)
+	case SignerBackendRemote:
+		signer, err = NewRemoteSigner(conf.RemoteURL, conf.RemoteAddress, conf.RemoteToken, conf.RemoteTimeout)
+	default:
+		return nil, fmt.Errorf("unknown signer backend: %s", conf.Backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Broker signer (%s) initialized with address: %s", conf.Backend, signer.GetAddress().Hex())
+	return signer, nil
+}
+
+// Signer handles signing operations using a private key held in memory
 type Signer struct {
 	privateKey *ecdsa.PrivateKey
 }
@@ -28,10 +127,6 @@ func NewSigner(privateKeyHex string) (*Signer, error) {
 		return nil, err
 	}
 
-	publicKey := privateKey.Public().(*ecdsa.PublicKey)
-
-	log.Printf("Broker signer initialized with address: %s", crypto.PubkeyToAddress(*publicKey).Hex())
-
 	return &Signer{privateKey: privateKey}, nil
 }
 
@@ -65,21 +160,59 @@ func (s *Signer) NitroSign(encodedState []byte) (nitrolite.Signature, error) {
 	}, nil
 }
 
+// SignHash creates an ECDSA signature of a digest
+func (s *Signer) SignHash(hash []byte) ([]byte, error) {
+	return crypto.Sign(hash, s.privateKey)
+}
+
+// NewTransactor returns transaction options signing with the private key
+func (s *Signer) NewTransactor(chainID *big.Int) (*bind.TransactOpts, error) {
+	return bind.NewKeyedTransactorWithChainID(s.privateKey, chainID)
+}
+
 // GetPublicKey returns the public key associated with the signer
 func (s *Signer) GetPublicKey() *ecdsa.PublicKey {
 	return s.privateKey.Public().(*ecdsa.PublicKey)
 }
 
-// GetPrivateKey returns the private key used by the signer
-func (s *Signer) GetPrivateKey() *ecdsa.PrivateKey {
-	return s.privateKey
-}
-
 // GetAddress returns the address derived from the signer's public key
 func (s *Signer) GetAddress() common.Address {
 	return crypto.PubkeyToAddress(*s.GetPublicKey())
 }
 
+// signKeccak signs the Keccak256 hash of data with a digest signing function
+func signKeccak(signHash func([]byte) ([]byte, error), data []byte) ([]byte, error) {
+	return signHash(crypto.Keccak256(data))
+}
+
+// toNitroSignature converts a 65 byte signature to the nitrolite format with V as 27 or 28
+func toNitroSignature(sig []byte) nitrolite.Signature {
+	var nitroSig nitrolite.Signature
+	copy(nitroSig.R[:], sig[0:32])
+	copy(nitroSig.S[:], sig[32:64])
+	nitroSig.V = sig[64] + 27
+	return nitroSig
+}
+
+// hashTransactor returns transaction options for a signer that can only sign digests
+func hashTransactor(address common.Address, chainID *big.Int, signHash func([]byte) ([]byte, error)) *bind.TransactOpts {
+	txSigner := types.LatestSignerForChainID(chainID)
+	return &bind.TransactOpts{
+		From: address,
+		Signer: func(from common.Address, tx *types.Transaction) (*types.Transaction, error) {
+			if from != address {
+				return nil, bind.ErrNotAuthorized
+			}
+			sig, err := signHash(txSigner.Hash(tx).Bytes())
+			if err != nil {
+				return nil, err
+			}
+			return tx.WithSignature(txSigner, sig)
+		},
+		Context: context.Background(),
+	}
+}
+
 // ValidateSignature validates the signature of a message against the provided address
 func ValidateSignature(message []byte, signatureHex, expectedAddrHex string) (bool, error) {
 	recoveredHex, err := RecoverAddress(message, signatureHex)
@@ -91,6 +224,11 @@ func ValidateSignature(message []byte, signatureHex, expectedAddrHex string) (bo
 
 // RecoverAddress takes the original message and its hex-encoded signature, and returns the address
 func RecoverAddress(message []byte, signatureHex string) (string, error) {
+	return RecoverAddressFromHash(crypto.Keccak256(message), signatureHex)
+}
+
+// RecoverAddressFromHash takes a signed digest and its hex-encoded signature, and returns the address
+func RecoverAddressFromHash(hash []byte, signatureHex string) (string, error) {
 	sig, err := hexutil.Decode(signatureHex)
 	if err != nil {
 		return "", fmt.Errorf("invalid signature hex: %w", err)
@@ -103,9 +241,7 @@ func RecoverAddress(message []byte, signatureHex string) (string, error) {
 		sig[64] -= 27
 	}
 
-	msgHash := crypto.Keccak256Hash(message)
-
-	pubkey, err := crypto.SigToPub(msgHash.Bytes(), sig)
+	pubkey, err := crypto.SigToPub(hash, sig)
 	if err != nil {
 		return "", fmt.Errorf("signature recovery failed: %w", err)
 	}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/erc7824/go-nitrolite"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testBrokerSigner checks that a signer backend produces signatures of its own address
+func testBrokerSigner(t *testing.T, signer BrokerSigner) {
+	message := []byte("clearnode")
+
+	sig, err := signer.Sign(message)
+	require.NoError(t, err)
+	recovered, err := RecoverAddress(message, hexutil.Encode(sig))
+	require.NoError(t, err)
+	assert.Equal(t, signer.GetAddress().Hex(), recovered)
+
+	nitroSig, err := signer.NitroSign(message)
+	require.NoError(t, err)
+	valid, err := nitrolite.Verify(message, nitroSig, signer.GetAddress())
+	require.NoError(t, err)
+	assert.True(t, valid)
+
+	token, _, err := IssueSessionToken(signer, SessionClaims{Address: "0x1111111111111111111111111111111111111111"}, time.Minute)
+	require.NoError(t, err)
+	_, err = VerifySessionToken(signer.GetAddress(), token)
+	require.NoError(t, err)
+
+	chainID := big.NewInt(137)
+	opts, err := signer.NewTransactor(chainID)
+	require.NoError(t, err)
+	assert.Equal(t, signer.GetAddress(), opts.From)
+
+	tx := types.NewTx(&types.DynamicFeeTx{ChainID: chainID, Nonce: 1, Gas: 21000, GasTipCap: big.NewInt(1), GasFeeCap: big.NewInt(2)})
+	signedTx, err := opts.Signer(opts.From, tx)
+	require.NoError(t, err)
+	sender, err := types.Sender(types.LatestSignerForChainID(chainID), signedTx)
+	require.NoError(t, err)
+	assert.Equal(t, signer.GetAddress(), sender)
+}
+
+func TestLocalSigner(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	signer, err := NewBrokerSigner(SignerConfig{Backend: SignerBackendLocal, PrivateKey: hexutil.Encode(crypto.FromECDSA(key))})
+	require.NoError(t, err)
+	assert.Equal(t, crypto.PubkeyToAddress(key.PublicKey), signer.GetAddress())
+	testBrokerSigner(t, signer)
+
+	_, err = NewBrokerSigner(SignerConfig{Backend: SignerBackendLocal})
+	assert.EqualError(t, err, "BROKER_PRIVATE_KEY is required for the local signer")
+
+	_, err = NewBrokerSigner(SignerConfig{Backend: "kms"})
+	assert.EqualError(t, err, "unknown signer backend: kms")
+}
+
+func TestKeystoreSigner(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	address := crypto.PubkeyToAddress(key.PublicKey)
+
+	keyJSON, err := keystore.EncryptKey(&keystore.Key{Id: uuid.New(), Address: address, PrivateKey: key}, "secret", keystore.LightScryptN, keystore.LightScryptP)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "broker.json")
+	require.NoError(t, os.WriteFile(keyPath, keyJSON, 0600))
+	passwordPath := filepath.Join(dir, "password")
+	require.NoError(t, os.WriteFile(passwordPath, []byte("secret\n"), 0600))
+
+	signer, err := NewBrokerSigner(SignerConfig{Backend: SignerBackendKeystore, KeystorePath: keyPath, KeystorePasswordFile: passwordPath})
+	require.NoError(t, err)
+	assert.Equal(t, address, signer.GetAddress())
+	testBrokerSigner(t, signer)
+
+	_, err = NewKeystoreSigner(keyPath, "wrong")
+	assert.ErrorContains(t, err, "failed to unlock keystore")
+}
+
+func TestRemoteSigner(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	otherKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	// A service implementing the documented wire format, signing digests as is with the key of the address in the path
+	keys := map[string]*ecdsa.PrivateKey{
+		address.Hex(): key,
+		"0x2222222222222222222222222222222222222222": otherKey,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		signingKey, ok := keys[strings.TrimPrefix(r.URL.Path, "/api/v1/sign/")]
+		if r.Method != http.MethodPost || !ok {
+			http.NotFound(w, r)
+			return
+		}
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var req struct {
+			Digest string `json:"digest"`
+		}
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		require.NoError(t, decoder.Decode(&req))
+		digest, err := hexutil.Decode(req.Digest)
+		require.NoError(t, err)
+		require.Len(t, digest, 32)
+
+		sig, err := crypto.Sign(digest, signingKey)
+		require.NoError(t, err)
+		sig[64] += 27
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"signature":"` + hexutil.Encode(sig) + `"}`))
+	}))
+	defer server.Close()
+
+	signer, err := NewBrokerSigner(SignerConfig{Backend: SignerBackendRemote, RemoteURL: server.URL, RemoteAddress: address.Hex(), RemoteToken: "token", RemoteTimeout: time.Second})
+	require.NoError(t, err)
+	testBrokerSigner(t, signer)
+
+	unauthorized, err := NewRemoteSigner(server.URL, address.Hex(), "", time.Second)
+	require.NoError(t, err)
+	_, err = unauthorized.Sign([]byte("clearnode"))
+	assert.ErrorContains(t, err, "remote signer returned status 401")
+
+	// Signatures by another key are rejected
+	mismatched, err := NewRemoteSigner(server.URL, "0x2222222222222222222222222222222222222222", "token", time.Second)
+	require.NoError(t, err)
+	_, err = mismatched.Sign([]byte("clearnode"))
+	assert.EqualError(t, err, "remote signer signature does not match the broker address")
+
+	_, err = NewRemoteSigner(server.URL, "not-an-address", "", time.Second)
+	assert.Error(t, err)
+}
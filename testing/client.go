@@ -106,6 +106,25 @@ func (r CloseAppSignData) MarshalJSON() ([]byte, error) {
 	return json.Marshal(arr)
 }
 
+// SubmitAppStateParams represents parameters needed to update the allocations of an open virtual app
+type SubmitAppStateParams struct {
+	AppSessionID string          `json:"app_session_id"`
+	Version      uint64          `json:"version"`
+	Allocations  []AppAllocation `json:"allocations"`
+}
+
+type SubmitAppStateSignData struct {
+	RequestID uint64
+	Method    string
+	Params    []SubmitAppStateParams
+	Timestamp uint64
+}
+
+func (r SubmitAppStateSignData) MarshalJSON() ([]byte, error) {
+	arr := []interface{}{r.RequestID, r.Method, r.Params, r.Timestamp}
+	return json.Marshal(arr)
+}
+
 // ResizeChannelParams represents parameters needed for resizing a channel
 type ResizeChannelParams struct {
 	ChannelID        string   `json:"channel_id"`
@@ -126,6 +145,29 @@ func (r ResizeChannelSignData) MarshalJSON() ([]byte, error) {
 	return json.Marshal(arr)
 }
 
+// TransferParams represents parameters needed for an off-chain transfer
+type TransferParams struct {
+	Allocations []TransferAllocation `json:"allocations"`
+}
+
+type TransferAllocation struct {
+	Destination string          `json:"destination"`
+	AssetSymbol string          `json:"asset"`
+	Amount      decimal.Decimal `json:"amount"`
+}
+
+type TransferSignData struct {
+	RequestID uint64
+	Method    string
+	Params    []TransferParams
+	Timestamp uint64
+}
+
+func (r TransferSignData) MarshalJSON() ([]byte, error) {
+	arr := []interface{}{r.RequestID, r.Method, r.Params, r.Timestamp}
+	return json.Marshal(arr)
+}
+
 // NewSigner creates a new signer from a hex-encoded private key
 func NewSigner(privateKeyHex string) (*Signer, error) {
 	if len(privateKeyHex) >= 2 && privateKeyHex[:2] == "0x" {
@@ -712,6 +754,31 @@ func main() {
 				log.Fatalf("Error marshaling sign data: %v", err)
 			}
 
+		case "submit_app_state":
+			// Special handling for submit_app_state
+			var submitParams SubmitAppStateParams
+			paramsJSON, err := json.Marshal(rpcData.Params[0])
+			if err != nil {
+				log.Fatalf("Error marshaling submit app state params: %v", err)
+			}
+			if err := json.Unmarshal(paramsJSON, &submitParams); err != nil {
+				log.Fatalf("Error unmarshaling submit app state params: %v", err)
+			}
+
+			// Create the special sign data structure
+			signData := SubmitAppStateSignData{
+				RequestID: rpcData.RequestID,
+				Method:    rpcData.Method,
+				Params:    []SubmitAppStateParams{submitParams},
+				Timestamp: rpcData.Timestamp,
+			}
+
+			// Marshal using the custom MarshalJSON method
+			dataToSign, err = signData.MarshalJSON()
+			if err != nil {
+				log.Fatalf("Error marshaling sign data: %v", err)
+			}
+
 		case "resize_channel":
 			// Special handling for resize_channel
 			var resizeParams ResizeChannelParams
@@ -737,6 +804,31 @@ func main() {
 				log.Fatalf("Error marshaling sign data: %v", err)
 			}
 
+		case "transfer":
+			// Special handling for transfer
+			var transferParams TransferParams
+			paramsJSON, err := json.Marshal(rpcData.Params[0])
+			if err != nil {
+				log.Fatalf("Error marshaling transfer params: %v", err)
+			}
+			if err := json.Unmarshal(paramsJSON, &transferParams); err != nil {
+				log.Fatalf("Error unmarshaling transfer params: %v", err)
+			}
+
+			// Create the special sign data structure
+			signData := TransferSignData{
+				RequestID: rpcData.RequestID,
+				Method:    rpcData.Method,
+				Params:    []TransferParams{transferParams},
+				Timestamp: rpcData.Timestamp,
+			}
+
+			// Marshal using the custom MarshalJSON method
+			dataToSign, err = signData.MarshalJSON()
+			if err != nil {
+				log.Fatalf("Error marshaling sign data: %v", err)
+			}
+
 		default:
 			// Standard marshaling for other methods
 			dataToSign, err = json.Marshal(rpcData)
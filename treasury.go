@@ -0,0 +1,500 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/erc7824/go-nitrolite"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// treasuryCheckTimeout bounds the balance reads and transactions of a treasury check
+const treasuryCheckTimeout = time.Minute
+
+// nativeGasReserve is kept in the broker wallet when depositing the native token, to pay for gas
+var nativeGasReserve = big.NewInt(1e16)
+
+// RebalanceAction is the custody contract call of a rebalancing step
+type RebalanceAction string
+
+var (
+	RebalanceDeposit  RebalanceAction = "deposit"  // Moves funds from the broker wallet into the custody contract
+	RebalanceWithdraw RebalanceAction = "withdraw" // Moves funds from the custody contract back to the broker wallet
+)
+
+// RebalanceStatus is the progress of a rebalancing step
+type RebalanceStatus string
+
+var (
+	RebalancePlanned   RebalanceStatus = "planned"   // Not executed, as auto-execute is disabled
+	RebalanceWaiting   RebalanceStatus = "waiting"   // A previous rebalancing transaction of the chain is still pending
+	RebalanceApproving RebalanceStatus = "approving" // The custody contract was approved to pull the tokens, the deposit follows at the next check
+	RebalanceSent      RebalanceStatus = "sent"
+	RebalanceFailed    RebalanceStatus = "failed"
+)
+
+// TreasuryBackend is the part of an Ethereum client the treasury reads balances with
+type TreasuryBackend interface {
+	bind.ContractCaller
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+}
+
+// TreasuryChain is a running network whose broker liquidity the treasury keeps within the targets of its assets
+type TreasuryChain struct {
+	ChainID   uint32
+	Custody   common.Address
+	Backend   TreasuryBackend
+	TxManager *TxManager
+	Assets    []AssetConfig
+}
+
+// TreasuryAlert is an asset whose broker liquidity is outside of its targets
+type TreasuryAlert struct {
+	ChainID   uint32           `json:"chain_id"`
+	Token     string           `json:"token"`
+	Symbol    string           `json:"symbol"`
+	Alert     string           `json:"alert"` // below_min or above_max
+	Available decimal.Decimal  `json:"available"`
+	Min       *decimal.Decimal `json:"min,omitempty"`
+	Max       *decimal.Decimal `json:"max,omitempty"`
+}
+
+// RebalanceStep moves broker funds between its wallet and the custody contract of a chain
+type RebalanceStep struct {
+	ChainID   uint32          `json:"chain_id"`
+	Token     string          `json:"token"`
+	Symbol    string          `json:"symbol"`
+	Action    RebalanceAction `json:"action"`
+	Amount    decimal.Decimal `json:"amount"`
+	Available decimal.Decimal `json:"available"` // Broker liquidity before the step, less outstanding allocations
+	// Part of the deficit below the minimum the broker wallet cannot cover, to be bridged from another chain
+	Shortfall decimal.Decimal `json:"shortfall"`
+	Status    RebalanceStatus `json:"status"`
+	TxHash    string          `json:"tx_hash,omitempty"`
+	Error     string          `json:"error,omitempty"`
+
+	amount      *big.Int        // Amount in the smallest token unit
+	decimals    int32           // Decimals of the token
+	outstanding decimal.Decimal // Allocations of the chain and token when the step was planned
+}
+
+// TreasuryReport is the result of a treasury check
+type TreasuryReport struct {
+	CheckedAt time.Time       `json:"checked_at"`
+	Alerts    []TreasuryAlert `json:"alerts"`
+	Plan      []RebalanceStep `json:"plan"`
+}
+
+// Treasury tracks the available custody balance of the current broker key on every chain against the
+// min_liquidity and max_liquidity targets of the configured assets. Funds allocated to channels by signed
+// states that have not landed yet are not counted as liquidity, so they are never withdrawn. Liquidity outside of the targets raises
+// an alert and a rebalancing step towards the middle of the targets: a deposit from the broker wallet when
+// below the minimum, a withdrawal to the broker wallet when above the maximum. Funds are not moved between
+// chains; a deficit the wallet cannot cover is reported as a shortfall.
+type Treasury struct {
+	db      *gorm.DB
+	keys    *BrokerKeys
+	metrics *Metrics
+	// chains returns the running networks
+	chains      func() []TreasuryChain
+	autoExecute bool
+
+	checkMu sync.Mutex      // Serializes checks, so that a rebalancing step is not sent twice
+	mu      sync.Mutex      // Guards the alerts and the report, never held across RPC calls
+	alerts  map[string]bool // Raised alerts by chain, token and alert
+	report  TreasuryReport
+}
+
+// NewTreasury creates a treasury of the running networks, sending the rebalancing transactions if autoExecute is set
+func NewTreasury(db *gorm.DB, keys *BrokerKeys, metrics *Metrics, chains func() []TreasuryChain, autoExecute bool) *Treasury {
+	return &Treasury{
+		db:          db,
+		keys:        keys,
+		metrics:     metrics,
+		chains:      chains,
+		autoExecute: autoExecute,
+		alerts:      make(map[string]bool),
+		report:      TreasuryReport{Alerts: []TreasuryAlert{}, Plan: []RebalanceStep{}},
+	}
+}
+
+// Run checks the broker liquidity every interval until ctx is cancelled
+func (t *Treasury) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		t.Check(ctx, t.autoExecute)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Report returns the result of the last check
+func (t *Treasury) Report() TreasuryReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.report
+}
+
+// Check reads the broker liquidity of every asset with targets, updates the alerts and plans the
+// rebalancing steps, which are sent through the transaction manager of the chain if execute is set
+func (t *Treasury) Check(ctx context.Context, execute bool) TreasuryReport {
+	t.checkMu.Lock()
+	defer t.checkMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, treasuryCheckTimeout)
+	defer cancel()
+
+	chains := t.chains()
+	slices.SortFunc(chains, func(a, b TreasuryChain) int { return int(a.ChainID) - int(b.ChainID) })
+
+	report := TreasuryReport{CheckedAt: time.Now(), Alerts: []TreasuryAlert{}, Plan: []RebalanceStep{}}
+	broker := t.keys.Current().GetAddress()
+	for _, chain := range chains {
+		var steps []*RebalanceStep
+		for _, asset := range chain.Assets {
+			alert, step, err := t.checkAsset(ctx, chain, broker, asset)
+			if err != nil {
+				log.Printf("[Treasury] Error checking %s liquidity on chain %d: %v", asset.Symbol, chain.ChainID, err)
+				continue
+			}
+			if alert != nil {
+				report.Alerts = append(report.Alerts, *alert)
+			}
+			if step != nil {
+				steps = append(steps, step)
+			}
+		}
+
+		if len(steps) > 0 && execute {
+			t.execute(ctx, chain, broker, steps)
+		}
+		for _, step := range steps {
+			report.Plan = append(report.Plan, *step)
+		}
+	}
+
+	t.mu.Lock()
+	t.report = report
+	t.mu.Unlock()
+	return report
+}
+
+// checkAsset compares the broker liquidity of an asset with its targets and plans the step bringing it back within them
+func (t *Treasury) checkAsset(ctx context.Context, chain TreasuryChain, broker common.Address, asset AssetConfig) (*TreasuryAlert, *RebalanceStep, error) {
+	minTarget, maxTarget, err := asset.LiquidityTargets()
+	if err != nil {
+		return nil, nil, err
+	}
+	if minTarget == nil && maxTarget == nil {
+		return nil, nil, nil
+	}
+
+	token := common.HexToAddress(asset.Token)
+	custody, err := nitrolite.NewCustodyCaller(chain.Custody, chain.Backend)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to bind custody contract: %w", err)
+	}
+	info, err := custody.GetAccountInfo(&bind.CallOpts{Context: ctx}, broker, token)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get account info: %w", err)
+	}
+
+	toRaw := func(amount *decimal.Decimal) *big.Int {
+		if amount == nil {
+			return nil
+		}
+		return amount.Shift(int32(asset.Decimals)).BigInt()
+	}
+	toDecimal := func(amount *big.Int) decimal.Decimal {
+		return decimal.NewFromBigInt(amount, -int32(asset.Decimals))
+	}
+
+	// Funds allocated by signed states that have not landed yet must stay in the custody contract
+	outstanding, err := OutstandingAllocations(t.db, chain.ChainID, token.Hex(), "")
+	if err != nil {
+		return nil, nil, err
+	}
+	available := new(big.Int).Sub(info.Available, toRaw(&outstanding))
+	if available.Sign() < 0 {
+		available.SetInt64(0)
+	}
+
+	minRaw, maxRaw := toRaw(minTarget), toRaw(maxTarget)
+	belowMin := minRaw != nil && available.Cmp(minRaw) < 0
+	aboveMax := maxRaw != nil && available.Cmp(maxRaw) > 0
+	t.setAlert(chain.ChainID, asset, "below_min", belowMin, toDecimal(available))
+	t.setAlert(chain.ChainID, asset, "above_max", aboveMax, toDecimal(available))
+	if !belowMin && !aboveMax {
+		return nil, nil, nil
+	}
+
+	alert := &TreasuryAlert{
+		ChainID:   chain.ChainID,
+		Token:     token.Hex(),
+		Symbol:    asset.Symbol,
+		Alert:     "below_min",
+		Available: toDecimal(available),
+		Min:       minTarget,
+		Max:       maxTarget,
+	}
+	if aboveMax {
+		alert.Alert = "above_max"
+	}
+
+	var wallet *big.Int
+	if belowMin {
+		if wallet, err = t.walletBalance(ctx, chain, broker, token); err != nil {
+			return alert, nil, err
+		}
+	}
+
+	action, amount, shortfall := planRebalance(available, wallet, minRaw, maxRaw)
+	step := &RebalanceStep{
+		ChainID:   chain.ChainID,
+		Token:     token.Hex(),
+		Symbol:    asset.Symbol,
+		Action:    action,
+		Amount:    toDecimal(amount),
+		Available: toDecimal(available),
+		Shortfall: toDecimal(shortfall),
+		Status:    RebalancePlanned,
+
+		amount:      amount,
+		decimals:    int32(asset.Decimals),
+		outstanding: outstanding,
+	}
+	log.Printf("[Treasury] Planned %s of %s %s on chain %d, %s available, shortfall %s",
+		step.Action, step.Amount, step.Symbol, step.ChainID, step.Available, step.Shortfall)
+	return alert, step, nil
+}
+
+// walletBalance returns the balance of the broker wallet that can be deposited into the custody contract
+func (t *Treasury) walletBalance(ctx context.Context, chain TreasuryChain, broker, token common.Address) (*big.Int, error) {
+	if token != (common.Address{}) {
+		return ReadTokenBalance(ctx, chain.Backend, token, broker)
+	}
+
+	balance, err := chain.Backend.BalanceAt(ctx, broker, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wallet balance: %w", err)
+	}
+	balance.Sub(balance, nativeGasReserve)
+	if balance.Sign() < 0 {
+		return new(big.Int), nil
+	}
+	return balance, nil
+}
+
+// planRebalance returns the step bringing the available liquidity back to the middle of its targets,
+// or to the target that is set. A deposit is limited to the wallet balance, the remaining deficit
+// below the minimum is returned as shortfall.
+func planRebalance(available, wallet, minTarget, maxTarget *big.Int) (RebalanceAction, *big.Int, *big.Int) {
+	target := minTarget
+	if target == nil {
+		target = maxTarget
+	} else if maxTarget != nil {
+		target = new(big.Int).Add(minTarget, maxTarget)
+		target.Div(target, big.NewInt(2))
+	}
+
+	if available.Cmp(target) > 0 {
+		return RebalanceWithdraw, new(big.Int).Sub(available, target), new(big.Int)
+	}
+
+	amount := new(big.Int).Sub(target, available)
+	if amount.Cmp(wallet) > 0 {
+		amount.Set(wallet)
+	}
+	shortfall := new(big.Int).Sub(minTarget, available)
+	shortfall.Sub(shortfall, amount)
+	if shortfall.Sign() < 0 {
+		shortfall.SetInt64(0)
+	}
+	return RebalanceDeposit, amount, shortfall
+}
+
+// execute sends the rebalancing steps of a chain from the current broker key. Nothing is sent while a
+// previous rebalancing transaction of the chain is pending, as the liquidity it reads is not final yet.
+func (t *Treasury) execute(ctx context.Context, chain TreasuryChain, broker common.Address, steps []*RebalanceStep) {
+	pending, err := chain.TxManager.HasPending(TxKindApprove, TxKindDeposit, TxKindWithdraw)
+	if err != nil {
+		log.Printf("[Treasury] Error checking pending transactions on chain %d: %v", chain.ChainID, err)
+		return
+	}
+
+	for _, step := range steps {
+		if pending {
+			step.Status = RebalanceWaiting
+			continue
+		}
+		if step.amount.Sign() == 0 {
+			step.Status = RebalanceFailed
+			step.Error = "the broker wallet has no funds to deposit"
+			continue
+		}
+
+		tx, err := t.send(ctx, chain, broker, step)
+		result := "sent"
+		if err != nil {
+			step.Status = RebalanceFailed
+			step.Error = err.Error()
+			result = "failed"
+			log.Printf("[Treasury] Error sending %s of %s %s on chain %d: %v", step.Action, step.Amount, step.Symbol, chain.ChainID, err)
+		} else {
+			step.TxHash = tx.TxHash
+			log.Printf("[Treasury] Sent %s transaction %s for %s %s on chain %d", tx.Kind, tx.TxHash, step.Amount, step.Symbol, chain.ChainID)
+		}
+		if t.metrics != nil {
+			t.metrics.TreasuryRebalances.With(prometheus.Labels{
+				"network": fmt.Sprintf("%d", chain.ChainID),
+				"token":   step.Token,
+				"action":  string(step.Action),
+				"result":  result,
+			}).Inc()
+		}
+	}
+}
+
+// send sends the custody call of a step. An ERC-20 deposit first approves the custody contract
+// to pull the tokens if its allowance is too small, the deposit is sent at the next check.
+func (t *Treasury) send(ctx context.Context, chain TreasuryChain, broker common.Address, step *RebalanceStep) (*BrokerTransaction, error) {
+	token := common.HexToAddress(step.Token)
+
+	if step.Action == RebalanceWithdraw {
+		// Resizes may have reserved broker funds since the step was planned. The allocations are checked again in the
+		// critical section of the reservations, and a resize reserving funds after the withdrawal is sent sees it pending.
+		unlock := lockAllocations(chain.ChainID, step.Token)
+		defer unlock()
+		if err := t.keepAllocations(chain, step); err != nil {
+			return nil, err
+		}
+
+		data, err := custodyAbi.Pack("withdraw", token, step.amount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to pack withdraw call: %w", err)
+		}
+		step.Status = RebalanceSent
+		return chain.TxManager.Send(ctx, TxKindWithdraw, "", broker, chain.Custody, data)
+	}
+
+	var value *big.Int
+	if token == (common.Address{}) {
+		value = step.amount
+	} else {
+		allowance, err := ReadTokenAllowance(ctx, chain.Backend, token, broker, chain.Custody)
+		if err != nil {
+			return nil, err
+		}
+		if allowance.Cmp(step.amount) < 0 {
+			data, err := erc20Abi.Pack("approve", chain.Custody, step.amount)
+			if err != nil {
+				return nil, fmt.Errorf("failed to pack approve call: %w", err)
+			}
+			step.Status = RebalanceApproving
+			return chain.TxManager.Send(ctx, TxKindApprove, "", broker, token, data)
+		}
+	}
+
+	data, err := custodyAbi.Pack("deposit", token, step.amount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack deposit call: %w", err)
+	}
+	step.Status = RebalanceSent
+	return chain.TxManager.SendValue(ctx, TxKindDeposit, "", broker, chain.Custody, value, data)
+}
+
+// keepAllocations reduces a withdrawal by the broker funds allocated to channels since it was planned
+func (t *Treasury) keepAllocations(chain TreasuryChain, step *RebalanceStep) error {
+	outstanding, err := OutstandingAllocations(t.db, chain.ChainID, step.Token, "")
+	if err != nil {
+		return err
+	}
+	allocated := outstanding.Sub(step.outstanding)
+	if !allocated.IsPositive() {
+		return nil
+	}
+
+	amount := new(big.Int).Sub(step.amount, allocated.Shift(step.decimals).Ceil().BigInt())
+	if amount.Sign() <= 0 {
+		return errors.New("the liquidity above the maximum was allocated to channels")
+	}
+	step.amount = amount
+	step.Amount = decimal.NewFromBigInt(amount, -step.decimals)
+	return nil
+}
+
+// PendingWithdrawals returns the amount of a token that pending withdrawals of a broker key take out of its custody
+// balance on a chain. The custody balance only reflects a withdrawal once it is mined.
+func PendingWithdrawals(db *gorm.DB, chainID uint32, broker common.Address, token string) (*big.Int, error) {
+	var transactions []BrokerTransaction
+	err := db.Where("chain_id = ? AND kind = ? AND status = ? AND from_address = ?", chainID, TxKindWithdraw, TxStatusPending, broker.Hex()).
+		Find(&transactions).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load pending withdrawals: %w", err)
+	}
+
+	total := new(big.Int)
+	for _, tx := range transactions {
+		if len(tx.Data) < 4 {
+			continue
+		}
+		args, err := custodyAbi.Methods["withdraw"].Inputs.Unpack(tx.Data[4:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode withdrawal %s: %w", tx.TxHash, err)
+		}
+		withdrawn, ok := args[0].(common.Address)
+		amount, isAmount := args[1].(*big.Int)
+		if !ok || !isAmount || withdrawn != common.HexToAddress(token) {
+			continue
+		}
+		total.Add(total, amount)
+	}
+	return total, nil
+}
+
+// setAlert publishes whether an alert of an asset is raised and logs when it is raised or cleared
+func (t *Treasury) setAlert(chainID uint32, asset AssetConfig, alert string, raised bool, available decimal.Decimal) {
+	token := common.HexToAddress(asset.Token).Hex()
+	if t.metrics != nil {
+		value := 0.0
+		if raised {
+			value = 1
+		}
+		t.metrics.TreasuryAlerts.With(prometheus.Labels{
+			"network": fmt.Sprintf("%d", chainID),
+			"token":   token,
+			"alert":   alert,
+		}).Set(value)
+	}
+
+	key := fmt.Sprintf("%d:%s:%s", chainID, token, alert)
+	t.mu.Lock()
+	changed := t.alerts[key] != raised
+	t.alerts[key] = raised
+	t.mu.Unlock()
+	if !changed {
+		return
+	}
+	if raised {
+		log.Printf("[Treasury] Alert %s raised for %s on chain %d, %s available (min %q, max %q)",
+			alert, asset.Symbol, chainID, available, asset.MinLiquidity, asset.MaxLiquidity)
+	} else {
+		log.Printf("[Treasury] Alert %s cleared for %s on chain %d, %s available", alert, asset.Symbol, chainID, available)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/erc7824/go-nitrolite"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanRebalance(t *testing.T) {
+	// Liquidity below the minimum is raised to the middle of the targets, as far as the wallet allows
+	action, amount, shortfall := planRebalance(big.NewInt(2), big.NewInt(100), big.NewInt(10), big.NewInt(20))
+	assert.Equal(t, RebalanceDeposit, action)
+	assert.Equal(t, big.NewInt(13), amount)
+	assert.Zero(t, shortfall.Sign())
+
+	action, amount, shortfall = planRebalance(big.NewInt(2), big.NewInt(5), big.NewInt(10), nil)
+	assert.Equal(t, RebalanceDeposit, action)
+	assert.Equal(t, big.NewInt(5), amount)
+	assert.Equal(t, big.NewInt(3), shortfall)
+
+	// Liquidity above the maximum is lowered to the middle of the targets, or to the maximum alone
+	action, amount, _ = planRebalance(big.NewInt(30), nil, big.NewInt(10), big.NewInt(20))
+	assert.Equal(t, RebalanceWithdraw, action)
+	assert.Equal(t, big.NewInt(15), amount)
+
+	action, amount, _ = planRebalance(big.NewInt(30), nil, nil, big.NewInt(20))
+	assert.Equal(t, RebalanceWithdraw, action)
+	assert.Equal(t, big.NewInt(10), amount)
+}
+
+func TestTreasuryRebalancesNativeToken(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	signer := newTestSigner(t)
+	manager, backend := setupTestTxManager(t, db, signer, types.GenesisAlloc{}, DefaultGasPolicy(), nil)
+	client := backend.Client()
+
+	chainID, err := client.ChainID(context.Background())
+	require.NoError(t, err)
+	opts, err := signer.NewTransactor(chainID)
+	require.NoError(t, err)
+	custodyAddr, _, custody, err := nitrolite.DeployCustody(opts, client)
+	require.NoError(t, err)
+	backend.Commit()
+
+	ether := func(amount int64) *big.Int {
+		return new(big.Int).Mul(big.NewInt(amount), big.NewInt(1e18))
+	}
+	available := func() *big.Int {
+		info, err := custody.GetAccountInfo(&bind.CallOpts{}, signer.GetAddress(), common.Address{})
+		require.NoError(t, err)
+		return info.Available
+	}
+	mine := func() {
+		backend.Commit()
+		manager.checkPending(context.Background())
+	}
+
+	chain := TreasuryChain{
+		ChainID:   uint32(chainID.Uint64()),
+		Custody:   custodyAddr,
+		Backend:   client,
+		TxManager: manager,
+		Assets: []AssetConfig{{
+			Token:        common.Address{}.Hex(),
+			Symbol:       "eth",
+			Decimals:     18,
+			MinLiquidity: "10",
+			MaxLiquidity: "20",
+		}},
+	}
+	newTreasury := func(autoExecute bool) *Treasury {
+		return NewTreasury(db, NewBrokerKeys(signer, nil), testMetrics(), func() []TreasuryChain { return []TreasuryChain{chain} }, autoExecute)
+	}
+
+	// Without auto-execute the plan is only reported
+	report := newTreasury(false).Check(context.Background(), false)
+	require.Len(t, report.Alerts, 1)
+	assert.Equal(t, "below_min", report.Alerts[0].Alert)
+	require.Len(t, report.Plan, 1)
+	assert.Equal(t, RebalanceDeposit, report.Plan[0].Action)
+	assert.Equal(t, "15", report.Plan[0].Amount.String())
+	assert.Equal(t, RebalancePlanned, report.Plan[0].Status)
+
+	var count int64
+	require.NoError(t, db.Model(&BrokerTransaction{}).Count(&count).Error)
+	assert.Zero(t, count)
+
+	// The deposit is sent from the broker wallet, nothing more is sent until it is mined
+	treasury := newTreasury(true)
+	report = treasury.Check(context.Background(), true)
+	require.Len(t, report.Plan, 1)
+	assert.Equal(t, RebalanceSent, report.Plan[0].Status)
+	assert.NotEmpty(t, report.Plan[0].TxHash)
+
+	report = treasury.Check(context.Background(), true)
+	require.Len(t, report.Plan, 1)
+	assert.Equal(t, RebalanceWaiting, report.Plan[0].Status)
+
+	mine()
+	assert.Equal(t, ether(15), available())
+
+	var deposit BrokerTransaction
+	require.NoError(t, db.Where("kind = ?", TxKindDeposit).First(&deposit).Error)
+	assert.Equal(t, TxStatusConfirmed, deposit.Status)
+	assert.Equal(t, ether(15).String(), deposit.Value)
+
+	report = treasury.Check(context.Background(), true)
+	assert.Empty(t, report.Alerts)
+	assert.Empty(t, report.Plan)
+	assert.Equal(t, report, treasury.Report())
+
+	// Excess liquidity is withdrawn back to the broker wallet
+	chain.Assets[0].MinLiquidity = "1"
+	chain.Assets[0].MaxLiquidity = "5"
+	report = treasury.Check(context.Background(), true)
+	require.Len(t, report.Alerts, 1)
+	assert.Equal(t, "above_max", report.Alerts[0].Alert)
+	require.Len(t, report.Plan, 1)
+	assert.Equal(t, RebalanceWithdraw, report.Plan[0].Action)
+	assert.Equal(t, "12", report.Plan[0].Amount.String())
+
+	mine()
+	assert.Equal(t, ether(3), available())
+
+	// Funds allocated by a signed state that has not landed yet are not withdrawn
+	channel := Channel{
+		ChannelID:   "0xTreasuryChannel",
+		Participant: "0xParticipant",
+		Status:      ChannelStatusOpen,
+		Token:       common.Address{}.Hex(),
+		ChainID:     chain.ChainID,
+		Adjudicator: "0xAdj",
+	}
+	require.NoError(t, db.Create(&channel).Error)
+	require.NoError(t, db.Create(&PendingSettlement{
+		ChannelID:   channel.ChannelID,
+		Participant: channel.Participant,
+		AssetSymbol: "eth",
+		Allocated:   decimal.NewFromInt(2),
+		ExpiresAt:   time.Now().Add(time.Hour),
+	}).Error)
+
+	chain.Assets[0].MaxLiquidity = "2"
+	report = treasury.Check(context.Background(), true)
+	assert.Empty(t, report.Alerts)
+	assert.Empty(t, report.Plan)
+
+	// Funds reserved between planning and sending a withdrawal are kept as well
+	chain.Assets[0].MinLiquidity = "0.1"
+	chain.Assets[0].MaxLiquidity = "0.5"
+	_, step, err := treasury.checkAsset(context.Background(), chain, signer.GetAddress(), chain.Assets[0])
+	require.NoError(t, err)
+	require.NotNil(t, step)
+	assert.Equal(t, "0.7", step.Amount.String())
+
+	require.NoError(t, ReserveAllocation(db, channel.ChannelID, decimal.RequireFromString("2.5")))
+	treasury.execute(context.Background(), chain, signer.GetAddress(), []*RebalanceStep{step})
+	assert.Equal(t, RebalanceSent, step.Status)
+	assert.Equal(t, "0.2", step.Amount.String())
+
+	// The pending withdrawal is not available to allocations until it is mined
+	withdrawing, err := PendingWithdrawals(db, chain.ChainID, signer.GetAddress(), common.Address{}.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, "200000000000000000", withdrawing.String())
+
+	mine()
+	assert.Equal(t, "2800000000000000000", available().String())
+	withdrawing, err = PendingWithdrawals(db, chain.ChainID, signer.GetAddress(), common.Address{}.Hex())
+	require.NoError(t, err)
+	assert.Zero(t, withdrawing.Sign())
+}
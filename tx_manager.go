@@ -0,0 +1,554 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/txpool"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+const (
+	// txMonitorInterval is how often pending broker transactions are checked for receipts
+	txMonitorInterval = 15 * time.Second
+	// txFeeBumpPercent is how much the fees of a stuck transaction are raised when it is replaced.
+	// Nodes only accept a replacement paying at least 10% more than the transaction it replaces.
+	txFeeBumpPercent = 20
+)
+
+// TxKind identifies the custody contract call made by a broker transaction
+type TxKind string
+
+var (
+	TxKindJoin       TxKind = "join"
+	TxKindCheckpoint TxKind = "checkpoint"
+	TxKindApprove    TxKind = "approve"
+	TxKindDeposit    TxKind = "deposit"
+	TxKindWithdraw   TxKind = "withdraw"
+)
+
+// TxStatus represents the state of a broker transaction
+type TxStatus string
+
+var (
+	TxStatusPending   TxStatus = "pending"
+	TxStatusConfirmed TxStatus = "confirmed"
+	TxStatusFailed    TxStatus = "failed"
+)
+
+// BrokerTransaction is a transaction sent by the broker. It is persisted before it is broadcast,
+// so that transactions still pending after a restart keep being tracked and their nonces are not reused.
+type BrokerTransaction struct {
+	ID             uint     `gorm:"primaryKey"`
+	ChainID        uint32   `gorm:"column:chain_id;not null;index:idx_broker_transactions_status"`
+	Kind           TxKind   `gorm:"column:kind;not null"`
+	ChannelID      string   `gorm:"column:channel_id;not null;default:'';index"`
+	From           string   `gorm:"column:from_address;not null"`
+	To             string   `gorm:"column:to_address;not null"`
+	Data           []byte   `gorm:"column:data"`
+	Value          string   `gorm:"column:value;not null;default:''"` // Wei sent with the call, empty if none
+	Nonce          uint64   `gorm:"column:nonce;not null"`
+	GasLimit       uint64   `gorm:"column:gas_limit;not null"`
+	GasPrice       string   `gorm:"column:gas_price;not null"`              // Gas price, or max fee per gas of EIP-1559 transactions, in wei
+	GasTipCap      string   `gorm:"column:gas_tip_cap;not null;default:''"` // Max priority fee per gas in wei, empty for legacy transactions
+	TxHash         string   `gorm:"column:tx_hash;not null;index"`
+	ReplacedHashes string   `gorm:"column:replaced_hashes;not null;default:''"` // Comma separated hashes of the transactions this one replaced
+	Resubmits      int      `gorm:"column:resubmits;not null;default:0"`
+	Status         TxStatus `gorm:"column:status;not null;index:idx_broker_transactions_status"`
+	Error          string   `gorm:"column:error;not null;default:''"`
+	BlockNumber    uint64   `gorm:"column:block_number;not null;default:0"`
+	SentAt         time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// TableName specifies the table name for the BrokerTransaction model
+func (BrokerTransaction) TableName() string {
+	return "broker_transactions"
+}
+
+// hashes returns the hash of the transaction followed by the hashes of the transactions it replaced
+func (t *BrokerTransaction) hashes() []common.Hash {
+	hashes := []common.Hash{common.HexToHash(t.TxHash)}
+	if t.ReplacedHashes == "" {
+		return hashes
+	}
+	for _, hash := range strings.Split(t.ReplacedHashes, ",") {
+		hashes = append(hashes, common.HexToHash(hash))
+	}
+	return hashes
+}
+
+// value returns the wei sent with the call
+func (t *BrokerTransaction) value() (*big.Int, error) {
+	if t.Value == "" {
+		return new(big.Int), nil
+	}
+	value, ok := new(big.Int).SetString(t.Value, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid value %q", t.Value)
+	}
+	return value, nil
+}
+
+// fees returns the fees the transaction was last sent with
+func (t *BrokerTransaction) fees() (TxFees, error) {
+	feeCap, ok := new(big.Int).SetString(t.GasPrice, 10)
+	if !ok {
+		return TxFees{}, fmt.Errorf("invalid gas price %q", t.GasPrice)
+	}
+	fees := TxFees{FeeCap: feeCap}
+	if t.GasTipCap != "" {
+		if fees.TipCap, ok = new(big.Int).SetString(t.GasTipCap, 10); !ok {
+			return TxFees{}, fmt.Errorf("invalid gas tip cap %q", t.GasTipCap)
+		}
+	}
+	return fees, nil
+}
+
+// setFees records the fees the transaction is sent with
+func (t *BrokerTransaction) setFees(fees TxFees) {
+	t.GasPrice = fees.FeeCap.String()
+	t.GasTipCap = ""
+	if fees.TipCap != nil {
+		t.GasTipCap = fees.TipCap.String()
+	}
+}
+
+// TxBackend is the part of an Ethereum client used to send and track broker transactions
+type TxBackend interface {
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	SuggestGasTipCap(ctx context.Context) (*big.Int, error)
+	EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error)
+	SendTransaction(ctx context.Context, tx *types.Transaction) error
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+}
+
+// TxManagerConfig controls how long broker transactions may stay unmined before they are replaced
+type TxManagerConfig struct {
+	ResubmitTimeout time.Duration // Time after which an unmined transaction is replaced with higher fees
+	MaxResubmits    int           // Number of fee bumps after which the transaction is only rebroadcast
+}
+
+// TxManager sends the broker transactions of a chain. It assigns nonces per broker key so that
+// concurrent calls never race, waits for receipts and replaces stuck transactions with higher fees.
+type TxManager struct {
+	client      TxBackend
+	db          *gorm.DB
+	chainID     uint32
+	transactors map[common.Address]*bind.TransactOpts // Broker key address -> transaction signer
+	conf        TxManagerConfig
+	policy      GasPolicy
+	metrics     *Metrics
+	onResult    func(BrokerTransaction)
+
+	mu     sync.Mutex
+	nonces map[common.Address]uint64 // Next nonce of each broker key
+}
+
+// NewTxManager creates the transaction manager of a chain sending from every broker key and pricing
+// transactions with the gas policy of the network. onResult is called once a transaction is confirmed or failed on-chain.
+func NewTxManager(client TxBackend, db *gorm.DB, chainID *big.Int, keys *BrokerKeys, conf TxManagerConfig, policy GasPolicy, metrics *Metrics, onResult func(BrokerTransaction)) (*TxManager, error) {
+	transactors := make(map[common.Address]*bind.TransactOpts)
+	for _, signer := range keys.All() {
+		opts, err := signer.NewTransactor(chainID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create transaction signer: %w", err)
+		}
+		transactors[signer.GetAddress()] = opts
+	}
+
+	return &TxManager{
+		client:      client,
+		db:          db,
+		chainID:     uint32(chainID.Int64()),
+		transactors: transactors,
+		conf:        conf,
+		policy:      policy,
+		metrics:     metrics,
+		onResult:    onResult,
+		nonces:      make(map[common.Address]uint64),
+	}, nil
+}
+
+// Send signs a call to a contract with the next nonce of the broker key, persists it and broadcasts it.
+// A transaction that could not be broadcast is stored as failed and its nonce is reused.
+func (m *TxManager) Send(ctx context.Context, kind TxKind, channelID string, from, to common.Address, data []byte) (*BrokerTransaction, error) {
+	return m.SendValue(ctx, kind, channelID, from, to, nil, data)
+}
+
+// SendValue is like Send, additionally sending value wei with the call
+func (m *TxManager) SendValue(ctx context.Context, kind TxKind, channelID string, from, to common.Address, value *big.Int, data []byte) (*BrokerTransaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.transactors[from]; !ok {
+		return nil, fmt.Errorf("no transaction signer for broker key %s", from.Hex())
+	}
+
+	gasLimit, err := m.policy.EstimateGasLimit(ctx, m.client, from, to, value, data)
+	if err != nil {
+		return nil, err
+	}
+	fees, err := m.policy.SuggestFees(ctx, m.client)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		nonce, err := m.nextNonce(ctx, from)
+		if err != nil {
+			return nil, err
+		}
+
+		record := &BrokerTransaction{
+			ChainID:   m.chainID,
+			Kind:      kind,
+			ChannelID: channelID,
+			From:      from.Hex(),
+			To:        to.Hex(),
+			Data:      data,
+			Nonce:     nonce,
+			GasLimit:  gasLimit,
+			Status:    TxStatusPending,
+			SentAt:    time.Now(),
+		}
+		if value != nil && value.Sign() > 0 {
+			record.Value = value.String()
+		}
+		record.setFees(fees)
+		tx, err := m.sign(record, fees)
+		if err != nil {
+			return nil, err
+		}
+		record.TxHash = tx.Hash().Hex()
+		if err := m.db.Create(record).Error; err != nil {
+			return nil, fmt.Errorf("failed to store transaction: %w", err)
+		}
+
+		sendErr := m.client.SendTransaction(ctx, tx)
+		if sendErr == nil {
+			m.nonces[from] = nonce + 1
+			m.recordFees(fees)
+			log.Printf("[TxManager] Sent %s transaction %s with nonce %d on chain %d", kind, record.TxHash, nonce, m.chainID)
+			return record, nil
+		}
+
+		record.Status = TxStatusFailed
+		record.Error = sendErr.Error()
+		if err := m.db.Save(record).Error; err != nil {
+			log.Printf("[TxManager] Error storing failed transaction %s: %v", record.TxHash, err)
+		}
+		m.recordResult(record, nil)
+
+		// The cached nonce is stale if the key was used outside the node, resync it once
+		if attempt == 0 && strings.Contains(sendErr.Error(), core.ErrNonceTooLow.Error()) {
+			delete(m.nonces, from)
+			continue
+		}
+		return record, fmt.Errorf("failed to send %s transaction: %w", kind, sendErr)
+	}
+}
+
+// HasPending reports whether a transaction of one of the kinds is still pending on the chain
+func (m *TxManager) HasPending(kinds ...TxKind) (bool, error) {
+	var count int64
+	err := m.db.Model(&BrokerTransaction{}).
+		Where("chain_id = ? AND status = ? AND kind IN ?", m.chainID, TxStatusPending, kinds).
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to count pending transactions: %w", err)
+	}
+	return count > 0, nil
+}
+
+// nextNonce returns the next nonce of a broker key, loading it from the node and the
+// transactions still pending in the database the first time the key is used
+func (m *TxManager) nextNonce(ctx context.Context, from common.Address) (uint64, error) {
+	if nonce, ok := m.nonces[from]; ok {
+		return nonce, nil
+	}
+
+	nonce, err := m.client.PendingNonceAt(ctx, from)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get pending nonce: %w", err)
+	}
+
+	// Pending transactions sent before a restart may no longer be in the node's pool
+	var last BrokerTransaction
+	err = m.db.Where("chain_id = ? AND from_address = ? AND status = ?", m.chainID, from.Hex(), TxStatusPending).
+		Order("nonce DESC").
+		First(&last).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, fmt.Errorf("failed to get pending transactions: %w", err)
+	}
+	if err == nil && last.Nonce >= nonce {
+		nonce = last.Nonce + 1
+	}
+
+	m.nonces[from] = nonce
+	return nonce, nil
+}
+
+// sign signs the transaction described by record with the given fees,
+// as an EIP-1559 transaction if the fees have a tip cap
+func (m *TxManager) sign(record *BrokerTransaction, fees TxFees) (*types.Transaction, error) {
+	from := common.HexToAddress(record.From)
+	opts, ok := m.transactors[from]
+	if !ok {
+		return nil, fmt.Errorf("no transaction signer for broker key %s", record.From)
+	}
+
+	value, err := record.value()
+	if err != nil {
+		return nil, err
+	}
+
+	to := common.HexToAddress(record.To)
+	var txData types.TxData = &types.LegacyTx{
+		Nonce:    record.Nonce,
+		GasPrice: fees.FeeCap,
+		Gas:      record.GasLimit,
+		To:       &to,
+		Value:    value,
+		Data:     record.Data,
+	}
+	if fees.TipCap != nil {
+		txData = &types.DynamicFeeTx{
+			ChainID:   new(big.Int).SetUint64(uint64(m.chainID)),
+			Nonce:     record.Nonce,
+			GasFeeCap: fees.FeeCap,
+			GasTipCap: fees.TipCap,
+			Gas:       record.GasLimit,
+			To:        &to,
+			Value:     value,
+			Data:      record.Data,
+		}
+	}
+	tx, err := opts.Signer(from, types.NewTx(txData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign transaction: %w", err)
+	}
+	return tx, nil
+}
+
+// MonitorPending periodically checks the pending transactions of the chain until ctx is cancelled
+func (m *TxManager) MonitorPending(ctx context.Context) {
+	ticker := time.NewTicker(txMonitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.checkPending(ctx)
+		}
+	}
+}
+
+// checkPending records the receipts of mined transactions and replaces the ones that are stuck
+func (m *TxManager) checkPending(ctx context.Context) {
+	var pending []BrokerTransaction
+	if err := m.db.Where("chain_id = ? AND status = ?", m.chainID, TxStatusPending).Order("nonce").Find(&pending).Error; err != nil {
+		log.Printf("[TxManager] Error loading pending transactions on chain %d: %v", m.chainID, err)
+		return
+	}
+
+	for i := range pending {
+		if err := m.checkTransaction(ctx, &pending[i]); err != nil {
+			log.Printf("[TxManager] Error checking transaction %s on chain %d: %v", pending[i].TxHash, m.chainID, err)
+		}
+	}
+}
+
+// checkTransaction looks for a receipt of the transaction or any transaction it replaced
+func (m *TxManager) checkTransaction(ctx context.Context, record *BrokerTransaction) error {
+	// The nonce is read before the receipts, so a used nonce without a receipt was taken by another transaction
+	minedNonce, err := m.client.NonceAt(ctx, common.HexToAddress(record.From), nil)
+	if err != nil {
+		return fmt.Errorf("failed to get nonce: %w", err)
+	}
+
+	indeterminate := false
+	for _, hash := range record.hashes() {
+		receipt, err := m.client.TransactionReceipt(ctx, hash)
+		if errors.Is(err, ethereum.NotFound) {
+			continue
+		}
+		// Nodes still indexing transactions cannot tell whether the transaction was mined yet
+		if err != nil && strings.Contains(err.Error(), "transaction indexing is in progress") {
+			indeterminate = true
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get receipt: %w", err)
+		}
+		return m.finalize(record, receipt)
+	}
+
+	if minedNonce > record.Nonce {
+		// One of the hashes may have been mined, so the transaction is only failed once every lookup is definite
+		if indeterminate {
+			return nil
+		}
+		return m.fail(record, "nonce was used by another transaction")
+	}
+
+	if time.Since(record.SentAt) < m.conf.ResubmitTimeout {
+		return nil
+	}
+	return m.resubmit(ctx, record)
+}
+
+// finalize stores the outcome of a mined transaction
+func (m *TxManager) finalize(record *BrokerTransaction, receipt *types.Receipt) error {
+	if receipt.TxHash.Hex() != record.TxHash {
+		// One of the replaced transactions was mined
+		replaced := removeHash(strings.Split(record.ReplacedHashes, ","), receipt.TxHash.Hex())
+		record.ReplacedHashes = strings.Join(append(replaced, record.TxHash), ",")
+		record.TxHash = receipt.TxHash.Hex()
+	}
+	record.BlockNumber = receipt.BlockNumber.Uint64()
+	m.recordResult(record, receipt)
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return m.fail(record, "transaction reverted")
+	}
+
+	record.Status = TxStatusConfirmed
+	if err := m.db.Save(record).Error; err != nil {
+		return fmt.Errorf("failed to store transaction: %w", err)
+	}
+	log.Printf("[TxManager] %s transaction %s confirmed in block %d on chain %d", record.Kind, record.TxHash, record.BlockNumber, m.chainID)
+
+	if m.onResult != nil {
+		m.onResult(*record)
+	}
+	return nil
+}
+
+// fail stores a transaction that will never succeed
+func (m *TxManager) fail(record *BrokerTransaction, reason string) error {
+	if record.BlockNumber == 0 {
+		m.recordResult(record, nil)
+	}
+	record.Status = TxStatusFailed
+	record.Error = reason
+	if err := m.db.Save(record).Error; err != nil {
+		return fmt.Errorf("failed to store transaction: %w", err)
+	}
+	log.Printf("[TxManager] %s transaction %s failed on chain %d: %s", record.Kind, record.TxHash, m.chainID, reason)
+
+	if m.onResult != nil {
+		m.onResult(*record)
+	}
+	return nil
+}
+
+// resubmit replaces a stuck transaction with one paying higher fees, keeping its nonce.
+// Once the maximum number of fee bumps or the fee cap is reached the transaction is only broadcast again.
+func (m *TxManager) resubmit(ctx context.Context, record *BrokerTransaction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fees, err := record.fees()
+	if err != nil {
+		return err
+	}
+	bumped := false
+	if record.Resubmits < m.conf.MaxResubmits {
+		fees, bumped = m.policy.BumpFees(ctx, m.client, fees)
+	}
+
+	tx, err := m.sign(record, fees)
+	if err != nil {
+		return err
+	}
+	if err := m.client.SendTransaction(ctx, tx); err != nil && !strings.Contains(err.Error(), txpool.ErrAlreadyKnown.Error()) {
+		return fmt.Errorf("failed to resubmit transaction: %w", err)
+	}
+
+	if tx.Hash().Hex() != record.TxHash {
+		if record.ReplacedHashes != "" {
+			record.ReplacedHashes += ","
+		}
+		record.ReplacedHashes += record.TxHash
+		record.TxHash = tx.Hash().Hex()
+		log.Printf("[TxManager] Replaced stuck %s transaction with %s at fee cap %s on chain %d", record.Kind, record.TxHash, fees.FeeCap, m.chainID)
+	}
+	if bumped {
+		record.Resubmits++
+		m.recordFees(fees)
+	}
+	record.setFees(fees)
+	record.SentAt = time.Now()
+	if err := m.db.Save(record).Error; err != nil {
+		return fmt.Errorf("failed to store transaction: %w", err)
+	}
+	return nil
+}
+
+// recordFees updates the fee metrics of the network with the fees of a sent transaction
+func (m *TxManager) recordFees(fees TxFees) {
+	if m.metrics == nil {
+		return
+	}
+	network := fmt.Sprintf("%d", m.chainID)
+	m.metrics.BrokerTxFeeCap.With(prometheus.Labels{"network": network}).Set(weiToGwei(fees.FeeCap))
+	if fees.TipCap != nil {
+		m.metrics.BrokerTxTipCap.With(prometheus.Labels{"network": network}).Set(weiToGwei(fees.TipCap))
+	}
+}
+
+// recordResult counts a finished transaction and the fees it paid, receipt is nil if it was never mined
+func (m *TxManager) recordResult(record *BrokerTransaction, receipt *types.Receipt) {
+	if m.metrics == nil {
+		return
+	}
+	network := fmt.Sprintf("%d", m.chainID)
+	status := TxStatusFailed
+	if receipt != nil && receipt.Status == types.ReceiptStatusSuccessful {
+		status = TxStatusConfirmed
+	}
+	m.metrics.BrokerTransactions.With(prometheus.Labels{"network": network, "kind": string(record.Kind), "status": string(status)}).Inc()
+	if receipt == nil || receipt.EffectiveGasPrice == nil {
+		return
+	}
+
+	// Reverted transactions pay for their gas too
+	fee := new(big.Int).Mul(new(big.Int).SetUint64(receipt.GasUsed), receipt.EffectiveGasPrice)
+	m.metrics.BrokerTxFeesSpent.With(prometheus.Labels{"network": network}).Add(weiToGwei(fee))
+	m.metrics.BrokerTxGasUsed.With(prometheus.Labels{"network": network}).Add(float64(receipt.GasUsed))
+}
+
+// weiToGwei converts a wei amount to gwei
+func weiToGwei(wei *big.Int) float64 {
+	gwei, _ := new(big.Float).Quo(new(big.Float).SetInt(wei), big.NewFloat(1e9)).Float64()
+	return gwei
+}
+
+// removeHash returns hashes without the given hash
+func removeHash(hashes []string, hash string) []string {
+	result := make([]string, 0, len(hashes))
+	for _, h := range hashes {
+		if h != hash && h != "" {
+			result = append(result, h)
+		}
+	}
+	return result
+}
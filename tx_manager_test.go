@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/erc7824/go-nitrolite"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// revertingCode is the runtime code of a contract reverting calls with a non-zero gas price,
+// so that gas estimation succeeds while the mined transaction fails
+var revertingCode = common.FromHex("0x3a15600957600080fd5b00")
+
+// setupTestTxManager creates a transaction manager on a simulated chain where the signer is funded
+func setupTestTxManager(t *testing.T, db *gorm.DB, signer BrokerSigner, alloc types.GenesisAlloc, policy GasPolicy, onResult func(BrokerTransaction)) (*TxManager, *simulated.Backend) {
+	alloc[signer.GetAddress()] = types.Account{Balance: new(big.Int).Mul(big.NewInt(1000), big.NewInt(1e18))}
+	backend := simulated.NewBackend(alloc)
+	t.Cleanup(func() { backend.Close() })
+
+	chainID, err := backend.Client().ChainID(context.Background())
+	require.NoError(t, err)
+	manager, err := NewTxManager(backend.Client(), db, chainID, NewBrokerKeys(signer, nil), TxManagerConfig{ResubmitTimeout: time.Minute, MaxResubmits: 1}, policy, testMetrics(), onResult)
+	require.NoError(t, err)
+	return manager, backend
+}
+
+func TestTxManagerSend(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	signer := newTestSigner(t)
+	var mu sync.Mutex
+	var results []BrokerTransaction
+	manager, backend := setupTestTxManager(t, db, signer, types.GenesisAlloc{}, DefaultGasPolicy(), func(tx BrokerTransaction) {
+		mu.Lock()
+		defer mu.Unlock()
+		results = append(results, tx)
+	})
+
+	// Concurrent sends get consecutive nonces
+	to := common.HexToAddress("0x00000000000000000000000000000000000c0de1")
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := manager.Send(context.Background(), TxKindCheckpoint, "0xChannel", signer.GetAddress(), to, nil)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	var transactions []BrokerTransaction
+	require.NoError(t, db.Order("nonce").Find(&transactions).Error)
+	require.Len(t, transactions, 5)
+	for i, tx := range transactions {
+		assert.Equal(t, uint64(i), tx.Nonce)
+		assert.Equal(t, TxStatusPending, tx.Status)
+	}
+
+	// Nothing happens until the transactions are mined
+	manager.checkPending(context.Background())
+	assert.Empty(t, results)
+
+	backend.Commit()
+	manager.checkPending(context.Background())
+
+	require.NoError(t, db.Order("nonce").Find(&transactions).Error)
+	for _, tx := range transactions {
+		assert.Equal(t, TxStatusConfirmed, tx.Status)
+		assert.NotZero(t, tx.BlockNumber)
+	}
+	assert.Len(t, results, 5)
+
+	// A key without a transaction signer is rejected
+	_, err := manager.Send(context.Background(), TxKindJoin, "0xChannel", common.HexToAddress("0x01"), to, nil)
+	assert.EqualError(t, err, "no transaction signer for broker key 0x0000000000000000000000000000000000000001")
+}
+
+func TestTxManagerReplacesStuckTransaction(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	signer := newTestSigner(t)
+	manager, backend := setupTestTxManager(t, db, signer, types.GenesisAlloc{}, DefaultGasPolicy(), nil)
+
+	to := common.HexToAddress("0x00000000000000000000000000000000000c0de1")
+	sent, err := manager.Send(context.Background(), TxKindJoin, "0xChannel", signer.GetAddress(), to, nil)
+	require.NoError(t, err)
+
+	stuck := func() {
+		require.NoError(t, db.Model(&BrokerTransaction{}).Where("id = ?", sent.ID).Update("sent_at", time.Now().Add(-time.Hour)).Error)
+		manager.checkPending(context.Background())
+	}
+
+	// A transaction pending for longer than the resubmit timeout is replaced with a higher gas price
+	stuck()
+	var replaced BrokerTransaction
+	require.NoError(t, db.First(&replaced, sent.ID).Error)
+	assert.NotEqual(t, sent.TxHash, replaced.TxHash)
+	assert.Equal(t, sent.TxHash, replaced.ReplacedHashes)
+	assert.Equal(t, sent.Nonce, replaced.Nonce)
+	assert.Equal(t, 1, replaced.Resubmits)
+	sentPrice, _ := new(big.Int).SetString(sent.GasPrice, 10)
+	replacedPrice, _ := new(big.Int).SetString(replaced.GasPrice, 10)
+	assert.True(t, replacedPrice.Cmp(sentPrice) > 0)
+
+	// After the last fee bump the transaction is only broadcast again
+	stuck()
+	var rebroadcast BrokerTransaction
+	require.NoError(t, db.First(&rebroadcast, sent.ID).Error)
+	assert.Equal(t, replaced.TxHash, rebroadcast.TxHash)
+	assert.Equal(t, replaced.GasPrice, rebroadcast.GasPrice)
+	assert.Equal(t, 1, rebroadcast.Resubmits)
+
+	backend.Commit()
+	manager.checkPending(context.Background())
+
+	var confirmed BrokerTransaction
+	require.NoError(t, db.First(&confirmed, sent.ID).Error)
+	assert.Equal(t, TxStatusConfirmed, confirmed.Status)
+	assert.Equal(t, replaced.TxHash, confirmed.TxHash)
+}
+
+// indexingBackend answers receipt lookups like a node that is still indexing transactions
+type indexingBackend struct {
+	TxBackend
+}
+
+func (b indexingBackend) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	return nil, errors.New("transaction indexing is in progress")
+}
+
+func TestTxManagerWaitsForIndexing(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	signer := newTestSigner(t)
+	manager, backend := setupTestTxManager(t, db, signer, types.GenesisAlloc{}, DefaultGasPolicy(), nil)
+
+	to := common.HexToAddress("0x00000000000000000000000000000000000c0de1")
+	sent, err := manager.Send(context.Background(), TxKindJoin, "0xChannel", signer.GetAddress(), to, nil)
+	require.NoError(t, err)
+	backend.Commit()
+	require.NoError(t, db.Model(&BrokerTransaction{}).Where("id = ?", sent.ID).Update("sent_at", time.Now().Add(-time.Hour)).Error)
+
+	// The nonce is used, but without a receipt the transaction is neither failed nor replaced
+	client := manager.client
+	manager.client = indexingBackend{TxBackend: client}
+	manager.checkPending(context.Background())
+
+	var pending BrokerTransaction
+	require.NoError(t, db.First(&pending, sent.ID).Error)
+	assert.Equal(t, TxStatusPending, pending.Status)
+	assert.Equal(t, sent.TxHash, pending.TxHash)
+	assert.Zero(t, pending.Resubmits)
+
+	manager.client = client
+	manager.checkPending(context.Background())
+
+	var confirmed BrokerTransaction
+	require.NoError(t, db.First(&confirmed, sent.ID).Error)
+	assert.Equal(t, TxStatusConfirmed, confirmed.Status)
+}
+
+func TestTxManagerSendFailure(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	signer := newTestSigner(t)
+	manager, _ := setupTestTxManager(t, db, signer, types.GenesisAlloc{}, DefaultGasPolicy(), nil)
+
+	// Transactions of a key that cannot pay for gas are rejected by the node
+	unfunded := newTestSigner(t)
+	manager.transactors[unfunded.GetAddress()], _ = unfunded.NewTransactor(big.NewInt(1337))
+
+	to := common.HexToAddress("0x00000000000000000000000000000000000c0de1")
+	record, err := manager.Send(context.Background(), TxKindJoin, "0xChannel", unfunded.GetAddress(), to, nil)
+	require.Error(t, err)
+	assert.Equal(t, TxStatusFailed, record.Status)
+
+	// The nonce of a failed send is reused
+	var stored BrokerTransaction
+	require.NoError(t, db.First(&stored, record.ID).Error)
+	assert.Equal(t, TxStatusFailed, stored.Status)
+	assert.NotEmpty(t, stored.Error)
+	assert.Equal(t, uint64(0), manager.nonces[unfunded.GetAddress()])
+}
+
+func TestCustodyDefendsAgainAfterFailedCheckpoint(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	signer := newTestSigner(t)
+	c := newTestCustody(t, db, 1337)
+	c.keys = NewBrokerKeys(signer, nil)
+
+	// The custody contract reverts the checkpoint call
+	manager, backend := setupTestTxManager(t, db, signer, types.GenesisAlloc{c.custodyAddr: {Code: revertingCode, Balance: big.NewInt(0)}}, DefaultGasPolicy(), c.handleTransactionResult)
+	c.txManager = manager
+
+	channel := Channel{
+		ChannelID:   common.HexToHash("0xabca").Hex(),
+		ChainID:     1337,
+		Participant: "0x1111111111111111111111111111111111111111",
+		Status:      ChannelStatusChallenged,
+		BrokerKeyID: signer.GetAddress().Hex(),
+	}
+	require.NoError(t, db.Create(&channel).Error)
+	_, err := SaveChannelState(db, channel.ChannelID, nitrolite.IntentOPERATE, 1, []byte{}, []nitrolite.Allocation{}, nitrolite.Signature{V: 27}, &nitrolite.Signature{V: 28})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	checkpoints := func() int64 {
+		var count int64
+		require.NoError(t, db.Model(&BrokerTransaction{}).Where("kind = ? AND channel_id = ?", TxKindCheckpoint, channel.ChannelID).Count(&count).Error)
+		return count
+	}
+
+	c.defendChallenge(ctx, channel.ChannelID, time.Now().Add(time.Hour))
+	assert.Equal(t, int64(1), checkpoints())
+
+	// The first checkpoint reverts, the still challenged channel is checkpointed again
+	backend.Commit()
+	manager.checkPending(context.Background())
+	assert.Eventually(t, func() bool { return checkpoints() == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	// Once the channel is no longer challenged a failed checkpoint is not sent again
+	require.NoError(t, db.Model(&Channel{}).Where("channel_id = ?", channel.ChannelID).Update("status", ChannelStatusOpen).Error)
+	backend.Commit()
+	manager.checkPending(context.Background())
+	assert.Never(t, func() bool { return checkpoints() > 2 }, 200*time.Millisecond, 10*time.Millisecond)
+}
+
+func TestCustodyJoinFailed(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	signer := newTestSigner(t)
+	c := newTestCustody(t, db, 1337)
+	c.keys = NewBrokerKeys(signer, nil)
+	var updates []Channel
+	c.sendChannelUpdate = func(channel Channel) { updates = append(updates, channel) }
+
+	// The custody contract reverts the join call
+	manager, backend := setupTestTxManager(t, db, signer, types.GenesisAlloc{c.custodyAddr: {Code: revertingCode, Balance: big.NewInt(0)}}, DefaultGasPolicy(), c.handleTransactionResult)
+	c.txManager = manager
+
+	channel := Channel{
+		ChannelID:   common.HexToHash("0xabc9").Hex(),
+		ChainID:     1337,
+		Participant: "0x1111111111111111111111111111111111111111",
+		Status:      ChannelStatusJoining,
+		BrokerKeyID: signer.GetAddress().Hex(),
+	}
+	require.NoError(t, db.Create(&channel).Error)
+
+	require.NoError(t, c.Join(channel, nitrolite.Signature{V: 27}))
+	var transactions []BrokerTransaction
+	require.NoError(t, db.Where("channel_id = ?", channel.ChannelID).Find(&transactions).Error)
+	require.Len(t, transactions, 1)
+	assert.Equal(t, TxKindJoin, transactions[0].Kind)
+	assert.Equal(t, c.custodyAddr.Hex(), transactions[0].To)
+
+	backend.Commit()
+	manager.checkPending(context.Background())
+
+	var failed BrokerTransaction
+	require.NoError(t, db.First(&failed, transactions[0].ID).Error)
+	assert.Equal(t, TxStatusFailed, failed.Status)
+	assert.Equal(t, "transaction reverted", failed.Error)
+
+	stored, err := GetChannelByID(db, channel.ChannelID)
+	require.NoError(t, err)
+	assert.Equal(t, ChannelStatusJoinFailed, stored.Status)
+	require.Len(t, updates, 1)
+	assert.Equal(t, ChannelStatusJoinFailed, updates[0].Status)
+}
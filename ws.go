@@ -12,6 +12,8 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
@@ -22,27 +24,33 @@ var validate = validator.New()
 
 // UnifiedWSHandler manages WebSocket connections with authentication
 type UnifiedWSHandler struct {
-	signer        *Signer
+	signer        BrokerSigner // Current broker key
+	brokerKeys    *BrokerKeys
 	db            *gorm.DB
 	upgrader      websocket.Upgrader
-	connections   map[string]*websocket.Conn
+	connections   map[string]map[*WSConnection]struct{} // Address -> open connections
 	connectionsMu sync.RWMutex
 	authManager   *AuthManager
 	metrics       *Metrics
 	rpcStore      *RPCStore
 	config        *Config
+	wallets       *ContractWallets
+	liquidity     Liquidity
 }
 
 func NewUnifiedWSHandler(
-	signer *Signer,
+	brokerKeys *BrokerKeys,
 	db *gorm.DB,
 	metrics *Metrics,
 	rpcStore *RPCStore,
 	config *Config,
+	wallets *ContractWallets,
+	liquidity Liquidity,
 ) *UnifiedWSHandler {
 	return &UnifiedWSHandler{
-		signer: signer,
-		db:     db,
+		signer:     brokerKeys.Current(),
+		brokerKeys: brokerKeys,
+		db:         db,
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
@@ -50,21 +58,26 @@ func NewUnifiedWSHandler(
 				return true // Allow all origins for testing; should be restricted in production
 			},
 		},
-		connections: make(map[string]*websocket.Conn),
+		connections: make(map[string]map[*WSConnection]struct{}),
 		authManager: NewAuthManager(),
 		metrics:     metrics,
 		rpcStore:    rpcStore,
 		config:      config,
+		wallets:     wallets,
+		liquidity:   liquidity,
 	}
 }
 
 // HandleConnection handles the WebSocket connection lifecycle.
 func (h *UnifiedWSHandler) HandleConnection(w http.ResponseWriter, r *http.Request) {
-	conn, err := h.upgrader.Upgrade(w, r, nil)
+	wsConn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("Failed to upgrade to WebSocket: %v", err)
 		return
 	}
+
+	// All writes go through the connection's outbound queue
+	conn := NewWSConnection(wsConn, h.metrics, h.config.wsOutboundQueueSize, h.config.wsPingInterval)
 	defer conn.Close()
 
 	// Increment connection metrics
@@ -73,6 +86,9 @@ func (h *UnifiedWSHandler) HandleConnection(w http.ResponseWriter, r *http.Reque
 	defer h.metrics.ConnectedClients.Dec()
 
 	var address string
+	var scope string
+	var sigCtx SigningContext
+	var tokenExpiry time.Time // The connection stays authorized until its session token expires
 	var authenticated bool
 
 	// Read messages until authentication completes
@@ -116,7 +132,7 @@ func (h *UnifiedWSHandler) HandleConnection(w http.ResponseWriter, r *http.Reque
 
 		case "auth_verify":
 			// Client is responding to a challenge
-			authAddr, err := HandleAuthVerify(conn, &rpcMsg, h.authManager, h.signer)
+			claims, err := HandleAuthVerify(conn, &rpcMsg, h.authManager, h.brokerKeys, h.db, h.wallets, h.config.sessionTokenTTL)
 			if err != nil {
 				log.Printf("Authentication verification failed: %v", err)
 				h.sendErrorResponse(address, nil, conn, err.Error())
@@ -125,7 +141,10 @@ func (h *UnifiedWSHandler) HandleConnection(w http.ResponseWriter, r *http.Reque
 			}
 
 			// Authentication successful
-			address = authAddr
+			address = claims.Address
+			scope = claims.Scope
+			tokenExpiry = time.Unix(claims.ExpiresAt, 0)
+			sigCtx = SigningContext{Mode: claims.SignatureMode, ChainID: claims.ChainID, Broker: h.signer.GetAddress(), Wallets: h.wallets}
 			authenticated = true
 			h.metrics.AuthSuccess.Inc()
 
@@ -139,14 +158,14 @@ func (h *UnifiedWSHandler) HandleConnection(w http.ResponseWriter, r *http.Reque
 	log.Printf("Authentication successful for: %s", address)
 
 	// Store connection for authenticated user
-	h.connectionsMu.Lock()
-	h.connections[address] = conn
-	h.connectionsMu.Unlock()
+	if !h.addConnection(address, conn) {
+		log.Printf("Connection limit reached for participant: %s", address)
+		h.sendErrorResponse(address, nil, conn, fmt.Sprintf("Too many connections for this address, the limit is %d", h.config.maxConnectionsPerAddress))
+		return
+	}
 
 	defer func() {
-		h.connectionsMu.Lock()
-		delete(h.connections, address)
-		h.connectionsMu.Unlock()
+		h.removeConnection(address, conn)
 		log.Printf("Connection closed for participant: %s", address)
 	}()
 
@@ -176,7 +195,7 @@ func (h *UnifiedWSHandler) HandleConnection(w http.ResponseWriter, r *http.Reque
 		h.metrics.MessageReceived.Inc()
 
 		// Check if session is still valid
-		if !h.authManager.ValidateSession(address) {
+		if !h.authManager.ValidateSession(address, tokenExpiry) {
 			log.Printf("Session expired for participant: %s", address)
 			h.sendErrorResponse(address, nil, conn, "Session expired. Please re-authenticate.")
 			break
@@ -224,6 +243,11 @@ func (h *UnifiedWSHandler) HandleConnection(w http.ResponseWriter, r *http.Reque
 		// Track RPC request by method
 		h.metrics.RPCRequests.WithLabelValues(msg.Req.Method).Inc()
 
+		if !ScopeAllows(scope, msg.Req.Method) {
+			h.sendErrorResponse(address, &msg, conn, fmt.Sprintf("Method %s is not allowed by the session scope", msg.Req.Method))
+			continue
+		}
+
 		switch msg.Req.Method {
 		case "ping":
 			rpcResponse, handlerErr = HandlePing(&msg)
@@ -234,7 +258,7 @@ func (h *UnifiedWSHandler) HandleConnection(w http.ResponseWriter, r *http.Reque
 			}
 
 		case "get_config":
-			rpcResponse, handlerErr = HandleGetConfig(&msg, h.config, h.signer)
+			rpcResponse, handlerErr = HandleGetConfig(&msg, h.config, h.brokerKeys)
 			if handlerErr != nil {
 				log.Printf("Error handling get_config: %v", handlerErr)
 				h.sendErrorResponse(address, &msg, conn, "Failed to get config: "+handlerErr.Error())
@@ -249,6 +273,14 @@ func (h *UnifiedWSHandler) HandleConnection(w http.ResponseWriter, r *http.Reque
 				continue
 			}
 
+		case "get_liquidity":
+			rpcResponse, handlerErr = HandleGetLiquidity(&msg, h.db, h.brokerKeys, h.liquidity)
+			if handlerErr != nil {
+				log.Printf("Error handling get_liquidity: %v", handlerErr)
+				h.sendErrorResponse(address, &msg, conn, "Failed to get liquidity: "+handlerErr.Error())
+				continue
+			}
+
 		case "get_ledger_balances":
 			rpcResponse, handlerErr = HandleGetLedgerBalances(&msg, address, h.db)
 			if handlerErr != nil {
@@ -273,8 +305,22 @@ func (h *UnifiedWSHandler) HandleConnection(w http.ResponseWriter, r *http.Reque
 				continue
 			}
 
+		case "transfer":
+			rpcResponse, handlerErr = HandleTransfer(&msg, address, h.db, sigCtx)
+			if handlerErr != nil {
+				log.Printf("Error handling transfer: %v", handlerErr)
+				h.sendErrorResponse(address, &msg, conn, "Failed to process transfer: "+handlerErr.Error())
+				continue
+			}
+			h.sendBalanceUpdate(address)
+			if transfer, ok := rpcResponse.Res.Params[0].(TransferResponse); ok {
+				for _, alloc := range transfer.Allocations {
+					h.sendBalanceUpdate(alloc.Destination)
+				}
+			}
+			recordHistory = true
 		case "create_app_session":
-			rpcResponse, handlerErr = HandleCreateApplication(&msg, h.db)
+			rpcResponse, handlerErr = HandleCreateApplication(&msg, h.db, sigCtx)
 			if handlerErr != nil {
 				log.Printf("Error handling create_app_session: %v", handlerErr)
 				h.sendErrorResponse(address, &msg, conn, "Failed to create application: "+handlerErr.Error())
@@ -283,13 +329,22 @@ func (h *UnifiedWSHandler) HandleConnection(w http.ResponseWriter, r *http.Reque
 			h.sendBalanceUpdate(address)
 			recordHistory = true
 		case "close_app_session":
-			rpcResponse, handlerErr = HandleCloseApplication(&msg, h.db)
+			rpcResponse, handlerErr = HandleCloseApplication(&msg, h.db, sigCtx)
 			if handlerErr != nil {
 				log.Printf("Error handling close_app_session: %v", handlerErr)
 				h.sendErrorResponse(address, &msg, conn, "Failed to close application: "+handlerErr.Error())
 				continue
 			}
-			h.sendBalanceUpdate(address)
+			h.sendAppSessionBalanceUpdates(address, rpcResponse)
+			recordHistory = true
+		case "submit_app_state":
+			rpcResponse, handlerErr = HandleSubmitAppState(&msg, h.db, sigCtx)
+			if handlerErr != nil {
+				log.Printf("Error handling submit_app_state: %v", handlerErr)
+				h.sendErrorResponse(address, &msg, conn, "Failed to submit app state: "+handlerErr.Error())
+				continue
+			}
+			h.sendAppSessionBalanceUpdates(address, rpcResponse)
 			recordHistory = true
 		case "get_app_sessions":
 			rpcResponse, handlerErr = HandleGetAppSessions(&msg, h.db)
@@ -300,7 +355,7 @@ func (h *UnifiedWSHandler) HandleConnection(w http.ResponseWriter, r *http.Reque
 			}
 
 		case "resize_channel":
-			rpcResponse, handlerErr = HandleResizeChannel(&msg, h.db, h.signer)
+			rpcResponse, handlerErr = HandleResizeChannel(&msg, h.db, h.brokerKeys, h.liquidity, h.config.settlementLockTTL, sigCtx)
 			if handlerErr != nil {
 				log.Printf("Error handling resize_channel: %v", handlerErr)
 				h.sendErrorResponse(address, &msg, conn, "Failed to resize channel: "+handlerErr.Error())
@@ -308,7 +363,7 @@ func (h *UnifiedWSHandler) HandleConnection(w http.ResponseWriter, r *http.Reque
 			}
 			recordHistory = true
 		case "close_channel":
-			rpcResponse, handlerErr = HandleCloseChannel(&msg, h.db, h.signer)
+			rpcResponse, handlerErr = HandleCloseChannel(&msg, h.db, h.brokerKeys, h.config.settlementLockTTL, sigCtx)
 			if handlerErr != nil {
 				log.Printf("Error handling close_channel: %v", handlerErr)
 				h.sendErrorResponse(address, &msg, conn, "Failed to close channel: "+handlerErr.Error())
@@ -323,6 +378,14 @@ func (h *UnifiedWSHandler) HandleConnection(w http.ResponseWriter, r *http.Reque
 				continue
 			}
 
+		case "get_channel_state":
+			rpcResponse, handlerErr = HandleGetChannelState(&msg, address, h.db)
+			if handlerErr != nil {
+				log.Printf("Error handling get_channel_state: %v", handlerErr)
+				h.sendErrorResponse(address, &msg, conn, "Failed to get channel state: "+handlerErr.Error())
+				continue
+			}
+
 		case "get_rpc_history":
 			rpcResponse, handlerErr = HandleGetRPCHistory(address, &msg, h.rpcStore)
 			if handlerErr != nil {
@@ -349,26 +412,9 @@ func (h *UnifiedWSHandler) HandleConnection(w http.ResponseWriter, r *http.Reque
 			}
 		}
 
-		// Use NextWriter for safer message delivery
-		w, err := conn.NextWriter(websocket.TextMessage)
-		if err != nil {
-			log.Printf("Error getting writer for response: %v", err)
-			continue
+		if err := conn.Write(wsResponseData); err != nil {
+			log.Printf("Error sending response: %v", err)
 		}
-
-		if _, err := w.Write(wsResponseData); err != nil {
-			log.Printf("Error writing response: %v", err)
-			w.Close()
-			continue
-		}
-
-		if err := w.Close(); err != nil {
-			log.Printf("Error closing writer for response: %v", err)
-			continue
-		}
-
-		// Increment sent message counter
-		h.metrics.MessageSent.Inc()
 	}
 }
 
@@ -410,43 +456,26 @@ func forwardMessage(rpc *RPCMessage, msg []byte, fromAddress string, h *UnifiedW
 			continue
 		}
 
-		h.connectionsMu.RLock()
-		recipientConn, exists := h.connections[recipient]
-		h.connectionsMu.RUnlock()
-		if exists {
-			// Use NextWriter for safer message delivery
-			w, err := recipientConn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				log.Printf("Error getting writer for forwarded message to %s: %v", recipient, err)
-				continue
-			}
-
-			if _, err := w.Write(msg); err != nil {
-				log.Printf("Error writing forwarded message to %s: %v", recipient, err)
-				w.Close()
-				continue
-			}
-
-			if err := w.Close(); err != nil {
-				log.Printf("Error closing writer for forwarded message to %s: %v", recipient, err)
-				continue
-			}
-
-			// Increment sent message counter for each forwarded message
-			h.metrics.MessageSent.Inc()
-
-			log.Printf("Successfully forwarded message to %s", recipient)
-		} else {
+		recipientConns := h.getConnections(recipient)
+		if len(recipientConns) == 0 {
 			log.Printf("Recipient %s not connected", recipient)
 			continue
 		}
+
+		for _, recipientConn := range recipientConns {
+			if err := recipientConn.Write(msg); err != nil {
+				log.Printf("Error forwarding message to %s: %v", recipient, err)
+			}
+		}
+
+		log.Printf("Successfully forwarded message to %s", recipient)
 	}
 
 	return nil
 }
 
 // sendErrorResponse creates and sends an error response to the client
-func (h *UnifiedWSHandler) sendErrorResponse(sender string, rpc *RPCMessage, conn *websocket.Conn, errMsg string) {
+func (h *UnifiedWSHandler) sendErrorResponse(sender string, rpc *RPCMessage, conn *WSConnection, errMsg string) {
 	reqID := uint64(time.Now().UnixMilli())
 	if rpc != nil && rpc.Req != nil {
 		reqID = rpc.Req.RequestID
@@ -472,31 +501,9 @@ func (h *UnifiedWSHandler) sendErrorResponse(sender string, rpc *RPCMessage, con
 		}
 	}
 
-	// Set a short write deadline to prevent blocking on unresponsive clients
-	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
-
-	// Use NextWriter for safer message delivery
-	w, err := conn.NextWriter(websocket.TextMessage)
-	if err != nil {
-		log.Printf("Error getting writer for error response: %v", err)
-		return
+	if err := conn.Write(responseData); err != nil {
+		log.Printf("Error sending error response: %v", err)
 	}
-
-	if _, err := w.Write(responseData); err != nil {
-		log.Printf("Error writing error response: %v", err)
-		w.Close()
-		return
-	}
-
-	if err := w.Close(); err != nil {
-		log.Printf("Error closing writer for error response: %v", err)
-	}
-
-	// Increment sent message counter
-	h.metrics.MessageSent.Inc()
-
-	// Reset the write deadline
-	conn.SetWriteDeadline(time.Time{})
 }
 
 // sendResponse sends a response with a given method and payload to a recipient
@@ -513,36 +520,20 @@ func (h *UnifiedWSHandler) sendResponse(recipient string, method string, payload
 		return
 	}
 
-	h.connectionsMu.RLock()
-	recipientConn, exists := h.connections[recipient]
-	h.connectionsMu.RUnlock()
-	if exists {
-		// Use NextWriter for safer message delivery
-		w, err := recipientConn.NextWriter(websocket.TextMessage)
-		if err != nil {
-			log.Printf("Error getting writer for %s update to %s: %v", updateType, recipient, err)
-			return
-		}
-
-		if _, err := w.Write(responseData); err != nil {
-			log.Printf("Error writing %s update to %s: %v", updateType, recipient, err)
-			w.Close()
-			return
-		}
-
-		if err := w.Close(); err != nil {
-			log.Printf("Error closing writer for %s update to %s: %v", updateType, recipient, err)
-			return
-		}
-
-		// Increment sent message counter
-		h.metrics.MessageSent.Inc()
-
-		log.Printf("Successfully sent %s update to %s", updateType, recipient)
-	} else {
+	recipientConns := h.getConnections(recipient)
+	if len(recipientConns) == 0 {
 		log.Printf("Recipient %s not connected", recipient)
 		return
 	}
+
+	// Every connection of the recipient receives the update
+	for _, recipientConn := range recipientConns {
+		if err := recipientConn.Write(responseData); err != nil {
+			log.Printf("Error sending %s update to %s: %v", updateType, recipient, err)
+		}
+	}
+
+	log.Printf("Successfully sent %s update to %s", updateType, recipient)
 }
 
 // sendBalanceUpdate sends balance updates to the client
@@ -555,6 +546,20 @@ func (h *UnifiedWSHandler) sendBalanceUpdate(sender string) {
 	h.sendResponse(sender, "bu", []any{balances}, "balance")
 }
 
+// sendAppSessionBalanceUpdates sends balance updates to the sender and every participant of an app session response
+func (h *UnifiedWSHandler) sendAppSessionBalanceUpdates(sender string, rpcResponse *RPCMessage) {
+	h.sendBalanceUpdate(sender)
+	appSession, ok := rpcResponse.Res.Params[0].(*AppSessionResponse)
+	if !ok {
+		return
+	}
+	for _, participant := range appSession.Participants {
+		if !strings.EqualFold(participant, sender) {
+			h.sendBalanceUpdate(participant)
+		}
+	}
+}
+
 // sendChannelsUpdate sends multiple channels updates to the client
 func (h *UnifiedWSHandler) sendChannelsUpdate(address string, channels []Channel) {
 	resp := []ChannelResponse{}
@@ -601,12 +606,62 @@ func (h *UnifiedWSHandler) CloseAllConnections() {
 	h.connectionsMu.RLock()
 	defer h.connectionsMu.RUnlock()
 
-	for userID, conn := range h.connections {
-		log.Printf("Closing connection for participant: %s", userID)
-		conn.Close()
+	for userID, conns := range h.connections {
+		log.Printf("Closing %d connection(s) for participant: %s", len(conns), userID)
+		for conn := range conns {
+			conn.Close()
+		}
 	}
 }
 
+// addConnection registers an authenticated connection for an address.
+// It returns false if the address already has the maximum number of connections.
+func (h *UnifiedWSHandler) addConnection(address string, conn *WSConnection) bool {
+	h.connectionsMu.Lock()
+	defer h.connectionsMu.Unlock()
+
+	conns, ok := h.connections[address]
+	if !ok {
+		conns = make(map[*WSConnection]struct{})
+		h.connections[address] = conns
+	}
+
+	if h.config.maxConnectionsPerAddress > 0 && len(conns) >= h.config.maxConnectionsPerAddress {
+		return false
+	}
+
+	conns[conn] = struct{}{}
+	return true
+}
+
+// removeConnection unregisters a connection, leaving other connections of the same address untouched
+func (h *UnifiedWSHandler) removeConnection(address string, conn *WSConnection) {
+	h.connectionsMu.Lock()
+	defer h.connectionsMu.Unlock()
+
+	conns, ok := h.connections[address]
+	if !ok {
+		return
+	}
+
+	delete(conns, conn)
+	if len(conns) == 0 {
+		delete(h.connections, address)
+	}
+}
+
+// getConnections returns a snapshot of the open connections of an address
+func (h *UnifiedWSHandler) getConnections(address string) []*WSConnection {
+	h.connectionsMu.RLock()
+	defer h.connectionsMu.RUnlock()
+
+	conns := make([]*WSConnection, 0, len(h.connections[address]))
+	for conn := range h.connections[address] {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
 // AuthResponse represents the server's challenge response
 type AuthResponse struct {
 	ChallengeMessage uuid.UUID `json:"challenge_message"` // The message to sign
@@ -614,12 +669,19 @@ type AuthResponse struct {
 
 // AuthVerifyParams represents parameters for completing authentication
 type AuthVerifyParams struct {
-	Challenge uuid.UUID `json:"challenge"` // The challenge token
-	Address   string    `json:"address"`   // The client's address
+	Challenge uuid.UUID `json:"challenge"`       // The challenge token
+	Address   string    `json:"address"`         // The client's address
+	Scope     string    `json:"scope,omitempty"` // Comma separated RPC methods the session may call, all by default
+	JWT       string    `json:"jwt,omitempty"`   // Session token from an earlier auth_verify, replaces the challenge
+
+	SessionKey *SessionKeyGrant `json:"session_key,omitempty"` // Optional delegation to an ephemeral signing key
+
+	SignatureMode string `json:"sig_mode,omitempty"` // raw (default) or eip712
+	ChainID       uint32 `json:"chain_id,omitempty"` // Chain of the EIP-712 domain, required in eip712 mode
 }
 
 // HandleAuthRequest initializes the authentication process by generating a challenge
-func HandleAuthRequest(signer *Signer, conn *websocket.Conn, rpc *RPCMessage, authManager *AuthManager) error {
+func HandleAuthRequest(signer BrokerSigner, conn *WSConnection, rpc *RPCMessage, authManager *AuthManager) error {
 	// Parse the parameters
 	if len(rpc.Req.Params) < 1 {
 		return errors.New("missing parameters")
@@ -651,25 +713,112 @@ func HandleAuthRequest(signer *Signer, conn *websocket.Conn, rpc *RPCMessage, au
 
 	// Send the challenge response
 	responseData, _ := json.Marshal(response)
-	return conn.WriteMessage(websocket.TextMessage, responseData)
+	return conn.Write(responseData)
 }
 
-// HandleAuthVerify verifies an authentication response to a challenge
-func HandleAuthVerify(conn *websocket.Conn, rpc *RPCMessage, authManager *AuthManager, signer *Signer) (string, error) {
+// HandleAuthVerify verifies an authentication response to a challenge, or a session token issued by an earlier auth_verify.
+// It returns the claims of the session token sent back to the client.
+func HandleAuthVerify(conn *WSConnection, rpc *RPCMessage, authManager *AuthManager, keys *BrokerKeys, db *gorm.DB, wallets *ContractWallets, tokenTTL time.Duration) (*SessionClaims, error) {
 	if len(rpc.Req.Params) < 1 {
-		return "", errors.New("missing parameters")
+		return nil, errors.New("missing parameters")
 	}
 
 	var authParams AuthVerifyParams
 	paramsJSON, err := json.Marshal(rpc.Req.Params[0])
 	if err != nil {
-		return "", fmt.Errorf("failed to parse parameters: %w", err)
+		return nil, fmt.Errorf("failed to parse parameters: %w", err)
 	}
 
 	if err := json.Unmarshal(paramsJSON, &authParams); err != nil {
-		return "", fmt.Errorf("invalid parameters format: %w", err)
+		return nil, fmt.Errorf("invalid parameters format: %w", err)
+	}
+
+	// Tokens of any broker key are accepted, new tokens and responses use the current key
+	signer := keys.Current()
+
+	var claims *SessionClaims
+	var token string
+	var sessionKey *SessionKey
+	if authParams.JWT != "" {
+		// Reconnect with a session token, no wallet signature required
+		claims, err = keys.VerifySessionToken(authParams.JWT)
+		if err != nil {
+			return nil, err
+		}
+		token = authParams.JWT
+		authManager.registerAuthSession(claims.Address)
+	} else {
+		mode, err := ParseSignatureMode(authParams.SignatureMode)
+		if err != nil {
+			return nil, err
+		}
+		if mode == SignatureModeEIP712 && authParams.ChainID == 0 {
+			return nil, errors.New("chain_id is required for eip712 signatures")
+		}
+		sigCtx := SigningContext{Mode: mode, ChainID: authParams.ChainID, Broker: signer.GetAddress(), Wallets: wallets}
+
+		// Check the grant before the challenge is consumed
+		if authParams.SessionKey != nil {
+			if err := authParams.SessionKey.Validate(authParams.Address); err != nil {
+				return nil, err
+			}
+		}
+
+		addr, err := verifyChallengeResponse(rpc, authParams, authManager, sigCtx)
+		if err != nil {
+			return nil, err
+		}
+
+		// The grant is part of the request signed by the wallet and the session key
+		if authParams.SessionKey != nil {
+			sessionKey, err = RegisterSessionKey(db, addr, *authParams.SessionKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to register session key: %w", err)
+			}
+		}
+
+		scope := authParams.Scope
+		if scope == "" {
+			scope = ScopeAll
+		}
+		token, claims, err = IssueSessionToken(signer, SessionClaims{
+			Address:       addr,
+			Scope:         scope,
+			SignatureMode: mode,
+			ChainID:       authParams.ChainID,
+		}, tokenTTL)
+		if err != nil {
+			return nil, err
+		}
 	}
 
+	result := map[string]any{
+		"address":   claims.Address,
+		"success":   true,
+		"jwt_token": token,
+	}
+	if sessionKey != nil {
+		result["session_key"] = sessionKey.Address
+	}
+	response := CreateResponse(rpc.Req.RequestID, "auth_verify", []any{result}, time.Now())
+
+	// Sign the response with the server's key
+	resBytes, _ := json.Marshal(response.Req)
+	signature, _ := signer.Sign(resBytes)
+	response.Sig = []string{hexutil.Encode(signature)}
+
+	responseData, _ := json.Marshal(response)
+	if err = conn.Write(responseData); err != nil {
+		log.Printf("Error sending auth success: %v", err)
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// verifyChallengeResponse checks that the request is signed by the address the challenge was issued for.
+// In eip712 mode the signature is over the typed data of the parameters instead of the raw request.
+func verifyChallengeResponse(rpc *RPCMessage, authParams AuthVerifyParams, authManager *AuthManager, sigCtx SigningContext) (string, error) {
 	// Ensure address has 0x prefix
 	addr := authParams.Address
 	if !strings.HasPrefix(addr, "0x") {
@@ -681,35 +830,41 @@ func HandleAuthVerify(conn *websocket.Conn, rpc *RPCMessage, authManager *AuthMa
 		return "", errors.New("missing signature in request")
 	}
 
-	reqBytes, err := json.Marshal(rpc.Req)
-	if err != nil {
-		return "", errors.New("error serializing auth message")
+	var digest []byte
+	if sigCtx.Mode == SignatureModeEIP712 {
+		authParams.Address = addr
+		hash, _, err := apitypes.TypedDataAndHash(authParams.TypedData(sigCtx.Domain()))
+		if err != nil {
+			return "", fmt.Errorf("failed to hash typed data: %w", err)
+		}
+		digest = hash
+	} else {
+		reqBytes, err := json.Marshal(rpc.Req)
+		if err != nil {
+			return "", errors.New("error serializing auth message")
+		}
+		digest = crypto.Keccak256(reqBytes)
 	}
 
-	isValid, err := ValidateSignature(reqBytes, rpc.Sig[0], addr)
-	if err != nil || !isValid {
+	if !sigCtx.VerifySignature(digest, rpc.Sig[0], addr) {
 		return "", errors.New("invalid signature")
 	}
 
-	err = authManager.ValidateChallenge(authParams.Challenge, addr)
-	if err != nil {
-		log.Printf("Challenge verification failed: %v", err)
-		return "", err
+	// The session key proves possession by signing the same request, so no wallet can register the address
+	// of another user as its session key
+	if authParams.SessionKey != nil {
+		if len(rpc.Sig) < 2 {
+			return "", errors.New("missing session key signature")
+		}
+		recovered, err := RecoverAddressFromHash(digest, rpc.Sig[1])
+		if err != nil || !strings.EqualFold(recovered, authParams.SessionKey.Address) {
+			return "", errors.New("invalid session key signature")
+		}
 	}
 
-	response := CreateResponse(rpc.Req.RequestID, "auth_verify", []any{map[string]any{
-		"address": addr,
-		"success": true,
-	}}, time.Now())
-
-	// Sign the response with the server's key
-	resBytes, _ := json.Marshal(response.Req)
-	signature, _ := signer.Sign(resBytes)
-	response.Sig = []string{hexutil.Encode(signature)}
-
-	responseData, _ := json.Marshal(response)
-	if err = conn.WriteMessage(websocket.TextMessage, responseData); err != nil {
-		log.Printf("Error sending auth success: %v", err)
+	err := authManager.ValidateChallenge(authParams.Challenge, addr)
+	if err != nil {
+		log.Printf("Challenge verification failed: %v", err)
 		return "", err
 	}
 
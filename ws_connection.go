@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// writeWait is the time allowed to write a single message to the peer
+	writeWait = 10 * time.Second
+	// slowConsumerTimeout is how long a sender waits for room in a full outbound queue
+	// before the peer is considered a slow consumer and disconnected
+	slowConsumerTimeout = time.Second
+)
+
+var (
+	errConnectionClosed = errors.New("connection closed")
+	errSlowConsumer     = errors.New("slow consumer: outbound queue is full")
+)
+
+// WSConnection wraps a WebSocket connection with a buffered outbound queue drained by a single writer goroutine,
+// so that messages can be sent from any goroutine without concurrent writes to the underlying connection
+type WSConnection struct {
+	conn         *websocket.Conn
+	metrics      *Metrics
+	pingInterval time.Duration
+	outbound     chan []byte
+	closing      chan struct{}
+	done         chan struct{}
+
+	// mu guards closed; senders hold it for reading while enqueueing, so that
+	// no message is enqueued after the writer goroutine has flushed the queue
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewWSConnection wraps conn and starts its writer goroutine.
+// If pingInterval is not zero, the peer is pinged on that interval and the connection
+// is considered dead when nothing, including a pong, is received for twice the interval.
+func NewWSConnection(conn *websocket.Conn, metrics *Metrics, queueSize int, pingInterval time.Duration) *WSConnection {
+	if queueSize <= 0 {
+		queueSize = 1
+	}
+
+	c := &WSConnection{
+		conn:         conn,
+		metrics:      metrics,
+		pingInterval: pingInterval,
+		outbound:     make(chan []byte, queueSize),
+		closing:      make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+
+	if pingInterval > 0 {
+		c.extendReadDeadline()
+		conn.SetPongHandler(func(string) error {
+			c.extendReadDeadline()
+			return nil
+		})
+	}
+
+	go c.writeLoop()
+	return c
+}
+
+// Write enqueues a text message for delivery. If the queue stays full for slowConsumerTimeout,
+// the connection is closed and errSlowConsumer is returned.
+func (c *WSConnection) Write(data []byte) error {
+	c.mu.RLock()
+	if c.closed {
+		c.mu.RUnlock()
+		return errConnectionClosed
+	}
+
+	// Fast path when the queue has room
+	select {
+	case c.outbound <- data:
+		c.metrics.OutboundQueueDepth.Inc()
+		c.mu.RUnlock()
+		return nil
+	default:
+	}
+
+	// Apply backpressure to the sender until the writer catches up
+	timer := time.NewTimer(slowConsumerTimeout)
+	defer timer.Stop()
+
+	select {
+	case c.outbound <- data:
+		c.metrics.OutboundQueueDepth.Inc()
+		c.mu.RUnlock()
+		return nil
+	case <-timer.C:
+		c.mu.RUnlock()
+	}
+
+	log.Printf("Disconnecting slow consumer %s: outbound queue is full", c.conn.RemoteAddr())
+	c.metrics.SlowConsumerDisconnects.Inc()
+	// Unblock the writer goroutine stuck on the unresponsive peer
+	c.conn.Close()
+	c.Close()
+	return errSlowConsumer
+}
+
+// Close stops accepting new messages. Queued messages are flushed before the underlying connection is closed.
+func (c *WSConnection) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.closing)
+}
+
+// Done is closed once the writer goroutine has exited and the underlying connection is closed
+func (c *WSConnection) Done() <-chan struct{} {
+	return c.done
+}
+
+// ReadMessage reads the next message from the peer. It must only be called from a single goroutine.
+// A peer that stopped answering pings is reported with a timeout error.
+func (c *WSConnection) ReadMessage() (int, []byte, error) {
+	messageType, data, err := c.conn.ReadMessage()
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			log.Printf("Reaping idle connection %s: no pong received within %s", c.conn.RemoteAddr(), c.pongWait())
+			c.metrics.IdleConnectionsReaped.Inc()
+		}
+		return messageType, data, err
+	}
+
+	if c.pingInterval > 0 {
+		c.extendReadDeadline()
+	}
+	return messageType, data, nil
+}
+
+// pongWait is how long the peer may stay silent before the connection is reaped
+func (c *WSConnection) pongWait() time.Duration {
+	return 2 * c.pingInterval
+}
+
+// extendReadDeadline gives the peer another pongWait to show it is alive
+func (c *WSConnection) extendReadDeadline() {
+	if err := c.conn.SetReadDeadline(time.Now().Add(c.pongWait())); err != nil {
+		log.Printf("Error setting read deadline for %s: %v", c.conn.RemoteAddr(), err)
+	}
+}
+
+// writeLoop is the only goroutine writing to the underlying connection
+func (c *WSConnection) writeLoop() {
+	defer close(c.done)
+	defer c.conn.Close()
+
+	// A nil channel never fires, which disables pings
+	var pings <-chan time.Time
+	if c.pingInterval > 0 {
+		pingTicker := time.NewTicker(c.pingInterval)
+		defer pingTicker.Stop()
+		pings = pingTicker.C
+	}
+
+	failed := false
+	write := func(data []byte) {
+		c.metrics.OutboundQueueDepth.Dec()
+		if failed {
+			return
+		}
+		if err := c.writeMessage(data); err != nil {
+			log.Printf("Error writing message to %s: %v", c.conn.RemoteAddr(), err)
+			failed = true
+			// Make the reader fail too, so that the connection is torn down
+			c.conn.Close()
+			return
+		}
+		c.metrics.MessageSent.Inc()
+	}
+
+	for {
+		select {
+		case data := <-c.outbound:
+			write(data)
+		case <-pings:
+			if failed {
+				continue
+			}
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				log.Printf("Error sending ping to %s: %v", c.conn.RemoteAddr(), err)
+				failed = true
+				c.conn.Close()
+			}
+		case <-c.closing:
+			for {
+				select {
+				case data := <-c.outbound:
+					write(data)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// writeMessage writes a single text message with a write deadline
+func (c *WSConnection) writeMessage(data []byte) error {
+	if err := c.conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+		return err
+	}
+
+	// Use NextWriter for safer message delivery
+	w, err := c.conn.NextWriter(websocket.TextMessage)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
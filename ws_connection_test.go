@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupTestWSConnection returns a server side WSConnection and the client end of the same connection
+func setupTestWSConnection(t *testing.T, queueSize int, pingInterval time.Duration) (*WSConnection, *websocket.Conn) {
+	t.Helper()
+
+	serverConns := make(chan *WSConnection, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		serverConns <- NewWSConnection(conn, testMetrics(), queueSize, pingInterval)
+	}))
+	t.Cleanup(server.Close)
+
+	client := dialTestWS(t, "ws"+strings.TrimPrefix(server.URL, "http"))
+	serverConn := <-serverConns
+	t.Cleanup(serverConn.Close)
+
+	return serverConn, client
+}
+
+func TestWSConnectionConcurrentWrites(t *testing.T) {
+	conn, client := setupTestWSConnection(t, 4, 0)
+
+	const writers, messagesPerWriter = 8, 50
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < messagesPerWriter; j++ {
+				assert.NoError(t, conn.Write([]byte(fmt.Sprintf("%d-%d", i, j))))
+			}
+		}(i)
+	}
+
+	received := make(map[string]bool)
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(5*time.Second)))
+	for len(received) < writers*messagesPerWriter {
+		_, data, err := client.ReadMessage()
+		require.NoError(t, err)
+		received[string(data)] = true
+	}
+	wg.Wait()
+
+	// Queued messages are flushed before the connection is closed
+	require.NoError(t, conn.Write([]byte("last")))
+	conn.Close()
+	_, data, err := client.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "last", string(data))
+
+	<-conn.Done()
+	assert.ErrorIs(t, conn.Write([]byte("late")), errConnectionClosed)
+}
+
+func TestWSConnectionSlowConsumer(t *testing.T) {
+	conn, _ := setupTestWSConnection(t, 1, 0)
+
+	// The client never reads, so once the socket buffers fill up the queue stays full
+	payload := bytes.Repeat([]byte("x"), 1<<20)
+	var err error
+	for i := 0; i < 256 && err == nil; i++ {
+		err = conn.Write(payload)
+	}
+	require.ErrorIs(t, err, errSlowConsumer)
+
+	select {
+	case <-conn.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow consumer connection was not closed")
+	}
+	assert.ErrorIs(t, conn.Write(payload), errConnectionClosed)
+}
+
+func TestWSConnectionKeepalive(t *testing.T) {
+	const pingInterval = 50 * time.Millisecond
+
+	// readUntilError mimics the server read loop, closing the connection once reading fails
+	readUntilError := func(conn *WSConnection) {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}
+
+	t.Run("responsive peer stays connected", func(t *testing.T) {
+		conn, client := setupTestWSConnection(t, 1, pingInterval)
+		go readUntilError(conn)
+
+		// Reading on the client answers the server pings with pongs
+		go func() {
+			for {
+				if _, _, err := client.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		select {
+		case <-conn.Done():
+			t.Fatal("responsive connection was reaped")
+		case <-time.After(10 * pingInterval):
+		}
+	})
+
+	t.Run("unresponsive peer is reaped", func(t *testing.T) {
+		conn, _ := setupTestWSConnection(t, 1, pingInterval)
+		go readUntilError(conn)
+
+		// The client never reads, so pings are never answered
+		select {
+		case <-conn.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("unresponsive connection was not reaped")
+		}
+	})
+}
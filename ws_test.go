@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// testMetrics registers the Prometheus metrics once for all WebSocket tests
+var testMetrics = sync.OnceValue(NewMetrics)
+
+// setupTestWSServer starts a WebSocket server backed by the given database and config.
+// A random broker key is used if signer is nil.
+func setupTestWSServer(t *testing.T, db *gorm.DB, signer *Signer, config *Config) (*UnifiedWSHandler, string) {
+	t.Helper()
+
+	if signer == nil {
+		brokerKey, err := crypto.GenerateKey()
+		require.NoError(t, err)
+		signer = &Signer{privateKey: brokerKey}
+	}
+
+	if config.msgExpiryTime == 0 {
+		config.msgExpiryTime = 60
+	}
+	if config.sessionTokenTTL == 0 {
+		config.sessionTokenTTL = time.Hour
+	}
+
+	handler := NewUnifiedWSHandler(NewBrokerKeys(signer, nil), db, testMetrics(), NewRPCStore(db), config, NewContractWallets(time.Minute), nil)
+	server := httptest.NewServer(http.HandlerFunc(handler.HandleConnection))
+	t.Cleanup(func() {
+		handler.CloseAllConnections()
+		server.Close()
+	})
+
+	return handler, "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// dialTestWS opens a client connection to the test server
+func dialTestWS(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// sendTestRPC signs and sends a request on behalf of the key owner
+func sendTestRPC(t *testing.T, conn *websocket.Conn, key *ecdsa.PrivateKey, method string, params ...any) {
+	t.Helper()
+
+	req := &RPCData{
+		RequestID: uint64(time.Now().UnixNano()),
+		Method:    method,
+		Params:    params,
+		Timestamp: uint64(time.Now().UnixMilli()),
+	}
+	reqBytes, err := json.Marshal(req)
+	require.NoError(t, err)
+	sig, err := (&Signer{privateKey: key}).Sign(reqBytes)
+	require.NoError(t, err)
+
+	data, err := json.Marshal(RPCMessage{Req: req, Sig: []string{hexutil.Encode(sig)}})
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, data))
+}
+
+// readTestRPC reads the next server message
+func readTestRPC(t *testing.T, conn *websocket.Conn) *RPCMessage {
+	t.Helper()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+
+	msg, err := ParseRPCMessage(data)
+	require.NoError(t, err)
+	require.NotNil(t, msg.Res)
+	return msg
+}
+
+// authenticateTestWS runs the challenge flow and returns the auth_verify response
+func authenticateTestWS(t *testing.T, conn *websocket.Conn, key *ecdsa.PrivateKey, extraParams ...map[string]any) *RPCMessage {
+	t.Helper()
+
+	address := crypto.PubkeyToAddress(key.PublicKey).Hex()
+	sendTestRPC(t, conn, key, "auth_request", address)
+
+	challenge := readTestRPC(t, conn)
+	require.Equal(t, "auth_challenge", challenge.Res.Method)
+	challengeParams, ok := challenge.Res.Params[0].(map[string]any)
+	require.True(t, ok)
+
+	params := map[string]any{
+		"address":   address,
+		"challenge": challengeParams["challenge_message"],
+	}
+	for _, extra := range extraParams {
+		for k, v := range extra {
+			params[k] = v
+		}
+	}
+	sendTestRPC(t, conn, key, "auth_verify", params)
+	return readTestRPC(t, conn)
+}
+
+func TestMultipleConnectionsPerAddress(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	handler, url := setupTestWSServer(t, db, nil, &Config{maxConnectionsPerAddress: 2})
+
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	address := crypto.PubkeyToAddress(key.PublicKey).Hex()
+
+	// Both connections authenticate and receive the initial updates
+	conns := []*websocket.Conn{dialTestWS(t, url), dialTestWS(t, url)}
+	for i, conn := range conns {
+		verify := authenticateTestWS(t, conn, key)
+		assert.Equal(t, "auth_verify", verify.Res.Method)
+
+		// Initial updates are delivered to every connection of the address
+		for _, c := range conns[:i+1] {
+			assert.Equal(t, "channels", readTestRPC(t, c).Res.Method)
+			assert.Equal(t, "bu", readTestRPC(t, c).Res.Method)
+		}
+	}
+	assert.Len(t, handler.getConnections(address), 2)
+
+	// A notification reaches every connection of the address
+	handler.sendBalanceUpdate(address)
+	for _, conn := range conns {
+		assert.Equal(t, "bu", readTestRPC(t, conn).Res.Method)
+	}
+
+	// A connection over the limit is rejected
+	extra := dialTestWS(t, url)
+	authenticateTestWS(t, extra, key)
+	rejection := readTestRPC(t, extra)
+	assert.Equal(t, "error", rejection.Res.Method)
+	assert.Contains(t, rejection.Res.Params[0].(map[string]any)["error"], "Too many connections")
+	assert.Len(t, handler.getConnections(address), 2)
+
+	// Closing one connection keeps the other one registered
+	require.NoError(t, conns[0].Close())
+	require.Eventually(t, func() bool {
+		return len(handler.getConnections(address)) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	handler.sendBalanceUpdate(address)
+	assert.Equal(t, "bu", readTestRPC(t, conns[1]).Res.Method)
+}
+
+func TestSessionTokenReconnect(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	brokerKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	signer := &Signer{privateKey: brokerKey}
+
+	// Two replicas sharing the broker key
+	_, firstURL := setupTestWSServer(t, db, signer, &Config{})
+	_, secondURL := setupTestWSServer(t, db, signer, &Config{})
+
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	address := crypto.PubkeyToAddress(key.PublicKey).Hex()
+
+	first := dialTestWS(t, firstURL)
+	verify := authenticateTestWS(t, first, key, map[string]any{"scope": "get_ledger_balances"})
+	require.Equal(t, "auth_verify", verify.Res.Method)
+	token, ok := verify.Res.Params[0].(map[string]any)["jwt_token"].(string)
+	require.True(t, ok)
+	require.NotEmpty(t, token)
+
+	// Reconnect to the other replica with the token only, signed by an unrelated key
+	otherKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	second := dialTestWS(t, secondURL)
+	sendTestRPC(t, second, otherKey, "auth_verify", map[string]any{"jwt": token})
+
+	verify = readTestRPC(t, second)
+	require.Equal(t, "auth_verify", verify.Res.Method)
+	assert.Equal(t, address, verify.Res.Params[0].(map[string]any)["address"])
+	assert.Equal(t, "channels", readTestRPC(t, second).Res.Method)
+	assert.Equal(t, "bu", readTestRPC(t, second).Res.Method)
+
+	// The scope of the original session carries over
+	sendTestRPC(t, second, key, "get_ledger_balances")
+	assert.Equal(t, "get_ledger_balances", readTestRPC(t, second).Res.Method)
+
+	sendTestRPC(t, second, key, "get_assets")
+	rejection := readTestRPC(t, second)
+	assert.Equal(t, "error", rejection.Res.Method)
+	assert.Contains(t, rejection.Res.Params[0].(map[string]any)["error"], "not allowed by the session scope")
+
+	// A token signed by another broker is rejected
+	_, foreignURL := setupTestWSServer(t, db, nil, &Config{})
+	foreign := dialTestWS(t, foreignURL)
+	sendTestRPC(t, foreign, key, "auth_verify", map[string]any{"jwt": token})
+	rejection = readTestRPC(t, foreign)
+	assert.Equal(t, "error", rejection.Res.Method)
+	assert.Contains(t, rejection.Res.Params[0].(map[string]any)["error"], "invalid session token signature")
+}